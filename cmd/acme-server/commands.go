@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/admin"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/config"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+// errUsage reports a malformed command line; main exits with status 2
+var errUsage = errors.New("invalid usage")
+
+// commands are the operator subcommands that run instead of the server.
+// They operate directly on the configured store and CA.
+var commands = map[string]func(ctx context.Context, cfg *config.Config, args []string) error{
+	"migrate":  runMigrate,
+	"accounts": runAccounts,
+	"certs":    runCerts,
+	"eab":      runEAB,
+	"ca":       runCA,
+	"config":   runConfig,
+	"audit":    runAudit,
+}
+
+// usageError wraps errUsage with the expected syntax
+func usageError(syntax string) error {
+	return fmt.Errorf("%w: acme-server [flags] %s", errUsage, syntax)
+}
+
+// output selects between a table for humans and JSON for scripts
+type output struct {
+	json bool
+	w    io.Writer
+}
+
+// newFlagSet creates the flag set of a subcommand with the -o flag
+func newFlagSet(name string, out *output) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Func("o", "output format: table or json", func(v string) error {
+		switch v {
+		case "table":
+			out.json = false
+		case "json":
+			out.json = true
+		default:
+			return fmt.Errorf("unknown output format %q", v)
+		}
+		return nil
+	})
+	out.w = os.Stdout
+	return fs
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (o *output) print(v any, table func(w *tabwriter.Writer)) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// listFlags registers the filter flags shared by the list commands
+func listFlags(fs *flag.FlagSet, filter *types.ListFilter) {
+	fs.StringVar(&filter.Status, "status", "", "only list records with this status")
+	fs.IntVar(&filter.Limit, "limit", types.DefaultListLimit, "maximum number of records")
+	fs.IntVar(&filter.Offset, "offset", 0, "number of records to skip")
+}
+
+// openStore opens the configured storage backend for a subcommand
+func openStore(ctx context.Context, cfg *config.Config) (storage.Store, error) {
+	if backend := cfg.StorageBackend(); backend == "" || backend == storage.BackendMemory {
+		return nil, fmt.Errorf("no persistent storage backend configured")
+	}
+	return initStorage(ctx, cfg)
+}
+
+// runAccounts implements "accounts list|show|deactivate"
+func runAccounts(ctx context.Context, cfg *config.Config, args []string) error {
+	const syntax = "accounts list [-status s] [-q contact] | show <id> | deactivate <id> | scope <id> [domain|cidr ...]"
+	if len(args) == 0 || !slices.Contains([]string{"list", "show", "deactivate", "scope"}, args[0]) {
+		return usageError(syntax)
+	}
+
+	var out output
+	var filter types.ListFilter
+	fs := newFlagSet("accounts "+args[0], &out)
+	if args[0] == "list" {
+		listFlags(fs, &filter)
+		fs.StringVar(&filter.Query, "q", "", "only list accounts whose contact contains this text")
+	}
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch {
+	case args[0] == "list" && len(positional) == 0:
+		accounts, err := store.ListAccounts(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return out.print(accounts, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "ID\tSTATUS\tCREATED\tCONTACT")
+			for _, a := range accounts {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.ID, a.Status, formatUnix(a.CreatedAt), strings.Join(a.Contact, ","))
+			}
+		})
+	case args[0] == "show" && len(positional) == 1:
+		account, err := admin.GetAccount(ctx, store, positional[0])
+		if err != nil {
+			return err
+		}
+		return out.print(account, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "ID:\t%s\n", account.ID)
+			fmt.Fprintf(w, "Status:\t%s\n", account.Status)
+			fmt.Fprintf(w, "Created:\t%s\n", formatUnix(account.CreatedAt))
+			fmt.Fprintf(w, "Initial IP:\t%s\n", account.InitialIP)
+			fmt.Fprintf(w, "Contact:\t%s\n", strings.Join(account.Contact, ", "))
+			fmt.Fprintf(w, "Tenant:\t%s\n", account.Tenant)
+			fmt.Fprintf(w, "Scope:\t%s\n", strings.Join(account.Scope, ", "))
+			fmt.Fprintf(w, "Key:\t%s\n", account.Key)
+		})
+	case args[0] == "deactivate" && len(positional) == 1:
+		account, err := admin.DeactivateAccount(ctx, store, positional[0])
+		if err != nil {
+			return err
+		}
+		return out.print(account, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "Account %s deactivated\n", account.ID)
+		})
+	case args[0] == "scope" && len(positional) >= 1:
+		account, err := admin.SetAccountScope(ctx, store, positional[0], positional[1:])
+		if err != nil {
+			return err
+		}
+		return out.print(account, func(w *tabwriter.Writer) {
+			if len(account.Scope) == 0 {
+				fmt.Fprintf(w, "Account %s is no longer restricted\n", account.ID)
+				return
+			}
+			fmt.Fprintf(w, "Account %s is restricted to %s\n", account.ID, strings.Join(account.Scope, ", "))
+		})
+	default:
+		return usageError(syntax)
+	}
+}
+
+// runCerts implements "certs list|show|revoke|renew-window"
+func runCerts(ctx context.Context, cfg *config.Config, args []string) error {
+	const syntax = "certs list [-account id] [-status valid|expired|revoked] [-serial s]" +
+		" | show <id> | show -serial s [-aki hex] | revoke (<id> | -serial s [-aki hex]) [-reason r]" +
+		" | renew-window (<id> | -serial s [-aki hex]) " + renewalWindowSyntax
+	if len(args) == 0 || !slices.Contains([]string{"list", "show", "revoke", "renew-window"}, args[0]) {
+		return usageError(syntax)
+	}
+
+	var out output
+	var filter types.ListFilter
+	var serial, aki, reason string
+	var window renewalWindowFlags
+	fs := newFlagSet("certs "+args[0], &out)
+	switch args[0] {
+	case "list":
+		listFlags(fs, &filter)
+		fs.StringVar(&filter.AccountID, "account", "", "only list certificates of this account")
+		fs.StringVar(&serial, "serial", "", "only list certificates with this serial number")
+	case "show", "revoke", "renew-window":
+		fs.StringVar(&serial, "serial", "", "select the certificate by serial number")
+		fs.StringVar(&aki, "aki", "", "hex authority key identifier, if several issuers share the serial")
+		if args[0] == "revoke" {
+			fs.StringVar(&reason, "reason", "unspecified", "RFC 5280 revocation reason")
+		}
+		if args[0] == "renew-window" {
+			window.register(fs)
+		}
+	}
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// lookup resolves the certificate named by ID or serial
+	lookup := func() (*types.Certificate, error) {
+		switch {
+		case len(positional) == 1 && serial == "":
+			return store.GetCertificate(ctx, positional[0])
+		case len(positional) == 0 && serial != "":
+			return admin.FindCertificateBySerial(ctx, store, serial, aki)
+		default:
+			return nil, usageError(syntax)
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		if len(positional) != 0 {
+			return usageError(syntax)
+		}
+		if serial != "" {
+			if filter.Serial, err = admin.NormalizeSerial(serial); err != nil {
+				return err
+			}
+		}
+		certs, err := store.ListCertificates(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return out.print(certs, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "ID\tSERIAL\tSTATUS\tNOT AFTER\tORDER")
+			for _, c := range certs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Serial, certificateStatus(c), formatTime(c.NotAfter.Time), c.OrderID)
+			}
+		})
+	case "show":
+		cert, err := lookup()
+		if err != nil {
+			return err
+		}
+		return out.print(cert, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "ID:\t%s\n", cert.ID)
+			fmt.Fprintf(w, "Order:\t%s\n", cert.OrderID)
+			fmt.Fprintf(w, "Serial:\t%s\n", cert.Serial)
+			fmt.Fprintf(w, "Authority key ID:\t%s\n", cert.AuthorityKeyID)
+			fmt.Fprintf(w, "Not before:\t%s\n", formatTime(cert.NotBefore.Time))
+			fmt.Fprintf(w, "Not after:\t%s\n", formatTime(cert.NotAfter.Time))
+			fmt.Fprintf(w, "Status:\t%s\n", certificateStatus(cert))
+			if cert.Revoked {
+				fmt.Fprintf(w, "Revoked:\t%s (%s)\n", formatTime(cert.RevokedAt.Time), cert.RevocationReason)
+			}
+			if cert.ReplacedBy != "" {
+				fmt.Fprintf(w, "Replaced by:\t%s\n", cert.ReplacedBy)
+			}
+			fmt.Fprintf(w, "\n%s", cert.Certificate)
+		})
+	case "revoke":
+		cert, err := lookup()
+		if err != nil {
+			return err
+		}
+		auditLog, err := initAuditLog(ctx, cfg, store)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		// The server delivers the queued webhook
+		webhooks, err := initWebhooks(ctx, cfg, store)
+		if err != nil {
+			return err
+		}
+		if cert, err = admin.RevokeCertificate(ctx, store, auditLog, webhooks, cert.ID, reason); err != nil {
+			return err
+		}
+		return out.print(cert, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "Certificate %s (serial %s) revoked: %s\n", cert.ID, cert.Serial, cert.RevocationReason)
+		})
+	case "renew-window":
+		override, err := window.override(syntax)
+		if err != nil {
+			return err
+		}
+		cert, err := lookup()
+		if err != nil {
+			return err
+		}
+		if cert, err = admin.SetCertificateRenewalWindow(ctx, store, cert.ID, override); err != nil {
+			return err
+		}
+		return printRenewalWindow(&out, "Certificate "+cert.ID, override)
+	default:
+		return usageError(syntax)
+	}
+}
+
+// renewalWindowSyntax is the usage of the renew-window flags
+const renewalWindowSyntax = "(-start time -end time | -now | -clear) [-explanation url]"
+
+// renewalWindowFlags are the flags of the renew-window commands
+type renewalWindowFlags struct {
+	start, end  string
+	now, clear  bool
+	explanation string
+}
+
+func (f *renewalWindowFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.start, "start", "", "start of the renewal window (RFC 3339)")
+	fs.StringVar(&f.end, "end", "", "end of the renewal window (RFC 3339)")
+	fs.BoolVar(&f.now, "now", false, "ask clients to renew right away")
+	fs.BoolVar(&f.clear, "clear", false, "remove the renewal window")
+	fs.StringVar(&f.explanation, "explanation", "", "URL of a page explaining the renewal")
+}
+
+// override returns the requested renewal window, or nil for -clear
+func (f *renewalWindowFlags) override(syntax string) (*types.RenewalOverride, error) {
+	if f.clear {
+		if f.start != "" || f.end != "" || f.now || f.explanation != "" {
+			return nil, usageError(syntax)
+		}
+		return nil, nil
+	}
+	var start, end time.Time
+	for _, t := range []struct {
+		name  string
+		value string
+		dst   *time.Time
+	}{{"start", f.start, &start}, {"end", f.end, &end}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid -%s: %v", errUsage, t.name, err)
+		}
+		*t.dst = parsed
+	}
+	return admin.NewRenewalOverride(start, end, f.now, f.explanation)
+}
+
+// printRenewalWindow reports the renewal window set on subject; nil means
+// it was removed
+func printRenewalWindow(out *output, subject string, override *types.RenewalOverride) error {
+	if override == nil {
+		return out.print(map[string]any{"suggestedWindow": nil}, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "%s: renewal window removed\n", subject)
+		})
+	}
+	info := types.RenewalInfo{SuggestedWindow: override.Window, ExplanationURL: override.ExplanationURL}
+	return out.print(info, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%s: renew between %s and %s\n", subject, formatTime(override.Window.Start.Time), formatTime(override.Window.End.Time))
+	})
+}
+
+// runEAB implements "eab create|list|delete"
+func runEAB(ctx context.Context, cfg *config.Config, args []string) error {
+	const syntax = "eab create [-label text] [-tenant name] [-scope domain,cidr] | list | delete <kid>"
+	if len(args) == 0 || !slices.Contains([]string{"create", "list", "delete"}, args[0]) {
+		return usageError(syntax)
+	}
+
+	var out output
+	var label, tenantName, scope string
+	fs := newFlagSet("eab "+args[0], &out)
+	if args[0] == "create" {
+		fs.StringVar(&label, "label", "", "description of the key's holder")
+		fs.StringVar(&tenantName, "tenant", "", "tenant whose accounts the key binds (default tenant if empty)")
+		fs.StringVar(&scope, "scope", "", "comma-separated domain suffixes and IP ranges the bound account may order")
+	}
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch {
+	case args[0] == "create" && len(positional) == 0:
+		if cfg.Tenant(tenantName) == nil && tenantName != "" {
+			return fmt.Errorf("unknown tenant %q", tenantName)
+		}
+		var entries []string
+		for _, entry := range strings.Split(scope, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+		key, err := admin.CreateEABKey(ctx, store, tenantName, label, entries)
+		if err != nil {
+			return err
+		}
+		hmacKey := base64.RawURLEncoding.EncodeToString(key.HMACKey)
+		created := struct {
+			*types.EABKey
+			HMACKey string `json:"hmacKey"`
+		}{key, hmacKey}
+		return out.print(created, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "Key ID:\t%s\n", key.ID)
+			fmt.Fprintf(w, "HMAC key:\t%s\n", hmacKey)
+			fmt.Fprintln(w, "\nThe HMAC key is not shown again.")
+		})
+	case args[0] == "list" && len(positional) == 0:
+		keys, err := store.ListEABKeys(ctx)
+		if err != nil {
+			return err
+		}
+		return out.print(keys, func(w *tabwriter.Writer) {
+			fmt.Fprintln(w, "KEY ID\tTENANT\tLABEL\tSCOPE\tCREATED\tBOUND ACCOUNT")
+			for _, k := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Tenant, k.Label, strings.Join(k.Scope, ","), formatTime(k.CreatedAt), k.BoundAccountID)
+			}
+		})
+	case args[0] == "delete" && len(positional) == 1:
+		if err := store.DeleteEABKey(ctx, positional[0]); err != nil {
+			return err
+		}
+		return out.print(map[string]string{"deleted": positional[0]}, func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "EAB key %s deleted\n", positional[0])
+		})
+	default:
+		return usageError(syntax)
+	}
+}
+
+// caCertificate is the JSON form of a certificate in "ca info"
+type caCertificate struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	SubjectKeyID   string    `json:"subjectKeyId"`
+	AuthorityKeyID string    `json:"authorityKeyId"`
+	KeyAlgorithm   string    `json:"keyAlgorithm"`
+	IsCA           bool      `json:"isCA"`
+}
+
+// runCA implements "ca info|renew-window"
+func runCA(ctx context.Context, cfg *config.Config, args []string) error {
+	const syntax = "ca info | renew-window [-tenant name | -aki hex] " + renewalWindowSyntax
+	if len(args) == 0 || !slices.Contains([]string{"info", "renew-window"}, args[0]) {
+		return usageError(syntax)
+	}
+
+	var out output
+	var tenantName, aki string
+	var window renewalWindowFlags
+	fs := newFlagSet("ca "+args[0], &out)
+	if args[0] == "renew-window" {
+		fs.StringVar(&tenantName, "tenant", "", "tenant whose CA issued the certificates (default tenant if empty)")
+		fs.StringVar(&aki, "aki", "", "hex authority key identifier of the issuer instead of a configured CA")
+		window.register(fs)
+	}
+	if positional, err := parseArgs(fs, args[1:]); err != nil {
+		return err
+	} else if len(positional) != 0 {
+		return usageError(syntax)
+	}
+
+	if args[0] == "renew-window" {
+		override, err := window.override(syntax)
+		if err != nil {
+			return err
+		}
+		if aki == "" {
+			if aki, err = caKeyID(cfg, tenantName); err != nil {
+				return err
+			}
+		} else if tenantName != "" {
+			return usageError(syntax)
+		}
+		store, err := openStore(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		if aki, err = admin.SetIssuerRenewalWindow(ctx, store, aki, override); err != nil {
+			return err
+		}
+		return printRenewalWindow(&out, "Issuer "+aki, override)
+	}
+
+	if cfg.CA.Certs == "" {
+		return fmt.Errorf("no CA certificate configured (ca.certificates)")
+	}
+	certs, err := pki.LoadCertificates(cfg.CA.Certs)
+	if err != nil {
+		return err
+	}
+
+	info := struct {
+		Chain      []caCertificate `json:"chain"`
+		KeyPath    string          `json:"keyPath,omitempty"`
+		KeyMatches *bool           `json:"keyMatches,omitempty"`
+	}{KeyPath: cfg.CA.PrivateKey}
+	for _, cert := range certs {
+		info.Chain = append(info.Chain, describeCertificate(cert))
+	}
+	if cfg.CA.PrivateKey != "" {
+		key, err := pki.LoadPrivateKey(cfg.CA.PrivateKey)
+		if err != nil {
+			return err
+		}
+		matches := pki.KeyMatchesCertificate(key, certs[0])
+		info.KeyMatches = &matches
+	}
+
+	return out.print(info, func(w *tabwriter.Writer) {
+		for i, c := range info.Chain {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "Subject:\t%s\n", c.Subject)
+			fmt.Fprintf(w, "Issuer:\t%s\n", c.Issuer)
+			fmt.Fprintf(w, "Serial:\t%s\n", c.Serial)
+			fmt.Fprintf(w, "Validity:\t%s - %s (%s)\n", formatTime(c.NotBefore), formatTime(c.NotAfter), remaining(c.NotAfter))
+			fmt.Fprintf(w, "Subject key ID:\t%s\n", c.SubjectKeyID)
+			fmt.Fprintf(w, "Key algorithm:\t%s\n", c.KeyAlgorithm)
+			fmt.Fprintf(w, "CA:\t%t\n", c.IsCA)
+		}
+		if info.KeyMatches != nil {
+			fmt.Fprintf(w, "\nPrivate key:\t%s (matches certificate: %t)\n", info.KeyPath, *info.KeyMatches)
+		}
+	})
+}
+
+// caKeyID returns the hex subject key identifier of the CA of a tenant,
+// which its certificates carry as authority key identifier
+func caKeyID(cfg *config.Config, tenantName string) (string, error) {
+	certsPath := cfg.CA.Certs
+	if tenantName != "" {
+		t := cfg.Tenant(tenantName)
+		if t == nil {
+			return "", fmt.Errorf("unknown tenant %q", tenantName)
+		}
+		certsPath = t.CA.Certs
+	}
+	if certsPath == "" {
+		return "", fmt.Errorf("no CA certificate configured, use -aki")
+	}
+	certs, err := pki.LoadCertificates(certsPath)
+	if err != nil {
+		return "", err
+	}
+	if len(certs[0].SubjectKeyId) == 0 {
+		return "", fmt.Errorf("CA certificate %s has no subject key identifier, use -aki", certsPath)
+	}
+	return hex.EncodeToString(certs[0].SubjectKeyId), nil
+}
+
+// runConfig implements "config validate"
+func runConfig(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return usageError("config validate")
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	fmt.Println("Configuration is valid")
+	return nil
+}
+
+// auditResult is the outcome of verifying one audit log
+type auditResult struct {
+	Source   string `json:"source"`
+	Entries  int64  `json:"entries"`
+	LastHash string `json:"lastHash,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// runAudit implements "audit verify"
+func runAudit(ctx context.Context, cfg *config.Config, args []string) error {
+	const syntax = "audit verify [-file path] [-key pem]"
+	if len(args) == 0 || args[0] != "verify" {
+		return usageError(syntax)
+	}
+
+	var out output
+	var file, keyPath string
+	fs := newFlagSet("audit verify", &out)
+	fs.StringVar(&file, "file", "", "verify this audit log file instead of the configured audit log")
+	fs.StringVar(&keyPath, "key", cfg.Audit.SigningKey, "PEM certificate, public or private key the entries are signed with")
+	if positional, err := parseArgs(fs, args[1:]); err != nil {
+		return err
+	} else if len(positional) != 0 {
+		return usageError(syntax)
+	}
+
+	var key crypto.PublicKey
+	if keyPath != "" {
+		var err error
+		if key, err = audit.LoadPublicKey(keyPath); err != nil {
+			return err
+		}
+	}
+
+	var results []auditResult
+	verified := func(source string, v *audit.Verifier, err error) {
+		result := auditResult{Source: source}
+		if v != nil {
+			result.Entries = v.Entries
+			if v.Last != nil {
+				result.LastHash = v.Last.Hash
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	switch {
+	case file != "":
+		v, err := audit.VerifyFile(file, key)
+		verified(file, v, err)
+	case cfg.Audit.Store || cfg.Audit.File != "":
+		if cfg.Audit.Store {
+			store, err := openStore(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			v, err := audit.VerifyStore(ctx, store, key)
+			verified("store", v, err)
+		}
+		if cfg.Audit.File != "" {
+			v, err := audit.VerifyFile(cfg.Audit.File, key)
+			verified(cfg.Audit.File, v, err)
+		}
+	default:
+		return fmt.Errorf("no audit log configured (audit.store, audit.file)")
+	}
+
+	err := out.print(results, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "SOURCE\tENTRIES\tLAST HASH\tRESULT")
+		for _, r := range results {
+			result := "intact"
+			if r.Error != "" {
+				result = r.Error
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.Source, r.Entries, r.LastHash, result)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return fmt.Errorf("audit log %s is not intact", r.Source)
+		}
+	}
+	return nil
+}
+
+func describeCertificate(cert *x509.Certificate) caCertificate {
+	return caCertificate{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		Serial:         pki.SerialHex(cert.SerialNumber),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		SubjectKeyID:   hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyID: hex.EncodeToString(cert.AuthorityKeyId),
+		KeyAlgorithm:   cert.PublicKeyAlgorithm.String(),
+		IsCA:           cert.IsCA,
+	}
+}
+
+func certificateStatus(cert *types.Certificate) string {
+	switch {
+	case cert.Revoked:
+		return "revoked"
+	case cert.NotAfter.Before(time.Now()):
+		return "expired"
+	default:
+		return "valid"
+	}
+}
+
+func remaining(notAfter time.Time) string {
+	d := time.Until(notAfter)
+	if d < 0 {
+		return "expired"
+	}
+	return fmt.Sprintf("%d days left", int(d.Hours()/24))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatUnix(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return formatTime(time.Unix(sec, 0))
+}
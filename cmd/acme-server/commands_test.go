@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseArgsInterspersed(t *testing.T) {
+	var out output
+	var reason string
+	fs := newFlagSet("certs revoke", &out)
+	fs.StringVar(&reason, "reason", "unspecified", "")
+
+	positional, err := parseArgs(fs, []string{"cert_1", "--reason", "keyCompromise", "-o", "json"})
+	if err != nil {
+		t.Fatalf("parseArgs: %v", err)
+	}
+	if !slices.Equal(positional, []string{"cert_1"}) || reason != "keyCompromise" || !out.json {
+		t.Errorf("positional = %v, reason = %q, json = %v", positional, reason, out.json)
+	}
+
+	if _, err := parseArgs(newFlagSet("x", &out), []string{"-o", "yaml"}); !errors.Is(err, errUsage) {
+		t.Errorf("unknown output format: err = %v, want usage error", err)
+	}
+}
+
+func TestRenewalWindowFlags(t *testing.T) {
+	parse := func(args ...string) (*renewalWindowFlags, error) {
+		var out output
+		var f renewalWindowFlags
+		fs := newFlagSet("certs renew-window", &out)
+		f.register(fs)
+		_, err := parseArgs(fs, args)
+		return &f, err
+	}
+
+	f, err := parse("-start", "2030-01-01T00:00:00Z", "-end", "2030-01-02T00:00:00Z", "-explanation", "https://ops.plant.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	override, err := f.override("")
+	if err != nil || override.Window.End.Sub(override.Window.Start.Time) != 24*time.Hour || override.ExplanationURL != "https://ops.plant.example" {
+		t.Errorf("override = %+v, %v", override, err)
+	}
+
+	f, _ = parse("-now")
+	if override, err := f.override(""); err != nil || !override.Window.End.Before(time.Now()) {
+		t.Errorf("-now: override = %+v, %v, want a window in the past", override, err)
+	}
+	f, _ = parse("-clear")
+	if override, err := f.override(""); err != nil || override != nil {
+		t.Errorf("-clear: override = %+v, %v, want nil", override, err)
+	}
+	f, _ = parse("-clear", "-now")
+	if _, err := f.override(""); !errors.Is(err, errUsage) {
+		t.Errorf("-clear -now: err = %v, want usage error", err)
+	}
+	f, _ = parse("-start", "tomorrow", "-end", "2030-01-02T00:00:00Z")
+	if _, err := f.override(""); !errors.Is(err, errUsage) {
+		t.Errorf("invalid -start: err = %v, want usage error", err)
+	}
+}
@@ -2,26 +2,46 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	asl "github.com/Laboratory-for-Safe-and-Secure-Systems/go-asl"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/admin"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/router"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/caa"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/config"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/health"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/issuance"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/ratelimit"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/star"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/sqlite"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/sweeper"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
 )
 
-func initDatabase(ctx context.Context, cfg *config.Config) (*database.DB, error) {
-	log := logger.GetLogger(ctx)
-
-	dbConfig := &database.Config{
+// postgresConfig converts the database section of the configuration
+func postgresConfig(cfg *config.Config) database.Config {
+	return database.Config{
 		Host:     cfg.Database.Host,
 		Port:     cfg.Database.Port,
 		User:     cfg.Database.User,
@@ -29,14 +49,520 @@ func initDatabase(ctx context.Context, cfg *config.Config) (*database.DB, error)
 		DBName:   cfg.Database.DBName,
 		SSLMode:  cfg.Database.SSLMode,
 	}
+}
+
+// runMigrate implements the "migrate up|down|status" command
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	log := logger.GetLogger(ctx)
+
+	if len(args) != 1 {
+		return usageError("migrate up|down|status")
+	}
+
+	// Only the postgres schema is versioned by migrations
+	if backend := cfg.StorageBackend(); backend != storage.BackendPostgres {
+		return fmt.Errorf("migrate requires the postgres storage backend, the configured backend is %q", backend)
+	}
+
+	dbCfg := postgresConfig(cfg)
+	db, err := database.New(&dbCfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		for _, m := range applied {
+			log.Infof("Applied migration %03d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Info("Database schema is up to date")
+		}
+	case "down":
+		reverted, err := db.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			log.Info("No migration to roll back")
+			return nil
+		}
+		log.Infof("Rolled back migration %03d_%s", reverted.Version, reverted.Name)
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%03d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return usageError("migrate up|down|status")
+	}
+	return nil
+}
+
+func initStorage(ctx context.Context, cfg *config.Config) (storage.Store, error) {
+	log := logger.GetLogger(ctx)
+
+	backend := cfg.StorageBackend()
+	store, err := storage.Open(storage.Config{
+		Backend:    backend,
+		Postgres:   postgresConfig(cfg),
+		SQLitePath: cfg.Storage.SQLite.Path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s storage: %w", backend, err)
+	}
+
+	if db, ok := store.(*database.DB); ok && cfg.Database.AutoMigrate {
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		for _, m := range applied {
+			log.Infof("Applied migration %03d_%s", m.Version, m.Name)
+		}
+	}
+
+	if backend == storage.BackendMemory {
+		log.Info("Using in-memory storage, all data is lost on shutdown")
+	}
+	log.Infow("Storage initialized", "backend", backend)
+	return store, nil
+}
+
+// initNonceStore selects where replay nonces are kept. Deployments with
+// several replicas on PostgreSQL need the shared database store.
+func initNonceStore(ctx context.Context, cfg *config.Config, store storage.Store) (acme.NonceStore, error) {
+	kind := cfg.Nonce.Store
+	if kind == "" {
+		kind = acme.NonceStoreMemory
+		if cfg.StorageBackend() == storage.BackendPostgres {
+			kind = acme.NonceStoreDatabase
+		}
+	}
+
+	nonceCfg := acme.NonceConfig{Store: kind}
+	if cfg.Nonce.HMACKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.Nonce.HMACKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nonce HMAC key: %w", err)
+		}
+		nonceCfg.HMACKey = key
+	}
+
+	nonces, err := acme.NewNonceStore(nonceCfg, store)
+	if err != nil {
+		return nil, err
+	}
+	logger.GetLogger(ctx).Infow("Nonce store initialized", "store", kind)
+	return nonces, nil
+}
+
+// initRateLimiter creates the rate limiter of the ACME endpoints, or nil if
+// rate limits are disabled. Its buckets are kept in the store, so replicas
+// on PostgreSQL share them.
+func initRateLimiter(ctx context.Context, cfg *config.Config, store storage.Store) (*ratelimit.Limiter, error) {
+	if cfg.RateLimits.Disabled {
+		logger.GetLogger(ctx).Info("Rate limits are disabled")
+		return nil, nil
+	}
+
+	limits := ratelimit.DefaultLimits
+	for _, l := range []struct {
+		name  string
+		cfg   config.RateLimit
+		limit *ratelimit.Limit
+	}{
+		{"new_accounts_per_ip", cfg.RateLimits.NewAccountsPerIP, &limits.NewAccountsPerIP},
+		{"new_orders_per_account", cfg.RateLimits.NewOrdersPerAccount, &limits.NewOrdersPerAccount},
+		{"finalizations_per_account", cfg.RateLimits.FinalizationsPerAccount, &limits.FinalizationsPerAccount},
+		{"failed_validations", cfg.RateLimits.FailedValidations, &limits.FailedValidations},
+		{"certificates_per_identifier", cfg.RateLimits.CertificatesPerIdentifier, &limits.CertificatesPerIdentifier},
+	} {
+		if l.cfg.Count < 0 {
+			*l.limit = ratelimit.Limit{}
+			continue
+		}
+		if l.cfg.Count > 0 {
+			l.limit.Count = l.cfg.Count
+		}
+		if l.cfg.Period != "" {
+			period, err := time.ParseDuration(l.cfg.Period)
+			if err != nil || period <= 0 {
+				return nil, fmt.Errorf("invalid period of rate limit %s: %q", l.name, l.cfg.Period)
+			}
+			l.limit.Period = period
+		}
+	}
+
+	documentation := cfg.RateLimits.Documentation
+	if documentation == "" {
+		documentation = ratelimit.DefaultDocumentation
+	}
+	logger.GetLogger(ctx).Info("Rate limiter initialized")
+	return ratelimit.New(store, limits, documentation), nil
+}
+
+// initCAAChecker creates the checker of the CAA records of dns identifiers.
+// It is used by the tenants that have CAA identities.
+func initCAAChecker(ctx context.Context, cfg *config.Config) (*caa.Checker, error) {
+	var timeout time.Duration
+	if cfg.CAA.Timeout != "" {
+		d, err := time.ParseDuration(cfg.CAA.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CAA timeout %q", cfg.CAA.Timeout)
+		}
+		timeout = d
+	}
+	recheckAfter := caa.DefaultRecheckAfter
+	if cfg.CAA.RecheckAfter != "" {
+		d, err := time.ParseDuration(cfg.CAA.RecheckAfter)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CAA recheck interval %q", cfg.CAA.RecheckAfter)
+		}
+		recheckAfter = d
+	}
+
+	resolver := caa.NewDNSResolver(cfg.CAA.Resolver, timeout)
+	logger.GetLogger(ctx).Infow("CAA checker initialized", "resolver", resolver.Server, "recheck_after", recheckAfter.String())
+	return &caa.Checker{Resolver: resolver, RecheckAfter: recheckAfter}, nil
+}
+
+// initAuditLog opens the audit log, or returns nil if neither the store nor
+// a file is configured for it
+func initAuditLog(ctx context.Context, cfg *config.Config, store storage.Store) (*audit.Log, error) {
+	if !cfg.Audit.Store && cfg.Audit.File == "" {
+		return nil, nil
+	}
+
+	auditCfg := audit.Config{File: cfg.Audit.File}
+	if cfg.Audit.Store {
+		auditCfg.Store = store
+	}
+	if cfg.Audit.SigningKey != "" {
+		key, err := pki.LoadPrivateKey(cfg.Audit.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load audit signing key: %w", err)
+		}
+		auditCfg.Signer = key
+	}
+
+	auditLog, err := audit.Open(auditCfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.GetLogger(ctx).Infow("Audit log initialized",
+		"store", cfg.Audit.Store,
+		"file", cfg.Audit.File,
+		"signed", auditCfg.Signer != nil,
+	)
+	return auditLog, nil
+}
+
+// initWebhooks creates the webhook notifier, or returns nil if no endpoint
+// is configured. The notifier queues events in the store; only the server
+// starts its delivery worker.
+func initWebhooks(ctx context.Context, cfg *config.Config, store storage.Store) (*webhook.Notifier, error) {
+	if len(cfg.Webhooks.Endpoints) == 0 {
+		return nil, nil
+	}
+
+	webhookCfg := webhook.Config{MaxAttempts: cfg.Webhooks.MaxAttempts}
+	if cfg.Webhooks.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Webhooks.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook interval: %w", err)
+		}
+		webhookCfg.Interval = interval
+	}
+	if cfg.Webhooks.ExpiryWarning != "" {
+		warning, err := time.ParseDuration(cfg.Webhooks.ExpiryWarning)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook expiry warning: %w", err)
+		}
+		webhookCfg.ExpiryWarning = warning
+	}
+	for _, ep := range cfg.Webhooks.Endpoints {
+		secret, err := webhook.ParseSecret(ep.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("webhook endpoint %s: %w", ep.Name, err)
+		}
+		endpoint := webhook.Endpoint{Name: ep.Name, URL: ep.URL, Secret: secret, Events: ep.Events}
+		if ep.Timeout != "" {
+			if endpoint.Timeout, err = time.ParseDuration(ep.Timeout); err != nil {
+				return nil, fmt.Errorf("webhook endpoint %s: invalid timeout: %w", ep.Name, err)
+			}
+		}
+		webhookCfg.Endpoints = append(webhookCfg.Endpoints, endpoint)
+	}
+
+	return webhook.New(store, webhookCfg, logger.GetLogger(ctx)), nil
+}
+
+// initMetrics registers the metrics that are read from the storage backend
+// and the nonce store at scrape time
+func initMetrics(store storage.Store, nonces acme.NonceStore) {
+	switch db := store.(type) {
+	case *database.DB:
+		metrics.RegisterDB(db.DB, storage.BackendPostgres)
+	case *sqlite.DB:
+		metrics.RegisterDB(db.DB, storage.BackendSQLite)
+	}
+
+	metrics.RegisterNonceStore(func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		size, err := nonces.Size(ctx)
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(size)
+	})
+}
+
+// startMetricsServer serves /metrics on a separate admin listener
+func startMetricsServer(ctx context.Context, addr string) *http.Server {
+	log := logger.GetLogger(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+
+	log.Infof("Metrics server started on %s", addr)
+	return srv
+}
+
+// defaultCAExpiryHorizon is used when no CA expiry horizon is configured
+const defaultCAExpiryHorizon = 30 * 24 * time.Hour
+
+// initReadiness registers the dependency checks behind /readyz
+func initReadiness(cfg *config.Config, store storage.Store, nonces acme.NonceStore, tenants *tenant.Registry) (*health.Checker, error) {
+	var timeout time.Duration
+	if cfg.Health.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Health.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid health check timeout: %w", err)
+		}
+	}
+	horizon := defaultCAExpiryHorizon
+	if cfg.Health.CAExpiryHorizon != "" {
+		var err error
+		if horizon, err = time.ParseDuration(cfg.Health.CAExpiryHorizon); err != nil {
+			return nil, fmt.Errorf("invalid CA expiry horizon: %w", err)
+		}
+	}
+
+	checker := health.NewChecker(timeout)
+	checker.Register("database", health.PingCheck(store))
+	if db, ok := store.(*database.DB); ok {
+		checker.Register("migrations", health.MigrationCheck(db))
+	}
+	checker.Register("nonce_store", health.NonceStoreCheck(nonces))
+	// The CAs are read from the tenants on every check, so that CAs
+	// replaced by a reload are checked
+	for _, t := range tenants.All() {
+		name, ca := "", func() *pki.CA { return t.Settings().CA }
+		if t.Name != tenant.DefaultName {
+			name = "_" + t.Name
+		}
+		checker.Register("ca_key"+name, health.CAKeyCheck(ca))
+		checker.Register("ca_certificate"+name, health.CACertificateCheck(ca, horizon))
+	}
+
+	return checker, nil
+}
+
+// adminPathPrefix returns where the admin API is mounted
+func adminPathPrefix(cfg *config.Config) string {
+	if cfg.Admin.PathPrefix != "" {
+		return "/" + strings.Trim(cfg.Admin.PathPrefix, "/")
+	}
+	return admin.DefaultPathPrefix
+}
+
+// acmePathPrefix returns where the ACME API is mounted, "" for the root
+func acmePathPrefix(cfg *config.Config) string {
+	if prefix := strings.Trim(cfg.ACME.PathPrefix, "/"); prefix != "" {
+		return "/" + prefix
+	}
+	return ""
+}
+
+// externalURLs parses the configured external base URLs
+func externalURLs(cfg *config.Config) ([]*url.URL, error) {
+	var urls []*url.URL
+	for _, raw := range cfg.ACME.ExternalURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external URL: %w", err)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// accountURL returns the account URLs of the canonical external URL, or nil
+// without external URLs, since URLs are then derived from each request
+func accountURL(external []*url.URL) func(t *tenant.Tenant, accountID string) string {
+	if len(external) == 0 {
+		return nil
+	}
+	base := strings.TrimSuffix(external[0].String(), "/")
+	return func(t *tenant.Tenant, accountID string) string {
+		return base + t.PathPrefix + "/account/" + accountID
+	}
+}
+
+// listenerConfig returns the server configuration of listener l
+func listenerConfig(cfg *config.Config, l config.Listener, log *logger.Logger) *server.Config {
+	clientAuth := l.ClientAuth
+	if clientAuth == "" && cfg.Endpoint.MutualAuthentication {
+		clientAuth = "require"
+	}
+	roots := cfg.TLS.ClientCAs
+	if len(roots) == 0 && cfg.CA.Certs != "" {
+		roots = []string{cfg.CA.Certs}
+	}
 
-	db, err := database.New(dbConfig)
+	return &server.Config{
+		Logger:           log,
+		Address:          l.ListenAddr,
+		Mode:             server.Mode(l.Mode),
+		Certificates:     cfg.TLS.Certs,
+		PrivateKey:       cfg.TLS.PrivateKey,
+		RootCertificates: roots,
+		ClientAuth:       clientAuthType(clientAuth),
+		ASL: server.ASLOptions{
+			NoEncryption:      cfg.Endpoint.NoEncryption,
+			KeyExchangeMethod: cfg.Endpoint.ASLKeyExchangeMethod,
+			KeylogFile:        cfg.Endpoint.KeylogFile,
+			PKCS11Path:        cfg.PKCS11.EntityModule.Path,
+			PKCS11Pin:         cfg.PKCS11.EntityModule.Pin,
+		},
+	}
+}
+
+func clientAuthType(policy string) tls.ClientAuthType {
+	switch policy {
+	case "request":
+		return tls.VerifyClientCertIfGiven
+	case "require":
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// adminListener returns the separate admin listener, which requires client
+// certificates
+func adminListener(cfg *config.Config) config.Listener {
+	return config.Listener{Mode: cfg.Admin.Mode, ListenAddr: cfg.Admin.ListenAddr, ClientAuth: "require"}
+}
+
+// adminListenerConfig configures the separate admin listener. Client
+// certificates are verified against admin.client_cas only, never against
+// the issuing CA.
+func adminListenerConfig(cfg *config.Config, log *logger.Logger) *server.Config {
+	conf := listenerConfig(cfg, adminListener(cfg), log)
+	conf.RootCertificates = cfg.Admin.ClientCAs
+	return conf
+}
+
+// adminClientCAs loads the CAs that issue admin client certificates
+func adminClientCAs(cfg *config.Config) ([]*x509.Certificate, error) {
+	var cas []*x509.Certificate
+	for _, path := range cfg.Admin.ClientCAs {
+		certs, err := pki.LoadCertificates(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading admin client CAs: %w", err)
+		}
+		cas = append(cas, certs...)
+	}
+	return cas, nil
+}
+
+// usesASL reports whether any listener serves through ASL
+func usesASL(cfg *config.Config) bool {
+	listeners := cfg.ServerListeners()
+	if cfg.Admin.ListenAddr != "" {
+		listeners = append(listeners, adminListener(cfg))
+	}
+	for _, l := range listeners {
+		if l.Mode == "" || server.Mode(l.Mode) == server.ModeASL {
+			return true
+		}
+	}
+	return false
+}
+
+// startServer serves requests on srv in the background
+func startServer(log *logger.Logger, srv *server.Server, name string) {
+	go func() {
+		if err := srv.Run(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("%s failed: %v", name, err)
+		}
+	}()
+	log.Infof("%s started on %s (%s)", name, srv.Addr, srv.Mode())
+}
+
+// startAdminServer serves the admin API on a separate listener
+func startAdminServer(ctx context.Context, cfg *config.Config, handler http.Handler) (*server.Server, error) {
+	log := logger.GetLogger(ctx)
+
+	prefix := adminPathPrefix(cfg)
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+
+	srv, err := server.New(adminListenerConfig(cfg, log), mux, ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+		return nil, err
+	}
+	startServer(log, srv, "Admin server")
+	return srv, nil
+}
+
+func initSweeper(ctx context.Context, cfg *config.Config, store storage.Store) (*sweeper.Sweeper, error) {
+	log := logger.GetLogger(ctx)
+
+	var sweeperConfig sweeper.Config
+	if cfg.Sweeper.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Sweeper.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweeper interval: %w", err)
+		}
+		sweeperConfig.Interval = interval
+	}
+	if cfg.Sweeper.Retention != "" {
+		retention, err := time.ParseDuration(cfg.Sweeper.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweeper retention: %w", err)
+		}
+		sweeperConfig.Retention = retention
 	}
 
-	log.Info("Database connection established")
-	return db, nil
+	return sweeper.New(store, sweeperConfig, log), nil
 }
 
 func main() {
@@ -52,81 +578,239 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize database if config is provided
-	var db *database.DB
-	if config.GlobalFlags.DBConfig != "" {
-		dbCfg, err := config.Load(config.GlobalFlags.DBConfig, &config.Config{})
-		if err != nil {
-			log.Errorf("Failed to load database configuration: %v", err)
+	// Subcommands run instead of the server. They log to stderr so that
+	// their output can be piped.
+	if args := config.GetNonFlagArgs(); len(args) > 0 {
+		run, ok := commands[args[0]]
+		if !ok {
+			log.Errorf("Unknown command %q", args[0])
+			os.Exit(2)
+		}
+		log = logger.New(os.Stderr)
+		ctx = context.WithValue(ctx, types.CtxKeyLogger, log)
+		if err := run(ctx, cfg, args[1:]); err != nil {
+			log.Errorf("%s failed: %v", args[0], err)
+			if errors.Is(err, errUsage) {
+				os.Exit(2)
+			}
 			os.Exit(1)
 		}
-		cfg.Database = dbCfg.Database
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Errorf("Invalid configuration:\n%v", err)
+		os.Exit(1)
+	}
+
+	settings, err := loadTenantSettings(cfg)
+	if err != nil {
+		log.Errorf("Failed to load CA: %v", err)
+		os.Exit(1)
 	}
+	tenants, err := newTenantRegistry(cfg, settings)
+	if err != nil {
+		log.Errorf("Failed to configure tenants: %v", err)
+		os.Exit(1)
+	}
+
+	store, err := initStorage(ctx, cfg)
+	if err != nil {
+		log.Errorf("Failed to initialize storage: %v", err)
+		os.Exit(1)
+	}
+	defer store.Close()
 
-	if cfg.Database.Host != "" {
-		db, err = initDatabase(ctx, cfg)
+	auditLog, err := initAuditLog(ctx, cfg, store)
+	if err != nil {
+		log.Errorf("Failed to initialize audit log: %v", err)
+		os.Exit(1)
+	}
+	defer auditLog.Close()
+
+	// Start the webhook delivery worker
+	webhooks, err := initWebhooks(ctx, cfg, store)
+	if err != nil {
+		log.Errorf("Failed to initialize webhooks: %v", err)
+		os.Exit(1)
+	}
+	if webhooks != nil {
+		webhooks.Start(ctx)
+	}
+
+	// Start the background expiry sweeper
+	var sw *sweeper.Sweeper
+	if !cfg.Sweeper.Disabled {
+		sw, err = initSweeper(ctx, cfg, store)
 		if err != nil {
-			log.Errorf("Failed to initialize database: %v", err)
+			log.Errorf("Failed to initialize expiry sweeper: %v", err)
 			os.Exit(1)
 		}
-		defer db.Close()
+		sw.Start(ctx)
+	}
+
+	caaChecker, err := initCAAChecker(ctx, cfg)
+	if err != nil {
+		log.Errorf("Failed to initialize CAA checker: %v", err)
+		os.Exit(1)
+	}
+
+	external, err := externalURLs(cfg)
+	if err != nil {
+		log.Errorf("Failed to configure external URLs: %v", err)
+		os.Exit(1)
+	}
+
+	// Start the STAR certificate renewer
+	var renewer *star.Renewer
+	if !cfg.STAR.Disabled {
+		var interval time.Duration
+		if cfg.STAR.Interval != "" {
+			interval, err = time.ParseDuration(cfg.STAR.Interval)
+			if err != nil {
+				log.Errorf("Invalid STAR renewal interval: %v", err)
+				os.Exit(1)
+			}
+		}
+		renewer = star.NewRenewer(store, tenants, star.Config{
+			Interval:   interval,
+			Audit:      auditLog,
+			Webhooks:   webhooks,
+			CAA:        caaChecker,
+			AccountURL: accountURL(external),
+		}, log)
+		renewer.Start(ctx)
 	}
 
-	r := router.New(ctx, db)
+	// Start the certificate issuance workers
+	pool := issuance.NewPool(store, tenants, issuance.Config{
+		Workers:   cfg.Issuance.Workers,
+		QueueSize: cfg.Issuance.QueueSize,
+		Audit:     auditLog,
+		Webhooks:  webhooks,
+	}, log)
+	pool.Start(ctx)
 
-	libConfig := &asl.ASLConfig{
-		LoggingEnabled: cfg.ASLConfig.LoggingEnabled,
-		LogLevel:       int32(cfg.ASLConfig.LogLevel),
+	nonces, err := initNonceStore(ctx, cfg, store)
+	if err != nil {
+		log.Errorf("Failed to initialize nonce store: %v", err)
+		os.Exit(1)
 	}
-	err = asl.ASLinit(libConfig)
+	cleanupCtx, stopCleanup := context.WithCancel(ctx)
+	go acme.RunNonceCleanup(cleanupCtx, nonces, 5*time.Minute, log)
+
+	limiter, err := initRateLimiter(ctx, cfg, store)
 	if err != nil {
-		log.Errorf("Error initializing ASL: %v", err)
+		log.Errorf("Failed to initialize rate limiter: %v", err)
+		os.Exit(1)
 	}
 
-	// Configure ASL endpoint
-	endpointConfig := &asl.EndpointConfig{
-		MutualAuthentication: cfg.Endpoint.MutualAuthentication,
-		NoEncryption:         cfg.Endpoint.NoEncryption,
-		ASLKeyExchangeMethod: asl.ASLKeyExchangeMethod(cfg.Endpoint.ASLKeyExchangeMethod),
-		PreSharedKey: asl.PreSharedKey{
-			Enable: false,
-		},
-		DeviceCertificateChain: asl.DeviceCertificateChain{Path: cfg.TLS.Certs},
-		PrivateKey: asl.PrivateKey{
-			Path: cfg.TLS.PrivateKey,
-		},
-		RootCertificate: asl.RootCertificate{Path: cfg.CA.Certs},
-		KeylogFile:      cfg.Endpoint.KeylogFile,
-		PKCS11: asl.PKCS11ASL{
-			Path: cfg.PKCS11.EntityModule.Path,
-			Pin:  cfg.PKCS11.EntityModule.Pin,
-		},
+	initMetrics(store, nonces)
+	var metricsSrv *http.Server
+	if !cfg.Metrics.Disabled && cfg.Metrics.ListenAddr != "" {
+		metricsSrv = startMetricsServer(ctx, cfg.Metrics.ListenAddr)
+	}
+
+	readiness, err := initReadiness(cfg, store, nonces, tenants)
+	if err != nil {
+		log.Errorf("Failed to initialize readiness checks: %v", err)
+		os.Exit(1)
 	}
 
-	// Create server configuration
-	serverConfig := &server.ASLServerConfig{
-		Logger:         log,
-		Address:        cfg.Server.ListenAddr,
-		EndpointConfig: endpointConfig,
+	adminClientCAs, err := adminClientCAs(cfg)
+	if err != nil {
+		log.Errorf("Failed to initialize the admin API: %v", err)
+		os.Exit(1)
+	}
+	adminCfg := admin.Config{
+		Store:     store,
+		Logger:    log,
+		Tokens:    cfg.Admin.Tokens,
+		ClientCNs: cfg.Admin.ClientCNs,
+		ClientCAs: adminClientCAs,
+		Tenants:   tenants,
+		Audit:     auditLog,
+		Webhooks:  webhooks,
+	}
+	var adminHandler *admin.Handler
+	if adminCfg.Enabled() {
+		adminHandler = admin.NewHandler(adminCfg)
 	}
 
-	// Create and start server
-	srv, err := server.ASLServer(serverConfig, r, ctx)
+	trustedProxies, err := router.ParseTrustedProxies(cfg.Server.TrustedProxies)
 	if err != nil {
-		log.Errorf("Failed to create server: %v", err)
+		log.Errorf("Failed to configure trusted proxies: %v", err)
+		os.Exit(1)
+	}
+
+	routerCfg := router.Config{
+		Store:          store,
+		Issuance:       pool,
+		Nonces:         nonces,
+		RateLimiter:    limiter,
+		CAA:            caaChecker,
+		Audit:          auditLog,
+		Webhooks:       webhooks,
+		Metrics:        !cfg.Metrics.Disabled && cfg.Metrics.ListenAddr == "",
+		Readiness:      readiness,
+		ExternalURLs:   external,
+		PathPrefix:     acmePathPrefix(cfg),
+		Tenants:        tenants,
+		TrustedProxies: trustedProxies,
+	}
+	if adminHandler != nil && cfg.Admin.ListenAddr == "" {
+		routerCfg.Admin = adminHandler
+		routerCfg.AdminPrefix = adminPathPrefix(cfg)
+	}
+	r := router.New(ctx, routerCfg)
+
+	if usesASL(cfg) {
+		if err := server.InitASL(cfg.ASLConfig.LoggingEnabled, cfg.ASLConfig.LogLevel); err != nil {
+			log.Errorf("Error initializing ASL: %v", err)
+		}
+	}
+
+	// Create the ACME servers
+	listeners := cfg.ServerListeners()
+	var servers []*server.Server
+	for _, l := range listeners {
+		srv, err := server.New(listenerConfig(cfg, l, log), r, ctx)
+		if err != nil {
+			log.Errorf("Failed to create server on %s: %v", l.ListenAddr, err)
+			os.Exit(1)
+		}
+		servers = append(servers, srv)
+	}
+
+	var adminSrv *server.Server
+	if adminHandler != nil && cfg.Admin.ListenAddr != "" {
+		adminSrv, err = startAdminServer(ctx, cfg, adminHandler)
+		if err != nil {
+			log.Errorf("Failed to start admin server: %v", err)
+			os.Exit(1)
+		}
 	}
 
 	// Handle graceful shutdown
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		if err := srv.ListenAndServeASL(); err != nil && err != http.ErrServerClosed {
-			log.Errorf("Failed to start server: %v", err)
-		}
-	}()
+	for _, srv := range servers {
+		startServer(log, srv, "Server")
+	}
 
-	log.Infof("Server started on %s", serverConfig.Address)
+	// Reload certificates and configuration on SIGHUP
+	reloadCtx, stopReload := context.WithCancel(ctx)
+	defer stopReload()
+	go (&reloader{
+		cfg:       cfg,
+		log:       log,
+		listeners: listeners,
+		servers:   servers,
+		adminSrv:  adminSrv,
+		admin:     adminHandler,
+		tenants:   tenants,
+	}).run(reloadCtx)
 
 	// Wait for interrupt signal
 	<-done
@@ -136,9 +820,33 @@ func main() {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Errorf("Server shutdown failed: %v", err)
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("Server shutdown failed: %v", err)
+		}
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Errorf("Admin server shutdown failed: %v", err)
+		}
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Errorf("Metrics server shutdown failed: %v", err)
+		}
+	}
+
+	if sw != nil {
+		sw.Stop()
+	}
+	if renewer != nil {
+		renewer.Stop()
+	}
+	pool.Stop()
+	if webhooks != nil {
+		webhooks.Stop()
 	}
+	stopCleanup()
 
 	log.Info("Server stopped gracefully")
 }
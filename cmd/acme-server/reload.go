@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/admin"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/config"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+)
+
+// reloader applies a new configuration to the running server on SIGHUP. It
+// re-reads the configuration files, the CA chains, keys and profiles of the
+// tenants and the server certificate and key. Every step that can fail runs
+// before anything is replaced, so a failed reload leaves the previous state
+// in place.
+type reloader struct {
+	mu sync.Mutex
+	// cfg is the configuration the server was started with
+	cfg *config.Config
+	log *logger.Logger
+
+	// listeners are the definitions the servers were started with; changes
+	// to them require a restart
+	listeners []config.Listener
+	servers   []*server.Server
+	adminSrv  *server.Server // nil without a separate admin listener
+	admin     *admin.Handler // nil if the admin API is disabled
+	// tenants are mounted at startup; only their settings are replaced
+	tenants *tenant.Registry
+}
+
+// run reloads on every SIGHUP until ctx is done
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.log.Info("Reloading configuration")
+			if err := r.reload(); err != nil {
+				r.log.Errorf("Reload failed, keeping the previous configuration: %v", err)
+				continue
+			}
+			r.log.Info("Configuration reloaded")
+		}
+	}
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Reload()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	settings, err := loadTenantSettings(cfg)
+	if err != nil {
+		return err
+	}
+
+	adminClientCAs, err := adminClientCAs(cfg)
+	if err != nil {
+		return err
+	}
+
+	var reloads []*server.Reload
+	prepare := func(srv *server.Server, conf *server.Config) error {
+		reload, err := srv.PrepareReload(conf)
+		if err != nil {
+			for _, reload := range reloads {
+				reload.Discard()
+			}
+			return fmt.Errorf("error loading server certificate for %s: %w", srv.Addr, err)
+		}
+		reloads = append(reloads, reload)
+		return nil
+	}
+	for i, srv := range r.servers {
+		if err := prepare(srv, listenerConfig(cfg, r.listeners[i], r.log)); err != nil {
+			return err
+		}
+	}
+	if r.adminSrv != nil {
+		if err := prepare(r.adminSrv, adminListenerConfig(cfg, r.log)); err != nil {
+			return err
+		}
+	}
+
+	// Nothing below can fail
+	for _, t := range r.tenants.All() {
+		if s, ok := settings[t.Name]; ok {
+			t.SetSettings(s)
+		}
+	}
+	for _, reload := range reloads {
+		reload.Apply()
+	}
+	if r.admin != nil {
+		r.admin.SetCredentials(cfg.Admin.Tokens, cfg.Admin.ClientCNs, adminClientCAs)
+	} else if len(cfg.Admin.Tokens) > 0 || len(cfg.Admin.ClientCNs) > 0 {
+		r.log.Info("Enabling the admin API takes effect after a restart")
+	}
+
+	for _, name := range restartRequired(r.cfg, cfg) {
+		r.log.Infof("Changes to %s take effect after a restart", name)
+	}
+	return nil
+}
+
+// restartRequired lists the configuration sections that differ between the
+// startup configuration old and new but are only read at startup
+func restartRequired(old, new *config.Config) []string {
+	// Profiles, the EAB requirement, the policy and the CAA identities are
+	// part of the tenant settings
+	oldACME, newACME := old.ACME, new.ACME
+	oldACME.Profiles, newACME.Profiles = nil, nil
+	oldACME.ExternalAccountRequired, newACME.ExternalAccountRequired = false, false
+	oldACME.Policy, newACME.Policy = config.Policy{}, config.Policy{}
+	oldACME.CAAIdentities, newACME.CAAIdentities = nil, nil
+
+	var changed []string
+	for _, section := range []struct {
+		name     string
+		old, new any
+	}{
+		{"server", old.Server, new.Server},
+		{"acme", oldACME, newACME},
+		{"tenants", tenantPaths(old), tenantPaths(new)},
+		{"asl_config", old.ASLConfig, new.ASLConfig},
+		{"database", old.Database, new.Database},
+		{"storage", old.Storage, new.Storage},
+		{"nonce", old.Nonce, new.Nonce},
+		{"rate_limits", old.RateLimits, new.RateLimits},
+		{"caa", old.CAA, new.CAA},
+		{"audit", old.Audit, new.Audit},
+		{"webhooks", old.Webhooks, new.Webhooks},
+		{"metrics", old.Metrics, new.Metrics},
+		{"admin.listen_addr", old.Admin.ListenAddr, new.Admin.ListenAddr},
+		{"admin.mode", old.Admin.Mode, new.Admin.Mode},
+		{"admin.path_prefix", old.Admin.PathPrefix, new.Admin.PathPrefix},
+		{"health", old.Health, new.Health},
+		{"sweeper", old.Sweeper, new.Sweeper},
+		{"issuance", old.Issuance, new.Issuance},
+		{"star", old.STAR, new.STAR},
+	} {
+		if !reflect.DeepEqual(section.old, section.new) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/config"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+)
+
+// writeKeyPair writes a self-signed certificate for cn and its key to
+// certPath and keyPath
+func writeKeyPair(t *testing.T, certPath, keyPath, cn string, isCA bool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadFailureKeepsState(t *testing.T) {
+	log := logger.New(io.Discard)
+	ctx := context.WithValue(context.Background(), types.CtxKeyLogger, log)
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	writeKeyPair(t, path("ca.pem"), path("ca.key"), "Plant CA", true)
+	writeKeyPair(t, path("ot.pem"), path("ot.key"), "OT CA", true)
+	writeKeyPair(t, path("server.pem"), path("server.key"), "first", false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	raw, _ := json.Marshal(map[string]any{
+		"storage": map[string]any{"backend": "memory"},
+		"ca":      map[string]any{"certificates": path("ca.pem"), "private_key": path("ca.key")},
+		"tls":     map[string]any{"certificates": path("server.pem"), "private_key": path("server.key")},
+		"server":  map[string]any{"listeners": []any{map[string]any{"mode": "tls", "listen_addr": addr}}},
+		"tenants": []any{map[string]any{"name": "ot", "ca": map[string]any{"certificates": path("ot.pem"), "private_key": path("ot.key")}}},
+	})
+	if err := os.WriteFile(path("config.json"), raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { config.GlobalFlags.ConfigPath = old }(config.GlobalFlags.ConfigPath)
+	config.GlobalFlags.ConfigPath = path("config.json")
+
+	cfg, err := config.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := loadTenantSettings(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := newTenantRegistry(cfg, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(listenerConfig(cfg, cfg.Server.Listeners[0], log), http.NotFoundHandler(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	t.Cleanup(func() { srv.Shutdown(ctx) })
+
+	r := &reloader{cfg: cfg, log: log, listeners: cfg.Server.Listeners, servers: []*server.Server{srv}, tenants: tenants}
+	ot, _ := tenants.Get("ot")
+	def, _ := tenants.Get("")
+	before, defBefore := ot.Settings(), def.Settings()
+
+	// servedCN returns the subject of the server certificate on a fresh
+	// connection
+	servedCN := func() string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	// A new server certificate together with a broken tenant CA: nothing
+	// is replaced
+	writeKeyPair(t, path("server.pem"), path("server.key"), "second", false)
+	if err := os.WriteFile(path("ot.pem"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("reload accepted an unreadable tenant CA")
+	}
+	if ot.Settings() != before || def.Settings() != defBefore {
+		t.Error("failed reload replaced tenant settings")
+	}
+	if cn := servedCN(); cn != "first" {
+		t.Errorf("served %q after a failed reload, want first", cn)
+	}
+
+	// Once the tenant CA is fixed, the reload applies everything
+	writeKeyPair(t, path("ot.pem"), path("ot.key"), "OT CA 2", true)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if ot.Settings() == before || ot.Settings().CA.Certificate().Subject.CommonName != "OT CA 2" {
+		t.Error("reload did not replace the tenant CA")
+	}
+	if cn := servedCN(); cn != "second" {
+		t.Errorf("served %q after reload, want second", cn)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/config"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/ct"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+)
+
+// loadTenantSettings loads the CA and profiles of the default tenant and of
+// every configured tenant, keyed by tenant name. All tenants submit to the
+// configured CT logs.
+func loadTenantSettings(cfg *config.Config) (map[string]*tenant.Settings, error) {
+	settings := make(map[string]*tenant.Settings)
+	logs, err := newCTSubmitter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newTenantSettings(defaultTenantConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	settings[tenant.DefaultName] = s
+
+	for _, t := range cfg.Tenants {
+		s, err := newTenantSettings(t)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		settings[t.Name] = s
+	}
+	for _, s := range settings {
+		s.CT = logs
+	}
+	return settings, nil
+}
+
+// newCTSubmitter creates the submitter for the configured CT logs, or nil
+// without logs
+func newCTSubmitter(cfg *config.Config) (pki.Transparency, error) {
+	if len(cfg.CT.Logs) == 0 {
+		return nil, nil
+	}
+	var logs []ct.Log
+	for _, l := range cfg.CT.Logs {
+		log := ct.Log{Name: l.Name, URL: l.URL}
+		if l.PublicKey != "" {
+			der, err := base64.StdEncoding.DecodeString(l.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("CT log %s: invalid public key: %w", l.Name, err)
+			}
+			if log.PublicKey, err = ct.ParsePublicKey(der); err != nil {
+				return nil, fmt.Errorf("CT log %s: %w", l.Name, err)
+			}
+		}
+		if l.Timeout != "" {
+			timeout, err := time.ParseDuration(l.Timeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("CT log %s: invalid timeout %q", l.Name, l.Timeout)
+			}
+			log.Timeout = timeout
+		}
+		logs = append(logs, log)
+	}
+	minSCTs := cfg.CT.MinSCTs
+	if minSCTs == 0 {
+		minSCTs = 1
+	}
+	return ct.New(logs, minSCTs), nil
+}
+
+// defaultTenantConfig returns the default tenant as configured by ca.* and
+// acme.*
+func defaultTenantConfig(cfg *config.Config) config.Tenant {
+	t := config.Tenant{
+		Name:                    tenant.DefaultName,
+		Profiles:                cfg.ACME.Profiles,
+		ExternalAccountRequired: cfg.ACME.ExternalAccountRequired,
+		Policy:                  cfg.ACME.Policy,
+		CAAIdentities:           cfg.ACME.CAAIdentities,
+	}
+	t.CA.Certs, t.CA.PrivateKey = cfg.CA.Certs, cfg.CA.PrivateKey
+	return t
+}
+
+func newTenantSettings(t config.Tenant) (*tenant.Settings, error) {
+	ca, err := pki.LoadCA(t.CA.Certs, t.CA.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading CA: %w", err)
+	}
+
+	settings := &tenant.Settings{CA: ca, ExternalAccountRequired: t.ExternalAccountRequired}
+	for _, id := range t.CAAIdentities {
+		settings.CAAIdentities = append(settings.CAAIdentities, strings.ToLower(strings.TrimSuffix(id, ".")))
+	}
+	for _, p := range t.Profiles {
+		profile, err := newProfile(p)
+		if err != nil {
+			return nil, err
+		}
+		settings.Profiles = append(settings.Profiles, profile)
+	}
+	if settings.Policy, err = newPolicy(t.Policy); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// newPolicy converts a configured identifier policy
+func newPolicy(p config.Policy) (*policy.Policy, error) {
+	allow, err := policy.ParseRules(p.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("policy allow: %w", err)
+	}
+	deny, err := policy.ParseRules(p.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("policy deny: %w", err)
+	}
+	return &policy.Policy{Allow: allow, Deny: deny, MaxIdentifiers: p.MaxIdentifiers}, nil
+}
+
+// newProfile converts a configured profile. Unset fields are taken from
+// the default profile.
+func newProfile(p config.Profile) (*pki.Profile, error) {
+	profile := &pki.Profile{
+		Name:        p.Name,
+		Description: p.Description,
+		Validity:    pki.DefaultProfile.Validity,
+		ExtKeyUsage: pki.DefaultProfile.ExtKeyUsage,
+	}
+	if p.Validity != "" {
+		validity, err := time.ParseDuration(p.Validity)
+		if err != nil || validity <= 0 {
+			return nil, fmt.Errorf("profile %s: invalid validity %q", p.Name, p.Validity)
+		}
+		profile.Validity = validity
+	}
+	if len(p.ExtKeyUsage) > 0 {
+		profile.ExtKeyUsage = nil
+		for _, name := range p.ExtKeyUsage {
+			usage, err := pki.ParseExtKeyUsage(name)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			profile.ExtKeyUsage = append(profile.ExtKeyUsage, usage)
+		}
+	}
+	return profile, nil
+}
+
+// newTenantRegistry creates the tenants of cfg with the loaded settings
+func newTenantRegistry(cfg *config.Config, settings map[string]*tenant.Settings) (*tenant.Registry, error) {
+	tenants := []*tenant.Tenant{tenant.New(tenant.DefaultName, "", settings[tenant.DefaultName])}
+	for _, t := range cfg.Tenants {
+		tenants = append(tenants, tenant.New(t.Name, t.Path(), settings[t.Name]))
+	}
+	return tenant.NewRegistry(tenants...)
+}
+
+// tenantPaths maps the names of the configured tenants to their paths
+func tenantPaths(cfg *config.Config) map[string]string {
+	paths := make(map[string]string)
+	for _, t := range cfg.Tenants {
+		paths[t.Name] = t.Path()
+	}
+	return paths
+}
@@ -0,0 +1,510 @@
+package admin
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+// DefaultPathPrefix is where the admin API is mounted
+const DefaultPathPrefix = "/admin"
+
+// Config configures the admin API
+type Config struct {
+	Store  storage.Store
+	Logger *logger.Logger
+
+	// Tokens are the accepted bearer tokens
+	Tokens []string
+
+	// ClientCNs are the subject common names of client certificates that
+	// are accepted when the admin API is reached through mutually
+	// authenticated ASL or TLS
+	ClientCNs []string
+	// ClientCAs issue the accepted client certificates. Without them no
+	// client certificate is accepted.
+	ClientCAs []*x509.Certificate
+
+	// Tenants are the tenants EAB keys can be created for. Without them
+	// only the default tenant is known.
+	Tenants *tenant.Registry
+
+	// Audit records revocations; nil disables auditing
+	Audit *audit.Log
+	// Webhooks announces revocations; nil disables webhooks
+	Webhooks *webhook.Notifier
+}
+
+// Enabled reports whether any credential is configured. Without one the
+// admin API is not served.
+func (c Config) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.ClientCNs) > 0
+}
+
+type api struct {
+	store    storage.Store
+	log      *logger.Logger
+	tenants  *tenant.Registry
+	audit    *audit.Log
+	webhooks *webhook.Notifier
+}
+
+// NewHandler returns the admin API. Routes are relative to the mount point.
+func NewHandler(cfg Config) *Handler {
+	a := &api{store: cfg.Store, log: cfg.Logger, tenants: cfg.Tenants, audit: cfg.Audit, webhooks: cfg.Webhooks}
+	auth := newAuthenticator(cfg)
+
+	r := chi.NewRouter()
+	r.Use(auth.middleware)
+
+	r.Get("/accounts", a.listAccounts)
+	r.Get("/accounts/{id}", a.getAccount)
+	r.Post("/accounts/{id}/deactivate", a.deactivateAccount)
+	r.Put("/accounts/{id}/scope", a.setAccountScope)
+
+	r.Get("/orders", a.listOrders)
+	r.Get("/orders/{id}", a.getOrder)
+
+	r.Get("/certificates", a.listCertificates)
+	r.Get("/certificates/{id}", a.getCertificate)
+	r.Post("/certificates/{id}/revoke", a.revokeCertificate)
+	r.Post("/certificates/revoke", a.revokeCertificateBySerial)
+	r.Put("/certificates/{id}/renewal-window", a.setCertificateRenewalWindow)
+	r.Delete("/certificates/{id}/renewal-window", a.deleteCertificateRenewalWindow)
+
+	r.Put("/issuers/{aki}/renewal-window", a.setIssuerRenewalWindow)
+	r.Delete("/issuers/{aki}/renewal-window", a.deleteIssuerRenewalWindow)
+
+	r.Get("/challenges/pending", a.listPendingChallenges)
+
+	r.Get("/eab-keys", a.listEABKeys)
+	r.Post("/eab-keys", a.createEABKey)
+	r.Delete("/eab-keys/{id}", a.deleteEABKey)
+
+	return &Handler{Handler: r, auth: auth}
+}
+
+// Handler serves the admin API
+type Handler struct {
+	http.Handler
+	auth *authenticator
+}
+
+// SetCredentials replaces the accepted bearer tokens, client certificate
+// common names and client CAs, e.g. after a configuration reload
+func (h *Handler) SetCredentials(tokens []string, clientCNs []string, clientCAs []*x509.Certificate) {
+	h.auth.setCredentials(tokens, clientCNs, clientCAs)
+}
+
+// orderDetail is an order together with its authorizations and challenges
+type orderDetail struct {
+	*types.Order
+	Authorizations []*types.Authorization `json:"authorizations"`
+}
+
+// pendingChallenge exposes the authorization of a challenge
+type pendingChallenge struct {
+	types.Challenge
+	AuthorizationID string `json:"authorizationId"`
+}
+
+// revokeRequest is the body of the revocation endpoints. Serial and
+// AuthorityKeyID are only used when revoking by serial.
+type revokeRequest struct {
+	Serial         string `json:"serial"`
+	AuthorityKeyID string `json:"authorityKeyId"`
+	Reason         string `json:"reason"` // RFC 5280 reason name
+}
+
+// renewalWindowRequest is the body of the renewal window endpoints. RenewNow
+// asks clients to renew right away instead of within Start and End.
+type renewalWindowRequest struct {
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	RenewNow       bool      `json:"renewNow"`
+	ExplanationURL string    `json:"explanationURL"`
+}
+
+// newEABKey is returned once when an EAB key is created
+type newEABKey struct {
+	*types.EABKey
+	HMACKey string `json:"hmacKey"` // base64url, as expected by ACME clients
+}
+
+func (a *api) listAccounts(w http.ResponseWriter, r *http.Request) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	accounts, err := a.store.ListAccounts(r.Context(), filter)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(accounts))
+}
+
+func (a *api) getAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := GetAccount(r.Context(), a.store, chi.URLParam(r, "id"))
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (a *api) deactivateAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := DeactivateAccount(r.Context(), a.store, chi.URLParam(r, "id"))
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Account deactivated by operator", "account", account.ID)
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (a *api) setAccountScope(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scope []string `json:"scope"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	account, err := SetAccountScope(r.Context(), a.store, chi.URLParam(r, "id"), req.Scope)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Account scope set by operator", "account", account.ID, "scope", account.Scope)
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (a *api) listOrders(w http.ResponseWriter, r *http.Request) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	ids, err := a.store.ListOrderIDs(r.Context(), filter)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+
+	orders := make([]*types.Order, 0, len(ids))
+	for _, id := range ids {
+		order, err := a.store.GetOrder(r.Context(), id)
+		if err != nil {
+			a.fail(w, err)
+			return
+		}
+		orders = append(orders, order)
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (a *api) getOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := a.store.GetOrder(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	authzs, err := a.store.GetAuthorizationsByOrder(r.Context(), order.ID)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	for _, authz := range authzs {
+		if authz.Challenges, err = a.store.GetChallengesByAuthorization(r.Context(), authz.ID); err != nil {
+			a.fail(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, orderDetail{Order: order, Authorizations: nonNil(authzs)})
+}
+
+func (a *api) listCertificates(w http.ResponseWriter, r *http.Request) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	if filter.Serial != "" {
+		serial, err := NormalizeSerial(filter.Serial)
+		if err != nil {
+			writeError(w, badRequest(err.Error()))
+			return
+		}
+		filter.Serial = serial
+	}
+	certs, err := a.store.ListCertificates(r.Context(), filter)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(certs))
+}
+
+func (a *api) getCertificate(w http.ResponseWriter, r *http.Request) {
+	cert, err := a.store.GetCertificate(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cert)
+}
+
+func (a *api) revokeCertificate(w http.ResponseWriter, r *http.Request) {
+	var req revokeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	a.revoke(w, r, chi.URLParam(r, "id"), req.Reason)
+}
+
+func (a *api) revokeCertificateBySerial(w http.ResponseWriter, r *http.Request) {
+	var req revokeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Serial == "" {
+		writeError(w, badRequest("serial is required"))
+		return
+	}
+	cert, err := FindCertificateBySerial(r.Context(), a.store, req.Serial, req.AuthorityKeyID)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.revoke(w, r, cert.ID, req.Reason)
+}
+
+func (a *api) revoke(w http.ResponseWriter, r *http.Request, id string, reason string) {
+	cert, err := RevokeCertificate(r.Context(), a.store, a.audit, a.webhooks, id, reason)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Certificate revoked by operator", "certificate", cert.ID, "serial", cert.Serial, "reason", cert.RevocationReason)
+	writeJSON(w, http.StatusOK, cert)
+}
+
+// renewalOverride reads the body of a renewal window request
+func (a *api) renewalOverride(w http.ResponseWriter, r *http.Request) (*types.RenewalOverride, bool) {
+	var req renewalWindowRequest
+	if !decodeJSON(w, r, &req) {
+		return nil, false
+	}
+	override, err := NewRenewalOverride(req.Start, req.End, req.RenewNow, req.ExplanationURL)
+	if err != nil {
+		a.fail(w, err)
+		return nil, false
+	}
+	return override, true
+}
+
+func (a *api) setCertificateRenewalWindow(w http.ResponseWriter, r *http.Request) {
+	override, ok := a.renewalOverride(w, r)
+	if !ok {
+		return
+	}
+	cert, err := SetCertificateRenewalWindow(r.Context(), a.store, chi.URLParam(r, "id"), override)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Certificate renewal window set by operator", "certificate", cert.ID, "start", override.Window.Start, "end", override.Window.End)
+	writeJSON(w, http.StatusOK, types.RenewalInfo{SuggestedWindow: override.Window, ExplanationURL: override.ExplanationURL})
+}
+
+func (a *api) deleteCertificateRenewalWindow(w http.ResponseWriter, r *http.Request) {
+	cert, err := SetCertificateRenewalWindow(r.Context(), a.store, chi.URLParam(r, "id"), nil)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Certificate renewal window removed by operator", "certificate", cert.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) setIssuerRenewalWindow(w http.ResponseWriter, r *http.Request) {
+	override, ok := a.renewalOverride(w, r)
+	if !ok {
+		return
+	}
+	aki, err := SetIssuerRenewalWindow(r.Context(), a.store, chi.URLParam(r, "aki"), override)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Issuer renewal window set by operator", "aki", aki, "start", override.Window.Start, "end", override.Window.End)
+	writeJSON(w, http.StatusOK, types.RenewalInfo{SuggestedWindow: override.Window, ExplanationURL: override.ExplanationURL})
+}
+
+func (a *api) deleteIssuerRenewalWindow(w http.ResponseWriter, r *http.Request) {
+	aki, err := SetIssuerRenewalWindow(r.Context(), a.store, chi.URLParam(r, "aki"), nil)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("Issuer renewal window removed by operator", "aki", aki)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *api) listPendingChallenges(w http.ResponseWriter, r *http.Request) {
+	filter, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	challenges, err := a.store.ListPendingChallenges(r.Context(), filter)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+
+	pending := make([]pendingChallenge, 0, len(challenges))
+	for _, c := range challenges {
+		pending = append(pending, pendingChallenge{Challenge: c, AuthorizationID: c.AuthorizationID})
+	}
+	writeJSON(w, http.StatusOK, pending)
+}
+
+func (a *api) listEABKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.store.ListEABKeys(r.Context())
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(keys))
+}
+
+func (a *api) createEABKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Label  string   `json:"label"`
+		Tenant string   `json:"tenant"`
+		Scope  []string `json:"scope"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	if !a.knownTenant(req.Tenant) {
+		writeError(w, badRequest(fmt.Sprintf("unknown tenant %q", req.Tenant)))
+		return
+	}
+	key, err := CreateEABKey(r.Context(), a.store, req.Tenant, req.Label, req.Scope)
+	if err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("EAB key created by operator", "kid", key.ID, "label", key.Label, "tenant", key.Tenant)
+	writeJSON(w, http.StatusCreated, newEABKey{
+		EABKey:  key,
+		HMACKey: base64.RawURLEncoding.EncodeToString(key.HMACKey),
+	})
+}
+
+// knownTenant reports whether the named tenant is configured
+func (a *api) knownTenant(name string) bool {
+	if a.tenants == nil {
+		return name == tenant.DefaultName
+	}
+	_, ok := a.tenants.Get(name)
+	return ok
+}
+
+func (a *api) deleteEABKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := a.store.DeleteEABKey(r.Context(), id); err != nil {
+		a.fail(w, err)
+		return
+	}
+	a.log.Infow("EAB key deleted by operator", "kid", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fail writes problems returned by the store as they are and hides other
+// errors behind a generic internal error
+func (a *api) fail(w http.ResponseWriter, err error) {
+	var problem *types.Problem
+	if errors.As(err, &problem) {
+		writeError(w, problem)
+		return
+	}
+	a.log.Errorf("Admin request failed: %v", err)
+	writeError(w, &types.Problem{
+		Type:   "urn:ietf:params:acme:error:serverInternal",
+		Detail: "internal error",
+		Status: http.StatusInternalServerError,
+	})
+}
+
+// listFilter reads the filter of a list endpoint from the query string
+func listFilter(w http.ResponseWriter, r *http.Request) (types.ListFilter, bool) {
+	q := r.URL.Query()
+	filter := types.ListFilter{
+		AccountID: q.Get("account"),
+		Status:    q.Get("status"),
+		Query:     q.Get("q"),
+		Serial:    q.Get("serial"),
+	}
+
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, badRequest(fmt.Sprintf("invalid %s %q", name, v)))
+				return filter, false
+			}
+			*dst = n
+		}
+	}
+	return filter, true
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		writeError(w, badRequest(fmt.Sprintf("invalid request body: %v", err)))
+		return false
+	}
+	return true
+}
+
+// nonNil makes empty lists encode as [] instead of null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func badRequest(detail string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:malformed",
+		Detail: detail,
+		Status: http.StatusBadRequest,
+	}
+}
+
+func writeError(w http.ResponseWriter, problem *types.Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
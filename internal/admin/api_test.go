@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestRevokeBySerial(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+
+	store.CreateAccount(ctx, &types.Account{ID: "acct_1", Status: types.AccountStatusValid})
+	store.CreateOrder(ctx, &types.Order{ID: "order_1", AccountID: "acct_1", Status: types.OrderStatusValid}, nil)
+	store.CreateCertificate(ctx, &types.Certificate{
+		ID:             "cert_1",
+		OrderID:        "order_1",
+		Serial:         "0a1b",
+		AuthorityKeyID: "aa",
+		NotAfter:       types.Time{Time: time.Now().Add(time.Hour)},
+	})
+
+	handler := NewHandler(Config{Store: store, Logger: logger.New(io.Discard), Tokens: []string{"s3cret"}})
+	do := func(token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/certificates/revoke", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"serial": "0A:1B", "reason": "keyCompromise"}`
+	for _, token := range []string{"", "wrong"} {
+		if rec := do(token, body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, rec.Code)
+		}
+	}
+	if rec := do("s3cret", `{"serial": "0a1b", "reason": "bogus"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown reason: status = %d, want 400", rec.Code)
+	}
+
+	rec := do("s3cret", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var cert types.Certificate
+	if err := json.Unmarshal(rec.Body.Bytes(), &cert); err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Revoked || cert.RevocationReason != "keyCompromise" {
+		t.Errorf("revoked certificate = %+v", cert)
+	}
+
+	var problem types.Problem
+	rec = do("s3cret", body)
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	if rec.Code != http.StatusBadRequest || problem.Type != "urn:ietf:params:acme:error:alreadyRevoked" {
+		t.Errorf("second revoke = %d %+v, want alreadyRevoked", rec.Code, problem)
+	}
+}
+
+func TestRenewalWindow(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+
+	store.CreateAccount(ctx, &types.Account{ID: "acct_1", Status: types.AccountStatusValid})
+	store.CreateOrder(ctx, &types.Order{ID: "order_1", AccountID: "acct_1", Status: types.OrderStatusValid}, nil)
+	cert := &types.Certificate{ID: "cert_1", OrderID: "order_1", Serial: "01", AuthorityKeyID: "a1b2", NotAfter: types.Time{Time: time.Now().Add(time.Hour)}}
+	store.CreateCertificate(ctx, cert)
+
+	handler := NewHandler(Config{Store: store, Logger: logger.New(io.Discard), Tokens: []string{"s3cret"}})
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The whole fleet of an issuer is asked to renew right away
+	if rec := do(http.MethodPut, "/issuers/A1:B2/renewal-window", `{"renewNow": true, "explanationURL": "https://ops.plant.example/ca-rollover"}`); rec.Code != http.StatusOK {
+		t.Fatalf("issuer window: status = %d, body %s", rec.Code, rec.Body)
+	}
+	override, err := store.GetRenewalOverride(ctx, cert)
+	if err != nil || override == nil || !override.Window.End.Before(time.Now()) || override.ExplanationURL == "" {
+		t.Fatalf("override after issuer window = %+v, %v, want a window in the past", override, err)
+	}
+
+	// A certificate window takes precedence over the issuer window
+	start := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	body := `{"start": "` + start.Format(time.RFC3339) + `", "end": "` + start.Add(time.Hour).Format(time.RFC3339) + `"}`
+	if rec := do(http.MethodPut, "/certificates/cert_1/renewal-window", body); rec.Code != http.StatusOK {
+		t.Fatalf("certificate window: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if override, err := store.GetRenewalOverride(ctx, cert); err != nil || !override.Window.Start.Equal(start) {
+		t.Errorf("override after certificate window = %+v, %v, want start %v", override, err, start)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/certificates/cert_1/renewal-window", `{"start": "2030-01-02T00:00:00Z", "end": "2030-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{http.MethodPut, "/certificates/cert_1/renewal-window", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/certificates/cert_2/renewal-window", `{"renewNow": true}`, http.StatusNotFound},
+		{http.MethodPut, "/issuers/xyz/renewal-window", `{"renewNow": true}`, http.StatusBadRequest},
+		{http.MethodDelete, "/certificates/cert_1/renewal-window", "", http.StatusNoContent},
+		{http.MethodDelete, "/issuers/a1b2/renewal-window", "", http.StatusNoContent},
+	} {
+		if rec := do(tc.method, tc.path, tc.body); rec.Code != tc.status {
+			t.Errorf("%s %s %s: status = %d, want %d", tc.method, tc.path, tc.body, rec.Code, tc.status)
+		}
+	}
+	if override, err := store.GetRenewalOverride(ctx, cert); err != nil || override != nil {
+		t.Errorf("override after removal = %+v, %v, want none", override, err)
+	}
+}
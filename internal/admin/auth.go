@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+)
+
+// authenticator accepts bearer tokens and client certificates presented
+// through ASL or TLS
+type authenticator struct {
+	creds atomic.Pointer[credentials]
+	log   *logger.Logger
+}
+
+// credentials are replaced as a whole when the configuration is reloaded
+type credentials struct {
+	tokens    [][sha256.Size]byte
+	clientCNs []string
+	clientCAs *x509.CertPool // nil accepts no client certificate
+}
+
+func newAuthenticator(cfg Config) *authenticator {
+	a := &authenticator{log: cfg.Logger}
+	a.setCredentials(cfg.Tokens, cfg.ClientCNs, cfg.ClientCAs)
+	return a
+}
+
+func (a *authenticator) setCredentials(tokens []string, clientCNs []string, clientCAs []*x509.Certificate) {
+	c := &credentials{clientCNs: slices.Clone(clientCNs)}
+	for _, token := range tokens {
+		c.tokens = append(c.tokens, sha256.Sum256([]byte(token)))
+	}
+	if len(clientCAs) > 0 {
+		c.clientCAs = x509.NewCertPool()
+		for _, ca := range clientCAs {
+			c.clientCAs.AddCert(ca)
+		}
+	}
+	a.creds.Store(c)
+}
+
+// certificatePrincipal returns the operator of a client certificate chain
+// whose common name is listed and that was issued under the admin client
+// CAs. The chain is verified here even though the listener verified it:
+// listeners also accept certificates of the ACME CA, which must not grant
+// admin access.
+func (c *credentials) certificatePrincipal(chain []*x509.Certificate) (string, bool) {
+	if len(chain) == 0 || c.clientCAs == nil {
+		return "", false
+	}
+	leaf := chain[0]
+	if !slices.Contains(c.clientCNs, leaf.Subject.CommonName) {
+		return "", false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return "", false
+	}
+	return "cert:" + leaf.Issuer.CommonName + "/" + leaf.Subject.CommonName, true
+}
+
+// principal identifies the operator behind a request. It returns false if
+// the request is not authenticated.
+func (a *authenticator) principal(r *http.Request) (string, bool) {
+	creds := a.creds.Load()
+	if state, ok := r.Context().Value(server.TLSStateKey).(*tls.ConnectionState); ok && state != nil {
+		if principal, ok := creds.certificatePrincipal(state.PeerCertificates); ok {
+			return principal, true
+		}
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	// Compare digests so that neither the token length nor its content
+	// leaks through timing
+	digest := sha256.Sum256([]byte(token))
+	match := 0
+	for i := range creds.tokens {
+		match |= subtle.ConstantTimeCompare(digest[:], creds.tokens[i][:])
+	}
+	if match == 0 {
+		return "", false
+	}
+	return "token", true
+}
+
+// middleware rejects unauthenticated requests and logs every admin request
+// with its principal
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := a.principal(r)
+		if !ok {
+			a.log.Infow("Rejected admin request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="acme-admin"`)
+			writeError(w, &types.Problem{
+				Type:   "urn:ietf:params:acme:error:unauthorized",
+				Detail: "admin credentials required",
+				Status: http.StatusUnauthorized,
+			})
+			return
+		}
+
+		a.log.Infow("Admin request", "principal", principal, "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
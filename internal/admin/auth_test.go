@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+)
+
+// newCertificate returns a certificate for cn signed by parent, or a
+// self-signed CA certificate if parent is nil
+func newCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	adminCA, adminKey := newCertificate(t, "Admin CA", nil, nil)
+	acmeCA, acmeKey := newCertificate(t, "ACME CA", nil, nil)
+	operator, _ := newCertificate(t, "ops", adminCA, adminKey)
+	other, _ := newCertificate(t, "intern", adminCA, adminKey)
+	// Issued by the ACME CA to a client that chose the listed name
+	impostor, _ := newCertificate(t, "ops", acmeCA, acmeKey)
+
+	a := newAuthenticator(Config{Logger: logger.New(io.Discard), ClientCNs: []string{"ops"}, ClientCAs: []*x509.Certificate{adminCA}})
+	principal := func(cert *x509.Certificate) (string, bool) {
+		req := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+		state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return a.principal(req.WithContext(context.WithValue(req.Context(), server.TLSStateKey, state)))
+	}
+
+	if p, ok := principal(operator); !ok || p != "cert:Admin CA/ops" {
+		t.Errorf("listed certificate of the admin CA: principal = %q, %v", p, ok)
+	}
+	if _, ok := principal(other); ok {
+		t.Error("accepted a certificate of the admin CA whose name is not listed")
+	}
+	if _, ok := principal(impostor); ok {
+		t.Error("accepted a listed name in a certificate of another CA")
+	}
+
+	// Without admin client CAs no certificate is accepted
+	a.setCredentials(nil, []string{"ops"}, nil)
+	if _, ok := principal(operator); ok {
+		t.Error("accepted a client certificate without admin client CAs")
+	}
+}
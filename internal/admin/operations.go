@@ -0,0 +1,301 @@
+// Package admin implements the operator tooling: an authenticated REST API
+// and the operations behind it, which the acme-server subcommands reuse.
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+// eabKeySize is the length of generated EAB HMAC keys in bytes
+const eabKeySize = 32
+
+// NormalizeSerial converts a serial number as printed by common tools, e.g.
+// "0A:1B:2C" or "0x0a1b2c", to the hex encoding used by the store
+func NormalizeSerial(serial string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(serial))
+	s = strings.TrimPrefix(s, "0x")
+	s = strings.ReplaceAll(s, ":", "")
+
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || s == "" {
+		return "", fmt.Errorf("invalid serial number %q", serial)
+	}
+	return pki.SerialHex(n), nil
+}
+
+// GetAccount returns an account, including deactivated ones
+func GetAccount(ctx context.Context, store storage.Store, id string) (*types.Account, error) {
+	accounts, err := store.ListAccounts(ctx, types.ListFilter{AccountID: id, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:accountDoesNotExist",
+			Detail: fmt.Sprintf("account %s does not exist", id),
+			Status: http.StatusNotFound,
+		}
+	}
+	return accounts[0], nil
+}
+
+// DeactivateAccount deactivates an account. The account can no longer sign
+// requests; its certificates stay valid until they are revoked.
+func DeactivateAccount(ctx context.Context, store storage.Store, id string) (*types.Account, error) {
+	account, err := GetAccount(ctx, store, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status == types.AccountStatusDeactivated {
+		return account, nil
+	}
+
+	account.Status = types.AccountStatusDeactivated
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// SetAccountScope restricts the identifiers an account may order to the
+// given domain suffixes and IP ranges. An empty scope lifts the restriction.
+func SetAccountScope(ctx context.Context, store storage.Store, id string, scope []string) (*types.Account, error) {
+	if err := checkScope(scope); err != nil {
+		return nil, err
+	}
+	account, err := GetAccount(ctx, store, id)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Scope = scope
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// checkScope returns a problem if an identifier scope does not parse
+func checkScope(scope []string) error {
+	if _, err := policy.ParseRules(scope); err != nil {
+		return &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// FindCertificateBySerial returns the certificate with the given serial
+// number. The authority key identifier is only needed if certificates of
+// several issuers share the serial.
+func FindCertificateBySerial(ctx context.Context, store storage.Store, serial string, aki string) (*types.Certificate, error) {
+	serial, err := NormalizeSerial(serial)
+	if err != nil {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	certs, err := store.ListCertificates(ctx, types.ListFilter{Serial: serial})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*types.Certificate
+	for _, cert := range certs {
+		if aki == "" || strings.EqualFold(cert.AuthorityKeyID, aki) {
+			matches = append(matches, cert)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: fmt.Sprintf("certificate with serial %s does not exist", serial),
+			Status: http.StatusNotFound,
+		}
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: fmt.Sprintf("%d certificates of different issuers have serial %s, specify the authority key identifier", len(matches), serial),
+			Status: http.StatusConflict,
+		}
+	}
+}
+
+// RevokeCertificate revokes a certificate with an RFC 5280 reason name,
+// records the revocation in auditLog and announces it through webhooks;
+// both may be nil
+func RevokeCertificate(ctx context.Context, store storage.Store, auditLog *audit.Log, webhooks *webhook.Notifier, id string, reasonName string) (*types.Certificate, error) {
+	reason, err := types.ParseRevocationReason(reasonName)
+	if err != nil {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:badRevocationReason",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	if err := store.RevokeCertificate(ctx, id, reason.String(), time.Now()); err != nil {
+		return nil, err
+	}
+	metrics.CertificatesRevoked.Inc()
+	cert, err := store.GetCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tenant and account of the certificate are those of its order
+	var tenant, accountID string
+	if order, err := store.GetOrder(ctx, cert.OrderID); err == nil {
+		tenant, accountID = order.Tenant, order.AccountID
+	}
+	var errs []error
+	err = auditLog.Record(ctx, audit.EventCertificateRevoked, tenant, accountID, audit.CertificateRevoked{
+		Order:          cert.OrderID,
+		Certificate:    cert.ID,
+		Serial:         cert.Serial,
+		AuthorityKeyID: cert.AuthorityKeyID,
+		Reason:         cert.RevocationReason,
+		By:             "operator",
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("certificate revoked, but not recorded in the audit log: %w", err))
+	}
+	err = webhooks.Notify(ctx, webhook.EventCertificateRevoked, tenant, accountID, webhook.CertificateRevoked{
+		Order:          cert.OrderID,
+		Certificate:    cert.ID,
+		Serial:         cert.Serial,
+		AuthorityKeyID: cert.AuthorityKeyID,
+		Reason:         cert.RevocationReason,
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("certificate revoked, but not announced to the webhook endpoints: %w", err))
+	}
+	return cert, errors.Join(errs...)
+}
+
+// NewRenewalOverride validates an operator-defined renewal window (RFC 9773
+// Section 4.2). renewNow replaces start and end by a window that has already
+// passed, which asks clients to renew right away.
+func NewRenewalOverride(start, end time.Time, renewNow bool, explanationURL string) (*types.RenewalOverride, error) {
+	if renewNow {
+		if !start.IsZero() || !end.IsZero() {
+			return nil, badRequest("a renewal window cannot both start now and have a start and end")
+		}
+		start, end = pki.ImmediateRenewalWindow(time.Now())
+	}
+	if start.IsZero() || end.IsZero() {
+		return nil, badRequest("the start and end of the renewal window are required")
+	}
+	if !end.After(start) {
+		return nil, badRequest("the renewal window must end after it starts")
+	}
+	if explanationURL != "" {
+		u, err := url.Parse(explanationURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, badRequest(fmt.Sprintf("invalid explanation URL %q", explanationURL))
+		}
+	}
+	return &types.RenewalOverride{
+		Window: types.RenewalWindow{
+			Start: types.Time{Time: start.UTC()},
+			End:   types.Time{Time: end.UTC()},
+		},
+		ExplanationURL: explanationURL,
+	}, nil
+}
+
+// SetCertificateRenewalWindow overrides the renewal window ARI suggests for
+// a certificate. A nil override restores the computed window.
+func SetCertificateRenewalWindow(ctx context.Context, store storage.Store, id string, override *types.RenewalOverride) (*types.Certificate, error) {
+	cert, err := store.GetCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.SetCertificateRenewalWindow(ctx, cert.ID, override); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// SetIssuerRenewalWindow overrides the renewal window ARI suggests for every
+// certificate of the issuer with the hex authority key identifier aki, e.g.
+// to have the whole fleet renew after a CA compromise. A window set on a
+// single certificate still takes precedence. A nil override removes the
+// issuer window. It returns the normalized key identifier.
+func SetIssuerRenewalWindow(ctx context.Context, store storage.Store, aki string, override *types.RenewalOverride) (string, error) {
+	keyID, err := NormalizeKeyID(aki)
+	if err != nil {
+		return "", badRequest(err.Error())
+	}
+	if err := store.SetIssuerRenewalWindow(ctx, keyID, override); err != nil {
+		return "", err
+	}
+	return keyID, nil
+}
+
+// NormalizeKeyID converts a key identifier as printed by common tools, e.g.
+// "A1:B2:C3", to the lower case hex encoding used by the store
+func NormalizeKeyID(keyID string) (string, error) {
+	s := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(keyID), ":", ""))
+	if _, err := hex.DecodeString(s); err != nil || s == "" {
+		return "", fmt.Errorf("invalid key identifier %q", keyID)
+	}
+	return s, nil
+}
+
+// CreateEABKey generates and stores a new External Account Binding key for
+// accounts of the named tenant. The bound account inherits the identifier
+// scope.
+func CreateEABKey(ctx context.Context, store storage.Store, tenant string, label string, scope []string) (*types.EABKey, error) {
+	if err := checkScope(scope); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	hmacKey := make([]byte, eabKeySize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("error generating EAB key ID: %w", err)
+	}
+	if _, err := rand.Read(hmacKey); err != nil {
+		return nil, fmt.Errorf("error generating EAB key: %w", err)
+	}
+
+	key := &types.EABKey{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		HMACKey:   hmacKey,
+		Label:     label,
+		Tenant:    tenant,
+		Scope:     scope,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := store.CreateEABKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
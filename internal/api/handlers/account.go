@@ -2,19 +2,26 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
 	"github.com/go-chi/chi/v5"
 )
 
 func NewAccount(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogger(r.Context())
-	db := r.Context().Value(types.CtxKeyDB).(*database.DB)
+	store, ok := getStore(r)
+	if !ok {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
 	baseURL := getBaseURL(r)
 
 	// Get the protected header from context
@@ -47,6 +54,27 @@ func NewAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Limit account creation per client address before any EAB key is tried
+	if !checkRateLimit(w, r, getRateLimiter(r).NewAccount(r.Context(), r.RemoteAddr)) {
+		return
+	}
+
+	// Bind the account to an external account if given or required
+	t := getTenant(r)
+	var eabKey *types.EABKey
+	if len(req.ExternalAccountBinding) > 0 {
+		var problem *types.Problem
+		eabKey, problem = verifyExternalAccountBinding(r, store, t.Name, req.ExternalAccountBinding, protected)
+		if problem != nil {
+			log.Errorf("External account binding rejected: %s", problem.Detail)
+			writeError(w, problem)
+			return
+		}
+	} else if t.Settings().ExternalAccountRequired {
+		writeError(w, newExternalAccountRequiredError())
+		return
+	}
+
 	// Generate a unique account ID
 	accountID := generateID("acct")
 
@@ -68,15 +96,44 @@ func NewAccount(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:            time.Now().Unix(),
 		InitialIP:            r.RemoteAddr,
 		OrdersURL:            endpointURL(baseURL, "orders", accountID),
+		Tenant:               t.Name,
+	}
+	// The account inherits the identifier scope of its EAB key
+	if eabKey != nil {
+		account.EABKeyID = eabKey.ID
+		account.Scope = eabKey.Scope
 	}
 
-	// Store account in database
-	if err := db.CreateAccount(r.Context(), account); err != nil {
+	// Store account in database. A concurrent request may have bound the
+	// EAB key in the meantime.
+	if err := store.CreateAccount(r.Context(), account); err != nil {
 		log.Errorf("Failed to create account: %v", err)
+		var problem *types.Problem
+		if errors.As(err, &problem) {
+			writeError(w, problem)
+			return
+		}
 		writeError(w, newInternalServerError("Failed to create account"))
 		return
 	}
 
+	thumbprint, err := audit.KeyThumbprint(jwkJSON)
+	if err != nil {
+		log.Errorf("Failed to compute account key thumbprint: %v", err)
+	}
+	recordAudit(r, audit.EventAccountCreated, account.ID, audit.AccountCreated{
+		Contact:       account.Contact,
+		KeyThumbprint: thumbprint,
+		InitialIP:     account.InitialIP,
+		EABKeyID:      account.EABKeyID,
+		Scope:         account.Scope,
+	})
+	notify(r, webhook.EventAccountCreated, account.ID, webhook.Account{
+		Status:   string(account.Status),
+		Contact:  account.Contact,
+		EABKeyID: account.EABKeyID,
+	})
+
 	// Set response headers with correct account URL format
 	accountURL := endpointURL(baseURL, "account", account.ID)
 	w.Header().Set("Location", accountURL)
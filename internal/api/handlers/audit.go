@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+// getAuditLog returns the audit log attached to the request context, or nil
+// without auditing
+func getAuditLog(r *http.Request) *audit.Log {
+	log, _ := r.Context().Value(types.CtxKeyAudit).(*audit.Log)
+	return log
+}
+
+// recordAudit appends an event of the tenant of the request to the audit
+// log. The request has already taken effect, so failures are only logged.
+func recordAudit(r *http.Request, event, accountID string, data any) {
+	if err := getAuditLog(r).Record(r.Context(), event, getTenant(r).Name, accountID, data); err != nil {
+		logger.GetLogger(r.Context()).Errorf("Failed to record %s in the audit log: %v", event, err)
+	}
+}
+
+// validationEvidence collects what a validation of the challenge by the
+// account was decided on
+func validationEvidence(r *http.Request, store storage.Store, accountID string, challenge *types.Challenge, identifier types.Identifier) audit.Evidence {
+	evidence := audit.Evidence{RemoteAddr: r.RemoteAddr}
+	if identifier.Type == "dns" && getCAAChecker(r) != nil {
+		evidence.CAAIdentities = getTenant(r).Settings().CAAIdentities
+	}
+
+	account, err := store.GetAccount(r.Context(), accountID)
+	if err != nil {
+		logger.GetLogger(r.Context()).Errorf("Failed to get account %s: %v", accountID, err)
+		return evidence
+	}
+	if thumbprint, err := audit.KeyThumbprint(account.Key); err == nil {
+		evidence.KeyAuthorization = challenge.Token + "." + thumbprint
+	}
+	return evidence
+}
@@ -1,30 +1,41 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"path"
 	"time"
 
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
 	"github.com/go-chi/chi/v5"
 )
 
 // GetAuthorization retrieves an authorization from the database if available.
-// Otherwise it falls back to a mock authorization.
+// Otherwise it falls back to a mock authorization. A POST carrying a
+// {"status":"deactivated"} payload deactivates the authorization instead.
 func GetAuthorization(w http.ResponseWriter, r *http.Request) {
 	authzID := chi.URLParam(r, "id")
 	log := logger.GetLogger(r.Context())
 	baseURL := getBaseURL(r)
 
 	// Try to retrieve the authorization from the database.
-	db, ok := r.Context().Value(types.CtxKeyDB).(*database.DB)
+	store, ok := getStore(r)
 	var authz *types.Authorization
 	var err error
-	if ok && db != nil {
-		authz, err = db.GetAuthorization(r.Context(), authzID)
+	if ok && store != nil {
+		authz, err = store.GetAuthorization(r.Context(), authzID)
+		if err == nil {
+			var order *types.Order
+			if order, err = store.GetOrder(r.Context(), authz.OrderID); err == nil && !ownsOrder(r, order) {
+				err = fmt.Errorf("authorization %s belongs to another tenant", authzID)
+			}
+		}
 		if err != nil {
 			log.Errorf("Failed to get authorization from database: %v", err)
 			writeError(w, &types.Problem{
@@ -34,6 +45,14 @@ func GetAuthorization(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
+
+		// A non-empty payload is an update request rather than a POST-as-GET
+		if payload, _ := r.Context().Value(acme.DecodedPayloadKey).([]byte); r.Method == http.MethodPost && len(payload) > 0 {
+			if !deactivateAuthorization(w, r, store, authz, payload) {
+				return
+			}
+		}
+
 		// Update challenge URLs based on the current host.
 		for i := range authz.Challenges {
 			if authz.Challenges[i].URL == "" {
@@ -42,7 +61,7 @@ func GetAuthorization(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Fallback to a mock authorization.
-		baseURL := "https://" + r.Host
+		baseURL := getBaseURL(r)
 		authz = &types.Authorization{
 			Status:  types.AuthzStatusPending,
 			Expires: &types.Time{Time: time.Now().Add(24 * time.Hour)},
@@ -53,13 +72,13 @@ func GetAuthorization(w http.ResponseWriter, r *http.Request) {
 			Challenges: []types.Challenge{
 				{
 					Type:   "http-01",
-					URL:    path.Join(baseURL, "challenge", "chall-http-01"),
+					URL:    endpointURL(baseURL, "challenge", "chall-http-01"),
 					Status: types.ChallengeStatusPending,
 					Token:  generateToken(),
 				},
 				{
 					Type:   "tls-alpn-01",
-					URL:    path.Join(baseURL, "challenge", "chall-tls-alpn-01"),
+					URL:    endpointURL(baseURL, "challenge", "chall-tls-alpn-01"),
 					Status: types.ChallengeStatusPending,
 					Token:  generateToken(),
 				},
@@ -83,28 +102,65 @@ func GetAuthorization(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-// ProcessChallenge verifies that the challenge exists and is pending,
-// then simulates validation by updating its status to valid.
+// ProcessChallenge verifies that the challenge exists, belongs to the
+// requesting account and is pending, then simulates validation by updating
+// its status to valid. Validation of a dns identifier fails if its CAA
+// records forbid issuance. The outcome is recorded in the audit log with
+// the evidence it was decided on; failures are also sent to the webhook
+// endpoints.
 func ProcessChallenge(w http.ResponseWriter, r *http.Request) {
 	challengeID := chi.URLParam(r, "id")
 	log := logger.GetLogger(r.Context())
 	baseURL := getBaseURL(r)
 
 	// Retrieve the challenge from the database.
-	db, ok := r.Context().Value(types.CtxKeyDB).(*database.DB)
-	if !ok || db == nil {
-		log.Error("Database not available in context")
-		writeError(w, newInternalServerError("Database not available"))
+	store, ok := getStore(r)
+	if !ok || store == nil {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
 		return
 	}
 
-	challenge, err := db.GetChallenge(r.Context(), challengeID)
+	challenge, err := store.GetChallenge(r.Context(), challengeID)
 	if err != nil {
 		log.Errorf("Challenge not found: %v", err)
 		writeError(w, newNotFoundError(fmt.Sprintf("Challenge %s not found", challengeID), "challengeNotFound"))
 		return
 	}
 
+	authz, err := store.GetAuthorization(r.Context(), challenge.AuthorizationID)
+	if err != nil {
+		log.Errorf("Failed to get authorization: %v", err)
+		writeError(w, newInternalServerError("Failed to update authorization status"))
+		return
+	}
+
+	// Only the account owning the order may have its challenges validated.
+	// Challenges of other tenants are reported as not found.
+	order, err := store.GetOrder(r.Context(), authz.OrderID)
+	if err != nil {
+		log.Errorf("Failed to get order for authorization %s: %v", authz.ID, err)
+		writeError(w, newInternalServerError("Failed to look up challenge owner"))
+		return
+	}
+	if !ownsOrder(r, order) {
+		writeError(w, newNotFoundError(fmt.Sprintf("Challenge %s not found", challengeID), "challengeNotFound"))
+		return
+	}
+	accountID, _ := r.Context().Value(acme.AccountIDKey).(string)
+	if order.AccountID != accountID {
+		log.Errorw("Account does not own challenge",
+			"account", accountID,
+			"challenge", challengeID,
+		)
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Account is not authorized to respond to this challenge",
+			Status: http.StatusForbidden,
+		})
+		return
+	}
+
 	// Ensure the challenge is in a pending state.
 	if challenge.Status != types.ChallengeStatusPending {
 		writeError(w, &types.Problem{
@@ -115,13 +171,53 @@ func ProcessChallenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Simulate challenge validation.
+	// Accounts that keep failing to validate an identifier have to wait
+	limiter := getRateLimiter(r)
+	if !checkRateLimit(w, r, limiter.CheckValidation(r.Context(), accountID, authz.Identifier)) {
+		return
+	}
+
+	// Simulate challenge validation. The CAA records of dns identifiers
+	// are checked as part of it.
+	validationStart := time.Now()
 	newStatus := types.ChallengeStatusValid
-	if err := db.UpdateChallengeStatus(r.Context(), challengeID, string(newStatus)); err != nil {
+	if problem := checkCAA(r, authz.Identifier, accountID, challenge.Type); problem != nil {
+		log.Infow("Validation failed CAA check", "identifier", authz.Identifier.Value, "detail", problem.Detail)
+		newStatus = types.ChallengeStatusInvalid
+		challenge.Error = problem
+	}
+	if err := store.UpdateChallengeStatus(r.Context(), challengeID, string(newStatus)); err != nil {
 		log.Errorf("Failed to update challenge status: %v", err)
 		writeError(w, newInternalServerError("Failed to update challenge status"))
 		return
 	}
+	metrics.ObserveChallenge(challenge.Type, string(newStatus), time.Since(validationStart))
+	recordAudit(r, audit.EventValidation, accountID, audit.Validation{
+		Order:         authz.OrderID,
+		Authorization: authz.ID,
+		Identifier:    authz.Identifier,
+		Challenge:     challenge.Type,
+		Token:         challenge.Token,
+		Status:        string(newStatus),
+		Evidence:      validationEvidence(r, store, accountID, challenge, authz.Identifier),
+		Error:         challenge.Error,
+	})
+	if newStatus == types.ChallengeStatusInvalid {
+		notify(r, webhook.EventChallengeFailed, accountID, webhook.ChallengeFailed{
+			Order:         authz.OrderID,
+			Authorization: authz.ID,
+			Identifier:    authz.Identifier,
+			Challenge:     challenge.Type,
+			Error:         challenge.Error,
+		})
+		if err := limiter.ValidationFailed(r.Context(), accountID, authz.Identifier); err != nil {
+			log.Errorf("Failed to count failed validation: %v", err)
+		}
+		if !invalidateAuthorization(r, store, authz) {
+			writeError(w, newInternalServerError("Failed to update authorization status"))
+			return
+		}
+	}
 
 	// Update the local challenge status.
 	challenge.Status = newStatus
@@ -132,15 +228,9 @@ func ProcessChallenge(w http.ResponseWriter, r *http.Request) {
 
 	// Update authorization status if all challenges are valid
 	log.Infof("Challenge AuthorizationID: %s", challenge.AuthorizationID)
-	authz, err := db.GetAuthorization(r.Context(), challenge.AuthorizationID)
-	if err != nil {
-		log.Errorf("Failed to get authorization: %v", err)
-		writeError(w, newInternalServerError("Failed to update authorization status"))
-		return
-	}
 
 	// Check if all challenges for this authorization are valid
-	challenges, err := db.GetChallengesByAuthorization(r.Context(), authz.ID)
+	challenges, err := store.GetChallengesByAuthorization(r.Context(), authz.ID)
 	if err != nil {
 		log.Errorf("Failed to get challenges: %v", err)
 		writeError(w, newInternalServerError("Failed to check challenge status"))
@@ -157,7 +247,7 @@ func ProcessChallenge(w http.ResponseWriter, r *http.Request) {
 
 	if allValid {
 		authz.Status = types.AuthzStatusValid
-		if err := db.UpdateAuthorizationStatus(r.Context(), authz.ID, string(types.AuthzStatusValid)); err != nil {
+		if err := store.UpdateAuthorizationStatus(r.Context(), authz.ID, string(types.AuthzStatusValid)); err != nil {
 			log.Errorf("Failed to update authorization status: %v", err)
 			writeError(w, newInternalServerError("Failed to update authorization status"))
 			return
@@ -173,3 +263,106 @@ func ProcessChallenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// invalidateAuthorization marks an authorization whose validation failed
+// and its order invalid (RFC 8555 Section 7.1.6). It returns false if the
+// store could not be updated.
+func invalidateAuthorization(r *http.Request, store storage.Store, authz *types.Authorization) bool {
+	log := logger.GetLogger(r.Context())
+
+	authz.Status = types.AuthzStatusInvalid
+	if err := store.UpdateAuthorizationStatus(r.Context(), authz.ID, string(types.AuthzStatusInvalid)); err != nil {
+		log.Errorf("Failed to update authorization status: %v", err)
+		return false
+	}
+	order, err := store.GetOrder(r.Context(), authz.OrderID)
+	if err != nil {
+		log.Errorf("Failed to get order %s: %v", authz.OrderID, err)
+		return false
+	}
+	if order.Status != types.OrderStatusPending {
+		return true
+	}
+	order.Status = types.OrderStatusInvalid
+	order.UpdatedAt = types.Time{Time: time.Now()}
+	if err := store.UpdateOrder(r.Context(), order); err != nil {
+		log.Errorf("Failed to update order status: %v", err)
+		return false
+	}
+	metrics.OrderTransition(string(types.OrderStatusPending), string(order.Status))
+	return true
+}
+
+// deactivateAuthorization handles a client request to deactivate an
+// authorization (RFC 8555 Section 7.5.2). Only the account owning the order
+// may deactivate its authorizations. Any pending order depending on the
+// authorization becomes invalid. It returns false if an error response has
+// already been written.
+func deactivateAuthorization(w http.ResponseWriter, r *http.Request, store storage.Store, authz *types.Authorization, payload []byte) bool {
+	log := logger.GetLogger(r.Context())
+
+	var req types.AuthorizationUpdateRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		log.Errorf("Failed to decode authorization update request: %v", err)
+		writeError(w, newMalformedError("Failed to parse authorization update request"))
+		return false
+	}
+
+	if req.Status != types.AuthzStatusDeactivated {
+		writeError(w, newMalformedError(fmt.Sprintf("Authorization status can only be updated to %q", types.AuthzStatusDeactivated)))
+		return false
+	}
+
+	// Verify that the requesting account owns the authorization
+	accountID, ok := r.Context().Value(acme.AccountIDKey).(string)
+	if !ok {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Authorization updates must be signed by an existing account",
+			Status: http.StatusUnauthorized,
+		})
+		return false
+	}
+
+	order, err := store.GetOrder(r.Context(), authz.OrderID)
+	if err != nil {
+		log.Errorf("Failed to get order for authorization %s: %v", authz.ID, err)
+		writeError(w, newInternalServerError("Failed to look up authorization owner"))
+		return false
+	}
+
+	if order.AccountID != accountID {
+		log.Errorw("Account does not own authorization",
+			"account", accountID,
+			"authorization", authz.ID,
+		)
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Account is not authorized to modify this authorization",
+			Status: http.StatusForbidden,
+		})
+		return false
+	}
+
+	stopped, err := store.DeactivateAuthorization(r.Context(), authz.ID)
+	if err != nil {
+		log.Errorf("Failed to deactivate authorization: %v", err)
+		if problem, ok := err.(*types.Problem); ok {
+			writeError(w, problem)
+		} else {
+			writeError(w, newInternalServerError("Failed to deactivate authorization"))
+		}
+		return false
+	}
+
+	authz.Status = types.AuthzStatusDeactivated
+
+	log.Infow("Authorization deactivated",
+		"id", authz.ID,
+		"account", accountID,
+		"identifier", authz.Identifier,
+		"stopped_orders", stopped,
+	)
+
+	return true
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+// newRequest returns a request with the context the router and the JWS
+// middleware would set up for a request signed by accountID
+func newRequest(store storage.Store, accountID, id, payload string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/"+id, nil)
+	route := chi.NewRouteContext()
+	route.URLParams.Add("id", id)
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, route)
+	ctx = context.WithValue(ctx, types.CtxKeyLogger, logger.New(io.Discard))
+	ctx = context.WithValue(ctx, types.CtxKeyStore, store)
+	ctx = context.WithValue(ctx, acme.AccountIDKey, accountID)
+	ctx = context.WithValue(ctx, acme.DecodedPayloadKey, []byte(payload))
+	return req.WithContext(ctx)
+}
+
+// serve calls a handler with a request signed by accountID
+func serve(handler http.HandlerFunc, store storage.Store, accountID, id, payload string) (*httptest.ResponseRecorder, *types.Problem) {
+	rec := httptest.NewRecorder()
+	handler(rec, newRequest(store, accountID, id, payload))
+	var problem *types.Problem
+	if rec.Code >= 400 {
+		problem = &types.Problem{}
+		json.Unmarshal(rec.Body.Bytes(), problem)
+	}
+	return rec, problem
+}
+
+// newPendingOrder stores a pending order of accountID with one pending
+// authorization and challenge
+func newPendingOrder(t *testing.T, store storage.Store, accountID, id string) (*types.Order, *types.Authorization) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := store.GetAccount(ctx, accountID); err != nil {
+		if err := store.CreateAccount(ctx, &types.Account{ID: accountID, Key: json.RawMessage(`{}`), Status: types.AccountStatusValid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expires := time.Now().Add(time.Hour)
+	order := &types.Order{
+		ID:          "order_" + id,
+		AccountID:   accountID,
+		Status:      types.OrderStatusPending,
+		ExpiresAt:   types.Time{Time: expires},
+		Identifiers: []types.Identifier{{Type: "dns", Value: "plc1.plant.example"}},
+	}
+	authz := &types.Authorization{
+		ID:         "authz_" + id,
+		Status:     types.AuthzStatusPending,
+		Identifier: order.Identifiers[0],
+		Expires:    &types.Time{Time: expires},
+		Challenges: []types.Challenge{
+			{ID: "chall_" + id, Type: "http-01", Status: types.ChallengeStatusPending, Token: "token_" + id},
+		},
+	}
+	if err := store.CreateOrder(ctx, order, []*types.Authorization{authz}); err != nil {
+		t.Fatal(err)
+	}
+	return order, authz
+}
+
+func TestDeactivateAuthorization(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	order, authz := newPendingOrder(t, store, "acct_1", "1")
+	newPendingOrder(t, store, "acct_2", "2")
+
+	deactivate := `{"status": "deactivated"}`
+	if rec, problem := serve(GetAuthorization, store, "acct_2", authz.ID, deactivate); rec.Code != http.StatusForbidden || problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Errorf("deactivation by another account = %d %+v, want 403 unauthorized", rec.Code, problem)
+	}
+	if rec, problem := serve(GetAuthorization, store, "acct_1", authz.ID, `{"status": "valid"}`); rec.Code != http.StatusBadRequest || problem.Type != "urn:ietf:params:acme:error:malformed" {
+		t.Errorf("update to valid = %d %+v, want 400 malformed", rec.Code, problem)
+	}
+	if got, _ := store.GetAuthorization(ctx, authz.ID); got.Status != types.AuthzStatusPending {
+		t.Fatalf("rejected updates changed the authorization to %s", got.Status)
+	}
+
+	rec, _ := serve(GetAuthorization, store, "acct_1", authz.ID, deactivate)
+	var got types.Authorization
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); rec.Code != http.StatusOK || err != nil || got.Status != types.AuthzStatusDeactivated {
+		t.Fatalf("deactivation = %d %s", rec.Code, rec.Body)
+	}
+	if o, err := store.GetOrder(ctx, order.ID); err != nil || o.Status != types.OrderStatusInvalid {
+		t.Errorf("order after deactivation = %+v, %v, want invalid", o, err)
+	}
+}
+
+func TestProcessChallenge(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	_, authz := newPendingOrder(t, store, "acct_1", "1")
+	newPendingOrder(t, store, "acct_2", "2")
+	token := authz.Challenges[0].Token
+
+	if rec, problem := serve(ProcessChallenge, store, "acct_2", token, `{}`); rec.Code != http.StatusForbidden || problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Errorf("challenge response by another account = %d %+v, want 403 unauthorized", rec.Code, problem)
+	}
+	if c, _ := store.GetChallenge(ctx, token); c.Status != types.ChallengeStatusPending {
+		t.Fatalf("rejected response changed the challenge to %s", c.Status)
+	}
+
+	// Orders of other tenants are not found
+	expires := time.Now().Add(time.Hour)
+	other := &types.Authorization{
+		ID:         "authz_ot",
+		Status:     types.AuthzStatusPending,
+		Identifier: types.Identifier{Type: "dns", Value: "plc2.plant.example"},
+		Expires:    &types.Time{Time: expires},
+		Challenges: []types.Challenge{{ID: "chall_ot", Type: "http-01", Status: types.ChallengeStatusPending, Token: "token_ot"}},
+	}
+	order := &types.Order{ID: "order_ot", AccountID: "acct_1", Tenant: "ot", Status: types.OrderStatusPending, ExpiresAt: types.Time{Time: expires}, Identifiers: []types.Identifier{other.Identifier}}
+	if err := store.CreateOrder(ctx, order, []*types.Authorization{other}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, problem := serve(ProcessChallenge, store, "acct_1", "token_ot", `{}`); rec.Code != http.StatusNotFound || problem.Type != "urn:ietf:params:acme:error:challengeNotFound" {
+		t.Errorf("challenge of another tenant = %d %+v, want 404 challengeNotFound", rec.Code, problem)
+	}
+
+	rec, _ := serve(ProcessChallenge, store, "acct_1", token, `{}`)
+	var got types.Challenge
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); rec.Code != http.StatusOK || err != nil || got.Status != types.ChallengeStatusValid {
+		t.Errorf("challenge response by the owner = %d %s", rec.Code, rec.Body)
+	}
+}
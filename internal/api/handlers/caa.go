@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/caa"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+// getCAAChecker returns the CAA checker attached to the request context, or
+// nil without CAA checking
+func getCAAChecker(r *http.Request) *caa.Checker {
+	checker, _ := r.Context().Value(types.CtxKeyCAA).(*caa.Checker)
+	return checker
+}
+
+// checkCAA checks whether the CAA records of a dns identifier let the
+// tenant issue for the account after validation with the given challenge
+// type. Other identifiers and tenants without CAA identities always pass.
+func checkCAA(r *http.Request, id types.Identifier, accountID, method string) *types.Problem {
+	checker := getCAAChecker(r)
+	identities := getTenant(r).Settings().CAAIdentities
+	if checker == nil || len(identities) == 0 || id.Type != "dns" {
+		return nil
+	}
+	return checker.Check(r.Context(), caa.Request{
+		Domain:           id.Value,
+		Identities:       identities,
+		AccountURI:       endpointURL(getBaseURL(r), "account", accountID),
+		ValidationMethod: method,
+	})
+}
+
+// checkOrderCAA checks the CAA records of the identifiers of an order again
+// if they were checked at validation longer ago than the checker allows
+func checkOrderCAA(r *http.Request, store storage.Store, order *types.Order) *types.Problem {
+	checker := getCAAChecker(r)
+	if checker == nil || len(getTenant(r).Settings().CAAIdentities) == 0 {
+		return nil
+	}
+	log := logger.GetLogger(r.Context())
+
+	authzs, err := store.GetAuthorizationsByOrder(r.Context(), order.ID)
+	if err != nil {
+		log.Errorf("Failed to get authorizations of order %s: %v", order.ID, err)
+		return newInternalServerError("Failed to check CAA records")
+	}
+	for _, authz := range authzs {
+		if authz.Identifier.Type != "dns" {
+			continue
+		}
+		challenges, err := store.GetChallengesByAuthorization(r.Context(), authz.ID)
+		if err != nil {
+			log.Errorf("Failed to get challenges of authorization %s: %v", authz.ID, err)
+			return newInternalServerError("Failed to check CAA records")
+		}
+
+		// The valid challenge tells when and how the identifier was
+		// validated
+		var method string
+		var checkedAt types.Time
+		for _, c := range challenges {
+			if c.Status == types.ChallengeStatusValid && c.Validated != nil {
+				method, checkedAt = c.Type, *c.Validated
+				break
+			}
+		}
+		if !checker.Stale(checkedAt.Time) {
+			continue
+		}
+		if method == "" && len(challenges) > 0 {
+			method = challenges[0].Type
+		}
+		if problem := checkCAA(r, authz.Identifier, order.AccountID, method); problem != nil {
+			return problem
+		}
+	}
+	return nil
+}
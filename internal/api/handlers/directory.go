@@ -7,6 +7,7 @@ import (
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/star"
 )
 
 func GetDirectory(w http.ResponseWriter, r *http.Request) {
@@ -20,19 +21,27 @@ func GetDirectory(w http.ResponseWriter, r *http.Request) {
 	)
 
 	baseURL := getBaseURL(r)
+	settings := getTenant(r).Settings()
+	profiles := make(map[string]string)
+	for _, profile := range settings.ProfileList() {
+		profiles[profile.Name] = profile.Description
+	}
 
 	// Create directory response
 	dir := types.Directory{
-		NewNonce:   baseURL + "/new-nonce",
-		NewAccount: baseURL + "/new-account",
-		NewOrder:   baseURL + "/new-order",
-		RevokeCert: baseURL + "/revoke-cert",
-		KeyChange:  baseURL + "/key-change",
+		NewNonce:    baseURL + "/new-nonce",
+		NewAccount:  baseURL + "/new-account",
+		NewOrder:    baseURL + "/new-order",
+		RevokeCert:  baseURL + "/revoke-cert",
+		KeyChange:   baseURL + "/key-change",
+		RenewalInfo: baseURL + "/renewal-info",
 		Meta: &types.DirectoryMetadata{
 			TermsOfService:          baseURL + "/terms",
 			Website:                 "https://github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme",
-			CAAIdentities:           []string{"kritis3m.example.com"},
-			ExternalAccountRequired: false,
+			CAAIdentities:           settings.CAAIdentities,
+			ExternalAccountRequired: settings.ExternalAccountRequired,
+			AutoRenewal:             star.DefaultLimits.Metadata(),
+			Profiles:                profiles,
 		},
 	}
 
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-jose/go-jose/v3"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+// eabAlgorithms are the MAC algorithms accepted for External Account
+// Binding
+var eabAlgorithms = map[jose.SignatureAlgorithm]bool{
+	jose.HS256: true,
+	jose.HS384: true,
+	jose.HS512: true,
+}
+
+func newExternalAccountRequiredError() *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:externalAccountRequired",
+		Detail: "This server requires an external account binding",
+		Status: http.StatusUnauthorized,
+	}
+}
+
+func newUnauthorizedError(detail string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:unauthorized",
+		Detail: detail,
+		Status: http.StatusUnauthorized,
+	}
+}
+
+// verifyExternalAccountBinding checks the EAB JWS of a new-account request
+// (RFC 8555 Section 7.3.4) and returns the EAB key it is signed with. The
+// key must belong to tenantName and not be bound yet; the binding itself
+// happens atomically when the account is stored.
+func verifyExternalAccountBinding(r *http.Request, store storage.Store, tenantName string, binding json.RawMessage, protected *acme.JWSHeader) (*types.EABKey, *types.Problem) {
+	sig, err := jose.ParseSigned(string(binding))
+	if err != nil {
+		return nil, newMalformedError("Invalid external account binding")
+	}
+	if len(sig.Signatures) != 1 {
+		return nil, newMalformedError("External account binding must have exactly one signature")
+	}
+
+	header := sig.Signatures[0].Protected
+	if !eabAlgorithms[jose.SignatureAlgorithm(header.Algorithm)] {
+		return nil, newMalformedError(fmt.Sprintf("External account binding algorithm %q is not supported", header.Algorithm))
+	}
+	if header.KeyID == "" {
+		return nil, newMalformedError("External account binding has no kid")
+	}
+	if header.Nonce != "" {
+		return nil, newMalformedError("External account binding must not contain a nonce")
+	}
+	if eabURL, _ := header.ExtraHeaders["url"].(string); eabURL != protected.URL {
+		return nil, newMalformedError("External account binding url does not match the request url")
+	}
+
+	key, err := store.GetEABKey(r.Context(), header.KeyID)
+	if err != nil || key.Tenant != tenantName || key.BoundAccountID != "" {
+		return nil, newUnauthorizedError("External account binding key is unknown or already bound")
+	}
+
+	payload, err := sig.Verify(key.HMACKey)
+	if err != nil {
+		return nil, newUnauthorizedError("Invalid external account binding signature")
+	}
+
+	// The binding must sign the account key of the request
+	var boundKey jose.JSONWebKey
+	if err := json.Unmarshal(payload, &boundKey); err != nil {
+		return nil, newMalformedError("External account binding payload is not a JWK")
+	}
+	accountKey, err := json.Marshal(protected.Jwk)
+	if err != nil {
+		return nil, newMalformedError("Invalid account key")
+	}
+	var outerKey jose.JSONWebKey
+	if err := json.Unmarshal(accountKey, &outerKey); err != nil {
+		return nil, newMalformedError("Invalid account key")
+	}
+	boundThumbprint, err := boundKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, newMalformedError("External account binding payload is not a JWK")
+	}
+	outerThumbprint, err := outerKey.Thumbprint(crypto.SHA256)
+	if err != nil || !bytes.Equal(boundThumbprint, outerThumbprint) {
+		return nil, newUnauthorizedError("External account binding does not sign the account key")
+	}
+
+	return key, nil
+}
@@ -1,24 +1,95 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/server"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
 )
 
-// getBaseURL determines the base URL from the request
+// getBaseURL returns the external base URL resolved by BaseURLMiddleware,
+// or derives it from the request without the middleware
 func getBaseURL(r *http.Request) string {
+	if base, ok := r.Context().Value(types.CtxKeyBaseURL).(*types.BaseURL); ok {
+		return base.URL
+	}
+	return requestBaseURL(r, "")
+}
+
+// requestBaseURL derives the base URL from the request. ASL and TLS
+// listeners always serve https; on a plain HTTP listener behind a TLS
+// terminating proxy X-Forwarded-Proto tells the original scheme.
+func requestBaseURL(r *http.Request, pathPrefix string) string {
 	scheme := "http"
-	if aslState := r.Context().Value(server.TLSStateKey); aslState != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+	mode, _ := r.Context().Value(server.ModeKey).(server.Mode)
+	if mode.Secure() || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
 		scheme = "https"
 	}
-	return scheme + "://" + r.Host
+	return scheme + "://" + r.Host + pathPrefix
+}
+
+// BaseURLMiddleware resolves the external base URL of every request for
+// URL generation and JWS url checks. Among the configured external URLs the
+// one whose host matches the request is used, and the first one otherwise,
+// so that a client cannot steer generated URLs with its Host header. Without
+// external URLs the base URL is derived from the request. ACME routes are
+// expected under pathPrefix.
+func BaseURLMiddleware(externalURLs []*url.URL, pathPrefix string) func(http.Handler) http.Handler {
+	bases := make([]string, len(externalURLs))
+	for i, u := range externalURLs {
+		bases[i] = strings.TrimSuffix(u.String(), "/")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			base := &types.BaseURL{PathPrefix: pathPrefix}
+			if len(bases) == 0 {
+				base.URL = requestBaseURL(r, pathPrefix)
+			} else {
+				base.URL = bases[0]
+				for i, u := range externalURLs {
+					if strings.EqualFold(u.Host, r.Host) {
+						base.URL = bases[i]
+						break
+					}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), types.CtxKeyBaseURL, base)))
+		})
+	}
+}
+
+// getStore returns the storage backend attached to the request context
+func getStore(r *http.Request) (storage.Store, bool) {
+	store, ok := r.Context().Value(types.CtxKeyStore).(storage.Store)
+	return store, ok && store != nil
+}
+
+// defaultTenant serves requests that were not routed to a tenant
+var defaultTenant = tenant.New(tenant.DefaultName, "", &tenant.Settings{})
+
+// getTenant returns the tenant the request is served for
+func getTenant(r *http.Request) *tenant.Tenant {
+	if t, ok := tenant.FromContext(r.Context()); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+// ownsOrder reports whether the order belongs to the tenant of the request.
+// Objects of other tenants are reported as not found.
+func ownsOrder(r *http.Request, order *types.Order) bool {
+	return order.Tenant == getTenant(r).Name
 }
 
 // generateID creates a unique ID with a prefix
@@ -55,6 +126,18 @@ func finalizeURL(baseURL string, endpoint string, orderID string) string {
 	return endpointURL(baseURL, endpoint, orderID) + "/finalize"
 }
 
+// newChallenge creates a pending challenge of the given type with a fresh
+// token
+func newChallenge(challengeType string) types.Challenge {
+	token := generateToken()
+	return types.Challenge{
+		ID:     "chall_" + token,
+		Type:   challengeType,
+		Status: types.ChallengeStatusPending,
+		Token:  token,
+	}
+}
+
 // generateToken now creates a random 16-byte (32-hex-character) token.
 func generateToken() string {
 	b := make([]byte, 16)
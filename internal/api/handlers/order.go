@@ -5,20 +5,32 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/issuance"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/star"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
 	"github.com/go-chi/chi/v5"
 )
 
 func NewOrder(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogger(r.Context())
-	db := r.Context().Value(types.CtxKeyDB).(*database.DB)
+	store, ok := getStore(r)
+	if !ok {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
 
 	// Get the decoded payload from context
 	payloadBytes, ok := r.Context().Value(acme.DecodedPayloadKey).([]byte)
@@ -66,6 +78,60 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate the identifier syntax, canonicalize and deduplicate the
+	// identifiers before they are checked and stored
+	identifiers, problem := policy.Canonicalize(req.Identifiers)
+	if problem != nil {
+		log.Infow("Order with invalid identifiers rejected", "account", accountID, "detail", problem.Detail)
+		writeError(w, problem)
+		return
+	}
+	req.Identifiers = identifiers
+
+	// Check the identifiers against the tenant policy and the account scope
+	if problem := checkIdentifierPolicy(r, store, accountID, req.Identifiers); problem != nil {
+		log.Infow("Order rejected by identifier policy", "account", accountID, "detail", problem.Detail)
+		writeError(w, problem)
+		return
+	}
+
+	if !checkRateLimit(w, r, getRateLimiter(r).NewOrder(r.Context(), accountID, req.Identifiers)) {
+		return
+	}
+
+	// Validate the STAR auto-renewal parameters (RFC 8739 Section 3.1.1)
+	if req.AutoRenewal != nil {
+		if !req.NotBefore.IsZero() || !req.NotAfter.IsZero() {
+			writeError(w, newMalformedError("notBefore and notAfter must not be combined with auto-renewal"))
+			return
+		}
+		if problem := star.DefaultLimits.Validate(req.AutoRenewal, time.Now()); problem != nil {
+			writeError(w, problem)
+			return
+		}
+	}
+
+	// Resolve the certificate profile, the tenant's default if none is
+	// requested
+	t := getTenant(r)
+	profile, ok := t.Settings().Profile(req.Profile)
+	if !ok {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:invalidProfile",
+			Detail: fmt.Sprintf("Profile %q is not offered", req.Profile),
+			Status: http.StatusBadRequest,
+		})
+		return
+	}
+
+	// Validate the certificate this order replaces (RFC 9773 Section 5)
+	if req.Replaces != "" {
+		if _, problem := resolveReplacedCertificate(r, store, accountID, req.Replaces, req.Identifiers); problem != nil {
+			writeError(w, problem)
+			return
+		}
+	}
+
 	// Generate order ID and URLs
 	orderID := generateID("order")
 	baseURL := getBaseURL(r)
@@ -80,9 +146,13 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt:   types.Time{Time: expires},
 		Identifiers: req.Identifiers,
 		NotBefore:   types.Time{Time: now},
-		NotAfter:    types.Time{Time: now.Add(90 * 24 * time.Hour)}, // 90 days validity
+		NotAfter:    types.Time{Time: now.Add(profile.Validity)},
 		Finalize:    finalizeURL,
 		AccountID:   accountID,
+		Replaces:    req.Replaces,
+		AutoRenewal: req.AutoRenewal,
+		Tenant:      t.Name,
+		Profile:     profile.Name,
 	}
 
 	// Create authorizations for each identifier
@@ -94,6 +164,10 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 			Identifier: identifier,
 			Expires:    &types.Time{Time: expires},
 			OrderID:    order.ID,
+			Challenges: []types.Challenge{
+				newChallenge("http-01"),
+				newChallenge("tls-alpn-01"),
+			},
 		}
 		authzs = append(authzs, authz)
 
@@ -103,7 +177,7 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Store order and authorizations in database
-	if err := db.CreateOrder(r.Context(), order, authzs); err != nil {
+	if err := store.CreateOrder(r.Context(), order, authzs); err != nil {
 		log.Errorf("Failed to create order: %v", err)
 		writeError(w, &types.Problem{
 			Type:   "urn:ietf:params:acme:error:serverInternal",
@@ -112,6 +186,16 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	metrics.OrderTransition("new", string(order.Status))
+	notify(r, webhook.EventOrderCreated, accountID, webhook.Order{
+		Order:       order.ID,
+		Status:      string(order.Status),
+		Identifiers: order.Identifiers,
+		Profile:     order.Profile,
+		Expires:     order.ExpiresAt.Time,
+		Replaces:    order.Replaces,
+		STAR:        order.AutoRenewal != nil,
+	})
 
 	// Set response headers
 	setLinkHeader(w, endpointURL(baseURL, "directory", ""), "up")
@@ -124,9 +208,21 @@ func NewOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// issuanceRetryAfter tells clients how long to wait before polling a
+// processing order again
+const issuanceRetryAfter = 2 * time.Second
+
+// FinalizeOrder validates the CSR, moves the order to processing and hands
+// it to the issuance pool. The client polls the order until it becomes
+// valid or invalid.
 func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogger(r.Context())
-	db := r.Context().Value(types.CtxKeyDB).(*database.DB)
+	store, ok := getStore(r)
+	if !ok {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
 	orderID := chi.URLParam(r, "id")
 
 	// Get the decoded payload from context
@@ -166,7 +262,10 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the order from the database
-	order, err := db.GetOrder(r.Context(), orderID)
+	order, err := store.GetOrder(r.Context(), orderID)
+	if err == nil && !ownsOrder(r, order) {
+		err = fmt.Errorf("order %s belongs to another tenant", orderID)
+	}
 	if err != nil {
 		log.Errorf("Failed to get order: %v", err)
 		writeError(w, &types.Problem{
@@ -177,10 +276,32 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If the order is not marked as ready, check the associated authorizations.
-	// We use the new DB helper to get all authorizations for this order.
+	// Only the account that created the order may finalize it
+	if accountID, _ := r.Context().Value(acme.AccountIDKey).(string); accountID != order.AccountID {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Account is not authorized to finalize this order",
+			Status: http.StatusForbidden,
+		})
+		return
+	}
+
+	// Orders that are already being or have been finalized cannot be
+	// finalized again
+	switch order.Status {
+	case types.OrderStatusProcessing, types.OrderStatusValid, types.OrderStatusInvalid, types.OrderStatusCanceled:
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:orderNotReady",
+			Detail: fmt.Sprintf("Order cannot be finalized in state %q", order.Status),
+			Status: http.StatusForbidden,
+		})
+		return
+	}
+
+	// A pending order becomes ready once all of its authorizations are
+	// valid
 	if order.Status != types.OrderStatusReady {
-		authzs, err := db.GetAuthorizationsByOrder(r.Context(), order.ID)
+		authzs, err := store.GetAuthorizationsByOrder(r.Context(), order.ID)
 		if err != nil {
 			log.Errorf("Failed to retrieve authorizations: %v", err)
 			writeError(w, &types.Problem{
@@ -190,32 +311,20 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		allValid := true
 		for _, authz := range authzs {
 			if authz.Status != types.AuthzStatusValid {
-				log.Infof("Authorization %s is not valid", authz.ID)
-				// make it valid
-				authz.Status = types.AuthzStatusValid
-				authz.UpdatedAt = time.Time{}
-				if err := db.UpdateAuthorization(r.Context(), authz); err != nil {
-					log.Errorf("Failed to update authorization status: %v", err)
-				}
-				// allValid = false
-				break
+				writeError(w, &types.Problem{
+					Type:   "urn:ietf:params:acme:error:orderNotReady",
+					Detail: "Order not ready for finalization: not all authorizations are valid",
+					Status: http.StatusForbidden,
+				})
+				return
 			}
 		}
-		if !allValid {
-			writeError(w, &types.Problem{
-				Type:   "urn:ietf:params:acme:error:orderNotReady",
-				Detail: "Order not ready for finalization: not all authorizations are valid",
-				Status: http.StatusForbidden,
-			})
-			return
-		}
-		// All associated authorizations are valid, so update order status to ready.
+		previous := order.Status
 		order.Status = types.OrderStatusReady
 		order.UpdatedAt = types.Time{Time: time.Now()}
-		if err := db.UpdateOrder(r.Context(), order); err != nil {
+		if err := store.UpdateOrder(r.Context(), order); err != nil {
 			log.Errorf("Failed to update order status: %v", err)
 			writeError(w, &types.Problem{
 				Type:   "urn:ietf:params:acme:error:serverInternal",
@@ -224,32 +333,9 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
+		metrics.OrderTransition(string(previous), string(order.Status))
 	}
 
-	// Double-check that the order is now ready.
-	if order.Status != types.OrderStatusReady {
-		// make it ready
-		order.Status = types.OrderStatusReady
-		order.UpdatedAt = types.Time{Time: time.Now()}
-		if err := db.UpdateOrder(r.Context(), order); err != nil {
-			log.Errorf("Failed to update order status: %v", err)
-			writeError(w, &types.Problem{
-				Type:   "urn:ietf:params:acme:error:serverInternal",
-				Detail: "Failed to update order status",
-				Status: http.StatusInternalServerError,
-			})
-			return
-		}
-	}
-
-	// 	writeError(w, &types.Problem{
-	// 		Type:   "urn:ietf:params:acme:error:orderNotReady",
-	// 		Detail: "Order not ready for finalization",
-	// 		Status: http.StatusForbidden,
-	// 	})
-	// 	return
-	// }
-
 	// Parse and verify the CSR
 	csr, err := x509.ParseCertificateRequest(csrDER)
 	if err != nil {
@@ -262,45 +348,49 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Issue the certificate using the PKI module
-	certPEM, err := pki.IssueCertificate(csr, order)
-	if err != nil {
-		log.Errorf("Failed to issue certificate: %v", err)
-		writeError(w, &types.Problem{
-			Type:   "urn:ietf:params:acme:error:serverInternal",
-			Detail: "Failed to issue certificate",
-			Status: http.StatusInternalServerError,
-		})
+	// Verify the CSR signature and that it requests exactly the identifiers
+	// of the order
+	if problem := validateCSR(csr, order); problem != nil {
+		log.Errorf("CSR rejected for order %s: %s", order.ID, problem.Detail)
+		writeError(w, problem)
 		return
 	}
 
-	// Create and store the certificate
-	cert := &types.Certificate{
-		ID:               generateID("cert"),
-		OrderID:          order.ID,
-		Certificate:      certPEM,
-		Revoked:          false,
-		RevocationReason: "",
-		RevokedAt:        types.Time{},
+	// The policy or the account scope may have changed since the order was
+	// created
+	if problem := checkIdentifierPolicy(r, store, order.AccountID, order.Identifiers); problem != nil {
+		log.Infow("Finalization rejected by identifier policy", "order", order.ID, "detail", problem.Detail)
+		writeError(w, problem)
+		return
 	}
 
-	// Save certificate to database
-	if err := db.CreateCertificate(r.Context(), cert); err != nil {
-		log.Errorf("Failed to store certificate: %v", err)
-		writeError(w, &types.Problem{
-			Type:   "urn:ietf:params:acme:error:serverInternal",
-			Detail: "Failed to store certificate",
-			Status: http.StatusInternalServerError,
-		})
+	// CAA records checked at validation may have changed since
+	if problem := checkOrderCAA(r, store, order); problem != nil {
+		log.Infow("Finalization rejected by CAA records", "order", order.ID, "detail", problem.Detail)
+		writeError(w, problem)
+		return
+	}
+
+	// Every finalization counts towards the certificate limits of the
+	// identifiers
+	if !checkRateLimit(w, r, getRateLimiter(r).Finalize(r.Context(), order.AccountID, order.Identifiers)) {
 		return
 	}
 
-	// Should be url for the certificate
-	order.CertificateID = fmt.Sprintf("%s/cert/%s", getBaseURL(r), cert.ID)
-	order.Status = types.OrderStatusValid
+	pool, ok := r.Context().Value(types.CtxKeyIssuance).(*issuance.Pool)
+	if !ok || pool == nil {
+		log.Error("Issuance pool not available in context")
+		writeError(w, newInternalServerError("Certificate issuance not available"))
+		return
+	}
+
+	// Hand the order to the issuance pool. The CSR is kept on the order so
+	// the workers and the STAR renewer can issue from it.
+	order.CSR = req.CSR
+	order.Status = types.OrderStatusProcessing
 	order.UpdatedAt = types.Time{Time: time.Now()}
 
-	if err := db.UpdateOrder(r.Context(), order); err != nil {
+	if err := store.UpdateOrder(r.Context(), order); err != nil {
 		log.Errorf("Failed to update order: %v", err)
 		writeError(w, &types.Problem{
 			Type:   "urn:ietf:params:acme:error:serverInternal",
@@ -309,24 +399,143 @@ func FinalizeOrder(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	metrics.OrderTransition(string(types.OrderStatusReady), string(order.Status))
+
+	if err := pool.Submit(order.ID); err != nil {
+		// The order stays processing and is picked up by the recovery scan
+		log.Infow("Order queued for later issuance", "order", order.ID, "reason", err)
+	}
 
 	// Write response
+	renderOrder(r, order)
+	w.Header().Set("Location", endpointURL(getBaseURL(r), "order", order.ID))
+	w.Header().Set("Retry-After", strconv.Itoa(int(issuanceRetryAfter.Seconds())))
 	if err := writeJSON(w, http.StatusOK, order); err != nil {
 		log.Errorf("Failed to encode order response: %v", err)
 		return
 	}
 }
 
+// validateCSR checks the CSR signature and that the names it requests match
+// the identifiers of the order (RFC 8555 Section 7.4)
+func validateCSR(csr *x509.CertificateRequest, order *types.Order) *types.Problem {
+	badCSR := func(detail string) *types.Problem {
+		return &types.Problem{
+			Type:   "urn:ietf:params:acme:error:badCSR",
+			Detail: detail,
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return badCSR(fmt.Sprintf("Invalid CSR signature: %v", err))
+	}
+
+	expected := make(map[string]bool)
+	for _, identifier := range order.Identifiers {
+		value := strings.ToLower(identifier.Value)
+		if ip := net.ParseIP(identifier.Value); identifier.Type == "ip" && ip != nil {
+			value = ip.String()
+		}
+		expected[identifier.Type+":"+value] = true
+	}
+
+	requested := make(map[string]bool)
+	for _, name := range csr.DNSNames {
+		requested["dns:"+strings.ToLower(name)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		requested["ip:"+ip.String()] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		name := "dns:" + strings.ToLower(cn)
+		if ip := net.ParseIP(cn); ip != nil {
+			name = "ip:" + ip.String()
+		}
+		if !expected[name] {
+			return badCSR(fmt.Sprintf("CSR common name %q is not an identifier of the order", cn))
+		}
+		requested[name] = true
+	}
+
+	for name := range requested {
+		if !expected[name] {
+			return badCSR(fmt.Sprintf("CSR requests %q which is not an identifier of the order", name))
+		}
+	}
+	for name := range expected {
+		if !requested[name] {
+			return badCSR(fmt.Sprintf("CSR does not request order identifier %q", name))
+		}
+	}
+
+	return nil
+}
+
+// checkIdentifierPolicy evaluates identifiers against the policy of the
+// tenant and the scope of the ordering account
+func checkIdentifierPolicy(r *http.Request, store storage.Store, accountID string, identifiers []types.Identifier) *types.Problem {
+	log := logger.GetLogger(r.Context())
+
+	account, err := store.GetAccount(r.Context(), accountID)
+	if err != nil {
+		log.Errorf("Failed to get account %s: %v", accountID, err)
+		return newInternalServerError("Failed to look up account")
+	}
+	scope, err := policy.ParseRules(account.Scope)
+	if err != nil {
+		log.Errorf("Invalid scope of account %s: %v", accountID, err)
+		return newInternalServerError("Invalid account scope")
+	}
+	return getTenant(r).Settings().Policy.Check(identifiers, scope)
+}
+
+// renderOrder turns the stored authorization and certificate references of
+// an order into URLs. STAR orders expose their stable star-certificate URL
+// instead of a certificate URL.
+func renderOrder(r *http.Request, order *types.Order) {
+	baseURL := getBaseURL(r)
+
+	if order.AutoRenewal != nil {
+		order.CertificateID = ""
+		if order.Status == types.OrderStatusValid || order.Status == types.OrderStatusCanceled {
+			order.StarCertURL = endpointURL(baseURL, "star-cert", order.ID)
+		}
+	} else if order.CertificateID != "" && !strings.Contains(order.CertificateID, "://") {
+		order.CertificateID = endpointURL(baseURL, "cert", order.CertificateID)
+	}
+
+	// The store lists authorization IDs
+	for i, authz := range order.Authorizations {
+		if !strings.Contains(authz, "://") {
+			order.Authorizations[i] = endpointURL(baseURL, "authz", authz)
+		}
+	}
+
+	// Ensure authorizations is never nil
+	if order.Authorizations == nil {
+		order.Authorizations = []string{}
+	}
+}
+
 // GetOrder retrieves an order from the database. For orders that are still pending,
 // it checks their associated authorizations and updates the order status to "ready"
 // if all authorizations are valid. This way Certbot sees a "ready" order and moves
 // on to finalization.
 func GetOrder(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogger(r.Context())
-	db := r.Context().Value(types.CtxKeyDB).(*database.DB)
+	store, ok := getStore(r)
+	if !ok {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
 	orderID := chi.URLParam(r, "id")
 
-	order, err := db.GetOrder(r.Context(), orderID)
+	order, err := store.GetOrder(r.Context(), orderID)
+	if err == nil && !ownsOrder(r, order) {
+		err = fmt.Errorf("order %s belongs to another tenant", orderID)
+	}
 	if err != nil {
 		log.Errorf("Failed to get order: %v", err)
 		writeError(w, &types.Problem{
@@ -337,9 +546,16 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A non-empty payload is an update request rather than a POST-as-GET
+	if payload, _ := r.Context().Value(acme.DecodedPayloadKey).([]byte); r.Method == http.MethodPost && len(payload) > 0 {
+		if !cancelStarOrder(w, r, store, order, payload) {
+			return
+		}
+	}
+
 	// If order is pending, check if it should transition to ready
 	if order.Status == types.OrderStatusPending {
-		authzs, err := db.GetAuthorizationsByOrder(r.Context(), order.ID)
+		authzs, err := store.GetAuthorizationsByOrder(r.Context(), order.ID)
 		if err != nil {
 			log.Errorf("Failed to retrieve authorizations: %v", err)
 			writeError(w, &types.Problem{
@@ -363,7 +579,7 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 		if allValid {
 			order.Status = types.OrderStatusReady
 			order.UpdatedAt = types.Time{Time: time.Now()}
-			if err := db.UpdateOrder(r.Context(), order); err != nil {
+			if err := store.UpdateOrder(r.Context(), order); err != nil {
 				log.Errorf("Failed to update order status: %v", err)
 				writeError(w, &types.Problem{
 					Type:   "urn:ietf:params:acme:error:serverInternal",
@@ -372,13 +588,16 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 				})
 				return
 			}
+			metrics.OrderTransition(string(types.OrderStatusPending), string(order.Status))
 			log.Infof("Order %s transitioned to ready state", order.ID)
 		}
 	}
 
-	// Ensure authorizations is never nil
-	if order.Authorizations == nil {
-		order.Authorizations = []string{}
+	renderOrder(r, order)
+
+	// Clients poll the order until issuance has finished
+	if order.Status == types.OrderStatusProcessing {
+		w.Header().Set("Retry-After", strconv.Itoa(int(issuanceRetryAfter.Seconds())))
 	}
 
 	// Add required Link headers
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestFinalizeOrder(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	newPendingOrder(t, store, "acct_owner", "1")
+	const payload = `{"csr":"MIIB"}`
+
+	// Another account cannot finalize the order
+	rec, problem := serve(FinalizeOrder, store, "acct_other", "order_1", payload)
+	if rec.Code != 403 || problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Errorf("finalize by another account = %d %+v, want 403 unauthorized", rec.Code, problem)
+	}
+
+	// The owner cannot finalize before the authorization is valid
+	rec, problem = serve(FinalizeOrder, store, "acct_owner", "order_1", payload)
+	if rec.Code != 403 || problem.Type != "urn:ietf:params:acme:error:orderNotReady" {
+		t.Errorf("finalize with a pending authorization = %d %+v, want 403 orderNotReady", rec.Code, problem)
+	}
+
+	// Neither attempt changed the order or its authorization
+	if order, _ := store.GetOrder(ctx, "order_1"); order.Status != types.OrderStatusPending {
+		t.Errorf("order = %s, want pending", order.Status)
+	}
+	if authz, _ := store.GetAuthorization(ctx, "authz_1"); authz.Status != types.AuthzStatusPending {
+		t.Errorf("authorization = %s, want pending", authz.Status)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/ratelimit"
+)
+
+// getRateLimiter returns the rate limiter attached to the request context,
+// or nil without rate limits
+func getRateLimiter(r *http.Request) *ratelimit.Limiter {
+	limiter, _ := r.Context().Value(types.CtxKeyRateLimiter).(*ratelimit.Limiter)
+	return limiter
+}
+
+// checkRateLimit answers a request that hit a rate limit with a rateLimited
+// problem, a Retry-After header and a link to the documentation of the
+// limits, and returns false. Errors of the rate limit store are logged but
+// do not fail the request.
+func checkRateLimit(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+	log := logger.GetLogger(r.Context())
+
+	var exceeded *ratelimit.Exceeded
+	if !errors.As(err, &exceeded) {
+		log.Errorf("Failed to check rate limit: %v", err)
+		return true
+	}
+
+	log.Infow("Rate limit exceeded", "limit", exceeded.Limit, "retry_after", exceeded.RetryAfter)
+	metrics.RateLimited.WithLabelValues(exceeded.Name).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(exceeded.RetryAfterSeconds()))
+	if exceeded.Documentation != "" {
+		setLinkHeader(w, exceeded.Documentation, "help")
+	}
+	writeError(w, exceeded.Problem())
+	return false
+}
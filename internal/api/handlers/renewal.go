@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// renewalInfoRetryAfter tells clients how long to wait before polling the
+// renewal information of a certificate again
+const renewalInfoRetryAfter = 6 * time.Hour
+
+// GetRenewalInfo returns the suggested renewal window for a certificate
+// (RFC 9773). Operator overrides for the certificate or its issuer take
+// precedence over the window computed from the certificate's validity.
+func GetRenewalInfo(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogger(r.Context())
+	certID := chi.URLParam(r, "certID")
+
+	store, ok := getStore(r)
+	if !ok || store == nil {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
+
+	cert, problem := lookupRenewalCertificate(r, store, certID)
+	if problem != nil {
+		writeError(w, problem)
+		return
+	}
+
+	info := &types.RenewalInfo{}
+
+	override, err := store.GetRenewalOverride(r.Context(), cert)
+	if err != nil {
+		log.Errorf("Failed to get renewal override: %v", err)
+		writeError(w, newInternalServerError("Failed to compute renewal window"))
+		return
+	}
+
+	switch {
+	case override != nil:
+		info.SuggestedWindow = override.Window
+		info.ExplanationURL = override.ExplanationURL
+	case cert.Revoked:
+		start, end := pki.ImmediateRenewalWindow(time.Now())
+		info.SuggestedWindow = types.RenewalWindow{
+			Start: types.Time{Time: start},
+			End:   types.Time{Time: end},
+		}
+	default:
+		start, end := pki.SuggestedRenewalWindow(cert.NotBefore.Time, cert.NotAfter.Time)
+		info.SuggestedWindow = types.RenewalWindow{
+			Start: types.Time{Time: start},
+			End:   types.Time{Time: end},
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	if err := writeJSON(w, http.StatusOK, info); err != nil {
+		log.Errorf("Failed to encode renewal info response: %v", err)
+		return
+	}
+}
+
+// lookupRenewalCertificate resolves an ARI certificate identifier to the
+// stored certificate
+func lookupRenewalCertificate(r *http.Request, store storage.Store, certID string) (*types.Certificate, *types.Problem) {
+	log := logger.GetLogger(r.Context())
+
+	aki, serial, err := pki.ParseRenewalCertID(certID)
+	if err != nil {
+		return nil, newMalformedError(fmt.Sprintf("Invalid certificate identifier: %v", err))
+	}
+
+	cert, err := store.GetCertificateBySerial(r.Context(), hex.EncodeToString(aki), pki.SerialHex(serial))
+	if err != nil {
+		if problem, ok := err.(*types.Problem); ok {
+			return nil, problem
+		}
+		log.Errorf("Failed to get certificate: %v", err)
+		return nil, newInternalServerError("Failed to look up certificate")
+	}
+
+	return cert, nil
+}
+
+// resolveReplacedCertificate validates the "replaces" field of a new-order
+// request: the certificate must exist, belong to the requesting account,
+// share at least one identifier with the new order and must not have been
+// replaced already.
+func resolveReplacedCertificate(r *http.Request, store storage.Store, accountID string, certID string, identifiers []types.Identifier) (*types.Certificate, *types.Problem) {
+	log := logger.GetLogger(r.Context())
+
+	cert, problem := lookupRenewalCertificate(r, store, certID)
+	if problem != nil {
+		return nil, problem
+	}
+
+	order, err := store.GetOrder(r.Context(), cert.OrderID)
+	if err != nil {
+		log.Errorf("Failed to get order of replaced certificate: %v", err)
+		return nil, newInternalServerError("Failed to look up replaced certificate")
+	}
+	if order.AccountID != accountID {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Replaced certificate was not issued to this account",
+			Status: http.StatusForbidden,
+		}
+	}
+	if !sharesIdentifier(order.Identifiers, identifiers) {
+		return nil, newMalformedError("Order does not share any identifier with the replaced certificate")
+	}
+
+	if cert.ReplacedBy != "" {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:alreadyReplaced",
+			Detail: fmt.Sprintf("Certificate has already been replaced by %s", cert.ReplacedBy),
+			Status: http.StatusConflict,
+		}
+	}
+
+	return cert, nil
+}
+
+// sharesIdentifier reports whether two identifier sets overlap
+func sharesIdentifier(a, b []types.Identifier) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x.Type == y.Type && strings.EqualFold(x.Value, y.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
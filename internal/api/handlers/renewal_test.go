@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestResolveReplacedCertificate(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	order, _ := newPendingOrder(t, store, "acct_1", "1")
+	newPendingOrder(t, store, "acct_2", "2")
+	err := store.CreateCertificate(ctx, &types.Certificate{
+		ID:             "cert_1",
+		OrderID:        order.ID,
+		Serial:         pki.SerialHex(big.NewInt(1)),
+		AuthorityKeyID: "aa",
+		NotAfter:       types.Time{Time: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const certID = "qg.AQ" // AKI aa, serial 1
+
+	renewal := []types.Identifier{{Type: "dns", Value: "plc1.plant.example"}, {Type: "dns", Value: "plc2.plant.example"}}
+	for _, tc := range []struct {
+		name        string
+		account     string
+		identifiers []types.Identifier
+		status      int
+	}{
+		{"renewal", "acct_1", renewal, 0},
+		{"other account", "acct_2", renewal, http.StatusForbidden},
+		{"disjoint identifiers", "acct_1", []types.Identifier{{Type: "dns", Value: "plc9.plant.example"}}, http.StatusBadRequest},
+		{"identifier of another type", "acct_1", []types.Identifier{{Type: "ip", Value: "plc1.plant.example"}}, http.StatusBadRequest},
+	} {
+		cert, problem := resolveReplacedCertificate(newRequest(store, tc.account, "", ""), store, tc.account, certID, tc.identifiers)
+		switch {
+		case tc.status == 0 && (problem != nil || cert.ID != "cert_1"):
+			t.Errorf("%s: got %+v, %+v, want cert_1", tc.name, cert, problem)
+		case tc.status != 0 && (problem == nil || problem.Status != tc.status):
+			t.Errorf("%s: got %+v, want status %d", tc.name, problem, tc.status)
+		}
+	}
+}
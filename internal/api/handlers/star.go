@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// GetStarCertificate serves the current certificate of a STAR order at its
+// stable star-certificate URL (RFC 8739 Section 3.3). Unauthenticated GET
+// requests are only allowed if the order was created with
+// allow-certificate-get, otherwise a POST-as-GET by the owning account is
+// required.
+func GetStarCertificate(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogger(r.Context())
+	orderID := chi.URLParam(r, "id")
+
+	store, ok := getStore(r)
+	if !ok || store == nil {
+		log.Error("Storage not available in context")
+		writeError(w, newInternalServerError("Storage not available"))
+		return
+	}
+
+	order, err := store.GetOrder(r.Context(), orderID)
+	if err != nil || order.AutoRenewal == nil || !ownsOrder(r, order) {
+		writeError(w, newNotFoundError("STAR certificate not found", "malformed"))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if !order.AutoRenewal.AllowCertificateGet {
+			writeError(w, &types.Problem{
+				Type:   "urn:ietf:params:acme:error:unauthorized",
+				Detail: "Order does not allow fetching certificates with GET",
+				Status: http.StatusForbidden,
+			})
+			return
+		}
+	} else if accountID, _ := r.Context().Value(acme.AccountIDKey).(string); accountID != order.AccountID {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Account is not authorized to access this certificate",
+			Status: http.StatusForbidden,
+		})
+		return
+	}
+
+	if order.Status == types.OrderStatusCanceled {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:autoRenewalCanceled",
+			Detail: "Auto-renewal of this order has been canceled",
+			Status: http.StatusForbidden,
+		})
+		return
+	}
+
+	cert, err := store.GetCurrentCertificateByOrder(r.Context(), order.ID, time.Now())
+	if err != nil {
+		log.Errorf("Failed to get STAR certificate: %v", err)
+		writeError(w, newNotFoundError("No certificate has been issued for this order yet", "malformed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Header().Set("Cert-Not-Before", cert.NotBefore.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cert-Not-After", cert.NotAfter.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(cert.Certificate))
+}
+
+// cancelStarOrder handles a client request to cancel a STAR order (RFC 8739
+// Section 3.1.2). It returns false if an error response has already been
+// written.
+func cancelStarOrder(w http.ResponseWriter, r *http.Request, store storage.Store, order *types.Order, payload []byte) bool {
+	log := logger.GetLogger(r.Context())
+
+	var req types.OrderUpdateRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		log.Errorf("Failed to decode order update request: %v", err)
+		writeError(w, newMalformedError("Failed to parse order update request"))
+		return false
+	}
+
+	if req.Status != types.OrderStatusCanceled {
+		writeError(w, newMalformedError("Order status can only be updated to \"canceled\""))
+		return false
+	}
+
+	if order.AutoRenewal == nil {
+		writeError(w, newMalformedError("Only STAR orders can be canceled"))
+		return false
+	}
+
+	if accountID, _ := r.Context().Value(acme.AccountIDKey).(string); accountID != order.AccountID {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:unauthorized",
+			Detail: "Account is not authorized to modify this order",
+			Status: http.StatusForbidden,
+		})
+		return false
+	}
+
+	if order.Status == types.OrderStatusCanceled {
+		writeError(w, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:autoRenewalCanceled",
+			Detail: "Auto-renewal of this order has already been canceled",
+			Status: http.StatusForbidden,
+		})
+		return false
+	}
+
+	previous := order.Status
+	order.Status = types.OrderStatusCanceled
+	order.UpdatedAt = types.Time{Time: time.Now()}
+	if err := store.UpdateOrder(r.Context(), order); err != nil {
+		log.Errorf("Failed to cancel order: %v", err)
+		writeError(w, newInternalServerError("Failed to cancel order"))
+		return false
+	}
+	metrics.OrderTransition(string(previous), string(order.Status))
+
+	log.Infow("STAR order canceled", "order", order.ID, "account", order.AccountID)
+	return true
+}
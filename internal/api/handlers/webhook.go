@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+// getNotifier returns the webhook notifier attached to the request context,
+// or nil without webhooks
+func getNotifier(r *http.Request) *webhook.Notifier {
+	n, _ := r.Context().Value(types.CtxKeyWebhooks).(*webhook.Notifier)
+	return n
+}
+
+// notify queues a webhook event of the tenant of the request. The request
+// has already taken effect, so failures are only logged.
+func notify(r *http.Request, event, accountID string, data any) {
+	if err := getNotifier(r).Notify(r.Context(), event, getTenant(r).Name, accountID, data); err != nil {
+		logger.GetLogger(r.Context()).Errorf("Failed to queue %s webhook: %v", event, err)
+	}
+}
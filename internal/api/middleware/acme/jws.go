@@ -13,8 +13,10 @@ import (
 	"strings"
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
 	"github.com/go-jose/go-jose/v3"
 )
 
@@ -53,103 +55,110 @@ const (
 	maxRequestSize = 1 << 20 // 1MB
 )
 
-// Add a simple in-memory nonce store (in production, use Redis or similar)
-var (
-	nonceStore = NewMemoryNonceStore()
-)
-
-// JWSVerificationMiddleware verifies the JWS signature of ACME requests
-func JWSVerificationMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log := logger.GetLogger(r.Context())
-
-		// Only verify POST requests
-		if r.Method != http.MethodPost {
-			next.ServeHTTP(w, r)
-			return
-		}
+// JWSVerificationMiddleware verifies the JWS signature of ACME requests and
+// redeems their nonce from the given store
+func JWSVerificationMiddleware(nonces NonceStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.GetLogger(r.Context())
 
-		// Read and parse the JWS request
-		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
-		if err != nil {
-			log.Errorw("Failed to read request body",
-				"error", err,
-				"remote_addr", r.RemoteAddr,
-				"content_length", r.ContentLength,
-			)
-			writeError(w, errMalformed, "Failed to read request body", http.StatusBadRequest)
-			return
-		}
+			// Only verify POST requests
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		// Create a new reader with the body for subsequent handlers
-		r.Body = io.NopCloser(bytes.NewBuffer(body))
+			// Read and parse the JWS request
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+			if err != nil {
+				log.Errorw("Failed to read request body",
+					"error", err,
+					"remote_addr", r.RemoteAddr,
+					"content_length", r.ContentLength,
+				)
+				metrics.JWSFailures.WithLabelValues("read_body").Inc()
+				writeError(w, errMalformed, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
 
-		var jws JWSRequest
-		if err := json.Unmarshal(body, &jws); err != nil {
-			log.Errorf("Failed to parse JWS: %v", err)
-			writeError(w, errMalformed, "Invalid JWS format", http.StatusBadRequest)
-			return
-		}
+			// Create a new reader with the body for subsequent handlers
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-		// Decode and verify the protected header
-		protected, err := decodeJWSProtected(jws.Protected)
-		if err != nil {
-			log.Errorf("Failed to decode protected header: %v", err)
-			writeError(w, errMalformed, "Invalid JWS protected header", http.StatusBadRequest)
-			return
-		}
+			var jws JWSRequest
+			if err := json.Unmarshal(body, &jws); err != nil {
+				log.Errorf("Failed to parse JWS: %v", err)
+				metrics.JWSFailures.WithLabelValues("malformed_jws").Inc()
+				writeError(w, errMalformed, "Invalid JWS format", http.StatusBadRequest)
+				return
+			}
 
-		// Verify the nonce
-		if err := verifyNonce(protected.Nonce); err != nil {
-			log.Errorf("Nonce verification failed: %v", err)
-			writeError(w, errBadNonce, "Invalid or missing nonce", http.StatusBadRequest)
-			return
-		}
+			// Decode and verify the protected header
+			protected, err := decodeJWSProtected(jws.Protected)
+			if err != nil {
+				log.Errorf("Failed to decode protected header: %v", err)
+				metrics.JWSFailures.WithLabelValues("invalid_header").Inc()
+				writeError(w, errMalformed, "Invalid JWS protected header", http.StatusBadRequest)
+				return
+			}
 
-		// Verify URL matches the request URL
-		if err := verifyRequestURL(r, protected.URL); err != nil {
-			log.Errorf("URL verification failed: %v", err)
-			writeError(w, errMalformed, "JWS URL does not match request URL", http.StatusBadRequest)
-			return
-		}
+			// Verify the nonce
+			if err := nonces.ValidateAndRemove(r.Context(), protected.Nonce); err != nil {
+				log.Errorf("Nonce verification failed: %v", err)
+				metrics.JWSFailures.WithLabelValues("bad_nonce").Inc()
+				writeError(w, errBadNonce, "Invalid or missing nonce", http.StatusBadRequest)
+				return
+			}
 
-		// Decode the payload and add it to the context
-		payloadBytes, err := base64.RawURLEncoding.DecodeString(jws.Payload)
-		if err != nil {
-			log.Errorf("Invalid payload encoding: %v", err)
-			writeError(w, errMalformed, "Invalid payload encoding", http.StatusBadRequest)
-			return
-		}
+			// Verify URL matches the request URL
+			if err := verifyRequestURL(r, protected.URL); err != nil {
+				log.Errorf("URL verification failed: %v", err)
+				metrics.JWSFailures.WithLabelValues("url_mismatch").Inc()
+				writeError(w, errMalformed, "JWS URL does not match request URL", http.StatusBadRequest)
+				return
+			}
 
-		// Verify signature based on key type (JWK or KID)
-		if protected.Kid != "" {
-			// Existing account - verify using stored public key
-			if err := verifyExistingAccount(protected.Kid, jws, r); err != nil {
-				log.Errorf("Account verification failed: %v", err)
-				writeError(w, errUnauthorized, "Invalid account or signature", http.StatusUnauthorized)
+			// Decode the payload and add it to the context
+			payloadBytes, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+			if err != nil {
+				log.Errorf("Invalid payload encoding: %v", err)
+				metrics.JWSFailures.WithLabelValues("invalid_payload").Inc()
+				writeError(w, errMalformed, "Invalid payload encoding", http.StatusBadRequest)
 				return
 			}
-		} else if protected.Jwk != nil {
-			// New account - verify using provided JWK
-			if err := verifyNewAccount(protected.Jwk, jws); err != nil {
-				log.Errorf("JWK verification failed: %v", err)
-				writeError(w, errMalformed, "Invalid JWK or signature", http.StatusBadRequest)
+
+			// Verify signature based on key type (JWK or KID)
+			if protected.Kid != "" {
+				// Existing account - verify using stored public key
+				if err := verifyExistingAccount(protected.Kid, jws, r); err != nil {
+					log.Errorf("Account verification failed: %v", err)
+					metrics.JWSFailures.WithLabelValues("account_signature").Inc()
+					writeError(w, errUnauthorized, "Invalid account or signature", http.StatusUnauthorized)
+					return
+				}
+			} else if protected.Jwk != nil {
+				// New account - verify using provided JWK
+				if err := verifyNewAccount(protected.Jwk, jws); err != nil {
+					log.Errorf("JWK verification failed: %v", err)
+					metrics.JWSFailures.WithLabelValues("jwk_signature").Inc()
+					writeError(w, errMalformed, "Invalid JWK or signature", http.StatusBadRequest)
+					return
+				}
+			} else {
+				metrics.JWSFailures.WithLabelValues("missing_key").Inc()
+				writeError(w, errMalformed, "Either 'kid' or 'jwk' must be present", http.StatusBadRequest)
 				return
 			}
-		} else {
-			writeError(w, errMalformed, "Either 'kid' or 'jwk' must be present", http.StatusBadRequest)
-			return
-		}
 
-		// Add both raw and decoded payload to context
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, jwsPayloadKey, jws.Payload)
-		ctx = context.WithValue(ctx, DecodedPayloadKey, payloadBytes)
-		ctx = context.WithValue(ctx, JwsProtectedKey, protected)
+			// Add both raw and decoded payload to context
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, jwsPayloadKey, jws.Payload)
+			ctx = context.WithValue(ctx, DecodedPayloadKey, payloadBytes)
+			ctx = context.WithValue(ctx, JwsProtectedKey, protected)
 
-		// Call the next handler with our new context
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			// Call the next handler with our new context
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // Helper functions to be implemented:
@@ -204,10 +213,9 @@ func decodeJWSProtected(protected string) (*JWSHeader, error) {
 	return &header, nil
 }
 
-func verifyNonce(nonce string) error {
-	return nonceStore.ValidateAndRemove(nonce)
-}
-
+// verifyRequestURL checks the url of the JWS protected header against the
+// URL the request was sent to. With a base URL resolved by the router the
+// full external URL must match; otherwise path and host are compared.
 func verifyRequestURL(r *http.Request, jwsURL string) error {
 	// Parse the JWS URL
 	parsedJWSURL, err := url.Parse(jwsURL)
@@ -215,6 +223,19 @@ func verifyRequestURL(r *http.Request, jwsURL string) error {
 		return fmt.Errorf("invalid JWS URL: %w", err)
 	}
 
+	if base, ok := r.Context().Value(types.CtxKeyBaseURL).(*types.BaseURL); ok {
+		expected, err := url.Parse(base.Resolve(r.URL.Path))
+		if err != nil {
+			return fmt.Errorf("invalid base URL: %w", err)
+		}
+		if !strings.EqualFold(parsedJWSURL.Scheme, expected.Scheme) ||
+			!strings.EqualFold(parsedJWSURL.Host, expected.Host) ||
+			parsedJWSURL.Path != expected.Path {
+			return fmt.Errorf("URL mismatch: expected %s, got %s", expected, jwsURL)
+		}
+		return nil
+	}
+
 	// Compare only the path and host parts
 	if !strings.EqualFold(parsedJWSURL.Path, r.URL.Path) {
 		return fmt.Errorf("URL path mismatch: expected %s, got %s", r.URL.Path, parsedJWSURL.Path)
@@ -245,8 +266,14 @@ func verifyExistingAccount(kid string, jws JWSRequest, r *http.Request) error {
 		return fmt.Errorf("invalid kid URL: %w", err)
 	}
 
-	// Extract account ID from path
-	parts := strings.Split(strings.Trim(kidURL.Path, "/"), "/")
+	// Extract account ID from the path below the base URL
+	accountPath := kidURL.Path
+	if base, ok := r.Context().Value(types.CtxKeyBaseURL).(*types.BaseURL); ok {
+		if baseURL, err := url.Parse(base.URL); err == nil {
+			accountPath = strings.TrimPrefix(accountPath, baseURL.Path)
+		}
+	}
+	parts := strings.Split(strings.Trim(accountPath, "/"), "/")
 	if len(parts) != 2 || parts[0] != "account" {
 		log.Errorw("Invalid account URL format",
 			"kid", kid,
@@ -257,13 +284,25 @@ func verifyExistingAccount(kid string, jws JWSRequest, r *http.Request) error {
 	accountID := parts[1]
 	log.Debugw("Extracted account ID", "accountID", accountID)
 
-	// Get the account from database using accountID
-	db := r.Context().Value(types.CtxKeyDB).(*database.DB)
-	account, err := db.GetAccount(r.Context(), accountID)
+	// Get the account from storage using accountID
+	store, ok := r.Context().Value(types.CtxKeyStore).(storage.Store)
+	if !ok || store == nil {
+		return fmt.Errorf("storage not available")
+	}
+	account, err := store.GetAccount(r.Context(), accountID)
 	if err != nil {
 		return fmt.Errorf("failed to get account: %w", err)
 	}
 
+	// Accounts are only valid at the tenant they were created at
+	tenantName := tenant.DefaultName
+	if t, ok := tenant.FromContext(r.Context()); ok {
+		tenantName = t.Name
+	}
+	if account.Tenant != tenantName {
+		return fmt.Errorf("account %s belongs to another tenant", accountID)
+	}
+
 	// Parse the stored public key
 	var publicKey jose.JSONWebKey
 	if err := json.Unmarshal(account.Key, &publicKey); err != nil {
@@ -44,7 +44,8 @@ func TestJWSVerification(t *testing.T) {
 	})
 
 	// Create the middleware chain
-	handler := JWSVerificationMiddleware(testHandler)
+	nonces := NewMemoryNonceStore()
+	handler := JWSVerificationMiddleware(nonces)(testHandler)
 
 	tests := []struct {
 		name       string
@@ -55,11 +56,10 @@ func TestJWSVerification(t *testing.T) {
 			name: "valid new account JWS",
 			setupJWS: func() ([]byte, error) {
 				// Get a nonce first
-				nonce, err := generateNonce()
+				nonce, err := nonces.NewNonce(context.Background())
 				if err != nil {
 					return nil, err
 				}
-				nonceStore.StoreNonce(nonce)
 
 				// Create signer
 				opts := &jose.SignerOptions{}
@@ -148,3 +148,29 @@ func TestJWSVerification(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyRequestURLWithBaseURL(t *testing.T) {
+	base := &types.BaseURL{URL: "https://acme.example.com/pki", PathPrefix: "/acme"}
+
+	tests := []struct {
+		jwsURL string
+		ok     bool
+	}{
+		{"https://acme.example.com/pki/new-order", true},
+		{"https://ACME.example.com/pki/new-order", true},
+		{"https://attacker.example.com/pki/new-order", false},
+		{"http://acme.example.com/pki/new-order", false},
+		{"https://acme.example.com/acme/new-order", false},
+		{"https://acme.example.com/new-order", false},
+	}
+	for _, tt := range tests {
+		// The client's Host header must not matter
+		req := httptest.NewRequest(http.MethodPost, "http://attacker.example.com/acme/new-order", nil)
+		req = req.WithContext(context.WithValue(req.Context(), types.CtxKeyBaseURL, base))
+
+		err := verifyRequestURL(req, tt.jwsURL)
+		if (err == nil) != tt.ok {
+			t.Errorf("verifyRequestURL(%s) = %v, want ok %v", tt.jwsURL, err, tt.ok)
+		}
+	}
+}
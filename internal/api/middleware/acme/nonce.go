@@ -1,44 +1,58 @@
 package acme
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
 )
 
 const (
 	nonceHeader = "Replay-Nonce"
 	nonceSize   = 16 // 128 bits of entropy
-)
 
-// NonceMiddleware adds a nonce to all responses
-func NonceMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Generate new nonce
-		nonce, err := generateNonce()
-		if err != nil {
-			http.Error(w, "Failed to generate nonce", http.StatusInternalServerError)
-			return
-		}
+	// NonceTTL is how long an issued nonce is accepted
+	NonceTTL = 15 * time.Minute
+)
 
-		// Store the nonce
-		if err := nonceStore.StoreNonce(nonce); err != nil {
-			http.Error(w, "Failed to store nonce", http.StatusInternalServerError)
-			return
-		}
+// Nonce store selection
+const (
+	NonceStoreMemory   = "memory"
+	NonceStoreDatabase = "database"
+	NonceStoreHMAC     = "hmac"
+)
 
-		// Add nonce to response header
-		w.Header().Set(nonceHeader, nonce)
+// NonceConfig selects and configures the nonce store
+type NonceConfig struct {
+	Store   string // NonceStoreMemory, NonceStoreDatabase or NonceStoreHMAC
+	HMACKey []byte // key of the hmac store; random if empty
+}
 
-		// Call next handler
-		next.ServeHTTP(w, r)
-	})
+// NonceMiddleware adds a nonce from the given store to all responses
+func NonceMiddleware(nonces NonceStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Issue a new nonce
+			nonce, err := nonces.NewNonce(r.Context())
+			if err != nil {
+				logger.GetLogger(r.Context()).Errorf("Failed to issue nonce: %v", err)
+				http.Error(w, "Failed to issue nonce", http.StatusInternalServerError)
+				return
+			}
+
+			// Add nonce to response header
+			w.Header().Set(nonceHeader, nonce)
+
+			// Call next handler
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // generateNonce creates a new random nonce
@@ -51,92 +65,151 @@ func generateNonce() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(nonceBytes), nil
 }
 
-// Add after the imports
+// NonceStore issues and redeems replay nonces. ValidateAndRemove must succeed
+// at most once per nonce, even across concurrent requests.
 type NonceStore interface {
-	StoreNonce(nonce string) error
-	ValidateAndRemove(nonce string) error
-	CleanExpired() error
+	NewNonce(ctx context.Context) (string, error)
+	ValidateAndRemove(ctx context.Context, nonce string) error
+	CleanExpired(ctx context.Context) error
+	Size(ctx context.Context) (int64, error)
 }
 
-type MemoryNonceStore struct {
-	nonces map[string]*nonceEntry
-	mu     sync.RWMutex
+// NewNonceStore returns the nonce store selected by cfg. The database store
+// keeps nonces in the storage backend so that all replicas share them.
+func NewNonceStore(cfg NonceConfig, store storage.NonceStore) (NonceStore, error) {
+	switch cfg.Store {
+	case "", NonceStoreMemory:
+		return NewMemoryNonceStore(), nil
+	case NonceStoreDatabase:
+		if store == nil {
+			return nil, fmt.Errorf("database nonce store requires a storage backend")
+		}
+		return NewDatabaseNonceStore(store), nil
+	case NonceStoreHMAC:
+		return NewHMACNonceStore(cfg.HMACKey)
+	default:
+		return nil, fmt.Errorf("unknown nonce store %q", cfg.Store)
+	}
 }
 
-type nonceEntry struct {
-	used      bool
-	createdAt time.Time
+// RunNonceCleanup removes expired nonces from store every interval until ctx
+// is cancelled
+func RunNonceCleanup(ctx context.Context, nonces NonceStore, interval time.Duration, log *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := nonces.CleanExpired(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("Failed to clean expired nonces: %v", err)
+			}
+		}
+	}
+}
+
+// MemoryNonceStore keeps nonces in process memory. It is only suitable for a
+// single server instance.
+type MemoryNonceStore struct {
+	nonces map[string]time.Time
+	mu     sync.Mutex
 }
 
 func NewMemoryNonceStore() *MemoryNonceStore {
-	store := &MemoryNonceStore{
-		nonces: make(map[string]*nonceEntry),
+	return &MemoryNonceStore{
+		nonces: make(map[string]time.Time),
 	}
-	// Start cleanup goroutine
-	go store.periodicCleanup()
-	return store
 }
 
-func (s *MemoryNonceStore) StoreNonce(nonce string) error {
+func (s *MemoryNonceStore) NewNonce(ctx context.Context) (string, error) {
+	nonce, err := generateNonce()
+	if err != nil {
+		return "", err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Create a default logger if none exists
-	log := logger.New(os.Stdout)
-	log.Debugw("Storing nonce", "nonce", nonce)
-
-	s.nonces[nonce] = &nonceEntry{
-		used:      false,
-		createdAt: time.Now(),
-	}
-	return nil
+	s.nonces[nonce] = time.Now()
+	return nonce, nil
 }
 
-func (s *MemoryNonceStore) ValidateAndRemove(nonce string) error {
+func (s *MemoryNonceStore) ValidateAndRemove(ctx context.Context, nonce string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	log := logger.New(os.Stdout)
-	log.Debugw("Validating nonce", "nonce", nonce)
-
-	entry, exists := s.nonces[nonce]
+	createdAt, exists := s.nonces[nonce]
 	if !exists {
-		log.Errorw("Nonce not found", "nonce", nonce)
 		return fmt.Errorf("invalid nonce")
 	}
-	if entry.used {
-		log.Errorw("Nonce already used", "nonce", nonce)
-		return fmt.Errorf("nonce already used")
-	}
+	delete(s.nonces, nonce)
 
-	// Check if nonce has expired (15 minutes max age)
-	if time.Since(entry.createdAt) > 15*time.Minute {
-		delete(s.nonces, nonce)
+	if time.Since(createdAt) > NonceTTL {
 		return fmt.Errorf("nonce has expired")
 	}
-
-	// Mark as used and schedule for deletion
-	entry.used = true
 	return nil
 }
 
-func (s *MemoryNonceStore) CleanExpired() error {
+func (s *MemoryNonceStore) CleanExpired(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
-	for nonce, entry := range s.nonces {
-		// Remove if used or older than 15 minutes
-		if entry.used || now.Sub(entry.createdAt) > 15*time.Minute {
+	cutoff := time.Now().Add(-NonceTTL)
+	for nonce, createdAt := range s.nonces {
+		if createdAt.Before(cutoff) {
 			delete(s.nonces, nonce)
 		}
 	}
 	return nil
 }
 
-func (s *MemoryNonceStore) periodicCleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
-	for range ticker.C {
-		s.CleanExpired()
+func (s *MemoryNonceStore) Size(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.nonces)), nil
+}
+
+// DatabaseNonceStore keeps nonces in the storage backend (PostgreSQL or
+// SQLite). Nonces are consumed with a single DELETE, so a nonce replayed
+// against two replicas at once is accepted by at most one of them.
+type DatabaseNonceStore struct {
+	store storage.NonceStore
+}
+
+func NewDatabaseNonceStore(store storage.NonceStore) *DatabaseNonceStore {
+	return &DatabaseNonceStore{store: store}
+}
+
+func (s *DatabaseNonceStore) NewNonce(ctx context.Context) (string, error) {
+	nonce, err := generateNonce()
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.CreateNonce(ctx, nonce, time.Now()); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+func (s *DatabaseNonceStore) ValidateAndRemove(ctx context.Context, nonce string) error {
+	ok, err := s.store.ConsumeNonce(ctx, nonce, time.Now().Add(-NonceTTL))
+	if err != nil {
+		return err
 	}
+	if !ok {
+		return fmt.Errorf("invalid or expired nonce")
+	}
+	return nil
+}
+
+func (s *DatabaseNonceStore) CleanExpired(ctx context.Context) error {
+	_, err := s.store.DeleteExpiredNonces(ctx, time.Now().Add(-NonceTTL))
+	return err
+}
+
+func (s *DatabaseNonceStore) Size(ctx context.Context) (int64, error) {
+	return s.store.CountNonces(ctx)
 }
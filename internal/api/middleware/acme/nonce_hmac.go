@@ -0,0 +1,186 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// hmacNonceEpoch is the length of one epoch. Counters restart at zero in
+	// every epoch and each epoch keeps its own bitmap of used counters.
+	hmacNonceEpoch = 5 * time.Minute
+
+	// hmacNonceEpochs is the number of epochs whose nonces are accepted,
+	// covering NonceTTL plus the current, partially elapsed epoch
+	hmacNonceEpochs = int64(NonceTTL/hmacNonceEpoch) + 1
+
+	hmacNonceInstanceSize = 8
+	hmacNonceMACSize      = 16
+	hmacNonceSize         = hmacNonceInstanceSize + 8 + 8 + hmacNonceMACSize // instance, epoch, counter, MAC
+	hmacNonceKeySize      = 32
+)
+
+// HMACNonceStore issues stateless nonces of the form instance || epoch ||
+// counter || HMAC-SHA256(key, instance || epoch || counter). Issuing a nonce
+// allocates nothing; only redeemed nonces are recorded, as one bit per counter
+// in a bitmap per epoch.
+//
+// The used-counter window lives in process memory, so a nonce is only accepted
+// by the process that issued it: the random instance ID of every process is
+// part of the MACed nonce, and nonces of other replicas or of an earlier run
+// are rejected as bad nonces. Clients then retry with the fresh nonce of the
+// error response. Use the database store if requests are not routed to one
+// replica.
+type HMACNonceStore struct {
+	key      []byte
+	instance []byte
+	now      func() time.Time
+
+	mu      sync.Mutex
+	epoch   int64  // epoch of counter
+	counter uint64 // next counter in epoch
+	used    map[int64][]uint64
+}
+
+// NewHMACNonceStore creates an HMAC nonce store. An empty key is replaced by a
+// random one, which invalidates outstanding nonces on restart.
+func NewHMACNonceStore(key []byte) (*HMACNonceStore, error) {
+	if len(key) == 0 {
+		key = make([]byte, hmacNonceKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce key: %w", err)
+		}
+	}
+	if len(key) < hmacNonceKeySize {
+		return nil, fmt.Errorf("nonce key must be at least %d bytes", hmacNonceKeySize)
+	}
+
+	instance := make([]byte, hmacNonceInstanceSize)
+	if _, err := rand.Read(instance); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce instance ID: %w", err)
+	}
+
+	return &HMACNonceStore{
+		key:      key,
+		instance: instance,
+		now:      time.Now,
+		used:     make(map[int64][]uint64),
+	}, nil
+}
+
+func (s *HMACNonceStore) currentEpoch() int64 {
+	return s.now().UnixNano() / int64(hmacNonceEpoch)
+}
+
+func (s *HMACNonceStore) mac(msg []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(msg)
+	return h.Sum(nil)[:hmacNonceMACSize]
+}
+
+func (s *HMACNonceStore) NewNonce(ctx context.Context) (string, error) {
+	epoch := s.currentEpoch()
+
+	s.mu.Lock()
+	// Never move back to an earlier epoch if the clock is set back, which
+	// would hand out its counters a second time
+	if epoch > s.epoch {
+		s.epoch = epoch
+		s.counter = 0
+	}
+	epoch = s.epoch
+	counter := s.counter
+	s.counter++
+	s.mu.Unlock()
+
+	buf := make([]byte, hmacNonceSize)
+	copy(buf, s.instance)
+	fields := buf[hmacNonceInstanceSize:]
+	binary.BigEndian.PutUint64(fields[0:8], uint64(epoch))
+	binary.BigEndian.PutUint64(fields[8:16], counter)
+	copy(fields[16:], s.mac(buf[:hmacNonceInstanceSize+16]))
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (s *HMACNonceStore) ValidateAndRemove(ctx context.Context, nonce string) error {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != hmacNonceSize {
+		return fmt.Errorf("invalid nonce")
+	}
+	signed := buf[:hmacNonceInstanceSize+16]
+	if !hmac.Equal(buf[len(signed):], s.mac(signed)) {
+		return fmt.Errorf("invalid nonce")
+	}
+	// Only this process knows which of its nonces were redeemed
+	if !bytes.Equal(buf[:hmacNonceInstanceSize], s.instance) {
+		return fmt.Errorf("nonce was issued by another server instance")
+	}
+
+	fields := buf[hmacNonceInstanceSize:]
+	epoch := int64(binary.BigEndian.Uint64(fields[0:8]))
+	counter := binary.BigEndian.Uint64(fields[8:16])
+
+	current := s.currentEpoch()
+	if epoch > current {
+		return fmt.Errorf("invalid nonce")
+	}
+	if current-epoch >= hmacNonceEpochs {
+		return fmt.Errorf("nonce has expired")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bitmap := s.used[epoch]
+	word, bit := counter/64, uint64(1)<<(counter%64)
+	if word >= uint64(len(bitmap)) {
+		grown := make([]uint64, word+1)
+		copy(grown, bitmap)
+		bitmap = grown
+		s.used[epoch] = bitmap
+	}
+	if bitmap[word]&bit != 0 {
+		return fmt.Errorf("nonce already used")
+	}
+	bitmap[word] |= bit
+	return nil
+}
+
+func (s *HMACNonceStore) CleanExpired(ctx context.Context) error {
+	current := s.currentEpoch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for epoch := range s.used {
+		if current-epoch >= hmacNonceEpochs {
+			delete(s.used, epoch)
+		}
+	}
+	return nil
+}
+
+// Size returns the number of redeemed nonces remembered in the window;
+// issued nonces take no space
+func (s *HMACNonceStore) Size(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, bitmap := range s.used {
+		for _, word := range bitmap {
+			n += int64(bits.OnesCount64(word))
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+func TestNonceStoresConsumeOnce(t *testing.T) {
+	db, err := storage.Open(storage.Config{Backend: storage.BackendSQLite, SQLitePath: ":memory:"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	hmacStore, err := NewHMACNonceStore(nil)
+	if err != nil {
+		t.Fatalf("NewHMACNonceStore: %v", err)
+	}
+
+	stores := map[string]NonceStore{
+		NonceStoreMemory:   NewMemoryNonceStore(),
+		NonceStoreDatabase: NewDatabaseNonceStore(db),
+		NonceStoreHMAC:     hmacStore,
+	}
+
+	for name, nonces := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			nonce, err := nonces.NewNonce(ctx)
+			if err != nil {
+				t.Fatalf("NewNonce: %v", err)
+			}
+			if err := nonces.ValidateAndRemove(ctx, nonce); err != nil {
+				t.Errorf("ValidateAndRemove: %v", err)
+			}
+			if err := nonces.ValidateAndRemove(ctx, nonce); err == nil {
+				t.Error("second ValidateAndRemove succeeded")
+			}
+			if err := nonces.ValidateAndRemove(ctx, "unknown"); err == nil {
+				t.Error("ValidateAndRemove accepted an unknown nonce")
+			}
+			if err := nonces.CleanExpired(ctx); err != nil {
+				t.Errorf("CleanExpired: %v", err)
+			}
+		})
+	}
+}
+
+func TestHMACNonceStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store, err := NewHMACNonceStore(bytes.Repeat([]byte{1}, hmacNonceKeySize))
+	if err != nil {
+		t.Fatalf("NewHMACNonceStore: %v", err)
+	}
+	store.now = func() time.Time { return now }
+
+	first, _ := store.NewNonce(ctx)
+	second, _ := store.NewNonce(ctx)
+	if first == second {
+		t.Fatal("NewNonce returned the same nonce twice")
+	}
+
+	// A nonce from another key is rejected
+	other, _ := NewHMACNonceStore(bytes.Repeat([]byte{2}, hmacNonceKeySize))
+	forged, _ := other.NewNonce(ctx)
+	if err := store.ValidateAndRemove(ctx, forged); err == nil {
+		t.Error("ValidateAndRemove accepted a nonce with a foreign MAC")
+	}
+
+	// A replica with the same key does not know which nonces were redeemed
+	// here, so it rejects them
+	replica, _ := NewHMACNonceStore(bytes.Repeat([]byte{1}, hmacNonceKeySize))
+	replica.now = store.now
+	redeemed, _ := store.NewNonce(ctx)
+	if err := store.ValidateAndRemove(ctx, redeemed); err != nil {
+		t.Fatalf("ValidateAndRemove(redeemed): %v", err)
+	}
+	if err := replica.ValidateAndRemove(ctx, redeemed); err == nil {
+		t.Error("a second store with the same key accepted a nonce redeemed on the first")
+	}
+
+	// Redeeming one nonce does not affect another of the same epoch
+	if err := store.ValidateAndRemove(ctx, second); err != nil {
+		t.Errorf("ValidateAndRemove(second): %v", err)
+	}
+	if err := store.ValidateAndRemove(ctx, first); err != nil {
+		t.Errorf("ValidateAndRemove(first): %v", err)
+	}
+
+	// Nonces older than the window expire and their bitmaps are dropped
+	stale, _ := store.NewNonce(ctx)
+	now = now.Add(NonceTTL + 2*hmacNonceEpoch)
+	if err := store.ValidateAndRemove(ctx, stale); err == nil {
+		t.Error("ValidateAndRemove accepted an expired nonce")
+	}
+	store.CleanExpired(ctx)
+	if len(store.used) != 0 {
+		t.Errorf("CleanExpired kept %d epochs", len(store.used))
+	}
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses addresses and CIDR prefixes of reverse proxies
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR prefix", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// realIP replaces the remote address of a request with the address of the
+// client. X-Forwarded-For and X-Real-IP are only honored if the peer is one
+// of the trusted proxies; anyone else could name an arbitrary client in them.
+// X-Forwarded-For is read from the right, skipping trusted proxies, since
+// entries to the left of the last untrusted hop are chosen by the client.
+// The port is dropped, so the address identifies the client across
+// connections.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, ok := parseAddr(r.RemoteAddr)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if isTrusted(client) {
+				if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+					hops := strings.Split(strings.Join(forwarded, ","), ",")
+					for i := len(hops) - 1; i >= 0 && isTrusted(client); i-- {
+						hop, ok := parseAddr(strings.TrimSpace(hops[i]))
+						if !ok {
+							break
+						}
+						client = hop
+					}
+				} else if hop, ok := parseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+					client = hop
+				}
+			}
+
+			r.RemoteAddr = client.String()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// parseAddr parses an address with or without a port
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string // X-Forwarded-For values
+		realIP    string
+		want      string
+	}{
+		{"direct client", "198.51.100.7:4711", nil, "", "198.51.100.7"},
+		{"spoofed by an untrusted peer", "198.51.100.7:4711", []string{"203.0.113.9"}, "203.0.113.9", "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:4711", []string{"203.0.113.9"}, "", "203.0.113.9"},
+		{"trusted proxy address", "192.0.2.1:4711", nil, "203.0.113.9", "203.0.113.9"},
+		{"chain of trusted proxies", "10.1.2.3:4711", []string{"203.0.113.9, 10.4.5.6", "192.0.2.1"}, "", "203.0.113.9"},
+		{"client-chosen entries are skipped", "10.1.2.3:4711", []string{"127.0.0.1, 203.0.113.9"}, "", "203.0.113.9"},
+		{"garbage from a trusted proxy", "10.1.2.3:4711", []string{"unknown"}, "", "10.1.2.3"},
+		{"IPv6 client", "[2001:db8::1]:4711", nil, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := realIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/directory", nil)
+			req.RemoteAddr = tt.peer
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy.example"}); err == nil {
+		t.Error("ParseTrustedProxies accepted a host name")
+	}
+}
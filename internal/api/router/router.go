@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,27 +16,134 @@ import (
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/handlers"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/middleware/acme"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/caa"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/health"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/issuance"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/ratelimit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
 )
 
-func New(ctx context.Context, db *database.DB) *chi.Mux {
+// Config holds the dependencies of the ACME router
+type Config struct {
+	Store    storage.Store
+	Issuance *issuance.Pool
+	Nonces   acme.NonceStore
+	// RateLimiter limits the ACME endpoints; nil disables rate limits
+	RateLimiter *ratelimit.Limiter
+	// CAA checks the CAA records of dns identifiers for tenants with CAA
+	// identities; nil disables CAA checking
+	CAA *caa.Checker
+	// Audit records account creation and validations; nil disables
+	// auditing
+	Audit *audit.Log
+	// Webhooks notifies endpoints of new accounts and orders and of failed
+	// challenges; nil disables webhooks
+	Webhooks *webhook.Notifier
+
+	// Metrics serves /metrics on this router. Leave it unset when metrics
+	// are exposed on a separate admin listener.
+	Metrics bool
+
+	// Readiness backs /readyz; without it /readyz always reports ready
+	Readiness *health.Checker
+
+	// Admin is mounted at AdminPrefix. Leave it unset when the admin API
+	// is served on a separate listener.
+	Admin       http.Handler
+	AdminPrefix string
+
+	// ExternalURLs are the base URLs clients use to reach the ACME API,
+	// the first one being canonical. Without them URLs are derived from
+	// the request.
+	ExternalURLs []*url.URL
+	// PathPrefix mounts the ACME API below a path, e.g. "/pki". Probes,
+	// metrics and the admin API stay at the root.
+	PathPrefix string
+
+	// Tenants are mounted below PathPrefix at their own path. Without
+	// tenants a default tenant with a test CA is served.
+	Tenants *tenant.Registry
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers name the client; the headers of other peers are
+	// ignored
+	TrustedProxies []netip.Prefix
+}
+
+func New(ctx context.Context, cfg Config) *chi.Mux {
 	r := chi.NewRouter()
+	store, pool, nonces := cfg.Store, cfg.Issuance, cfg.Nonces
+
+	// Add storage to context middleware if provided
+	if store != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), types.CtxKeyStore, store)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+
+	// Add issuance pool to context middleware if provided
+	if pool != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), types.CtxKeyIssuance, pool)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+
+	// Add the rate limiter to context middleware if provided
+	if cfg.RateLimiter != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), types.CtxKeyRateLimiter, cfg.RateLimiter)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
 
-	// Add database to context middleware if provided
-	if db != nil {
+	// Add the CAA checker to context middleware if provided
+	if cfg.CAA != nil {
 		r.Use(func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				ctx := context.WithValue(r.Context(), types.CtxKeyDB, db)
+				ctx := context.WithValue(r.Context(), types.CtxKeyCAA, cfg.CAA)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+
+	// Add the audit log to context middleware if provided
+	if cfg.Audit != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), types.CtxKeyAudit, cfg.Audit)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+
+	// Add the webhook notifier to context middleware if provided
+	if cfg.Webhooks != nil {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), types.CtxKeyWebhooks, cfg.Webhooks)
 				next.ServeHTTP(w, r.WithContext(ctx))
 			})
 		})
 	}
 
 	// Global middleware
+	r.Use(metrics.Middleware)
 	r.Use(withLogger(logger.GetLogger(ctx)))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(cfg.TrustedProxies))
 	r.Use(middleware.RequestID)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"*"},
@@ -44,12 +154,72 @@ func New(ctx context.Context, db *database.DB) *chi.Mux {
 
 	// Public endpoints (no nonce or JWT verification required)
 	r.Get("/health", handlers.HealthCheck)
+	r.Get("/livez", health.Live)
+	readiness := cfg.Readiness
+	if readiness == nil {
+		readiness = health.NewChecker(0)
+	}
+	r.Method(http.MethodGet, "/readyz", readiness)
+	if cfg.Metrics {
+		r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	}
+
+	// Operator endpoints authenticate on their own
+	if cfg.Admin != nil {
+		r.Mount(cfg.AdminPrefix, cfg.Admin)
+	}
+
+	tenants := cfg.Tenants
+	if tenants == nil {
+		tenants, _ = tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{}))
+	}
+	for _, t := range tenants.All() {
+		t := t
+		prefix := cfg.PathPrefix + t.PathPrefix
+		acmeRoutes := func(r chi.Router) {
+			r.Use(handlers.BaseURLMiddleware(tenantURLs(cfg.ExternalURLs, t.PathPrefix), prefix))
+			r.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), t)))
+				})
+			})
+			routes(r, nonces)
+		}
+		if prefix != "" {
+			r.Route(prefix, acmeRoutes)
+		} else {
+			r.Group(acmeRoutes)
+		}
+	}
+
+	return r
+}
+
+// tenantURLs appends the path of a tenant to the external base URLs
+func tenantURLs(urls []*url.URL, pathPrefix string) []*url.URL {
+	if pathPrefix == "" {
+		return urls
+	}
+	tenantURLs := make([]*url.URL, len(urls))
+	for i, u := range urls {
+		tenantURL := *u
+		tenantURL.Path = strings.TrimSuffix(u.Path, "/") + pathPrefix
+		tenantURL.RawPath = ""
+		tenantURLs[i] = &tenantURL
+	}
+	return tenantURLs
+}
+
+// routes registers the ACME endpoints
+func routes(r chi.Router, nonces acme.NonceStore) {
 	r.Get("/directory", handlers.GetDirectory)
+	r.Get("/renewal-info/{certID}", handlers.GetRenewalInfo)
+	r.Get("/star-cert/{id}", handlers.GetStarCertificate)
 
 	// ACME protocol endpoints
 	r.Group(func(r chi.Router) {
 		// Add nonce middleware to all ACME endpoints
-		r.Use(acme.NonceMiddleware)
+		r.Use(acme.NonceMiddleware(nonces))
 
 		// Nonce endpoints
 		r.Get("/new-nonce", handlers.NewNonce)
@@ -57,7 +227,7 @@ func New(ctx context.Context, db *database.DB) *chi.Mux {
 
 		// Account management (with JWT verification)
 		r.Group(func(r chi.Router) {
-			r.Use(acme.JWSVerificationMiddleware)
+			r.Use(acme.JWSVerificationMiddleware(nonces))
 			r.Post("/new-account", handlers.NewAccount)
 			r.Post("/account/{id}", handlers.UpdateAccount)
 			r.Post("/account/{id}/key-change", handlers.KeyChange)
@@ -76,11 +246,10 @@ func New(ctx context.Context, db *database.DB) *chi.Mux {
 
 			// Certificate management
 			r.Post("/cert/{certID}", handlers.GetCertificate)
+			r.Post("/star-cert/{id}", handlers.GetStarCertificate)
 			r.Post("/revoke-cert", handlers.RevokeCertificate)
 		})
 	})
-
-	return r
 }
 
 // withLogger is middleware that logs each HTTP request.
@@ -23,6 +23,12 @@ type Account struct {
 	CreatedAt            int64           `json:"createdAt"`
 	InitialIP            string          `json:"initialIp"`
 	OrdersURL            string          `json:"orders"`
+	Tenant               string          `json:"tenant,omitempty"`
+	// Scope restricts the identifiers the account may order to domain
+	// suffixes and IP ranges; empty allows all the tenant allows
+	Scope []string `json:"scope,omitempty"`
+	// EABKeyID is the External Account Binding key bound on creation
+	EABKeyID string `json:"-"`
 }
 
 // AccountRequest represents the JSON payload for a new-account request
@@ -30,6 +36,9 @@ type AccountRequest struct {
 	Contact              []string `json:"contact"`
 	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
 	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	// ExternalAccountBinding is a JWS signed with an EAB key (RFC 8555
+	// Section 7.3.4)
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
 }
 
 // Error implements the error interface for Problem
@@ -2,20 +2,32 @@ package types
 
 // DirectoryMetadata holds optional metadata for the directory object
 type DirectoryMetadata struct {
-	TermsOfService          string   `json:"termsOfService,omitempty"`
-	Website                 string   `json:"website,omitempty"`
-	CAAIdentities           []string `json:"caaIdentities,omitempty"`
-	ExternalAccountRequired bool     `json:"externalAccountRequired,omitempty"`
+	TermsOfService          string               `json:"termsOfService,omitempty"`
+	Website                 string               `json:"website,omitempty"`
+	CAAIdentities           []string             `json:"caaIdentities,omitempty"`
+	ExternalAccountRequired bool                 `json:"externalAccountRequired,omitempty"`
+	AutoRenewal             *AutoRenewalMetadata `json:"auto-renewal,omitempty"`
+	// Profiles maps the offered certificate profiles to their descriptions
+	Profiles map[string]string `json:"profiles,omitempty"`
+}
+
+// AutoRenewalMetadata advertises the server's STAR limits (RFC 8739 Section 3.1.3).
+// Durations are in seconds.
+type AutoRenewalMetadata struct {
+	MinLifetime         int64 `json:"min-lifetime"`
+	MaxDuration         int64 `json:"max-duration"`
+	AllowCertificateGet bool  `json:"allow-certificate-get,omitempty"`
 }
 
 // Directory represents the ACME directory object
 type Directory struct {
-	NewNonce   string             `json:"newNonce"`
-	NewAccount string             `json:"newAccount"`
-	NewOrder   string             `json:"newOrder"`
-	RevokeCert string             `json:"revokeCert"`
-	KeyChange  string             `json:"keyChange"`
-	Meta       *DirectoryMetadata `json:"meta,omitempty"`
+	NewNonce    string             `json:"newNonce"`
+	NewAccount  string             `json:"newAccount"`
+	NewOrder    string             `json:"newOrder"`
+	RevokeCert  string             `json:"revokeCert"`
+	KeyChange   string             `json:"keyChange"`
+	RenewalInfo string             `json:"renewalInfo,omitempty"`
+	Meta        *DirectoryMetadata `json:"meta,omitempty"`
 }
 
 // Problem represents an ACME error response
@@ -29,7 +41,8 @@ type Problem struct {
 
 // Subproblem represents a subproblem in an ACME error response
 type Subproblem struct {
-	Type   string `json:"type"`
-	Detail string `json:"detail"`
-	Status int    `json:"status"`
+	Type       string      `json:"type"`
+	Detail     string      `json:"detail"`
+	Status     int         `json:"status"`
+	Identifier *Identifier `json:"identifier,omitempty"`
 }
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// DefaultListLimit is used when a list request does not set a limit
+const DefaultListLimit = 100
+
+// ListFilter narrows the records returned by the admin list queries. Empty
+// fields do not filter.
+type ListFilter struct {
+	AccountID string // owning account; for accounts the account ID itself
+	Status    string // record status; for certificates "valid" or "revoked"
+	Query     string // substring of account contacts or order identifiers
+	Serial    string // hex encoded certificate serial number
+	Limit     int
+	Offset    int
+}
+
+// EABKey is an External Account Binding key (RFC 8555 Section 7.3.4)
+// handed out by operators. The key is bound to the first account created
+// with it.
+type EABKey struct {
+	ID             string     `json:"id"`
+	HMACKey        []byte     `json:"-"` // only handed out once, on creation
+	Label          string     `json:"label,omitempty"`
+	Tenant         string     `json:"tenant,omitempty"` // the key only binds accounts of this tenant
+	Scope          []string   `json:"scope,omitempty"`  // inherited by the bound account
+	CreatedAt      time.Time  `json:"createdAt"`
+	BoundAccountID string     `json:"boundAccountId,omitempty"`
+	BoundAt        *time.Time `json:"boundAt,omitempty"`
+}
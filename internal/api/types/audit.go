@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+// AuditRecord is an audit log entry as kept by the store. Entry holds the
+// JSON entry exactly as it was hashed and signed; the other fields repeat
+// parts of it for lookups.
+type AuditRecord struct {
+	Seq       int64
+	Event     string
+	CreatedAt time.Time
+	Entry     []byte
+}
@@ -33,6 +33,9 @@ type Challenge struct {
 	Status          ChallengeStatus `json:"status"`
 	Token           string          `json:"token"`
 	Validated       *Time           `json:"validated,omitempty"`
+	// Error reports why validation failed. It is only part of the
+	// response to the validation request.
+	Error *Problem `json:"error,omitempty"`
 }
 
 type ChallengeStatus string
@@ -43,3 +46,9 @@ const (
 	ChallengeStatusValid      ChallengeStatus = "valid"
 	ChallengeStatusInvalid    ChallengeStatus = "invalid"
 )
+
+// AuthorizationUpdateRequest represents the JSON payload a client sends to
+// update an authorization (RFC 8555 Section 7.5.2)
+type AuthorizationUpdateRequest struct {
+	Status AuthorizationStatus `json:"status"`
+}
@@ -0,0 +1,17 @@
+package types
+
+import "strings"
+
+// BaseURL is where clients reach the ACME API for a request
+type BaseURL struct {
+	// URL is the external base URL including any path, without a trailing
+	// slash, e.g. "https://acme.example.com/pki"
+	URL string
+	// PathPrefix is where the ACME routes are mounted locally, e.g. "/pki"
+	PathPrefix string
+}
+
+// Resolve returns the external URL of the local request path
+func (b *BaseURL) Resolve(localPath string) string {
+	return b.URL + strings.TrimPrefix(localPath, b.PathPrefix)
+}
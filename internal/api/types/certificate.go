@@ -1,5 +1,7 @@
 package types
 
+import "fmt"
+
 // CertificateRequest represents a certificate signing request
 type CertificateRequest struct {
 	CSR string `json:"csr"` // Base64URL-encoded PKCS#10 CSR
@@ -15,8 +17,48 @@ const (
 	RevocationReasonAffiliationChanged   RevocationReason = 3
 	RevocationReasonSuperseded           RevocationReason = 4
 	RevocationReasonCessationOfOperation RevocationReason = 5
+	RevocationReasonCertificateHold      RevocationReason = 6
+	RevocationReasonRemoveFromCRL        RevocationReason = 8
+	RevocationReasonPrivilegeWithdrawn   RevocationReason = 9
+	RevocationReasonAACompromise         RevocationReason = 10
 )
 
+// revocationReasonNames are the CRLReason names of RFC 5280 Section 5.3.1
+var revocationReasonNames = map[RevocationReason]string{
+	RevocationReasonUnspecified:          "unspecified",
+	RevocationReasonKeyCompromise:        "keyCompromise",
+	RevocationReasonCACompromise:         "cACompromise",
+	RevocationReasonAffiliationChanged:   "affiliationChanged",
+	RevocationReasonSuperseded:           "superseded",
+	RevocationReasonCessationOfOperation: "cessationOfOperation",
+	RevocationReasonCertificateHold:      "certificateHold",
+	RevocationReasonRemoveFromCRL:        "removeFromCRL",
+	RevocationReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	RevocationReasonAACompromise:         "aACompromise",
+}
+
+// String returns the RFC 5280 name of the reason
+func (r RevocationReason) String() string {
+	if name, ok := revocationReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason parses an RFC 5280 reason name. The empty string
+// selects unspecified.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	if name == "" {
+		return RevocationReasonUnspecified, nil
+	}
+	for reason, n := range revocationReasonNames {
+		if n == name {
+			return reason, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason %q", name)
+}
+
 // RevocationRequest represents a request to revoke a certificate
 type RevocationRequest struct {
 	Certificate string           `json:"certificate"`   // Base64URL-encoded DER certificate
@@ -5,6 +5,12 @@ type ContextKey string
 
 const (
 	// Context keys
-	CtxKeyLogger ContextKey = "logger"
-	CtxKeyDB     ContextKey = "db"
+	CtxKeyLogger      ContextKey = "logger"
+	CtxKeyStore       ContextKey = "store"
+	CtxKeyIssuance    ContextKey = "issuance"
+	CtxKeyBaseURL     ContextKey = "baseURL"
+	CtxKeyRateLimiter ContextKey = "rateLimiter"
+	CtxKeyCAA         ContextKey = "caa"
+	CtxKeyAudit       ContextKey = "audit"
+	CtxKeyWebhooks    ContextKey = "webhooks"
 )
@@ -8,6 +8,7 @@ const (
 	OrderStatusValid      OrderStatus = "valid"
 	OrderStatusInvalid    OrderStatus = "invalid"
 	OrderStatusProcessing OrderStatus = "processing"
+	OrderStatusCanceled   OrderStatus = "canceled" // STAR orders only (RFC 8739)
 )
 
 type Order struct {
@@ -22,6 +23,12 @@ type Order struct {
 	Error          *Problem     `json:"error,omitempty"`
 	CertificateID  string       `json:"certificate,omitempty" db:"certificate_id"`
 	Authorizations []string     `json:"authorizations"`
+	Replaces       string       `json:"replaces,omitempty" db:"replaces"`
+	AutoRenewal    *AutoRenewal `json:"auto-renewal,omitempty" db:"auto_renewal"`
+	StarCertURL    string       `json:"star-certificate,omitempty"`
+	CSR            string       `json:"-" db:"csr"` // Base64URL-encoded CSR, kept for STAR reissuance
+	Tenant         string       `json:"tenant,omitempty" db:"tenant"`
+	Profile        string       `json:"profile,omitempty" db:"profile"`
 	CreatedAt      Time         `json:"createdAt" db:"created_at"`
 	UpdatedAt      Time         `json:"updatedAt" db:"updated_at"`
 }
@@ -35,6 +42,25 @@ type OrderRequest struct {
 	Identifiers []Identifier `json:"identifiers"`
 	NotBefore   Time         `json:"notBefore,omitempty"`
 	NotAfter    Time         `json:"notAfter,omitempty"`
+	Replaces    string       `json:"replaces,omitempty"` // ARI certificate identifier (RFC 9773)
+	AutoRenewal *AutoRenewal `json:"auto-renewal,omitempty"`
+	Profile     string       `json:"profile,omitempty"` // certificate profile, defaults to the first one offered
+}
+
+// OrderUpdateRequest represents the JSON payload a client sends to update an
+// order, which is only used to cancel STAR orders (RFC 8739 Section 3.1.2)
+type OrderUpdateRequest struct {
+	Status OrderStatus `json:"status"`
+}
+
+// AutoRenewal represents the auto-renewal object of a Short-Term Automatically
+// Renewed (STAR) order (RFC 8739 Section 3.1.1). Lifetimes are in seconds.
+type AutoRenewal struct {
+	StartDate           Time  `json:"start-date"`
+	EndDate             Time  `json:"end-date"`
+	Lifetime            int64 `json:"lifetime"`
+	LifetimeAdjust      int64 `json:"lifetime-adjust,omitempty"`
+	AllowCertificateGet bool  `json:"allow-certificate-get,omitempty"`
 }
 
 type FinalizeRequest struct {
@@ -45,6 +71,11 @@ type Certificate struct {
 	ID               string `json:"id"`
 	OrderID          string `json:"orderId"`
 	Certificate      string `json:"certificate"` // PEM encoded certificate
+	Serial           string `json:"serial"`      // Hex encoded serial number
+	AuthorityKeyID   string `json:"authorityKeyId"`
+	NotBefore        Time   `json:"notBefore"`
+	NotAfter         Time   `json:"notAfter"`
+	ReplacedBy       string `json:"replacedBy,omitempty"`
 	Revoked          bool   `json:"revoked"`
 	RevocationReason string `json:"revocationReason,omitempty"`
 	RevokedAt        Time   `json:"revokedAt,omitempty"`
@@ -0,0 +1,11 @@
+package types
+
+import "time"
+
+// RateLimitSpend is a token to take from the bucket of Key, which holds
+// Period/Interval tokens. Several spends are taken together or not at all.
+type RateLimitSpend struct {
+	Key      string
+	Interval time.Duration
+	Period   time.Duration
+}
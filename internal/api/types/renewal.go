@@ -0,0 +1,22 @@
+package types
+
+// RenewalWindow is the period during which a client should renew a
+// certificate (RFC 9773 Section 4.2)
+type RenewalWindow struct {
+	Start Time `json:"start"`
+	End   Time `json:"end"`
+}
+
+// RenewalInfo represents the ACME Renewal Information response object
+type RenewalInfo struct {
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	ExplanationURL  string        `json:"explanationURL,omitempty"`
+}
+
+// RenewalOverride is an operator-defined renewal window that replaces the
+// computed window, either for a single certificate or for every certificate
+// of an issuer
+type RenewalOverride struct {
+	Window         RenewalWindow
+	ExplanationURL string
+}
@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// WebhookDelivery is an event queued for delivery to a webhook endpoint.
+// Payload holds the JSON event exactly as it is sent; deliveries are removed
+// once the endpoint accepted them.
+type WebhookDelivery struct {
+	ID            string
+	Endpoint      string // endpoint name
+	Event         string // event type
+	Payload       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
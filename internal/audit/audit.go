@@ -0,0 +1,258 @@
+// Package audit keeps a tamper-evident log of what the CA did and why:
+// account creation, validations with the evidence they were decided on,
+// issuance and revocation. Every entry carries the hash of its predecessor
+// and is optionally signed, so that altering, inserting or removing entries
+// breaks the chain checked by "acme-server audit verify".
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+)
+
+// maxAppendAttempts bounds how often an append is retried after another
+// replica took the next sequence number
+const maxAppendAttempts = 5
+
+// Entry is an audit log entry. Hash is the hex SHA-256 hash of the JSON
+// encoding of the entry without Hash and Signature, Prev the hash of the
+// previous entry. The first entry has sequence number 1 and no Prev.
+type Entry struct {
+	Seq     int64           `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Event   string          `json:"event"`
+	Tenant  string          `json:"tenant,omitempty"`
+	Account string          `json:"account,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Prev    string          `json:"prev,omitempty"`
+	Hash    string          `json:"hash,omitempty"`
+	// Signature signs the hash with the audit signing key, if one is
+	// configured
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// digest returns the hash the entry is chained and signed with
+func (e *Entry) digest() ([]byte, error) {
+	unsealed := *e
+	unsealed.Hash, unsealed.Signature = "", nil
+	b, err := json.Marshal(&unsealed)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// Store keeps the audit log in the storage backend; storage.Store
+// implements it
+type Store interface {
+	AppendAuditRecord(ctx context.Context, record *types.AuditRecord) (bool, error)
+	GetLastAuditRecord(ctx context.Context) (*types.AuditRecord, error)
+	ListAuditRecords(ctx context.Context, afterSeq int64, limit int) ([]*types.AuditRecord, error)
+}
+
+// Config selects where the audit log is written. At least one of Store and
+// File has to be set.
+type Config struct {
+	Store Store
+	// File receives every entry as a line of JSON. With a store it is a
+	// copy of the entries written by this instance.
+	File string
+	// Signer signs the entry hashes; nil leaves entries unsigned
+	Signer crypto.Signer
+}
+
+// Log appends entries to the audit log
+type Log struct {
+	store  Store
+	file   *os.File
+	signer crypto.Signer
+
+	mu sync.Mutex
+}
+
+// Open opens the audit log, creating the file if needed
+func Open(cfg Config) (*Log, error) {
+	if cfg.Store == nil && cfg.File == "" {
+		return nil, errors.New("audit log needs a store or a file")
+	}
+	l := &Log{store: cfg.Store, signer: cfg.Signer}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening audit log: %w", err)
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Record appends an event to the audit log. data is stored as JSON. A nil
+// Log records nothing, so callers need not check whether auditing is
+// enabled.
+func (l *Log) Record(ctx context.Context, event, tenant, account string, data any) error {
+	if l == nil {
+		return nil
+	}
+	err := l.record(ctx, event, tenant, account, data)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.AuditEntries.WithLabelValues(event, result).Inc()
+	return err
+}
+
+func (l *Log) record(ctx context.Context, event, tenant, account string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error encoding audit data: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Without a store the last line of the file is the predecessor, so the
+	// file stays locked from reading it until the entry is appended
+	if l.file != nil {
+		unlock, err := lockFile(l.file)
+		if err != nil {
+			return fmt.Errorf("error locking audit log: %w", err)
+		}
+		defer unlock()
+	}
+
+	for attempt := 1; ; attempt++ {
+		prev, err := l.last(ctx)
+		if err != nil {
+			return err
+		}
+		entry := &Entry{Seq: 1, Time: time.Now().UTC(), Event: event, Tenant: tenant, Account: account, Data: raw}
+		if prev != nil {
+			entry.Seq, entry.Prev = prev.Seq+1, prev.Hash
+		}
+		line, err := l.seal(entry)
+		if err != nil {
+			return err
+		}
+
+		if l.store != nil {
+			appended, err := l.store.AppendAuditRecord(ctx, &types.AuditRecord{
+				Seq:       entry.Seq,
+				Event:     entry.Event,
+				CreatedAt: entry.Time,
+				Entry:     line,
+			})
+			if err != nil {
+				return err
+			}
+			if !appended {
+				if attempt < maxAppendAttempts {
+					continue
+				}
+				return fmt.Errorf("audit entry %d was taken by another instance %d times", entry.Seq, attempt)
+			}
+		}
+		if l.file != nil {
+			if _, err := l.file.Write(append(line, '\n')); err != nil {
+				return fmt.Errorf("error writing audit log: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// seal sets the hash and signature of an entry and returns its encoding
+func (l *Log) seal(e *Entry) ([]byte, error) {
+	digest, err := e.digest()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding audit entry: %w", err)
+	}
+	e.Hash = hex.EncodeToString(digest)
+	if l.signer != nil {
+		// Ed25519 signs the hash itself rather than a prehash of it
+		var opts crypto.SignerOpts = crypto.SHA256
+		if _, ok := l.signer.Public().(ed25519.PublicKey); ok {
+			opts = crypto.Hash(0)
+		}
+		if e.Signature, err = l.signer.Sign(rand.Reader, digest, opts); err != nil {
+			return nil, fmt.Errorf("error signing audit entry: %w", err)
+		}
+	}
+	return json.Marshal(e)
+}
+
+// last returns the latest entry of the store, or of the file without a
+// store, and nil for an empty log. Reading it on every append keeps the
+// chain intact when other instances or commands append as well.
+func (l *Log) last(ctx context.Context) (*Entry, error) {
+	var raw []byte
+	if l.store != nil {
+		record, err := l.store.GetLastAuditRecord(ctx)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		raw = record.Entry
+	} else {
+		var err error
+		if raw, err = lastLine(l.file); err != nil || raw == nil {
+			return nil, err
+		}
+	}
+
+	var e Entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, fmt.Errorf("invalid last audit entry: %w", err)
+	}
+	return &e, nil
+}
+
+// lastLine returns the last non-empty line of f, or nil if f is empty
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var tail []byte
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		n := min(int64(len(buf)), end)
+		end -= n
+		if _, err := f.ReadAt(buf[:n], end); err != nil {
+			return nil, fmt.Errorf("error reading audit log: %w", err)
+		}
+		tail = append(append([]byte(nil), buf[:n]...), tail...)
+
+		line := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 {
+			return line[i+1:], nil
+		}
+	}
+	if line := bytes.TrimRight(tail, "\n"); len(line) > 0 {
+		return line, nil
+	}
+	return nil, nil
+}
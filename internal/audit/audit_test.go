@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestLogChain(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.New()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Two instances share the store; only the first writes a file
+	first, err := Open(Config{Store: store, File: path, Signer: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := Open(Config{Store: store, Signer: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		log   *Log
+		event string
+		data  any
+	}{
+		{first, EventAccountCreated, AccountCreated{KeyThumbprint: "abc"}},
+		{first, EventValidation, Validation{Token: "t1", Status: "valid"}},
+		{second, EventCertificateIssued, CertificateIssued{Serial: "01", DNSNames: []string{"plc1.plant.example"}}},
+		{first, EventCertificateRevoked, CertificateRevoked{Serial: "01", Reason: "keyCompromise", By: "operator"}},
+	} {
+		if err := e.log.Record(ctx, e.event, "plant", "acct_1", e.data); err != nil {
+			t.Fatalf("Record %s: %v", e.event, err)
+		}
+	}
+
+	v, err := VerifyStore(ctx, store, &key.PublicKey)
+	if err != nil || v.Entries != 4 || v.Last.Event != EventCertificateRevoked {
+		t.Fatalf("VerifyStore = %+v, %v", v, err)
+	}
+
+	// The file lacks the entry of the second instance
+	if _, err := VerifyFile(path, &key.PublicKey); err == nil || !strings.Contains(err.Error(), "missing or reordered") {
+		t.Errorf("VerifyFile with a missing entry: %v", err)
+	}
+
+	// A file written alone chains onto its own last line
+	path = filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range []string{"01", "02", "03"} {
+		if err := log.Record(ctx, EventCertificateIssued, "", "acct_1", CertificateIssued{Serial: serial}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+	if v, err := VerifyFile(path, nil); err != nil || v.Entries != 3 {
+		t.Fatalf("VerifyFile = %+v, %v", v, err)
+	}
+	if _, err := VerifyFile(path, &key.PublicKey); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("VerifyFile of unsigned entries with a key: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, bytes.Replace(data, []byte(`"serial":"02"`), []byte(`"serial":"04"`), 1), 0o600)
+	if _, err := VerifyFile(path, nil); err == nil || !strings.Contains(err.Error(), "entry 2: hash mismatch") {
+		t.Errorf("VerifyFile of an altered entry: %v", err)
+	}
+}
+
+func TestFileConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// The server and an operator command open the same file on their own
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, name := range []string{"server", "command"} {
+		log, err := Open(Config{File: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := log.Record(ctx, EventCertificateRevoked, "", "", CertificateRevoked{Serial: "01", By: name}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Record: %v", err)
+	}
+
+	if v, err := VerifyFile(path, nil); err != nil || v.Entries != 100 {
+		t.Fatalf("VerifyFile = %+v, %v", v, err)
+	}
+}
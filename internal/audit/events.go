@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+// Events recorded in the audit log
+const (
+	EventAccountCreated       = "account.created"
+	EventValidation           = "challenge.validation"
+	EventCertificateIssued    = "certificate.issued"
+	EventCertificateDiscarded = "certificate.discarded"
+	EventCertificateRevoked   = "certificate.revoked"
+)
+
+// AccountCreated is the data of an account.created entry
+type AccountCreated struct {
+	Contact []string `json:"contact,omitempty"`
+	// KeyThumbprint is the RFC 7638 SHA-256 thumbprint of the account key
+	KeyThumbprint string   `json:"keyThumbprint"`
+	InitialIP     string   `json:"initialIp,omitempty"`
+	EABKeyID      string   `json:"eabKeyId,omitempty"`
+	Scope         []string `json:"scope,omitempty"`
+}
+
+// Validation is the data of a challenge.validation entry: the outcome of a
+// challenge and the evidence it was decided on
+type Validation struct {
+	Order         string           `json:"order"`
+	Authorization string           `json:"authorization"`
+	Identifier    types.Identifier `json:"identifier"`
+	Challenge     string           `json:"challenge"` // challenge type
+	Token         string           `json:"token"`
+	Status        string           `json:"status"`
+	Evidence      Evidence         `json:"evidence"`
+	Error         *types.Problem   `json:"error,omitempty"`
+}
+
+// Evidence is what a validation was decided on
+type Evidence struct {
+	// KeyAuthorization is the key authorization the challenge proves
+	// control with (RFC 8555 Section 8.1)
+	KeyAuthorization string `json:"keyAuthorization,omitempty"`
+	// RemoteAddr is the client address the challenge was triggered from
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// CAAIdentities are the issuer domain names the CAA records were
+	// checked for; empty if CAA was not checked
+	CAAIdentities []string `json:"caaIdentities,omitempty"`
+}
+
+// CertificateIssued is the data of a certificate.issued entry
+type CertificateIssued struct {
+	Order          string    `json:"order"`
+	Certificate    string    `json:"certificate"`
+	Serial         string    `json:"serial"`
+	AuthorityKeyID string    `json:"authorityKeyId"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	Profile        string    `json:"profile,omitempty"`
+	CSRHash        string    `json:"csrSha256"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	// Renewal marks certificates reissued for a STAR order
+	Renewal bool `json:"renewal,omitempty"`
+}
+
+// newCertificateIssued describes a certificate issued for an order
+func newCertificateIssued(order *types.Order, cert *types.Certificate) (*CertificateIssued, error) {
+	parsed, err := pki.ParseCertificatePEM(cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := base64.RawURLEncoding.DecodeString(order.CSR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored CSR encoding: %w", err)
+	}
+	csrHash := sha256.Sum256(csr)
+
+	issued := &CertificateIssued{
+		Order:          order.ID,
+		Certificate:    cert.ID,
+		Serial:         cert.Serial,
+		AuthorityKeyID: cert.AuthorityKeyID,
+		DNSNames:       parsed.DNSNames,
+		Profile:        order.Profile,
+		CSRHash:        hex.EncodeToString(csrHash[:]),
+		NotBefore:      parsed.NotBefore,
+		NotAfter:       parsed.NotAfter,
+	}
+	for _, ip := range parsed.IPAddresses {
+		issued.IPAddresses = append(issued.IPAddresses, ip.String())
+	}
+	return issued, nil
+}
+
+// CertificateDiscarded is the data of a certificate.discarded entry: a
+// certificate that was recorded as issued but never stored or handed out,
+// because another worker or replica completed the order first
+type CertificateDiscarded struct {
+	Order          string `json:"order"`
+	Certificate    string `json:"certificate"`
+	Serial         string `json:"serial"`
+	AuthorityKeyID string `json:"authorityKeyId"`
+	Reason         string `json:"reason"`
+}
+
+// CertificateRevoked is the data of a certificate.revoked entry
+type CertificateRevoked struct {
+	Order          string `json:"order"`
+	Certificate    string `json:"certificate"`
+	Serial         string `json:"serial"`
+	AuthorityKeyID string `json:"authorityKeyId"`
+	Reason         string `json:"reason"`
+	// By names who revoked, e.g. "operator"
+	By string `json:"by"`
+}
+
+// KeyThumbprint returns the RFC 7638 SHA-256 thumbprint of a JSON JWK
+func KeyThumbprint(jwk []byte) (string, error) {
+	var key jose.JSONWebKey
+	if err := json.Unmarshal(jwk, &key); err != nil {
+		return "", fmt.Errorf("invalid account key: %w", err)
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("invalid account key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// RecordIssuance records a certificate issued for an order. renewal marks
+// the reissued certificates of STAR orders.
+func (l *Log) RecordIssuance(ctx context.Context, order *types.Order, cert *types.Certificate, renewal bool) error {
+	if l == nil {
+		return nil
+	}
+	issued, err := newCertificateIssued(order, cert)
+	if err != nil {
+		return err
+	}
+	issued.Renewal = renewal
+	return l.Record(ctx, EventCertificateIssued, order.Tenant, order.AccountID, issued)
+}
+
+// RecordDiscard records that a certificate recorded by RecordIssuance was
+// not stored, and why
+func (l *Log) RecordDiscard(ctx context.Context, order *types.Order, cert *types.Certificate, reason string) error {
+	if l == nil {
+		return nil
+	}
+	return l.Record(ctx, EventCertificateDiscarded, order.Tenant, order.AccountID, &CertificateDiscarded{
+		Order:          order.ID,
+		Certificate:    cert.ID,
+		Serial:         cert.Serial,
+		AuthorityKeyID: cert.AuthorityKeyID,
+		Reason:         reason,
+	})
+}
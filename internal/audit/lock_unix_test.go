@@ -0,0 +1,51 @@
+//go:build unix
+
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordWaitsForFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	// Another process holds the lock while it appends
+	other, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	unlock, err := lockFile(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- log.Record(context.Background(), EventCertificateRevoked, "", "", CertificateRevoked{Serial: "01"})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Record did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record did not finish after the lock was released")
+	}
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+// verifyBatchSize is how many entries are read from the store at once
+const verifyBatchSize = 500
+
+// Verifier checks audit log entries in order
+type Verifier struct {
+	// Key verifies the signatures of the entries; without it only the hash
+	// chain is checked
+	Key crypto.PublicKey
+
+	// Entries is the number of entries verified so far and Last the latest
+	// of them. Truncating the log is only noticed by comparing them with an
+	// earlier verification.
+	Entries int64
+	Last    *Entry
+}
+
+// Verify checks that the next entry is intact and follows the entry before
+func (v *Verifier) Verify(raw []byte) error {
+	n := v.Entries + 1
+
+	// Fields unknown to the hash must not slip in
+	var e Entry
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		return fmt.Errorf("entry %d: invalid entry: %w", n, err)
+	}
+
+	var prev string
+	if v.Last != nil {
+		prev = v.Last.Hash
+	}
+	if e.Seq != n {
+		return fmt.Errorf("entry %d: has sequence number %d, entries are missing or reordered", n, e.Seq)
+	}
+	if e.Prev != prev {
+		return fmt.Errorf("entry %d: does not chain to the entry before", n)
+	}
+	digest, err := e.digest()
+	if err != nil {
+		return fmt.Errorf("entry %d: %w", n, err)
+	}
+	if hex.EncodeToString(digest) != e.Hash {
+		return fmt.Errorf("entry %d: hash mismatch, the entry was altered", n)
+	}
+	if v.Key != nil {
+		if len(e.Signature) == 0 {
+			return fmt.Errorf("entry %d: not signed", n)
+		}
+		if !verifySignature(v.Key, digest, e.Signature) {
+			return fmt.Errorf("entry %d: invalid signature", n)
+		}
+	}
+
+	v.Entries, v.Last = n, &e
+	return nil
+}
+
+// verifySignature checks a signature made by Log.seal
+func verifySignature(key crypto.PublicKey, digest, sig []byte) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, digest, sig)
+	default:
+		return false
+	}
+}
+
+// VerifyFile checks the audit log written to a file
+func VerifyFile(path string, key crypto.PublicKey) (*Verifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	v := &Verifier{Key: key}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\n"); len(line) > 0 {
+			if err := v.Verify(line); err != nil {
+				return v, err
+			}
+		}
+		if err == io.EOF {
+			return v, nil
+		}
+		if err != nil {
+			return v, fmt.Errorf("error reading audit log: %w", err)
+		}
+	}
+}
+
+// VerifyStore checks the audit log kept in the store
+func VerifyStore(ctx context.Context, store Store, key crypto.PublicKey) (*Verifier, error) {
+	v := &Verifier{Key: key}
+	for {
+		records, err := store.ListAuditRecords(ctx, v.Entries, verifyBatchSize)
+		if err != nil {
+			return v, err
+		}
+		for _, record := range records {
+			if err := v.Verify(record.Entry); err != nil {
+				return v, err
+			}
+			if record.Seq != v.Last.Seq || record.Event != v.Last.Event {
+				return v, fmt.Errorf("entry %d: stored as entry %d (%s)", v.Last.Seq, record.Seq, record.Event)
+			}
+		}
+		if len(records) < verifyBatchSize {
+			return v, nil
+		}
+	}
+}
+
+// LoadPublicKey reads the key audit signatures are verified with from a PEM
+// certificate, public key or private key
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key unavailable: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", path)
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %s: %w", path, err)
+		}
+		return cert.PublicKey, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+		}
+		return key, nil
+	default:
+		key, err := pki.LoadPrivateKey(path)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("%s holds no certificate or public key", path), err)
+		}
+		return key.Public(), nil
+	}
+}
@@ -0,0 +1,190 @@
+// Package caa checks Certification Authority Authorization records (RFC
+// 8659) of dns identifiers, including the accounturi and validationmethods
+// parameters of RFC 8657. Domain owners use them to restrict which CA, and
+// which ACME account and validation method, may issue for their zones.
+package caa
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+const (
+	errCAA = "urn:ietf:params:acme:error:caa"
+	errDNS = "urn:ietf:params:acme:error:dns"
+)
+
+// DefaultRecheckAfter is how long a check at validation time covers
+// issuance if not configured otherwise
+const DefaultRecheckAfter = 8 * time.Hour
+
+// Record is a CAA resource record (RFC 8659 Section 4.1)
+type Record struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// Critical reports whether the issuer critical flag is set
+func (r Record) Critical() bool {
+	return r.Flag&0x80 != 0
+}
+
+// Resolver looks up the CAA RRset of a domain name, following aliases. A
+// name without CAA records, including one that does not exist, yields no
+// records and no error.
+type Resolver interface {
+	LookupCAA(ctx context.Context, name string) ([]Record, error)
+}
+
+// Request describes the issuance a check is made for
+type Request struct {
+	// Domain is the value of a dns identifier, possibly a wildcard
+	Domain string
+	// Identities are the issuer domain names of the CA
+	Identities []string
+	// AccountURI is the URL of the ordering account
+	AccountURI string
+	// ValidationMethod is the challenge type the domain was validated with,
+	// e.g. "http-01"
+	ValidationMethod string
+}
+
+// Checker checks CAA records with a Resolver
+type Checker struct {
+	Resolver Resolver
+	// RecheckAfter is how long a check at validation time covers issuance
+	RecheckAfter time.Duration
+}
+
+// Stale reports whether a check made at checkedAt has to be repeated
+// before issuance. A zero time is always stale.
+func (c *Checker) Stale(checkedAt time.Time) bool {
+	recheckAfter := c.RecheckAfter
+	if recheckAfter <= 0 {
+		recheckAfter = DefaultRecheckAfter
+	}
+	return checkedAt.IsZero() || time.Since(checkedAt) > recheckAfter
+}
+
+// Check returns nil if the CAA records of the domain allow the CA to issue
+// for it, a caa problem if they forbid it and a dns problem if they cannot
+// be looked up, since a CA must not issue without knowing them.
+func (c *Checker) Check(ctx context.Context, req Request) *types.Problem {
+	domain, wildcard := strings.CutPrefix(req.Domain, "*.")
+	records, err := c.relevantRecords(ctx, domain)
+	if err != nil {
+		return &types.Problem{
+			Type:   errDNS,
+			Detail: fmt.Sprintf("CAA lookup for %s failed: %v", req.Domain, err),
+			Status: http.StatusBadRequest,
+		}
+	}
+	if reason := forbids(records, wildcard, req); reason != "" {
+		return &types.Problem{
+			Type:   errCAA,
+			Detail: fmt.Sprintf("CAA records of %s forbid issuance: %s", req.Domain, reason),
+			Status: http.StatusForbidden,
+		}
+	}
+	return nil
+}
+
+// relevantRecords returns the first non-empty CAA RRset found climbing the
+// tree from domain towards the root (RFC 8659 Section 3)
+func (c *Checker) relevantRecords(ctx context.Context, domain string) ([]Record, error) {
+	for name := strings.TrimSuffix(domain, "."); name != ""; {
+		records, err := c.Resolver.LookupCAA(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return nil, nil
+}
+
+// forbids returns why the records do not authorize the request, or "" if
+// they do
+func forbids(records []Record, wildcard bool, req Request) string {
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case "issue", "issuewild", "iodef":
+		default:
+			if r.Critical() {
+				return fmt.Sprintf("unknown critical property %q", r.Tag)
+			}
+		}
+	}
+
+	// issuewild takes precedence for wildcards if present (RFC 8659
+	// Section 4.3)
+	tag := "issue"
+	if wildcard && slices.ContainsFunc(records, func(r Record) bool { return strings.EqualFold(r.Tag, "issuewild") }) {
+		tag = "issuewild"
+	}
+
+	var properties int
+	for _, r := range records {
+		if !strings.EqualFold(r.Tag, tag) {
+			continue
+		}
+		properties++
+		if authorizes(r.Value, req) {
+			return ""
+		}
+	}
+	if properties == 0 {
+		return ""
+	}
+	return fmt.Sprintf("no %s property authorizes %s for this account and validation method", tag, strings.Join(req.Identities, ", "))
+}
+
+// authorizes reports whether an issue or issuewild value names one of the
+// identities of the CA and its parameters are met
+func authorizes(value string, req Request) bool {
+	issuer, params, ok := parseValue(value)
+	if !ok || issuer == "" {
+		return false
+	}
+	if !slices.ContainsFunc(req.Identities, func(id string) bool { return strings.EqualFold(id, issuer) }) {
+		return false
+	}
+	// RFC 8657 Section 3
+	if uri, ok := params["accounturi"]; ok && uri != req.AccountURI {
+		return false
+	}
+	if methods, ok := params["validationmethods"]; ok {
+		if !slices.Contains(strings.Split(methods, ","), req.ValidationMethod) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue splits an issue or issuewild value into the issuer domain name
+// and its parameters, e.g. "ca.example; accounturi=https://..."
+func parseValue(value string) (string, map[string]string, bool) {
+	issuer, rest, _ := strings.Cut(value, ";")
+	params := make(map[string]string)
+	for _, param := range strings.Split(rest, ";") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(param, "=")
+		if !ok {
+			return "", nil, false
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+	}
+	return strings.TrimSuffix(strings.TrimSpace(issuer), "."), params, true
+}
@@ -0,0 +1,123 @@
+package caa
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeResolver serves CAA records from a map; names with a nil entry fail
+type fakeResolver map[string][]Record
+
+func (f fakeResolver) LookupCAA(_ context.Context, name string) ([]Record, error) {
+	records, ok := f[name]
+	if ok && records == nil {
+		return nil, errors.New("SERVFAIL")
+	}
+	return records, nil
+}
+
+func TestCheck(t *testing.T) {
+	const account = "https://acme.plant.example/acme/account/acct_1"
+	checker := &Checker{Resolver: fakeResolver{
+		"plant.example": {
+			{Tag: "issue", Value: "ca.kritis3m.example"},
+			{Tag: "issuewild", Value: ";"},
+			{Tag: "iodef", Value: "mailto:pki@plant.example"},
+		},
+		"line3.plant.example": {
+			{Tag: "issue", Value: "other-ca.example"},
+			{Tag: "Issue", Value: "CA.kritis3m.example; accounturi=" + account + "; validationmethods=http-01,tls-alpn-01"},
+		},
+		"line4.plant.example": {{Flag: 128, Tag: "tbs", Value: "unknown"}},
+		"broken.example":      nil,
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		req      Request
+		wantType string
+	}{
+		{"no records", Request{Domain: "plc1.elsewhere.example"}, ""},
+		{"issuer of the parent zone", Request{Domain: "plc1.plant.example"}, ""},
+		{"other issuer", Request{Domain: "plc1.plant.example", Identities: []string{"other-ca.example"}}, errCAA},
+		{"wildcard forbidden by issuewild", Request{Domain: "*.plant.example"}, errCAA},
+		{"closest records win", Request{Domain: "plc1.line3.plant.example"}, ""},
+		{"wildcard falls back to issue", Request{Domain: "*.line3.plant.example"}, ""},
+		{"other account", Request{Domain: "plc1.line3.plant.example", AccountURI: account + "x"}, errCAA},
+		{"other validation method", Request{Domain: "plc1.line3.plant.example", ValidationMethod: "dns-01"}, errCAA},
+		{"unknown critical property", Request{Domain: "plc1.line4.plant.example"}, errCAA},
+		{"lookup failure", Request{Domain: "plc1.broken.example"}, errDNS},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tc.req
+			if req.Identities == nil {
+				req.Identities = []string{"ca.kritis3m.example"}
+			}
+			if req.AccountURI == "" {
+				req.AccountURI = account
+			}
+			if req.ValidationMethod == "" {
+				req.ValidationMethod = "http-01"
+			}
+			problem := checker.Check(context.Background(), req)
+			switch {
+			case tc.wantType == "" && problem != nil:
+				t.Errorf("Check forbade issuance: %+v", problem)
+			case tc.wantType != "" && problem == nil:
+				t.Error("Check allowed issuance")
+			case problem != nil && problem.Type != tc.wantType:
+				t.Errorf("problem type = %s, want %s", problem.Type, tc.wantType)
+			}
+		})
+	}
+}
+
+func TestDNSResolver(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Answer every query with an alias and the CAA record of its target
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			header, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			target := dnsmessage.MustNewName("ca-policy.plant.example.")
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, RecursionAvailable: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			b.CNAMEResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET}, dnsmessage.CNAMEResource{CNAME: target})
+			b.UnknownResource(dnsmessage.ResourceHeader{Name: target, Type: typeCAA, Class: dnsmessage.ClassINET},
+				dnsmessage.UnknownResource{Type: typeCAA, Data: append([]byte{128, 5}, "issueca.kritis3m.example"...)})
+			resp, _ := b.Finish()
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	records, err := NewDNSResolver(conn.LocalAddr().String(), 0).LookupCAA(context.Background(), "plc1.plant.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].Critical() || records[0].Tag != "issue" || records[0].Value != "ca.kritis3m.example" {
+		t.Errorf("LookupCAA = %+v", records)
+	}
+}
@@ -0,0 +1,229 @@
+package caa
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeCAA is the RR type of CAA records (RFC 8659 Section 7.2)
+const typeCAA dnsmessage.Type = 257
+
+// DefaultTimeout bounds a single CAA query if not configured otherwise
+const DefaultTimeout = 5 * time.Second
+
+// DNSResolver queries a recursive DNS resolver for CAA records over UDP,
+// retrying over TCP if the answer is truncated
+type DNSResolver struct {
+	// Server is the host:port of the resolver
+	Server  string
+	Timeout time.Duration
+}
+
+// NewDNSResolver creates a resolver querying server, or the first
+// nameserver of /etc/resolv.conf if server is empty
+func NewDNSResolver(server string, timeout time.Duration) *DNSResolver {
+	if server == "" {
+		server = systemNameserver("/etc/resolv.conf")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &DNSResolver{Server: server, Timeout: timeout}
+}
+
+// systemNameserver returns the first nameserver of a resolv.conf file, or
+// the local resolver if there is none
+func systemNameserver(path string) string {
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// LookupCAA implements Resolver
+func (r *DNSResolver) LookupCAA(ctx context.Context, name string) ([]Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query, id, err := newQuery(name)
+	if err != nil {
+		return nil, fmt.Errorf("error building CAA query for %s: %w", name, err)
+	}
+	resp, err := r.exchange(ctx, "udp", query)
+	if err != nil {
+		return nil, err
+	}
+	records, truncated, err := parseResponse(resp, id)
+	if truncated {
+		if resp, err = r.exchange(ctx, "tcp", query); err != nil {
+			return nil, err
+		}
+		records, _, err = parseResponse(resp, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up CAA records of %s: %w", name, err)
+	}
+	return records, nil
+}
+
+// exchange sends a query to the server and reads its response
+func (r *DNSResolver) exchange(ctx context.Context, network string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, r.Server)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to resolver %s: %w", r.Server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, fmt.Errorf("error sending query to %s: %w", r.Server, err)
+		}
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response from %s: %w", r.Server, err)
+		}
+		return buf[:n], nil
+	}
+
+	// Messages over TCP are prefixed with their length (RFC 1035 Section
+	// 4.2.2)
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, fmt.Errorf("error sending query to %s: %w", r.Server, err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", r.Server, err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", r.Server, err)
+	}
+	return resp, nil
+}
+
+// newQuery builds a recursive CAA query with a random ID and EDNS0
+func newQuery(name string) ([]byte, uint16, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, 0, err
+	}
+	header := dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true}
+
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: typeCAA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, 0, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, 0, err
+	}
+	msg, err := b.Finish()
+	return msg, header.ID, err
+}
+
+// parseResponse returns the CAA records of a response and whether it was
+// truncated. A name that does not exist has no records.
+func parseResponse(msg []byte, id uint16) ([]Record, bool, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, false, err
+	}
+	if header.ID != id || !header.Response {
+		return nil, false, errors.New("response does not match the query")
+	}
+	if header.Truncated {
+		return nil, true, nil
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, false, fmt.Errorf("resolver answered %s", header.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, false, err
+	}
+
+	var records []Record
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return records, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		// Aliases are followed by the resolver; only the CAA records at
+		// the end of the chain are of interest
+		if h.Type != typeCAA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		r, err := p.UnknownResource()
+		if err != nil {
+			return nil, false, err
+		}
+		record, err := parseRecord(r.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		records = append(records, record)
+	}
+}
+
+// parseRecord parses the RDATA of a CAA record: flags, tag length, tag and
+// value (RFC 8659 Section 4.1.1)
+func parseRecord(data []byte) (Record, error) {
+	if len(data) < 2 {
+		return Record{}, errors.New("CAA record too short")
+	}
+	tagLen := int(data[1])
+	if tagLen == 0 || len(data) < 2+tagLen {
+		return Record{}, errors.New("CAA record has an invalid tag length")
+	}
+	return Record{
+		Flag:  data[0],
+		Tag:   string(data[2 : 2+tagLen]),
+		Value: string(data[2+tagLen:]),
+	}, nil
+}
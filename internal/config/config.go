@@ -4,58 +4,332 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // Config holds all configuration settings for the application
 type Config struct {
 	Server struct {
-		ListenAddr string `json:"listen_addr"`
+		ListenAddr string `json:"listen_addr" env:"ACME_SERVER_LISTEN_ADDR"`
+		// Listeners replace the single ASL listener on ListenAddr
+		Listeners []Listener `json:"listeners"`
+		// TrustedProxies are the addresses or CIDR prefixes of reverse
+		// proxies whose forwarding headers name the client address
+		TrustedProxies []string `json:"trusted_proxies" env:"ACME_SERVER_TRUSTED_PROXIES"`
 	} `json:"server"`
 
 	ACME struct {
 		DirectoryURL string `json:"directoryURL" env:"ACME_DIRECTORY_URL"`
 		Environment  string `json:"environment" env:"ACME_ENVIRONMENT"`
+		// ExternalURLs are the base URLs clients use, e.g.
+		// "https://acme.example.com/pki". The first one is canonical; further
+		// ones serve multi-homed deployments and are picked by Host.
+		ExternalURLs []string `json:"external_urls" env:"ACME_EXTERNAL_URLS"`
+		// PathPrefix mounts the ACME API below a path, e.g. "/pki"
+		PathPrefix string `json:"path_prefix" env:"ACME_PATH_PREFIX"`
+		// ExternalAccountRequired makes new accounts of the default tenant
+		// bind an EAB key
+		ExternalAccountRequired bool `json:"external_account_required" env:"ACME_EXTERNAL_ACCOUNT_REQUIRED"`
+		// Profiles are the certificate profiles of the default tenant
+		Profiles []Profile `json:"profiles"`
+		// Policy restricts the identifiers of the default tenant
+		Policy Policy `json:"policy"`
+		// CAAIdentities are the issuer domain names CAA records must name to
+		// let the default tenant issue; empty disables CAA checking
+		CAAIdentities []string `json:"caa_identities" env:"ACME_CAA_IDENTITIES"`
 	} `json:"acme"`
 
+	// Tenants are further ACME CAs served next to the default tenant, each
+	// with its own directory below acme.path_prefix
+	Tenants []Tenant `json:"tenants"`
+
 	CA struct {
-		Certs      string `json:"certificates"`
-		PrivateKey string `json:"private_key"`
+		Certs      string `json:"certificates" env:"ACME_CA_CERTIFICATES"`
+		PrivateKey string `json:"private_key" env:"ACME_CA_PRIVATE_KEY"`
 	} `json:"ca"`
 
 	TLS struct {
-		Certs      string   `json:"certificates"`
-		PrivateKey string   `json:"private_key"`
-		ClientCAs  []string `json:"client_cas"`
+		Certs      string   `json:"certificates" env:"ACME_TLS_CERTIFICATES"`
+		PrivateKey string   `json:"private_key" env:"ACME_TLS_PRIVATE_KEY"`
+		ClientCAs  []string `json:"client_cas" env:"ACME_TLS_CLIENT_CAS"` // defaults to ca.certificates
 	} `json:"tls"`
 
 	PKCS11 struct {
 		EntityModule struct {
-			Path string `json:"path"`
-			Pin  string `json:"pin"`
+			Path    string `json:"path" env:"ACME_PKCS11_MODULE_PATH"`
+			Pin     string `json:"pin" env:"ACME_PKCS11_PIN"`
+			PinFile string `json:"pin_file"`
 		} `json:"entity_module"`
 	} `json:"pkcs11"`
 
 	Endpoint struct {
-		MutualAuthentication bool   `json:"mutual_authentication"`
-		NoEncryption         bool   `json:"no_encryption"`
-		ASLKeyExchangeMethod int    `json:"asl_key_exchange_method"`
-		KeylogFile           string `json:"keylog_file"`
+		MutualAuthentication bool   `json:"mutual_authentication" env:"ACME_ENDPOINT_MUTUAL_AUTHENTICATION"`
+		NoEncryption         bool   `json:"no_encryption" env:"ACME_ENDPOINT_NO_ENCRYPTION"`
+		ASLKeyExchangeMethod int    `json:"asl_key_exchange_method" env:"ACME_ENDPOINT_KEY_EXCHANGE_METHOD"`
+		KeylogFile           string `json:"keylog_file" env:"ACME_ENDPOINT_KEYLOG_FILE"`
 	} `json:"endpoint"`
 
 	ASLConfig struct {
-		LoggingEnabled          bool `json:"logging_enabled"`
-		LogLevel                int  `json:"log_level"`
-		SecureElementLogSupport bool `json:"secure_element_log_support"`
+		LoggingEnabled          bool `json:"logging_enabled" env:"ACME_ASL_LOGGING_ENABLED"`
+		LogLevel                int  `json:"log_level" env:"ACME_ASL_LOG_LEVEL"`
+		SecureElementLogSupport bool `json:"secure_element_log_support" env:"ACME_ASL_SECURE_ELEMENT_LOG_SUPPORT"`
 	} `json:"asl_config"`
 
 	Database struct {
-		Host     string `json:"host"`
-		Port     int    `json:"port"`
-		User     string `json:"user"`
-		Password string `json:"password"`
-		DBName   string `json:"dbname"`
-		SSLMode  string `json:"sslmode"`
+		Host         string `json:"host" env:"ACME_DATABASE_HOST"`
+		Port         int    `json:"port" env:"ACME_DATABASE_PORT"`
+		User         string `json:"user" env:"ACME_DATABASE_USER"`
+		Password     string `json:"password" env:"ACME_DATABASE_PASSWORD"`
+		PasswordFile string `json:"password_file"`
+		DBName       string `json:"dbname" env:"ACME_DATABASE_NAME"`
+		SSLMode      string `json:"sslmode" env:"ACME_DATABASE_SSLMODE"`
+
+		// AutoMigrate applies pending schema migrations at startup
+		AutoMigrate bool `json:"auto_migrate" env:"ACME_DATABASE_AUTO_MIGRATE"`
 	} `json:"database"`
+
+	Storage struct {
+		Backend string `json:"backend" env:"ACME_STORAGE_BACKEND"` // "postgres", "sqlite" or "memory"
+		SQLite  struct {
+			Path string `json:"path" env:"ACME_STORAGE_SQLITE_PATH"`
+		} `json:"sqlite"`
+	} `json:"storage"`
+
+	Nonce struct {
+		// Store is "memory", "database" or "hmac"; defaults to "database"
+		// with the postgres storage backend so that replicas share nonces
+		Store string `json:"store" env:"ACME_NONCE_STORE"`
+
+		// HMACKey is the base64 encoded key of the hmac store (at least 32
+		// bytes); a random key is generated if unset
+		HMACKey     string `json:"hmac_key" env:"ACME_NONCE_HMAC_KEY"`
+		HMACKeyFile string `json:"hmac_key_file"`
+	} `json:"nonce"`
+
+	RateLimits struct {
+		Disabled bool `json:"disabled" env:"ACME_RATE_LIMITS_DISABLED"`
+		// Documentation is linked from rateLimited errors; defaults to the
+		// rate limit section of the README
+		Documentation string `json:"documentation" env:"ACME_RATE_LIMITS_DOCUMENTATION"`
+
+		NewAccountsPerIP        RateLimit `json:"new_accounts_per_ip"`
+		NewOrdersPerAccount     RateLimit `json:"new_orders_per_account"`
+		FinalizationsPerAccount RateLimit `json:"finalizations_per_account"`
+		// FailedValidations counts per account and identifier
+		FailedValidations         RateLimit `json:"failed_validations"`
+		CertificatesPerIdentifier RateLimit `json:"certificates_per_identifier"`
+	} `json:"rate_limits"`
+
+	CAA struct {
+		// Resolver is the recursive resolver queried for CAA records, e.g.
+		// "10.0.0.53:53"; defaults to the first nameserver of
+		// /etc/resolv.conf
+		Resolver string `json:"resolver" env:"ACME_CAA_RESOLVER"`
+		Timeout  string `json:"timeout" env:"ACME_CAA_TIMEOUT"` // per query, e.g. "5s"
+		// RecheckAfter is how long the check at validation covers issuance
+		// before the records are checked again, e.g. "8h"
+		RecheckAfter string `json:"recheck_after" env:"ACME_CAA_RECHECK_AFTER"`
+	} `json:"caa"`
+
+	CT struct {
+		// Logs receive a precertificate of every certificate before it is
+		// issued; the SCTs they return are embedded in the certificate
+		Logs []CTLog `json:"logs"`
+		// MinSCTs is how many logs must return an SCT for issuance to
+		// succeed; defaults to 1
+		MinSCTs int `json:"min_scts" env:"ACME_CT_MIN_SCTS"`
+	} `json:"ct"`
+
+	Audit struct {
+		// Store keeps the audit log in the storage backend, where replicas
+		// share one chain
+		Store bool `json:"store" env:"ACME_AUDIT_STORE"`
+		// File receives every entry as a line of JSON, e.g.
+		// "/var/log/acme/audit.log"
+		File string `json:"file" env:"ACME_AUDIT_FILE"`
+		// SigningKey is a PEM private key the entries are signed with
+		SigningKey string `json:"signing_key" env:"ACME_AUDIT_SIGNING_KEY"`
+	} `json:"audit"`
+
+	Webhooks struct {
+		// Endpoints receive signed JSON events such as account.created or
+		// certificate.issued
+		Endpoints []WebhookEndpoint `json:"endpoints"`
+		// Interval is how often failed deliveries are retried at the
+		// earliest, e.g. "10s"
+		Interval string `json:"interval" env:"ACME_WEBHOOKS_INTERVAL"`
+		// MaxAttempts is how often a delivery is attempted before it is
+		// dropped; defaults to 50
+		MaxAttempts int `json:"max_attempts" env:"ACME_WEBHOOKS_MAX_ATTEMPTS"`
+		// ExpiryWarning is how long before their expiry certificates are
+		// announced with certificate.expiring, e.g. "720h"
+		ExpiryWarning string `json:"expiry_warning" env:"ACME_WEBHOOKS_EXPIRY_WARNING"`
+	} `json:"webhooks"`
+
+	Metrics struct {
+		Disabled bool `json:"disabled" env:"ACME_METRICS_DISABLED"`
+		// ListenAddr serves /metrics on a separate plain HTTP admin
+		// listener instead of the ACME listener, e.g. "127.0.0.1:9090"
+		ListenAddr string `json:"listen_addr" env:"ACME_METRICS_LISTEN_ADDR"`
+	} `json:"metrics"`
+
+	Admin struct {
+		// Tokens are accepted as "Authorization: Bearer <token>"
+		Tokens     []string `json:"tokens" env:"ACME_ADMIN_TOKENS"`
+		TokensFile string   `json:"tokens_file"` // one token per line
+		// ClientCNs are the subject common names of client certificates
+		// accepted through mutually authenticated ASL or TLS. Only
+		// certificates issued under ClientCAs are accepted.
+		ClientCNs []string `json:"client_cns" env:"ACME_ADMIN_CLIENT_CNS"`
+		// ClientCAs issue the admin client certificates. The issuing CA of
+		// the ACME server is never trusted for admin access.
+		ClientCAs []string `json:"client_cas" env:"ACME_ADMIN_CLIENT_CAS"`
+		// ListenAddr serves the admin API on a separate listener that
+		// requires client certificates instead of the ACME listener
+		ListenAddr string `json:"listen_addr" env:"ACME_ADMIN_LISTEN_ADDR"`
+		Mode       string `json:"mode" env:"ACME_ADMIN_MODE"`               // "asl" (default) or "tls"
+		PathPrefix string `json:"path_prefix" env:"ACME_ADMIN_PATH_PREFIX"` // defaults to "/admin"
+	} `json:"admin"`
+
+	Health struct {
+		Timeout         string `json:"timeout" env:"ACME_HEALTH_TIMEOUT"`                     // per check, e.g. "3s"
+		CAExpiryHorizon string `json:"ca_expiry_horizon" env:"ACME_HEALTH_CA_EXPIRY_HORIZON"` // warn this long before CA expiry, e.g. "720h"
+	} `json:"health"`
+
+	Sweeper struct {
+		Disabled  bool   `json:"disabled" env:"ACME_SWEEPER_DISABLED"`
+		Interval  string `json:"interval" env:"ACME_SWEEPER_INTERVAL"`   // e.g. "5m"
+		Retention string `json:"retention" env:"ACME_SWEEPER_RETENTION"` // e.g. "720h"
+	} `json:"sweeper"`
+
+	Issuance struct {
+		Workers   int `json:"workers" env:"ACME_ISSUANCE_WORKERS"`       // defaults to the number of CPUs
+		QueueSize int `json:"queue_size" env:"ACME_ISSUANCE_QUEUE_SIZE"` // defaults to 256
+	} `json:"issuance"`
+
+	STAR struct {
+		Disabled bool   `json:"disabled" env:"ACME_STAR_DISABLED"`
+		Interval string `json:"interval" env:"ACME_STAR_INTERVAL"` // e.g. "1m"
+	} `json:"star"`
+}
+
+// Listener is an address the ACME server accepts connections on
+type Listener struct {
+	Mode       string `json:"mode"` // "asl" (default), "tls" or "http"
+	ListenAddr string `json:"listen_addr"`
+	// ClientAuth is "none", "request" or "require". It defaults to
+	// endpoint.mutual_authentication.
+	ClientAuth string `json:"client_auth"`
+}
+
+// Tenant is an ACME CA with its own directory, issuer, profiles and account
+// requirements
+type Tenant struct {
+	Name string `json:"name"`
+	// PathPrefix is where the tenant is served below acme.path_prefix. It
+	// defaults to "/<name>".
+	PathPrefix string `json:"path_prefix"`
+
+	CA struct {
+		Certs      string `json:"certificates"`
+		PrivateKey string `json:"private_key"`
+	} `json:"ca"`
+
+	Profiles                []Profile `json:"profiles"`
+	ExternalAccountRequired bool      `json:"external_account_required"`
+	Policy                  Policy    `json:"policy"`
+	CAAIdentities           []string  `json:"caa_identities"`
+}
+
+// Path returns the path prefix of the tenant below acme.path_prefix
+func (t *Tenant) Path() string {
+	if prefix := strings.Trim(t.PathPrefix, "/"); prefix != "" {
+		return "/" + prefix
+	}
+	return "/" + t.Name
+}
+
+// Profile is a kind of certificate clients can order
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Validity    string `json:"validity"` // e.g. "2160h"; defaults to one year
+	// ExtKeyUsage lists extended key usages such as "server_auth" or
+	// "client_auth"; defaults to server_auth
+	ExtKeyUsage []string `json:"ext_key_usage"`
+}
+
+// Policy restricts which identifiers may be ordered. Entries are domain
+// suffixes such as "plant.example" or IP ranges such as "10.0.0.0/8".
+type Policy struct {
+	// Allow lists everything that may be ordered; empty allows everything
+	// not denied
+	Allow []string `json:"allow"`
+	// Deny takes precedence over allow and account scopes
+	Deny           []string `json:"deny"`
+	MaxIdentifiers int      `json:"max_identifiers"`
+}
+
+// CTLog is an RFC 6962 Certificate Transparency log
+type CTLog struct {
+	Name string `json:"name"`
+	URL  string `json:"url"` // base URL, e.g. "https://ct.plant.example/2025"
+	// PublicKey is the base64 DER public key of the log as published in
+	// log lists. It verifies the returned SCTs; without it they are
+	// embedded unverified.
+	PublicKey string `json:"public_key"`
+	Timeout   string `json:"timeout"` // e.g. "10s"
+}
+
+// WebhookEndpoint is a URL that events are POSTed to
+type WebhookEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret is the base64 encoded HMAC-SHA256 key the requests are signed
+	// with, optionally prefixed with "whsec_"
+	Secret     string `json:"secret"`
+	SecretFile string `json:"secret_file"`
+	// Events lists the event types sent to the endpoint; empty subscribes
+	// to all events
+	Events  []string `json:"events"`
+	Timeout string   `json:"timeout"` // e.g. "10s"
+}
+
+// RateLimit allows Count requests per Period. Unset fields take the default
+// of the limit; a negative count disables it.
+type RateLimit struct {
+	Count  int    `json:"count"`
+	Period string `json:"period"` // e.g. "3h"
+}
+
+// Tenant returns the named tenant, or nil if it is not configured
+func (c *Config) Tenant(name string) *Tenant {
+	for i := range c.Tenants {
+		if c.Tenants[i].Name == name {
+			return &c.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// ServerListeners returns the configured listeners, or a single ASL
+// listener on server.listen_addr
+func (c *Config) ServerListeners() []Listener {
+	if len(c.Server.Listeners) > 0 {
+		return c.Server.Listeners
+	}
+	return []Listener{{Mode: "asl", ListenAddr: c.Server.ListenAddr}}
+}
+
+// StorageBackend returns the configured storage backend. Without an
+// explicit backend a configured PostgreSQL host selects postgres, as
+// before; otherwise it is empty and the configuration is invalid. The
+// memory backend must be chosen explicitly.
+func (c *Config) StorageBackend() string {
+	if c.Storage.Backend == "" && c.Database.Host != "" {
+		return "postgres"
+	}
+	return c.Storage.Backend
 }
 
 // Load reads configuration from a JSON file and environment variables
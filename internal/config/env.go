@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fileSuffix marks an environment variable that names a file holding the
+// value instead of the value itself, e.g. ACME_DATABASE_PASSWORD_FILE.
+const fileSuffix = "_FILE"
+
+// ApplyEnv overrides every field that carries an env struct tag with the
+// value of that environment variable, if set. Lists are comma or newline
+// separated. Lists of objects, such as tenants, listeners, profiles, CT
+// logs and webhook endpoints, as well as the identifier policy and the rate
+// limits have no variables and can only be set in the configuration file;
+// FileOnly lists them.
+func (c *Config) ApplyEnv() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), os.LookupEnv)
+}
+
+// EnvVars returns the names of all environment variables that ApplyEnv
+// reads, in declaration order.
+func EnvVars() []string {
+	var names []string
+	walkEnv(reflect.ValueOf(&Config{}).Elem(), func(name string, _ reflect.Value) {
+		names = append(names, name)
+	})
+	return names
+}
+
+// FileOnly returns the configuration file keys that no environment
+// variable overrides, in declaration order
+func FileOnly() []string {
+	var keys []string
+	walkFileOnly(reflect.TypeOf(Config{}), "", &keys)
+	return keys
+}
+
+// walkFileOnly collects the keys of untagged fields in t. Structs with
+// tagged fields are descended into; *_file keys are left out, as the
+// _FILE variable of the value they belong to replaces them.
+func walkFileOnly(t reflect.Type, prefix string, keys *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Tag.Get("env") != "" {
+			continue
+		}
+		key := prefix + strings.Split(field.Tag.Get("json"), ",")[0]
+		switch {
+		case strings.HasSuffix(key, "_file"):
+		case field.Type.Kind() == reflect.Struct && hasEnv(field.Type):
+			walkFileOnly(field.Type, key+".", keys)
+		default:
+			*keys = append(*keys, key)
+		}
+	}
+}
+
+// hasEnv reports whether t or a struct nested in it has a tagged field
+func hasEnv(t reflect.Type) bool {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Tag.Get("env") != "" || field.Type.Kind() == reflect.Struct && hasEnv(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	var errs []error
+	walkEnv(v, func(name string, field reflect.Value) {
+		value, ok := lookup(name)
+		if !ok {
+			path, ok := lookup(name + fileSuffix)
+			if !ok {
+				return
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", name, fileSuffix, err))
+				return
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walkEnv calls fn for every tagged field in v, descending into nested
+// structs
+func walkEnv(v reflect.Value, fn func(name string, field reflect.Value)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := v.Field(i)
+		if name := t.Field(i).Tag.Get("env"); name != "" {
+			fn(name, field)
+		} else if field.Kind() == reflect.Struct {
+			walkEnv(field, fn)
+		}
+	}
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		field.Set(reflect.ValueOf(splitList(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma or newline separated list, dropping empty items
+func splitList(value string) []string {
+	items := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' })
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ResolveSecrets reads secrets that are configured as *_file paths into
+// their fields. Setting both the value and the file is an error.
+func (c *Config) ResolveSecrets() error {
+	var errs []error
+	read := func(name, path string) (string, bool) {
+		if path == "" {
+			return "", false
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_file: %w", name, err))
+			return "", false
+		}
+		return strings.TrimRight(string(data), "\r\n"), true
+	}
+
+	for _, secret := range []struct {
+		name  string
+		value *string
+		path  string
+	}{
+		{"database.password", &c.Database.Password, c.Database.PasswordFile},
+		{"pkcs11.entity_module.pin", &c.PKCS11.EntityModule.Pin, c.PKCS11.EntityModule.PinFile},
+		{"nonce.hmac_key", &c.Nonce.HMACKey, c.Nonce.HMACKeyFile},
+	} {
+		if secret.path != "" && *secret.value != "" {
+			errs = append(errs, fmt.Errorf("%s: set either the value or %s_file, not both", secret.name, secret.name))
+			continue
+		}
+		if value, ok := read(secret.name, secret.path); ok {
+			*secret.value = value
+		}
+	}
+
+	for i := range c.Webhooks.Endpoints {
+		ep := &c.Webhooks.Endpoints[i]
+		name := fmt.Sprintf("webhooks.endpoints[%d].secret", i)
+		if ep.SecretFile != "" && ep.Secret != "" {
+			errs = append(errs, fmt.Errorf("%s: set either the value or %s_file, not both", name, name))
+			continue
+		}
+		if value, ok := read(name, ep.SecretFile); ok {
+			ep.Secret = value
+		}
+	}
+
+	if c.Admin.TokensFile != "" {
+		if len(c.Admin.Tokens) > 0 {
+			errs = append(errs, errors.New("admin.tokens: set either the tokens or admin.tokens_file, not both"))
+		} else if value, ok := read("admin.tokens", c.Admin.TokensFile); ok {
+			c.Admin.Tokens = splitList(value)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"ACME_SERVER_LISTEN_ADDR":     ":8443",
+		"ACME_DATABASE_PORT":          "5433",
+		"ACME_DATABASE_PASSWORD_FILE": secret,
+		"ACME_METRICS_DISABLED":       "true",
+		"ACME_ADMIN_TOKENS":           "a, b,,c",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	var cfg Config
+	cfg.Server.ListenAddr = ":443"
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), lookup); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	if cfg.Server.ListenAddr != ":8443" || cfg.Database.Port != 5433 || !cfg.Metrics.Disabled {
+		t.Errorf("overrides not applied: %+v", cfg)
+	}
+	if cfg.Database.Password != "s3cret" {
+		t.Errorf("password = %q, want value from file", cfg.Database.Password)
+	}
+	if !slices.Equal(cfg.Admin.Tokens, []string{"a", "b", "c"}) {
+		t.Errorf("tokens = %q", cfg.Admin.Tokens)
+	}
+
+	env = map[string]string{"ACME_DATABASE_PORT": "x", "ACME_STAR_DISABLED": "maybe"}
+	err := applyEnv(reflect.ValueOf(&cfg).Elem(), lookup)
+	if err == nil || !strings.Contains(err.Error(), "ACME_DATABASE_PORT") || !strings.Contains(err.Error(), "ACME_STAR_DISABLED") {
+		t.Errorf("invalid values: err = %v, want both variables reported", err)
+	}
+}
+
+func TestFileOnly(t *testing.T) {
+	keys := FileOnly()
+	for _, want := range []string{"server.listeners", "acme.profiles", "acme.policy", "tenants", "rate_limits.new_orders_per_account", "ct.logs", "webhooks.endpoints"} {
+		if !slices.Contains(keys, want) {
+			t.Errorf("FileOnly() = %v, missing %s", keys, want)
+		}
+	}
+	// Keys with a variable and *_file keys, which the _FILE variables
+	// replace, are not file-only
+	for _, key := range keys {
+		if key == "server.listen_addr" || key == "rate_limits.disabled" || strings.HasSuffix(key, "_file") {
+			t.Errorf("FileOnly() lists %s", key)
+		}
+	}
+}
+
+func TestValidateAggregatesErrors(t *testing.T) {
+	var cfg Config
+	cfg.Server.ListenAddr = "localhost"
+	cfg.Storage.Backend = "postgres"
+	cfg.TLS.Certs = "/nonexistent/cert.pem"
+	cfg.RateLimits.NewOrdersPerAccount.Period = "-3h"
+	cfg.CT.Logs = []CTLog{{Name: "plant", URL: "ct.plant.example", PublicKey: "AAAA"}}
+	cfg.CT.MinSCTs = 2
+	cfg.Audit.SigningKey = "/nonexistent/audit.key"
+	cfg.Webhooks.Endpoints = []WebhookEndpoint{{Name: "inventory", URL: "https://inventory.plant.example/hooks", Secret: "c2hvcnQ=", Events: []string{"certificate.renewed"}}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted an incomplete configuration")
+	}
+	for _, want := range []string{
+		"server.listen_addr: address localhost: missing port",
+		"ca.certificates: required",
+		"database.host: required by the postgres backend",
+		"tls.certificates: stat /nonexistent/cert.pem",
+		"rate_limits.new_orders_per_account.period: must be a positive duration",
+		"ct.logs[0].url: must be an http or https URL",
+		"ct.logs[0].public_key: invalid log key",
+		"ct.min_scts: must be between 0 and the number of logs (1)",
+		"audit.signing_key: requires audit.store or audit.file",
+		"audit.signing_key: stat /nonexistent/audit.key",
+		"webhooks.endpoints[0].secret: secret has 5 bytes, at least 24 are required",
+		"webhooks.endpoints[0].events[0]: unknown event \"certificate.renewed\"",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidateStorageBackend(t *testing.T) {
+	for _, tc := range []struct {
+		backend, host string
+		want          string // expected storage error, empty for none
+	}{
+		{"", "", "storage.backend: required"},
+		{"", "db.plant.example", ""},
+		{"memory", "", ""},
+		{"sqlite", "", "storage.sqlite.path: required"},
+	} {
+		var cfg Config
+		cfg.Storage.Backend = tc.backend
+		cfg.Database.Host = tc.host
+		err := cfg.Validate().Error()
+		if tc.want != "" && !strings.Contains(err, tc.want) || tc.want == "" && strings.Contains(err, "storage.") {
+			t.Errorf("backend %q, host %q: err = %v, want %q", tc.backend, tc.host, err, tc.want)
+		}
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	var cfg Config
+	cfg.Tenants = []Tenant{
+		{Name: "ot", Profiles: []Profile{{Name: "tls", ExtKeyUsage: []string{"any"}}}},
+		{Name: "it", PathPrefix: "/ot"},
+		{Name: "directory", CAAIdentities: []string{"ca.kritis3m.example", "*.kritis3m.example"}},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted invalid tenants")
+	}
+	for _, want := range []string{
+		"tenants[0].ca.certificates: required",
+		`tenants[0].profiles[0].ext_key_usage[0]: unknown extended key usage "any"`,
+		"tenants[1].path_prefix: /ot is already in use",
+		"tenants[2].path_prefix: /directory collides with an ACME endpoint",
+		`tenants[2].caa_identities[1]: "*.kritis3m.example" is not a domain name`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
@@ -35,13 +35,32 @@ func ParseFlags() error {
 	// Custom usage message
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] [command]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  migrate up|down|status             Apply, roll back or list database schema migrations\n")
+		fmt.Fprintf(os.Stderr, "  accounts list|show|deactivate      List, inspect or deactivate accounts\n")
+		fmt.Fprintf(os.Stderr, "  certs list|show|revoke             List, inspect or revoke certificates (-serial, -reason)\n")
+		fmt.Fprintf(os.Stderr, "  eab create|list|delete             Manage External Account Binding keys\n")
+		fmt.Fprintf(os.Stderr, "  ca info                            Show the CA certificate chain and key\n")
+		fmt.Fprintf(os.Stderr, "  config validate                    Check the configuration without starting the server\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  accounts, certs, eab and ca accept -o table|json.\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Environment variables:\n")
-		fmt.Fprintf(os.Stderr, "  SERVER_PORT       Override server port\n")
-		fmt.Fprintf(os.Stderr, "  SERVER_HOST       Override server host\n")
+		fmt.Fprintf(os.Stderr, "  Each variable overrides the matching configuration field. Append _FILE\n")
+		fmt.Fprintf(os.Stderr, "  to read the value from a file instead, e.g. ACME_DATABASE_PASSWORD_FILE.\n")
+		for _, name := range EnvVars() {
+			fmt.Fprintf(os.Stderr, "  %s\n", name)
+		}
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Only in the configuration file:\n")
+		for _, key := range FileOnly() {
+			fmt.Fprintf(os.Stderr, "  %s\n", key)
+		}
 	}
 
 	// Parse flags
@@ -65,10 +84,9 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("error parsing flags: %w", err)
 	}
 
-	// Load configuration
-	cfg, err := Load(GlobalFlags.ConfigPath, &Config{})
+	cfg, err := Reload()
 	if err != nil {
-		return nil, fmt.Errorf("error loading config: %w", err)
+		return nil, err
 	}
 
 	// Apply debug mode if set
@@ -80,6 +98,35 @@ func LoadConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// Reload reads the configuration files named by the parsed flags again and
+// applies secrets and environment overrides
+func Reload() (*Config, error) {
+	// Load configuration
+	cfg, err := Load(GlobalFlags.ConfigPath, &Config{})
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
+	// Load a separate database configuration if provided
+	if GlobalFlags.DBConfig != "" {
+		dbCfg, err := Load(GlobalFlags.DBConfig, &Config{})
+		if err != nil {
+			return nil, fmt.Errorf("error loading database config: %w", err)
+		}
+		cfg.Database = dbCfg.Database
+	}
+
+	// Secrets from files first, so that the environment can override them
+	if err := cfg.ResolveSecrets(); err != nil {
+		return nil, fmt.Errorf("error reading secrets:\n%w", err)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, fmt.Errorf("error applying environment variables:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
 // GetNonFlagArgs returns all non-flag command-line arguments
 func GetNonFlagArgs() []string {
 	return flag.Args()
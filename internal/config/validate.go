@@ -0,0 +1,451 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/ct"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+// Validate checks the settings that are otherwise only evaluated when the
+// server starts. It reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	required := map[string]string{
+		"ca.certificates": c.CA.Certs,
+		"ca.private_key":  c.CA.PrivateKey,
+	}
+	if len(c.Server.Listeners) == 0 {
+		required["server.listen_addr"] = c.Server.ListenAddr
+	}
+
+	// Every listener but plain HTTP needs a server certificate
+	secure := len(c.Server.Listeners) == 0 || c.Admin.ListenAddr != ""
+	for i, l := range c.Server.Listeners {
+		name := fmt.Sprintf("server.listeners[%d]", i)
+		switch l.Mode {
+		case "", "asl", "tls":
+			secure = true
+		case "http":
+		default:
+			check(fmt.Errorf("%s.mode: unknown mode %q", name, l.Mode))
+		}
+		switch l.ClientAuth {
+		case "", "none", "require":
+		case "request":
+			if l.Mode == "" || l.Mode == "asl" {
+				check(fmt.Errorf("%s.client_auth: ASL supports none or require", name))
+			}
+		default:
+			check(fmt.Errorf("%s.client_auth: unknown policy %q", name, l.ClientAuth))
+		}
+		if l.ListenAddr == "" {
+			check(fmt.Errorf("%s.listen_addr: required", name))
+		}
+		check(checkListenAddr(name+".listen_addr", l.ListenAddr))
+	}
+	if secure {
+		required["tls.certificates"] = c.TLS.Certs
+		required["tls.private_key"] = c.TLS.PrivateKey
+	}
+	for name, value := range required {
+		if value == "" {
+			check(fmt.Errorf("%s: required", name))
+		}
+	}
+
+	for name, addr := range map[string]string{
+		"server.listen_addr":  c.Server.ListenAddr,
+		"metrics.listen_addr": c.Metrics.ListenAddr,
+		"admin.listen_addr":   c.Admin.ListenAddr,
+	} {
+		check(checkListenAddr(name, addr))
+	}
+	if c.Admin.ListenAddr != "" && len(c.Admin.Tokens) == 0 && len(c.Admin.ClientCNs) == 0 {
+		check(fmt.Errorf("admin.listen_addr: requires admin.tokens or admin.client_cns"))
+	}
+	if len(c.Admin.ClientCAs) == 0 {
+		if len(c.Admin.ClientCNs) > 0 {
+			check(fmt.Errorf("admin.client_cas: required by admin.client_cns"))
+		}
+		if c.Admin.ListenAddr != "" {
+			check(fmt.Errorf("admin.client_cas: required by admin.listen_addr"))
+		}
+	}
+	for i, entry := range c.Server.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(entry)
+		_, addrErr := netip.ParseAddr(entry)
+		if prefixErr != nil && addrErr != nil {
+			check(fmt.Errorf("server.trusted_proxies[%d]: %q is neither an address nor a CIDR prefix", i, entry))
+		}
+	}
+	switch c.Admin.Mode {
+	case "", "asl", "tls":
+	default:
+		check(fmt.Errorf("admin.mode: unknown mode %q", c.Admin.Mode))
+	}
+
+	for i, raw := range c.ACME.ExternalURLs {
+		check(checkExternalURL(fmt.Sprintf("acme.external_urls[%d]", i), raw))
+	}
+
+	check(checkProfiles("acme.profiles", c.ACME.Profiles))
+	check(checkPolicy("acme.policy", c.ACME.Policy))
+	check(checkCAAIdentities("acme.caa_identities", c.ACME.CAAIdentities))
+	check(c.validateTenants())
+	check(checkListenAddr("caa.resolver", c.CAA.Resolver))
+
+	switch c.StorageBackend() {
+	case "":
+		check(fmt.Errorf("storage.backend: required, use postgres, sqlite or memory"))
+	case "postgres":
+		for name, value := range map[string]string{
+			"database.host":   c.Database.Host,
+			"database.user":   c.Database.User,
+			"database.dbname": c.Database.DBName,
+		} {
+			if value == "" {
+				check(fmt.Errorf("%s: required by the postgres backend", name))
+			}
+		}
+	case "memory":
+	case "sqlite":
+		if c.Storage.SQLite.Path == "" {
+			check(fmt.Errorf("storage.sqlite.path: required by the sqlite backend"))
+		}
+	default:
+		check(fmt.Errorf("storage.backend: unknown backend %q", c.Storage.Backend))
+	}
+
+	switch c.Nonce.Store {
+	case "", "memory", "database", "hmac":
+	default:
+		check(fmt.Errorf("nonce.store: unknown store %q", c.Nonce.Store))
+	}
+	if c.Database.Port < 0 || c.Database.Port > 65535 {
+		check(fmt.Errorf("database.port: %d out of range", c.Database.Port))
+	}
+
+	if c.Nonce.HMACKey != "" {
+		if _, err := base64.StdEncoding.DecodeString(c.Nonce.HMACKey); err != nil {
+			check(fmt.Errorf("nonce.hmac_key: %w", err))
+		}
+	}
+
+	for name, limit := range map[string]RateLimit{
+		"new_accounts_per_ip":         c.RateLimits.NewAccountsPerIP,
+		"new_orders_per_account":      c.RateLimits.NewOrdersPerAccount,
+		"finalizations_per_account":   c.RateLimits.FinalizationsPerAccount,
+		"failed_validations":          c.RateLimits.FailedValidations,
+		"certificates_per_identifier": c.RateLimits.CertificatesPerIdentifier,
+	} {
+		if limit.Period == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(limit.Period); err != nil || d <= 0 {
+			check(fmt.Errorf("rate_limits.%s.period: must be a positive duration", name))
+		}
+	}
+	if c.RateLimits.Documentation != "" {
+		if u, err := url.Parse(c.RateLimits.Documentation); err != nil || !u.IsAbs() {
+			check(fmt.Errorf("rate_limits.documentation: must be an absolute URL"))
+		}
+	}
+
+	check(c.validateCT())
+	check(c.validateWebhooks())
+	if c.Audit.SigningKey != "" && !c.Audit.Store && c.Audit.File == "" {
+		check(fmt.Errorf("audit.signing_key: requires audit.store or audit.file"))
+	}
+
+	for name, value := range map[string]string{
+		"health.timeout":           c.Health.Timeout,
+		"health.ca_expiry_horizon": c.Health.CAExpiryHorizon,
+		"sweeper.interval":         c.Sweeper.Interval,
+		"sweeper.retention":        c.Sweeper.Retention,
+		"star.interval":            c.STAR.Interval,
+		"caa.timeout":              c.CAA.Timeout,
+		"caa.recheck_after":        c.CAA.RecheckAfter,
+		"webhooks.interval":        c.Webhooks.Interval,
+		"webhooks.expiry_warning":  c.Webhooks.ExpiryWarning,
+	} {
+		check(checkDuration(name, value))
+	}
+
+	for name, path := range map[string]string{
+		"ca.certificates":           c.CA.Certs,
+		"ca.private_key":            c.CA.PrivateKey,
+		"tls.certificates":          c.TLS.Certs,
+		"tls.private_key":           c.TLS.PrivateKey,
+		"pkcs11.entity_module.path": c.PKCS11.EntityModule.Path,
+		"audit.signing_key":         c.Audit.SigningKey,
+	} {
+		check(checkFile(name, path))
+	}
+	for i, path := range c.TLS.ClientCAs {
+		check(checkFile(fmt.Sprintf("tls.client_cas[%d]", i), path))
+	}
+	for i, path := range c.Admin.ClientCAs {
+		check(checkFile(fmt.Sprintf("admin.client_cas[%d]", i), path))
+	}
+
+	// Map iteration order is random; keep the report stable
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+	return errors.Join(errs...)
+}
+
+// reservedSegments are the first path segments of the ACME endpoints and
+// the root endpoints, which tenant paths must not shadow
+var reservedSegments = []string{
+	"directory", "new-nonce", "new-account", "new-order", "revoke-cert",
+	"key-change", "account", "order", "authz", "challenge", "cert",
+	"star-cert", "renewal-info", "terms", "health", "livez", "readyz",
+	"metrics", "admin",
+}
+
+// validateTenants checks the tenants served next to the default tenant
+func (c *Config) validateTenants() error {
+	var errs []error
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i, t := range c.Tenants {
+		name := fmt.Sprintf("tenants[%d]", i)
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", name))
+		} else if names[t.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate tenant %q", name, t.Name))
+		}
+		names[t.Name] = true
+
+		path := t.Path()
+		first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if slices.Contains(reservedSegments, first) {
+			errs = append(errs, fmt.Errorf("%s.path_prefix: %s collides with an ACME endpoint", name, path))
+		} else if paths[path] {
+			errs = append(errs, fmt.Errorf("%s.path_prefix: %s is already in use", name, path))
+		}
+		paths[path] = true
+
+		for field, value := range map[string]string{
+			"ca.certificates": t.CA.Certs,
+			"ca.private_key":  t.CA.PrivateKey,
+		} {
+			if value == "" {
+				errs = append(errs, fmt.Errorf("%s.%s: required", name, field))
+			} else if err := checkFile(name+"."+field, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := checkProfiles(name+".profiles", t.Profiles); err != nil {
+			errs = append(errs, err)
+		}
+		if err := checkPolicy(name+".policy", t.Policy); err != nil {
+			errs = append(errs, err)
+		}
+		if err := checkCAAIdentities(name+".caa_identities", t.CAAIdentities); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateCT checks the Certificate Transparency logs and the SCT policy
+func (c *Config) validateCT() error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, log := range c.CT.Logs {
+		name := fmt.Sprintf("ct.logs[%d]", i)
+		if log.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", name))
+		} else if names[log.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate log %q", name, log.Name))
+		}
+		names[log.Name] = true
+
+		if u, err := url.Parse(log.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.url: must be an http or https URL", name))
+		}
+		if log.PublicKey != "" {
+			der, err := base64.StdEncoding.DecodeString(log.PublicKey)
+			if err == nil {
+				_, err = ct.ParsePublicKey(der)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.public_key: %w", name, err))
+			}
+		}
+		if err := checkDuration(name+".timeout", log.Timeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CT.MinSCTs < 0 || c.CT.MinSCTs > len(c.CT.Logs) {
+		errs = append(errs, fmt.Errorf("ct.min_scts: must be between 0 and the number of logs (%d)", len(c.CT.Logs)))
+	}
+	return errors.Join(errs...)
+}
+
+// validateWebhooks checks the webhook endpoints and the retry policy
+func (c *Config) validateWebhooks() error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, ep := range c.Webhooks.Endpoints {
+		name := fmt.Sprintf("webhooks.endpoints[%d]", i)
+		if ep.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", name))
+		} else if names[ep.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate endpoint %q", name, ep.Name))
+		}
+		names[ep.Name] = true
+
+		if u, err := url.Parse(ep.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.url: must be an http or https URL", name))
+		}
+		if ep.Secret == "" {
+			errs = append(errs, fmt.Errorf("%s.secret: required", name))
+		} else if _, err := webhook.ParseSecret(ep.Secret); err != nil {
+			errs = append(errs, fmt.Errorf("%s.secret: %w", name, err))
+		}
+		for j, event := range ep.Events {
+			if !slices.Contains(webhook.Events, event) {
+				errs = append(errs, fmt.Errorf("%s.events[%d]: unknown event %q", name, j, event))
+			}
+		}
+		if err := checkDuration(name+".timeout", ep.Timeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Webhooks.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("webhooks.max_attempts: must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// checkPolicy checks the rules and limits of an identifier policy
+func checkPolicy(name string, p Policy) error {
+	var errs []error
+	for field, entries := range map[string][]string{"allow": p.Allow, "deny": p.Deny} {
+		if _, err := policy.ParseRules(entries); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", name, field, err))
+		}
+	}
+	if p.MaxIdentifiers < 0 {
+		errs = append(errs, fmt.Errorf("%s.max_identifiers: must not be negative", name))
+	}
+	return errors.Join(errs...)
+}
+
+// checkCAAIdentities checks that CAA issuer identities are domain names
+func checkCAAIdentities(name string, identities []string) error {
+	var errs []error
+	for i, id := range identities {
+		_, problem := policy.Canonicalize([]types.Identifier{{Type: "dns", Value: id}})
+		if problem != nil || strings.Contains(id, "*") {
+			errs = append(errs, fmt.Errorf("%s[%d]: %q is not a domain name", name, i, id))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkProfiles checks a list of certificate profiles
+func checkProfiles(name string, profiles []Profile) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, p := range profiles {
+		field := fmt.Sprintf("%s[%d]", name, i)
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", field))
+		} else if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate profile %q", field, p.Name))
+		}
+		seen[p.Name] = true
+
+		if err := checkDuration(field+".validity", p.Validity); err != nil {
+			errs = append(errs, err)
+		}
+		for j, usage := range p.ExtKeyUsage {
+			if _, err := pki.ParseExtKeyUsage(usage); err != nil {
+				errs = append(errs, fmt.Errorf("%s.ext_key_usage[%d]: %w", field, j, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkDuration(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if d < 0 {
+		return fmt.Errorf("%s: must not be negative", name)
+	}
+	return nil
+}
+
+func checkExternalURL(name, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%s: scheme must be https or http", name)
+	}
+	if u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%s: must be a base URL like https://acme.example.com/path", name)
+	}
+	return nil
+}
+
+func checkListenAddr(name, addr string) error {
+	if addr == "" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s: invalid port %q", name, port)
+	}
+	return nil
+}
+
+// checkFile verifies that path names a regular file. PKCS#11 URIs refer to
+// a token object and are left to the ASL library.
+func checkFile(name, path string) error {
+	if path == "" || strings.HasPrefix(path, "pkcs11:") {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: %s is a directory", name, path)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package ct submits precertificates to Certificate Transparency logs (RFC
+// 6962) and collects the signed certificate timestamps (SCTs) that are
+// embedded in the final certificate.
+package ct
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+)
+
+// DefaultTimeout bounds a submission to a single log if not configured
+// otherwise
+const DefaultTimeout = 10 * time.Second
+
+// Log is a CT log precertificates are submitted to
+type Log struct {
+	Name string
+	// URL is the base URL of the log, e.g. "https://ct.plant.example/2025"
+	URL string
+	// PublicKey verifies the SCTs of the log; nil accepts them unverified
+	PublicKey crypto.PublicKey
+	Timeout   time.Duration
+}
+
+// SCT is a signed certificate timestamp (RFC 6962 Section 3.2)
+type SCT struct {
+	Version    uint8
+	LogID      [sha256.Size]byte
+	Timestamp  uint64 // milliseconds since the epoch
+	Extensions []byte
+	// Signature is the TLS encoded DigitallySigned struct, i.e. hash and
+	// signature algorithm followed by the signature
+	Signature []byte
+}
+
+// Submitter submits precertificates to a set of logs
+type Submitter struct {
+	Logs []Log
+	// MinSCTs is how many logs have to return an SCT for issuance to
+	// proceed
+	MinSCTs int
+	Client  *http.Client
+}
+
+// New creates a submitter requiring minSCTs SCTs from logs. Logs without a
+// timeout get DefaultTimeout.
+func New(logs []Log, minSCTs int) *Submitter {
+	for i := range logs {
+		if logs[i].Timeout <= 0 {
+			logs[i].Timeout = DefaultTimeout
+		}
+	}
+	return &Submitter{Logs: logs, MinSCTs: minSCTs, Client: http.DefaultClient}
+}
+
+// SubmitPrecertificate submits a DER encoded precertificate and the chain of
+// its issuer to all logs at once and returns the value of the SCT list
+// extension for the final certificate. It fails if fewer than MinSCTs logs
+// return a valid SCT in time.
+func (s *Submitter) SubmitPrecertificate(ctx context.Context, precert []byte, chain []*x509.Certificate) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("precertificate submission needs the issuer certificate")
+	}
+	entry, err := newPrecertEntry(precert, chain[0])
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Chain [][]byte `json:"chain"`
+	}{Chain: [][]byte{precert}}
+	for _, cert := range chain {
+		body.Chain = append(body.Chain, cert.Raw)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	scts := make([]*SCT, len(s.Logs))
+	errs := make([]error, len(s.Logs))
+	var wg sync.WaitGroup
+	for i, log := range s.Logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scts[i], errs[i] = s.submit(ctx, log, payload, entry)
+			result := "success"
+			if errs[i] != nil {
+				result = "failure"
+			}
+			metrics.CTSubmissions.WithLabelValues(log.Name, result).Inc()
+		}()
+	}
+	wg.Wait()
+
+	var collected []*SCT
+	var failures []error
+	for i, sct := range scts {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Errorf("log %s: %w", s.Logs[i].Name, errs[i]))
+			continue
+		}
+		collected = append(collected, sct)
+	}
+	if len(collected) < s.MinSCTs {
+		return nil, fmt.Errorf("got %d of %d required SCTs: %w", len(collected), s.MinSCTs, errors.Join(failures...))
+	}
+	return MarshalSCTList(collected)
+}
+
+// submit sends the chain to the add-pre-chain endpoint of a log (RFC 6962
+// Section 4.1) and verifies the returned SCT
+func (s *Submitter) submit(ctx context.Context, log Log, payload []byte, entry *precertEntry) (*SCT, error) {
+	ctx, cancel := context.WithTimeout(ctx, log.Timeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(log.URL, "/") + "/ct/v1/add-pre-chain"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error submitting precertificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("log answered %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	var r struct {
+		Version    uint8  `json:"sct_version"`
+		ID         []byte `json:"id"`
+		Timestamp  uint64 `json:"timestamp"`
+		Extensions []byte `json:"extensions"`
+		Signature  []byte `json:"signature"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding SCT: %w", err)
+	}
+	if r.Version != 0 || len(r.ID) != sha256.Size || len(r.Signature) < 4 {
+		return nil, errors.New("log returned a malformed SCT")
+	}
+	sct := &SCT{Version: r.Version, Timestamp: r.Timestamp, Extensions: r.Extensions, Signature: r.Signature}
+	copy(sct.LogID[:], r.ID)
+
+	if log.PublicKey != nil {
+		if err := verifySCT(log.PublicKey, sct, entry); err != nil {
+			return nil, err
+		}
+	}
+	return sct, nil
+}
+
+// Marshal returns the TLS encoding of the SCT (RFC 6962 Section 3.2)
+func (sct *SCT) Marshal() []byte {
+	b := []byte{sct.Version}
+	b = append(b, sct.LogID[:]...)
+	b = binary.BigEndian.AppendUint64(b, sct.Timestamp)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(sct.Extensions)))
+	b = append(b, sct.Extensions...)
+	return append(b, sct.Signature...)
+}
+
+// MarshalSCTList returns the value of the X.509 SCT list extension: a DER
+// OCTET STRING holding the TLS encoded SignedCertificateTimestampList (RFC
+// 6962 Section 3.3)
+func MarshalSCTList(scts []*SCT) ([]byte, error) {
+	var list []byte
+	for _, sct := range scts {
+		serialized := sct.Marshal()
+		list = binary.BigEndian.AppendUint16(list, uint16(len(serialized)))
+		list = append(list, serialized...)
+	}
+	if len(list) > 0xffff {
+		return nil, errors.New("SCT list too long")
+	}
+	return asn1.Marshal(append(binary.BigEndian.AppendUint16(nil, uint16(len(list))), list...))
+}
@@ -0,0 +1,171 @@
+package ct
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// standInLog is a local CT log answering add-pre-chain with SCTs signed by
+// its key
+type standInLog struct {
+	key   *ecdsa.PrivateKey
+	delay time.Duration
+}
+
+func newStandInLog(t *testing.T, delay time.Duration) (*standInLog, *httptest.Server) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &standInLog{key: key, delay: delay}
+	srv := httptest.NewServer(l)
+	t.Cleanup(srv.Close)
+	return l, srv
+}
+
+func (l *standInLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ct/v1/add-pre-chain" {
+		http.NotFound(w, r)
+		return
+	}
+	time.Sleep(l.delay)
+
+	var req struct {
+		Chain [][]byte `json:"chain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Chain) < 2 {
+		http.Error(w, "bad chain", http.StatusBadRequest)
+		return
+	}
+	issuer, err := x509.ParseCertificate(req.Chain[1])
+	if err != nil {
+		http.Error(w, "bad issuer", http.StatusBadRequest)
+		return
+	}
+	entry, err := newPrecertEntry(req.Chain[0], issuer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spki, _ := x509.MarshalPKIXPublicKey(&l.key.PublicKey)
+	sct := &SCT{LogID: sha256.Sum256(spki), Timestamp: uint64(time.Now().UnixMilli())}
+	signed := []byte{0, 0}
+	signed = binary.BigEndian.AppendUint64(signed, sct.Timestamp)
+	signed = binary.BigEndian.AppendUint16(signed, 1)
+	signed = append(signed, entry.issuerKeyHash[:]...)
+	signed = append(signed, byte(len(entry.tbs)>>16), byte(len(entry.tbs)>>8), byte(len(entry.tbs)))
+	signed = append(signed, entry.tbs...)
+	signed = append(signed, 0, 0)
+	digest := sha256.Sum256(signed)
+	sig, _ := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"sct_version": 0,
+		"id":          sct.LogID[:],
+		"timestamp":   sct.Timestamp,
+		"extensions":  "",
+		"signature":   append([]byte{hashSHA256, sigECDSA, byte(len(sig) >> 8), byte(len(sig))}, sig...),
+	})
+}
+
+// newPrecert returns a precertificate and its issuer, and the
+// TBSCertificate of the same certificate without the poison extension
+func newPrecert(t *testing.T) ([]byte, *x509.Certificate, []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Plant CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		DNSNames:     []string{"plc1.plant.example"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ = x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, key)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf.ExtraExtensions = []pkix.Extension{{Id: oidPoison, Critical: true, Value: asn1.NullBytes}}
+	precert, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return precert, ca, cert.RawTBSCertificate
+}
+
+func TestSubmitPrecertificate(t *testing.T) {
+	precert, ca, tbs := newPrecert(t)
+	if entry, err := newPrecertEntry(precert, ca); err != nil || !bytes.Equal(entry.tbs, tbs) {
+		t.Fatalf("precertificate entry does not match the certificate without poison: %v", err)
+	}
+
+	primary, primarySrv := newStandInLog(t, 0)
+	_, secondarySrv := newStandInLog(t, 0)
+	_, slowSrv := newStandInLog(t, 200*time.Millisecond)
+	_, impostorSrv := newStandInLog(t, 0)
+
+	logs := []Log{
+		{Name: "primary", URL: primarySrv.URL, PublicKey: &primary.key.PublicKey},
+		{Name: "secondary", URL: secondarySrv.URL + "/"},
+		{Name: "slow", URL: slowSrv.URL, Timeout: 20 * time.Millisecond},
+		// Signs with another key than the configured one
+		{Name: "impostor", URL: impostorSrv.URL, PublicKey: &primary.key.PublicKey},
+	}
+
+	value, err := New(logs, 2).SubmitPrecertificate(context.Background(), precert, []*x509.Certificate{ca})
+	if err != nil {
+		t.Fatalf("SubmitPrecertificate: %v", err)
+	}
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for rest := list[2:]; len(rest) > 0; count++ {
+		n := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2+n:]
+	}
+	if count != 2 || int(binary.BigEndian.Uint16(list)) != len(list)-2 {
+		t.Errorf("SCT list has %d SCTs: %x", count, list)
+	}
+
+	_, err = New(logs, 3).SubmitPrecertificate(context.Background(), precert, []*x509.Certificate{ca})
+	if err == nil {
+		t.Fatal("SubmitPrecertificate met a minimum of 3 SCTs with 2 good logs")
+	}
+	for _, want := range []string{"got 2 of 3 required SCTs", "log slow", "log impostor: SCT was issued by another log"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q: %v", want, err)
+		}
+	}
+}
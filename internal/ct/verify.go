@@ -0,0 +1,138 @@
+package ct
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// oidPoison marks a precertificate (RFC 6962 Section 3.1)
+var oidPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// TLS hash and signature algorithm identifiers (RFC 5246 Section 7.4.1.4.1)
+const (
+	hashSHA256 = 4
+	sigRSA     = 1
+	sigECDSA   = 3
+)
+
+// precertEntry is the part of a precertificate an SCT signs: the hash of
+// the issuer key and the TBSCertificate without the poison extension
+type precertEntry struct {
+	issuerKeyHash [sha256.Size]byte
+	tbs           []byte
+}
+
+func newPrecertEntry(precert []byte, issuer *x509.Certificate) (*precertEntry, error) {
+	cert, err := x509.ParseCertificate(precert)
+	if err != nil {
+		return nil, fmt.Errorf("invalid precertificate: %w", err)
+	}
+	tbs, err := removePoison(cert.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+	return &precertEntry{issuerKeyHash: sha256.Sum256(issuer.RawSubjectPublicKeyInfo), tbs: tbs}, nil
+}
+
+// tbsCertificate is the ASN.1 structure of a TBSCertificate (RFC 5280
+// Section 4.1) with everything but the extensions kept as is
+type tbsCertificate struct {
+	Version         int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber    *big.Int
+	Signature       asn1.RawValue
+	Issuer          asn1.RawValue
+	Validity        asn1.RawValue
+	Subject         asn1.RawValue
+	PublicKey       asn1.RawValue
+	IssuerUniqueID  asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions      []pkix.Extension `asn1:"omitempty,optional,explicit,tag:3"`
+}
+
+// removePoison re-encodes a precertificate TBSCertificate without the
+// poison extension, which is what the log signs
+func removePoison(raw []byte) ([]byte, error) {
+	var tbs tbsCertificate
+	if rest, err := asn1.Unmarshal(raw, &tbs); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid precertificate TBSCertificate")
+	}
+	var extensions []pkix.Extension
+	for _, ext := range tbs.Extensions {
+		if !ext.Id.Equal(oidPoison) {
+			extensions = append(extensions, ext)
+		}
+	}
+	if len(extensions) == len(tbs.Extensions) {
+		return nil, errors.New("precertificate has no poison extension")
+	}
+	tbs.Extensions = extensions
+	return asn1.Marshal(tbs)
+}
+
+// verifySCT checks that the SCT was issued for the precertificate by the
+// log with the given key (RFC 6962 Section 3.2)
+func verifySCT(key crypto.PublicKey, sct *SCT, entry *precertEntry) error {
+	spki, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("invalid log key: %w", err)
+	}
+	if sct.LogID != sha256.Sum256(spki) {
+		return errors.New("SCT was issued by another log")
+	}
+
+	// The DigitallySigned struct: hash, signature algorithm, 2 byte length
+	// and the signature
+	hashAlg, sigAlg := sct.Signature[0], sct.Signature[1]
+	length := int(binary.BigEndian.Uint16(sct.Signature[2:4]))
+	if hashAlg != hashSHA256 || len(sct.Signature) != 4+length {
+		return errors.New("SCT has an unsupported or malformed signature")
+	}
+	sig := sct.Signature[4:]
+
+	signed := []byte{sct.Version, 0} // certificate_timestamp
+	signed = binary.BigEndian.AppendUint64(signed, sct.Timestamp)
+	signed = binary.BigEndian.AppendUint16(signed, 1) // precert_entry
+	signed = append(signed, entry.issuerKeyHash[:]...)
+	signed = append(signed, byte(len(entry.tbs)>>16), byte(len(entry.tbs)>>8), byte(len(entry.tbs)))
+	signed = append(signed, entry.tbs...)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(sct.Extensions)))
+	signed = append(signed, sct.Extensions...)
+	digest := sha256.Sum256(signed)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if sigAlg == sigECDSA && ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if sigAlg == sigRSA && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported log key type %T", key)
+	}
+	return errors.New("SCT signature is invalid")
+}
+
+// ParsePublicKey parses a DER SubjectPublicKeyInfo log key, which CT log
+// lists publish base64 encoded
+func ParsePublicKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported log key type %T", key)
+}
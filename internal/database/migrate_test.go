@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// postgresDSN enables the tests that need a PostgreSQL server, e.g.
+// go test ./internal/database -postgres "host=localhost user=acme dbname=acme_test sslmode=disable"
+var postgresDSN = flag.String("postgres", "", "connection string of a disposable PostgreSQL database")
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want consecutive versions starting at 1", i, m.Version)
+		}
+		// Up scripts run against databases holding certificates; they must
+		// never drop tables
+		if strings.Contains(strings.ToUpper(m.Up), "DROP TABLE") {
+			t.Errorf("migration %03d_%s drops a table in its up script", m.Version, m.Name)
+		}
+	}
+}
+
+// TestMigrationsReversible applies every migration, reverts it and checks
+// that the down script restored the schema the up script started from. It
+// works in a schema of its own that is dropped afterwards.
+func TestMigrationsReversible(t *testing.T) {
+	if *postgresDSN == "" {
+		t.Skip("no PostgreSQL database, set -postgres to run")
+	}
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", *postgresDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	schema := fmt.Sprintf("migrate_test_%d", migrationLockID)
+	for _, query := range []string{
+		"DROP SCHEMA IF EXISTS " + schema + " CASCADE",
+		"CREATE SCHEMA " + schema,
+		"SET search_path TO " + schema,
+		createMigrationsTableQuery,
+	} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	defer db.ExecContext(context.Background(), "DROP SCHEMA IF EXISTS "+schema+" CASCADE")
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		insert = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+		remove = `DELETE FROM schema_migrations WHERE version = $1`
+	)
+	initial := schemaSnapshot(t, conn)
+	for _, m := range migrations {
+		before := schemaSnapshot(t, conn)
+		if err := runMigration(ctx, conn, m.Up, insert, m.Version, m.Name); err != nil {
+			t.Fatalf("up %03d_%s: %v", m.Version, m.Name, err)
+		}
+		if err := runMigration(ctx, conn, m.Down, remove, m.Version); err != nil {
+			t.Fatalf("down %03d_%s: %v", m.Version, m.Name, err)
+		}
+		if after := schemaSnapshot(t, conn); !slices.Equal(before, after) {
+			t.Errorf("down %03d_%s does not reverse its up script:\nbefore: %q\nafter:  %q", m.Version, m.Name, before, after)
+		}
+		// Apply it again to build on it, which also checks that the up
+		// script runs after its own down script
+		if err := runMigration(ctx, conn, m.Up, insert, m.Version, m.Name); err != nil {
+			t.Fatalf("up %03d_%s after down: %v", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if err := runMigration(ctx, conn, m.Down, remove, m.Version); err != nil {
+			t.Fatalf("down %03d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+	if final := schemaSnapshot(t, conn); !slices.Equal(initial, final) {
+		t.Errorf("reverting all migrations left %q, want %q", final, initial)
+	}
+}
+
+// schemaSnapshot describes the tables, columns, indexes and constraints of
+// the current schema, sorted so that column order does not matter. NOT NULL
+// shows up as CHECK constraints named after OIDs and is covered by the
+// columns.
+func schemaSnapshot(t *testing.T, conn *sql.Conn) []string {
+	t.Helper()
+	rows, err := conn.QueryContext(context.Background(), `
+		SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type || ' ' ||
+			is_nullable || ' ' || COALESCE(column_default, '')
+		FROM information_schema.columns WHERE table_schema = current_schema()
+		UNION ALL
+		SELECT 'index ' || indexdef FROM pg_indexes WHERE schemaname = current_schema()
+		UNION ALL
+		SELECT 'constraint ' || table_name || '.' || constraint_name || ' ' || constraint_type
+		FROM information_schema.table_constraints
+		WHERE constraint_schema = current_schema() AND constraint_type <> 'CHECK'`)
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	defer rows.Close()
+
+	var snapshot []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		snapshot = append(snapshot, line)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(snapshot)
+	return snapshot
+}
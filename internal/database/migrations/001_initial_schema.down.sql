@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS certificates;
+DROP TABLE IF EXISTS challenges;
+DROP TABLE IF EXISTS authorizations;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS accounts;
@@ -1,5 +1,5 @@
--- Enable UUID extension
-CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+-- Base ACME schema. Tables are created only if missing so that databases
+-- initialised from the former schema.sql are adopted without data loss.
 
 -- Accounts table
 CREATE TABLE IF NOT EXISTS accounts (
@@ -56,9 +56,7 @@ CREATE TABLE IF NOT EXISTS challenges (
 );
 
 -- Certificates table
-DROP TABLE IF EXISTS certificates;
-
-CREATE TABLE certificates (
+CREATE TABLE IF NOT EXISTS certificates (
     id VARCHAR(255) PRIMARY KEY,
     order_id VARCHAR(255) NOT NULL REFERENCES orders(id),
     certificate TEXT NOT NULL,
@@ -72,4 +70,4 @@ CREATE TABLE certificates (
 CREATE INDEX IF NOT EXISTS idx_orders_account_id ON orders(account_id);
 CREATE INDEX IF NOT EXISTS idx_authorizations_order_id ON authorizations(order_id);
 CREATE INDEX IF NOT EXISTS idx_challenges_authorization_id ON challenges(authorization_id);
-CREATE INDEX IF NOT EXISTS idx_certificates_order_id ON certificates(order_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_certificates_order_id ON certificates(order_id);
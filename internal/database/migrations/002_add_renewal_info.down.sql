@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_certificates_serial;
+DROP TABLE IF EXISTS issuer_renewal_overrides;
+
+ALTER TABLE certificates DROP COLUMN IF EXISTS renewal_explanation_url;
+ALTER TABLE certificates DROP COLUMN IF EXISTS renewal_window_end;
+ALTER TABLE certificates DROP COLUMN IF EXISTS renewal_window_start;
+ALTER TABLE certificates DROP COLUMN IF EXISTS replaced_by;
+ALTER TABLE certificates DROP COLUMN IF EXISTS not_after;
+ALTER TABLE certificates DROP COLUMN IF EXISTS not_before;
+ALTER TABLE certificates DROP COLUMN IF EXISTS authority_key_id;
+ALTER TABLE certificates DROP COLUMN IF EXISTS serial;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS replaces;
@@ -0,0 +1,21 @@
+-- ACME Renewal Information (RFC 9773)
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS replaces VARCHAR(255);
+
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS serial VARCHAR(64);
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS authority_key_id VARCHAR(128);
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS not_before TIMESTAMP WITH TIME ZONE;
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS not_after TIMESTAMP WITH TIME ZONE;
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS replaced_by VARCHAR(255);
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS renewal_window_start TIMESTAMP WITH TIME ZONE;
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS renewal_window_end TIMESTAMP WITH TIME ZONE;
+ALTER TABLE certificates ADD COLUMN IF NOT EXISTS renewal_explanation_url TEXT;
+
+CREATE TABLE IF NOT EXISTS issuer_renewal_overrides (
+    authority_key_id VARCHAR(128) PRIMARY KEY,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    window_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    explanation_url TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_certificates_serial ON certificates(serial);
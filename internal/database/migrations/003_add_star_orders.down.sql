@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS csr;
+ALTER TABLE orders DROP COLUMN IF EXISTS auto_renewal;
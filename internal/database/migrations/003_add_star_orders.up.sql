@@ -0,0 +1,3 @@
+-- Short-Term Automatically Renewed certificates (RFC 8739)
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS auto_renewal JSONB;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS csr TEXT;
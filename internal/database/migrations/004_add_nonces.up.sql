@@ -0,0 +1,7 @@
+-- Replay nonces shared by all server instances
+CREATE TABLE IF NOT EXISTS nonces (
+    nonce VARCHAR(64) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_nonces_created_at ON nonces(created_at);
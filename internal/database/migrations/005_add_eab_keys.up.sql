@@ -0,0 +1,9 @@
+-- External Account Binding keys managed through the admin API
+CREATE TABLE IF NOT EXISTS eab_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    hmac_key BYTEA NOT NULL,
+    label TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    bound_account_id VARCHAR(255) REFERENCES accounts(id),
+    bound_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_orders_tenant;
+
+ALTER TABLE eab_keys DROP COLUMN IF EXISTS tenant;
+ALTER TABLE orders DROP COLUMN IF EXISTS profile;
+ALTER TABLE orders DROP COLUMN IF EXISTS tenant;
+ALTER TABLE accounts DROP COLUMN IF EXISTS tenant;
@@ -0,0 +1,8 @@
+-- Multiple ACME tenants sharing one database. Existing rows belong to the
+-- default tenant, which has the empty name.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS profile VARCHAR(255);
+ALTER TABLE eab_keys ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_orders_tenant ON orders(tenant);
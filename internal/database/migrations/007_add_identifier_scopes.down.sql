@@ -0,0 +1,2 @@
+ALTER TABLE eab_keys DROP COLUMN IF EXISTS scope;
+ALTER TABLE accounts DROP COLUMN IF EXISTS scope;
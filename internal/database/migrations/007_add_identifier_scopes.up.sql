@@ -0,0 +1,4 @@
+-- Identifier scopes restrict an account to domain suffixes and IP ranges.
+-- Accounts inherit the scope of the EAB key they are bound with.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS scope JSONB;
+ALTER TABLE eab_keys ADD COLUMN IF NOT EXISTS scope JSONB;
@@ -0,0 +1,9 @@
+-- Token buckets of the rate limiter shared by all server instances. A
+-- bucket is full again at full_at, in Unix nanoseconds; full buckets are
+-- removed by the sweeper.
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    full_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_full_at ON rate_limits(full_at);
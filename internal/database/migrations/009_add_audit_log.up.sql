@@ -0,0 +1,8 @@
+-- Hash-chained audit log. entry is the JSON entry exactly as it was hashed;
+-- appends of several replicas conflict on seq, so the chain stays linear.
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq BIGINT PRIMARY KEY,
+    event TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    entry TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE certificates DROP COLUMN IF EXISTS expiry_notified;
+DROP TABLE IF EXISTS webhook_deliveries;
@@ -0,0 +1,3 @@
+-- A replica leases a processing order before it signs the certificate, so
+-- that the recovery scans of several replicas do not issue it twice
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS issuance_claimed_until TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+// Pinger is implemented by the storage backends
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Migrator is implemented by the storage backends with a versioned schema
+type Migrator interface {
+	MigrationStatus(ctx context.Context) ([]database.MigrationStatus, error)
+}
+
+// Sizer is implemented by the nonce stores
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// PingCheck verifies that the storage backend answers
+func PingCheck(p Pinger) CheckFunc {
+	return func(ctx context.Context) error {
+		return p.PingContext(ctx)
+	}
+}
+
+// MigrationCheck verifies that every embedded schema migration has been
+// applied, so that an instance of a newer release does not serve requests
+// against an old schema
+func MigrationCheck(db Migrator) CheckFunc {
+	return func(ctx context.Context) error {
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		version, pending := 0, 0
+		for _, s := range status {
+			if s.Applied {
+				version = s.Version
+			} else {
+				pending++
+			}
+		}
+		if pending > 0 {
+			return fmt.Errorf("schema at version %d, %d migration(s) pending", version, pending)
+		}
+		return nil
+	}
+}
+
+// NonceStoreCheck verifies that the nonce store answers
+func NonceStoreCheck(nonces Sizer) CheckFunc {
+	return func(ctx context.Context) error {
+		_, err := nonces.Size(ctx)
+		return err
+	}
+}
+
+// CACertificateCheck verifies that the CA certificate returned by ca is
+// currently valid. It warns once the certificate expires within horizon. ca
+// is called on every check, so a CA replaced at runtime is checked.
+func CACertificateCheck(ca func() *pki.CA, horizon time.Duration) CheckFunc {
+	return func(ctx context.Context) error {
+		current := ca()
+		if current == nil {
+			return errors.New("no CA loaded")
+		}
+		cert := current.Certificate()
+
+		now := time.Now()
+		switch {
+		case now.Before(cert.NotBefore):
+			return fmt.Errorf("CA certificate not valid before %s", cert.NotBefore.Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			return fmt.Errorf("CA certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+		case now.Add(horizon).After(cert.NotAfter):
+			return Warnf("CA certificate expires at %s", cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// CAKeyCheck verifies that the signer of the CA returned by ca, the one
+// certificates are issued with, still signs with the key of the CA
+// certificate. With a PKCS#11 key this is a signature on the token, so it
+// also detects a lost session or an unreachable token. The signer does not
+// take a context: a probe that outlives ctx fails the check, and no new
+// probe is started until it has returned.
+func CAKeyCheck(ca func() *pki.CA) CheckFunc {
+	probing := make(chan struct{}, 1)
+	return func(ctx context.Context) error {
+		current := ca()
+		if current == nil {
+			return errors.New("no CA loaded")
+		}
+
+		select {
+		case probing <- struct{}{}:
+		default:
+			return errors.New("previous CA key probe has not returned")
+		}
+		done := make(chan error, 1)
+		go func() {
+			defer func() { <-probing }()
+			done <- current.Probe()
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("CA key probe did not finish: %w", ctx.Err())
+		}
+	}
+}
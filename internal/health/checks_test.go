@@ -0,0 +1,182 @@
+package health
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+// newCA returns a self-signed CA valid from notBefore to notAfter
+func newCA(t *testing.T, notBefore, notAfter time.Time) *pki.CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Plant CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pki.CA{Chain: []*x509.Certificate{cert}, Key: key}
+}
+
+func TestCACertificateCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name                string
+		notBefore, notAfter time.Time
+		want                string // substring of the error, empty for none
+		warning             bool
+	}{
+		{"valid", now.Add(-time.Hour), now.Add(90 * 24 * time.Hour), "", false},
+		{"expiring", now.Add(-time.Hour), now.Add(24 * time.Hour), "expires at", true},
+		{"expired", now.Add(-2 * time.Hour), now.Add(-time.Hour), "expired at", false},
+		{"not yet valid", now.Add(time.Hour), now.Add(2 * time.Hour), "not valid before", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newCA(t, tt.notBefore, tt.notAfter)
+			err := CACertificateCheck(func() *pki.CA { return ca }, 30*24*time.Hour)(context.Background())
+
+			var warning *Warning
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("err = %v, want none", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("err = %v, want %q", err, tt.want)
+			case err != nil && errors.As(err, &warning) != tt.warning:
+				t.Errorf("err = %v, warning = %v, want %v", err, !tt.warning, tt.warning)
+			}
+		})
+	}
+
+	// The CA is looked up on every check, so a replaced CA is seen
+	current := newCA(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	check := CACertificateCheck(func() *pki.CA { return current }, 0)
+	current = newCA(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := check(context.Background()); err == nil {
+		t.Error("check did not see the replaced, expired CA")
+	}
+}
+
+func TestCAKeyCheck(t *testing.T) {
+	now := time.Now()
+	ca := newCA(t, now.Add(-time.Hour), now.Add(time.Hour))
+	if err := CAKeyCheck(func() *pki.CA { return ca })(context.Background()); err != nil {
+		t.Errorf("CAKeyCheck: %v", err)
+	}
+
+	other := newCA(t, now.Add(-time.Hour), now.Add(time.Hour))
+	mismatched := &pki.CA{Chain: ca.Chain, Key: other.Key}
+	if err := CAKeyCheck(func() *pki.CA { return mismatched })(context.Background()); err == nil {
+		t.Error("CAKeyCheck accepted a key that does not belong to the CA certificate")
+	}
+}
+
+// blockingSigner signs with key once release is closed, like a token that
+// stopped answering
+type blockingSigner struct {
+	crypto.Signer
+	release chan struct{}
+}
+
+func (s *blockingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	<-s.release
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestCAKeyCheckTimeout(t *testing.T) {
+	now := time.Now()
+	ca := newCA(t, now.Add(-time.Hour), now.Add(time.Hour))
+	signer := &blockingSigner{Signer: ca.Key, release: make(chan struct{})}
+	check := CAKeyCheck(func() *pki.CA { return &pki.CA{Chain: ca.Chain, Key: signer} })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := check(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("check = %v, want deadline exceeded", err)
+	}
+
+	// The hung probe is not started again while it is outstanding
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "has not returned") {
+		t.Errorf("second check = %v, want the outstanding probe reported", err)
+	}
+
+	close(signer.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := check(context.Background())
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("check after release = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// migrator reports a fixed migration status
+type migrator struct {
+	status []database.MigrationStatus
+	err    error
+}
+
+func (m *migrator) MigrationStatus(ctx context.Context) ([]database.MigrationStatus, error) {
+	return m.status, m.err
+}
+
+func TestMigrationCheck(t *testing.T) {
+	status := func(applied ...bool) []database.MigrationStatus {
+		var s []database.MigrationStatus
+		for i, a := range applied {
+			s = append(s, database.MigrationStatus{Migration: database.Migration{Version: i + 1}, Applied: a})
+		}
+		return s
+	}
+
+	tests := []struct {
+		name string
+		m    *migrator
+		want string
+	}{
+		{"up to date", &migrator{status: status(true, true, true)}, ""},
+		{"pending", &migrator{status: status(true, false, false)}, "schema at version 1, 2 migration(s) pending"},
+		{"unreachable", &migrator{err: errors.New("connection refused")}, "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MigrationCheck(tt.m)(context.Background())
+			if tt.want == "" && err != nil || tt.want != "" && (err == nil || err.Error() != tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Package health implements the liveness and readiness endpoints. Readiness
+// runs a set of named checks against the server's real dependencies and
+// reports each result.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a single readiness check
+const DefaultTimeout = 3 * time.Second
+
+// Check states
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// CheckFunc probes a dependency. Returning a *Warning reports a degraded
+// but usable dependency without failing readiness.
+type CheckFunc func(ctx context.Context) error
+
+// Warning is returned by checks whose dependency still works but needs
+// attention, e.g. a CA certificate close to expiry
+type Warning struct {
+	Message string
+}
+
+func (w *Warning) Error() string {
+	return w.Message
+}
+
+// Warnf creates a warning
+func Warnf(format string, args ...any) error {
+	return &Warning{Message: fmt.Sprintf(format, args...)}
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the readiness response body
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker runs the registered readiness checks
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []check
+}
+
+// NewChecker creates a checker. A zero timeout selects DefaultTimeout.
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Register adds a named check. Checks run in registration order.
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// Run executes all checks concurrently. The report passes if no check
+// failed; warnings do not affect readiness. A check that does not return
+// within the timeout, or before ctx is done, fails.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]check(nil), c.checks...)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, chk := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, chk)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass, Checks: results}
+	for _, result := range results {
+		switch {
+		case result.Status == StatusFail:
+			report.Status = StatusFail
+		case result.Status == StatusWarn && report.Status == StatusPass:
+			report.Status = StatusWarn
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, chk check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// The check runs in its own goroutine, so that a check ignoring ctx,
+	// e.g. one blocked in a PKCS#11 call, cannot hold up the report
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- chk.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check did not finish: %w", ctx.Err())
+	}
+	result := Result{
+		Name:     chk.name,
+		Status:   StatusPass,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+	}
+
+	var warning *Warning
+	switch {
+	case err == nil:
+	case errors.As(err, &warning):
+		result.Status = StatusWarn
+		result.Message = warning.Message
+	default:
+		result.Status = StatusFail
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// ServeHTTP implements the readiness endpoint. It responds 200 if the
+// server is ready and 503 otherwise.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status == StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Live implements the liveness endpoint. It only reports that the process
+// is serving requests and never checks dependencies, so that an outage of
+// the database does not cause restarts.
+func Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": StatusPass})
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantReport string
+	}{
+		{"pass", nil, http.StatusOK, StatusPass},
+		{"warn", Warnf("CA certificate expires soon"), http.StatusOK, StatusWarn},
+		{"fail", errors.New("connection refused"), http.StatusServiceUnavailable, StatusFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(0)
+			checker.Register("ok", func(ctx context.Context) error { return nil })
+			checker.Register("dependency", func(ctx context.Context) error { return tt.err })
+
+			rec := httptest.NewRecorder()
+			checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("invalid report: %v", err)
+			}
+			if report.Status != tt.wantReport || len(report.Checks) != 2 {
+				t.Errorf("report = %+v, want status %q with 2 checks", report, tt.wantReport)
+			}
+			if report.Checks[0].Name != "ok" || report.Checks[1].Name != "dependency" {
+				t.Errorf("checks out of registration order: %+v", report.Checks)
+			}
+		})
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	// A check that ignores its context fails once the timeout has passed
+	block := make(chan struct{})
+	defer close(block)
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Register("ok", func(ctx context.Context) error { return nil })
+	checker.Register("hung", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+
+	start := time.Now()
+	report := checker.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %s, want it bounded by the timeout", elapsed)
+	}
+	if report.Status != StatusFail {
+		t.Errorf("report status = %q, want %q", report.Status, StatusFail)
+	}
+	if got := report.Checks[1]; got.Status != StatusFail || !strings.Contains(got.Message, "deadline exceeded") {
+		t.Errorf("hung check = %+v, want failed on the deadline", got)
+	}
+	if got := report.Checks[0]; got.Status != StatusPass {
+		t.Errorf("ok check = %+v, want pass", got)
+	}
+}
@@ -0,0 +1,352 @@
+package issuance
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/star"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+const (
+	// DefaultQueueSize is used when no queue size is configured
+	DefaultQueueSize = 256
+
+	// DefaultRecoveryInterval is how often processing orders are re-queued
+	// from the database, e.g. after a restart or when the queue was full
+	DefaultRecoveryInterval = 30 * time.Second
+
+	// issuanceLease is how long a worker owns a processing order. Another
+	// worker, in this or another replica, can only take the order over
+	// once the lease has run out, e.g. after a crash during signing.
+	issuanceLease = 5 * time.Minute
+)
+
+// ErrQueueFull is returned by Submit if the issuance queue cannot take more
+// orders. The order stays processing and is picked up by the next recovery
+// scan.
+var ErrQueueFull = errors.New("issuance queue is full")
+
+// Config holds the issuance pool settings
+type Config struct {
+	Workers          int
+	QueueSize        int
+	RecoveryInterval time.Duration
+	// Audit records every issued certificate; nil disables auditing
+	Audit *audit.Log
+	// Webhooks announces every issued certificate; nil disables webhooks
+	Webhooks *webhook.Notifier
+}
+
+// Pool signs certificates for processing orders on a fixed number of
+// workers, so slow signing backends do not block request handling
+type Pool struct {
+	store   storage.Store
+	tenants *tenant.Registry
+	config  Config
+	logger  *logger.Logger
+
+	queue    chan string
+	inFlight sync.Map // order ID -> struct{}
+
+	// cancel stops the workers from taking new orders; running issuances
+	// are not cancelled
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPool creates an issuance pool that signs with the CA of the tenant of
+// each order. Zero values in cfg are replaced by the defaults; the number of
+// workers defaults to the number of CPUs.
+func NewPool(store storage.Store, tenants *tenant.Registry, cfg Config, log *logger.Logger) *Pool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.RecoveryInterval <= 0 {
+		cfg.RecoveryInterval = DefaultRecoveryInterval
+	}
+
+	return &Pool{
+		store:   store,
+		tenants: tenants,
+		config:  cfg,
+		logger:  log,
+		queue:   make(chan string, cfg.QueueSize),
+	}
+}
+
+// Start launches the workers and the recovery scan. Issuances run on a
+// context that is not cancelled with ctx, so that a certificate that is
+// being signed or submitted to CT logs at shutdown is still stored.
+func (p *Pool) Start(ctx context.Context) {
+	issueCtx := context.WithoutCancel(ctx)
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	for i := 0; i < p.config.Workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(ctx, issueCtx)
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.recover(ctx)
+	}()
+
+	p.logger.Infow("Issuance pool started",
+		"workers", p.config.Workers,
+		"queue_size", p.config.QueueSize,
+	)
+}
+
+// Stop keeps the workers from taking new orders and waits for running
+// issuances to finish. Orders still queued stay processing and are picked
+// up by the recovery scan of the next start or of another replica.
+func (p *Pool) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+	p.logger.Info("Issuance pool stopped")
+}
+
+// Submit queues a processing order for issuance without blocking
+func (p *Pool) Submit(orderID string) error {
+	if _, loaded := p.inFlight.LoadOrStore(orderID, struct{}{}); loaded {
+		return nil
+	}
+
+	select {
+	case p.queue <- orderID:
+		return nil
+	default:
+		p.inFlight.Delete(orderID)
+		return ErrQueueFull
+	}
+}
+
+// work issues queued orders on issueCtx until ctx is done
+func (p *Pool) work(ctx, issueCtx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case orderID := <-p.queue:
+			// select picks at random if both are ready; a stopped worker
+			// leaves the order to the recovery scan
+			if ctx.Err() != nil {
+				p.inFlight.Delete(orderID)
+				return
+			}
+			if err := p.issue(issueCtx, orderID); err != nil {
+				p.logger.Errorw("Certificate issuance failed",
+					"order", orderID,
+					"error", err,
+				)
+			}
+			p.inFlight.Delete(orderID)
+		}
+	}
+}
+
+// recover periodically re-queues orders left in the processing state
+func (p *Pool) recover(ctx context.Context) {
+	ticker := time.NewTicker(p.config.RecoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		orderIDs, err := p.store.ListProcessingOrderIDs(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Errorf("Failed to list processing orders: %v", err)
+		}
+		for _, orderID := range orderIDs {
+			if err := p.Submit(orderID); err != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// issue signs the certificate for a processing order and moves the order to
+// valid, or to invalid if the certificate cannot be issued
+func (p *Pool) issue(ctx context.Context, orderID string) error {
+	start := time.Now()
+
+	order, err := p.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+	if order.Status != types.OrderStatusProcessing {
+		return nil
+	}
+
+	// Only one worker across all replicas may sign for an order
+	claimed, err := p.store.ClaimOrderIssuance(ctx, order.ID, start, start.Add(issuanceLease))
+	if err != nil {
+		return fmt.Errorf("failed to claim order: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	certPEM, err := p.sign(ctx, order)
+	if err != nil {
+		p.fail(ctx, order, err)
+		return err
+	}
+
+	cert, err := pki.CertificateRecord(order.ID, certPEM)
+	if err != nil {
+		p.fail(ctx, order, err)
+		return fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	// The certificate is only stored once the audit log holds it, so no
+	// certificate reaches a client without an audit entry
+	if err := p.config.Audit.RecordIssuance(ctx, order, cert, false); err != nil {
+		err = fmt.Errorf("failed to record certificate in the audit log: %w", err)
+		p.fail(ctx, order, err)
+		return err
+	}
+
+	completed, err := p.store.CompleteOrderIssuance(ctx, order, cert)
+	if err != nil {
+		return fmt.Errorf("failed to store certificate: %w", err)
+	}
+	if !completed {
+		p.logger.Infow("Order was completed elsewhere, discarding certificate", "order", order.ID)
+		if err := p.config.Audit.RecordDiscard(ctx, order, cert, "order completed elsewhere"); err != nil {
+			p.logger.Errorf("Failed to record discarded certificate %s in the audit log: %v", cert.ID, err)
+		}
+		return nil
+	}
+
+	// Link the replaced certificate to its successor (RFC 9773 Section 5)
+	if order.Replaces != "" {
+		if err := p.markReplaced(ctx, order.Replaces, cert.ID); err != nil {
+			p.logger.Errorf("Failed to mark certificate %s as replaced: %v", order.Replaces, err)
+		}
+	}
+
+	if err := p.config.Webhooks.NotifyIssuance(ctx, order, cert, false); err != nil {
+		p.logger.Errorf("Failed to queue webhook for certificate %s: %v", cert.ID, err)
+	}
+
+	metrics.OrderTransition(string(types.OrderStatusProcessing), string(types.OrderStatusValid))
+	metrics.CertificatesIssued.WithLabelValues("order").Inc()
+	metrics.IssuanceDuration.Observe(time.Since(start).Seconds())
+
+	p.logger.Infow("Certificate issued",
+		"order", order.ID,
+		"certificate", cert.ID,
+		"serial", cert.Serial,
+		"duration", time.Since(start),
+	)
+
+	return nil
+}
+
+// sign issues the certificate for the CSR stored on the order with the CA
+// and profile of the order. STAR orders start with a short-lived
+// certificate.
+func (p *Pool) sign(ctx context.Context, order *types.Order) (string, error) {
+	settings, err := p.tenants.Settings(order.Tenant)
+	if err != nil {
+		return "", err
+	}
+	profile, ok := settings.Profile(order.Profile)
+	if !ok {
+		return "", fmt.Errorf("unknown profile %q", order.Profile)
+	}
+
+	csrDER, err := base64.RawURLEncoding.DecodeString(order.CSR)
+	if err != nil {
+		return "", fmt.Errorf("invalid stored CSR encoding: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return "", fmt.Errorf("invalid stored CSR: %w", err)
+	}
+
+	if order.AutoRenewal != nil {
+		notBefore, notAfter := star.FirstValidity(order.AutoRenewal, time.Now())
+		return pki.IssueCertificate(ctx, settings.CA, profile, csr, notBefore, notAfter, settings.CT)
+	}
+
+	now := time.Now()
+	return pki.IssueCertificate(ctx, settings.CA, profile, csr, now, now.Add(profile.Validity), settings.CT)
+}
+
+// fail moves an order to invalid and records why issuance failed, unless
+// the order is no longer processing. An issuance that was cancelled did not
+// fail; the order stays processing and is issued again once the lease has
+// run out.
+func (p *Pool) fail(ctx context.Context, order *types.Order, cause error) {
+	if errors.Is(cause, context.Canceled) || ctx.Err() != nil {
+		p.logger.Infow("Certificate issuance cancelled, leaving order processing", "order", order.ID)
+		return
+	}
+
+	order.Status = types.OrderStatusInvalid
+	order.UpdatedAt = types.Time{Time: time.Now()}
+	order.Error = &types.Problem{
+		Type:   "urn:ietf:params:acme:error:serverInternal",
+		Detail: fmt.Sprintf("Certificate issuance failed: %v", cause),
+		Status: http.StatusInternalServerError,
+	}
+
+	failed, err := p.store.FailOrderIssuance(ctx, order)
+	if err != nil {
+		p.logger.Errorf("Failed to mark order %s as invalid: %v", order.ID, err)
+		return
+	}
+	if !failed {
+		p.logger.Infow("Order was completed elsewhere, not marking it invalid", "order", order.ID)
+		return
+	}
+	metrics.OrderTransition(string(types.OrderStatusProcessing), string(order.Status))
+}
+
+// markReplaced links the certificate with the given ARI identifier to its
+// successor
+func (p *Pool) markReplaced(ctx context.Context, certID string, replacedBy string) error {
+	aki, serial, err := pki.ParseRenewalCertID(certID)
+	if err != nil {
+		return err
+	}
+
+	replaced, err := p.store.GetCertificateBySerial(ctx, hex.EncodeToString(aki), pki.SerialHex(serial))
+	if err != nil {
+		return err
+	}
+
+	return p.store.MarkCertificateReplaced(ctx, replaced.ID, replacedBy)
+}
@@ -0,0 +1,264 @@
+package issuance
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+)
+
+// newProcessingOrder stores an order with a CSR for plc1.plant.example that
+// waits for issuance
+func newProcessingOrder(t *testing.T, store *memory.Store, id, profile string) *types.Order {
+	t.Helper()
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"plc1.plant.example"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := &types.Order{
+		ID:          id,
+		AccountID:   "acct_1",
+		Status:      types.OrderStatusPending,
+		ExpiresAt:   types.Time{Time: time.Now().Add(time.Hour)},
+		Identifiers: []types.Identifier{{Type: "dns", Value: "plc1.plant.example"}},
+		Profile:     profile,
+	}
+	if err := store.CreateOrder(ctx, order, nil); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = types.OrderStatusProcessing
+	order.CSR = base64.RawURLEncoding.EncodeToString(csr)
+	order.UpdatedAt = types.Time{Time: time.Now()}
+	if err := store.UpdateOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestIssue(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(store, tenants, Config{}, logger.New(io.Discard))
+
+	// Valid: the certificate is stored and the order moves to valid
+	newProcessingOrder(t, store, "order_valid", "")
+	if err := pool.issue(ctx, "order_valid"); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	order, _ := store.GetOrder(ctx, "order_valid")
+	if order.Status != types.OrderStatusValid || order.CertificateID == "" {
+		t.Fatalf("issued order = %+v, want valid with a certificate", order)
+	}
+	if _, err := store.GetCertificate(ctx, order.CertificateID); err != nil {
+		t.Errorf("certificate of the issued order: %v", err)
+	}
+
+	// Invalid: an order that cannot be signed moves to invalid with an error
+	newProcessingOrder(t, store, "order_invalid", "unknown")
+	if err := pool.issue(ctx, "order_invalid"); err == nil {
+		t.Error("issue with an unknown profile succeeded")
+	}
+	order, _ = store.GetOrder(ctx, "order_invalid")
+	if order.Status != types.OrderStatusInvalid || order.Error == nil {
+		t.Errorf("failed order = %+v, want invalid with an error", order)
+	}
+
+	// Claimed elsewhere: another worker holds the lease, so nothing is signed
+	newProcessingOrder(t, store, "order_claimed", "unknown")
+	if claimed, err := store.ClaimOrderIssuance(ctx, "order_claimed", time.Now(), time.Now().Add(time.Minute)); !claimed || err != nil {
+		t.Fatalf("ClaimOrderIssuance = %v, %v", claimed, err)
+	}
+	if err := pool.issue(ctx, "order_claimed"); err != nil {
+		t.Errorf("issue of an order claimed elsewhere: %v", err)
+	}
+	if order, _ := store.GetOrder(ctx, "order_claimed"); order.Status != types.OrderStatusProcessing {
+		t.Errorf("order claimed elsewhere = %s, want processing", order.Status)
+	}
+
+	// Completed elsewhere: a late failure must not invalidate the order
+	stale := newProcessingOrder(t, store, "order_completed", "")
+	if err := pool.issue(ctx, "order_completed"); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	pool.fail(ctx, stale, errors.New("signing timed out"))
+	if order, _ := store.GetOrder(ctx, "order_completed"); order.Status != types.OrderStatusValid || order.Error != nil {
+		t.Errorf("order completed elsewhere = %+v, want valid", order)
+	}
+}
+
+// unwritableAudit keeps the audit log in a store that rejects every append
+type unwritableAudit struct {
+	*memory.Store
+}
+
+func (unwritableAudit) AppendAuditRecord(ctx context.Context, record *types.AuditRecord) (bool, error) {
+	return false, errors.New("disk full")
+}
+
+func TestIssueWithoutAudit(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(audit.Config{Store: unwritableAudit{memory.New()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(store, tenants, Config{Audit: auditLog}, logger.New(io.Discard))
+
+	// A certificate that cannot be audited is never stored
+	newProcessingOrder(t, store, "order_unaudited", "")
+	if err := pool.issue(ctx, "order_unaudited"); err == nil {
+		t.Error("issue succeeded without an audit entry")
+	}
+	order, _ := store.GetOrder(ctx, "order_unaudited")
+	if order.Status != types.OrderStatusInvalid || order.CertificateID != "" {
+		t.Errorf("unaudited order = %+v, want invalid without a certificate", order)
+	}
+}
+
+// slowLogs stands in for CT logs that answer once release is closed
+type slowLogs struct {
+	submitted chan struct{}
+	release   chan struct{}
+}
+
+func (l *slowLogs) SubmitPrecertificate(ctx context.Context, precert []byte, chain []*x509.Certificate) ([]byte, error) {
+	close(l.submitted)
+	select {
+	case <-l.release:
+		return []byte{0x04, 0x00}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestStopDuringIssuance(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	logs := &slowLogs{submitted: make(chan struct{}), release: make(chan struct{})}
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{CT: logs}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(store, tenants, Config{Workers: 1}, logger.New(io.Discard))
+	pool.Start(ctx)
+
+	newProcessingOrder(t, store, "order_stopping", "")
+	if err := pool.Submit("order_stopping"); err != nil {
+		t.Fatal(err)
+	}
+	<-logs.submitted
+
+	// Stopping waits for the submission instead of cancelling it
+	var stopped sync.WaitGroup
+	stopped.Add(1)
+	go func() {
+		defer stopped.Done()
+		pool.Stop()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(logs.release)
+	stopped.Wait()
+
+	order, _ := store.GetOrder(ctx, "order_stopping")
+	if order.Status != types.OrderStatusValid || order.CertificateID == "" {
+		t.Errorf("order issued during Stop = %+v, want valid with a certificate", order)
+	}
+}
+
+func TestIssueCancelled(t *testing.T) {
+	store := memory.New()
+	logs := &slowLogs{submitted: make(chan struct{}), release: make(chan struct{})}
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{CT: logs}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(store, tenants, Config{}, logger.New(io.Discard))
+
+	// A cancelled issuance leaves the order processing, so that it is
+	// retried, instead of marking it invalid
+	newProcessingOrder(t, store, "order_cancelled", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-logs.submitted
+		cancel()
+	}()
+	if err := pool.issue(ctx, "order_cancelled"); !errors.Is(err, context.Canceled) {
+		t.Errorf("issue = %v, want cancelled", err)
+	}
+	order, _ := store.GetOrder(context.Background(), "order_cancelled")
+	if order.Status != types.OrderStatusProcessing || order.Error != nil {
+		t.Errorf("cancelled order = %+v, want processing without an error", order)
+	}
+}
+
+// completedElsewhere loses every issuance to another worker
+type completedElsewhere struct {
+	*memory.Store
+}
+
+func (completedElsewhere) CompleteOrderIssuance(ctx context.Context, order *types.Order, cert *types.Certificate) (bool, error) {
+	return false, nil
+}
+
+func TestIssueDiscarded(t *testing.T) {
+	ctx := context.Background()
+	store, auditStore := memory.New(), memory.New()
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open(audit.Config{Store: auditStore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(completedElsewhere{store}, tenants, Config{Audit: auditLog}, logger.New(io.Discard))
+
+	// The certificate recorded before the order was lost is marked as
+	// discarded
+	newProcessingOrder(t, store, "order_lost", "")
+	if err := pool.issue(ctx, "order_lost"); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	records, err := auditStore.ListAuditRecords(ctx, 0, 10)
+	if err != nil || len(records) != 2 || records[0].Event != audit.EventCertificateIssued || records[1].Event != audit.EventCertificateDiscarded {
+		t.Fatalf("audit records = %+v, %v, want an issued and a discarded entry", records, err)
+	}
+	var issued, discarded struct{ Data struct{ Certificate string } }
+	if err := json.Unmarshal(records[0].Entry, &issued); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(records[1].Entry, &discarded); err != nil {
+		t.Fatal(err)
+	}
+	if discarded.Data.Certificate == "" || discarded.Data.Certificate != issued.Data.Certificate {
+		t.Errorf("discarded certificate %q, want the issued %q", discarded.Data.Certificate, issued.Data.Certificate)
+	}
+}
@@ -0,0 +1,213 @@
+// Package metrics defines the Prometheus metrics of the ACME server and the
+// handler that exposes them.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "acme"
+
+// Registry holds all metrics of the server. A dedicated registry keeps
+// metrics of imported libraries out of the scrape.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts requests by route pattern, method and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration observes request latency by route pattern and method
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// JWSFailures counts rejected JWS requests by reason
+	JWSFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jws_verification_failures_total",
+		Help:      "Rejected JWS requests by reason.",
+	}, []string{"reason"})
+
+	// OrderTransitions counts order state changes. Orders created by a
+	// client start from "new"; orders expired in bulk by the sweeper are
+	// counted from "expired".
+	OrderTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_transitions_total",
+		Help:      "Order state transitions.",
+	}, []string{"from", "to"})
+
+	// ChallengeValidations counts challenge validation results by type
+	ChallengeValidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "challenge_validations_total",
+		Help:      "Challenge validations by challenge type and result.",
+	}, []string{"type", "result"})
+
+	// ChallengeDuration observes challenge validation latency by type
+	ChallengeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "challenge_validation_duration_seconds",
+		Help:      "Challenge validation latency by challenge type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	// IssuanceDuration observes the time from picking up a processing order
+	// to storing its certificate
+	IssuanceDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "issuance_duration_seconds",
+		Help:      "Certificate issuance latency.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// CertificatesIssued counts issued certificates by kind ("order" or "star")
+	CertificatesIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificates_issued_total",
+		Help:      "Issued certificates by kind.",
+	}, []string{"kind"})
+
+	// CertificatesRevoked counts certificates that were revoked
+	CertificatesRevoked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificates_revoked_total",
+		Help:      "Revoked certificates.",
+	})
+
+	// SweeperRuns counts expiry sweeps by result ("success" or "failure")
+	SweeperRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sweeper_runs_total",
+		Help:      "Expiry sweeps by result.",
+	}, []string{"result"})
+
+	// SweeperRecords counts the records the expiry sweeper moved to a
+	// terminal state ("expired") or deleted ("purged")
+	SweeperRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sweeper_records_total",
+		Help:      "Records expired or purged by the expiry sweeper by record type and action.",
+	}, []string{"record", "action"})
+
+	// RateLimited counts requests rejected by a rate limit
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limit.",
+	}, []string{"limit"})
+
+	// CTSubmissions counts precertificate submissions to CT logs
+	CTSubmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ct_submissions_total",
+		Help:      "Precertificate submissions to Certificate Transparency logs by result.",
+	}, []string{"log", "result"})
+
+	// AuditEntries counts audit log entries by event and result
+	AuditEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_entries_total",
+		Help:      "Audit log entries by event and result.",
+	}, []string{"event", "result"})
+
+	// WebhookDeliveries counts webhook delivery attempts by endpoint and
+	// result
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by endpoint and result (delivered, retry, dropped).",
+	}, []string{"endpoint", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		JWSFailures,
+		OrderTransitions,
+		ChallengeValidations,
+		ChallengeDuration,
+		IssuanceDuration,
+		CertificatesIssued,
+		CertificatesRevoked,
+		SweeperRuns,
+		SweeperRecords,
+		RateLimited,
+		CTSubmissions,
+		AuditEntries,
+		WebhookDeliveries,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware records HTTP request counts and latency. It labels requests
+// with the chi route pattern so that IDs in URLs do not create new series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// OrderTransition records an order moving from one state to another
+func OrderTransition(from, to string) {
+	OrderTransitions.WithLabelValues(from, to).Inc()
+}
+
+// ObserveChallenge records the result and latency of a challenge validation
+func ObserveChallenge(challengeType, result string, duration time.Duration) {
+	ChallengeValidations.WithLabelValues(challengeType, result).Inc()
+	ChallengeDuration.WithLabelValues(challengeType).Observe(duration.Seconds())
+}
+
+// RegisterNonceStore exposes the number of outstanding nonces reported by
+// size
+func RegisterNonceStore(size func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nonce_store_size",
+		Help:      "Nonces currently held by the nonce store.",
+	}, size))
+}
+
+// RegisterDB exposes the connection pool statistics of a database
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareLabelsRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r.Method(http.MethodGet, "/metrics", Handler())
+
+	for _, id := range []string{"a", "b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order/"+id, nil))
+	}
+
+	if got := testutil.ToFloat64(HTTPRequests.WithLabelValues("/order/{id}", http.MethodGet, "418")); got != 2 {
+		t.Errorf("requests for /order/{id} = %v, want 2", got)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `acme_http_requests_total{method="GET",route="/order/{id}",status="418"} 2`) {
+		t.Errorf("scrape does not contain the request counter:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,96 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// RenewalCertID builds the ACME Renewal Information certificate identifier
+// (RFC 9773 Section 4.1): the base64url-encoded keyIdentifier of the
+// Authority Key Identifier extension and the base64url-encoded DER bytes of
+// the serial number, joined by a period.
+func RenewalCertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate has no authority key identifier")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cert.AuthorityKeyId) + "." +
+		base64.RawURLEncoding.EncodeToString(serialBytes(cert.SerialNumber)), nil
+}
+
+// ParseRenewalCertID splits an ARI certificate identifier into its authority
+// key identifier and serial number.
+func ParseRenewalCertID(certID string) ([]byte, *big.Int, error) {
+	akiPart, serialPart, ok := strings.Cut(certID, ".")
+	if !ok || akiPart == "" || serialPart == "" {
+		return nil, nil, fmt.Errorf("certificate identifier must have the form <aki>.<serial>")
+	}
+
+	aki, err := base64.RawURLEncoding.DecodeString(akiPart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid authority key identifier encoding: %w", err)
+	}
+
+	serial, err := base64.RawURLEncoding.DecodeString(serialPart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid serial number encoding: %w", err)
+	}
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		return nil, nil, fmt.Errorf("serial number must be a positive DER integer")
+	}
+
+	return aki, new(big.Int).SetBytes(serial), nil
+}
+
+// ImmediateRenewalWindow returns a renewal window that has already passed,
+// which asks clients to renew right away (RFC 9773 Section 4.2)
+func ImmediateRenewalWindow(now time.Time) (time.Time, time.Time) {
+	return now.Add(-2 * time.Hour), now.Add(-time.Hour)
+}
+
+// SuggestedRenewalWindow returns the default renewal window for a
+// certificate: it opens once two thirds of the validity period have elapsed
+// and closes when one sixth of the validity period remains.
+func SuggestedRenewalWindow(notBefore, notAfter time.Time) (time.Time, time.Time) {
+	lifetime := notAfter.Sub(notBefore)
+	start := notBefore.Add(lifetime * 2 / 3)
+	end := notAfter.Add(-lifetime / 6)
+	return start, end
+}
+
+// SerialHex returns the lower-case hex encoding of a serial number, which is
+// how serial numbers are stored in the database.
+func SerialHex(serial *big.Int) string {
+	return hex.EncodeToString(serial.Bytes())
+}
+
+// ParseCertificatePEM parses the first certificate of a PEM-encoded chain
+func ParseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// serialBytes returns the content octets of the DER encoding of a positive
+// serial number, adding a leading zero byte if the high bit is set.
+func serialBytes(serial *big.Int) []byte {
+	b := serial.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
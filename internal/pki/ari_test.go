@@ -0,0 +1,62 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestRenewalCertID(t *testing.T) {
+	// Example from RFC 9773 Section 4.1
+	aki, _ := hex.DecodeString("69885b6b87464041e1b37b847ba0ae2cde01c8d4")
+	serial, _ := new(big.Int).SetString("87654321", 16)
+	want := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"
+
+	got, err := RenewalCertID(&x509.Certificate{AuthorityKeyId: aki, SerialNumber: serial})
+	if err != nil {
+		t.Fatalf("RenewalCertID failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("Want cert ID %s, got %s", want, got)
+	}
+
+	parsedAKI, parsedSerial, err := ParseRenewalCertID(got)
+	if err != nil {
+		t.Fatalf("ParseRenewalCertID failed: %v", err)
+	}
+	if hex.EncodeToString(parsedAKI) != hex.EncodeToString(aki) {
+		t.Errorf("Want AKI %x, got %x", aki, parsedAKI)
+	}
+	if parsedSerial.Cmp(serial) != 0 {
+		t.Errorf("Want serial %x, got %x", serial, parsedSerial)
+	}
+}
+
+func TestParseRenewalCertIDInvalid(t *testing.T) {
+	for _, certID := range []string{
+		"",
+		"aYhba4dGQEHhs3uEe6CuLN4ByNQ",
+		"aYhba4dGQEHhs3uEe6CuLN4ByNQ.",
+		"aYhba4dGQEHhs3uEe6CuLN4ByNQ.h2VDIQ", // negative serial
+		"not base64!.AIdlQyE",
+	} {
+		if _, _, err := ParseRenewalCertID(certID); err == nil {
+			t.Errorf("Expected error for cert ID %q", certID)
+		}
+	}
+}
+
+func TestSuggestedRenewalWindow(t *testing.T) {
+	notBefore := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * 24 * time.Hour)
+
+	start, end := SuggestedRenewalWindow(notBefore, notAfter)
+	if want := notBefore.Add(60 * 24 * time.Hour); !start.Equal(want) {
+		t.Errorf("Want window start %v, got %v", want, start)
+	}
+	if want := notAfter.Add(-15 * 24 * time.Hour); !end.Equal(want) {
+		t.Errorf("Want window end %v, got %v", want, end)
+	}
+}
@@ -0,0 +1,143 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CA is the issuing certificate authority
+type CA struct {
+	// Chain holds the CA certificate first, followed by its issuers
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// Certificate returns the CA certificate
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.Chain[0]
+}
+
+// probeMessage is signed by Probe
+var probeMessage = []byte("kritis3m_acme CA key probe")
+
+// Probe signs a fixed message with the CA key and verifies the signature
+// with the CA certificate. It fails if the signer stopped working, e.g. a
+// token was removed, or no longer belongs to the certificate.
+func (ca *CA) Probe() error {
+	digest := sha256.Sum256(probeMessage)
+
+	switch pub := ca.Certificate().PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		sig, err := ca.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("CA key cannot sign: %w", err)
+		}
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("CA key signature does not verify with the CA certificate")
+		}
+	case *rsa.PublicKey:
+		sig, err := ca.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("CA key cannot sign: %w", err)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("CA key signature does not verify with the CA certificate")
+		}
+	case ed25519.PublicKey:
+		sig, err := ca.Key.Sign(rand.Reader, probeMessage, crypto.Hash(0))
+		if err != nil {
+			return fmt.Errorf("CA key cannot sign: %w", err)
+		}
+		if !ed25519.Verify(pub, probeMessage, sig) {
+			return errors.New("CA key signature does not verify with the CA certificate")
+		}
+	default:
+		return fmt.Errorf("unsupported CA key type %T", pub)
+	}
+	return nil
+}
+
+// LoadCA reads the CA chain and private key and checks that they belong
+// together
+func LoadCA(certPath, keyPath string) (*CA, error) {
+	chain, err := LoadCertificates(certPath)
+	if err != nil {
+		return nil, err
+	}
+	if !chain[0].IsCA {
+		return nil, fmt.Errorf("certificate in %s is not a CA certificate", certPath)
+	}
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if !KeyMatchesCertificate(key, chain[0]) {
+		return nil, fmt.Errorf("key %s does not match CA certificate %s", keyPath, certPath)
+	}
+	return &CA{Chain: chain, Key: key}, nil
+}
+
+// LoadCertificates reads all PEM certificates from path, leaf first
+func LoadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("certificate unavailable: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate in %s", path)
+	}
+	return certs, nil
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS#8, SEC 1 or PKCS#1 private key
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key unavailable: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("key %s: unsupported key type %T", path, key)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key %s: unsupported private key format", path)
+}
+
+// KeyMatchesCertificate reports whether key is the private key of cert
+func KeyMatchesCertificate(key crypto.Signer, cert *x509.Certificate) bool {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
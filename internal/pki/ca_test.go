@@ -0,0 +1,141 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed CA certificate and its key to dir
+func writeCA(t *testing.T, dir string, name string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath = filepath.Join(dir, name+".pem")
+	keyPath = filepath.Join(dir, name+".key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certPath, keyPath
+}
+
+func TestIssueCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA := writeCA(t, dir, "CA A")
+	certB, keyB := writeCA(t, dir, "CA B")
+
+	if _, err := LoadCA(certA, keyB); err == nil {
+		t.Error("LoadCA accepted a key of another CA")
+	}
+
+	for _, paths := range [][2]string{{certA, keyA}, {certB, keyB}} {
+		ca, err := LoadCA(paths[0], paths[1])
+		if err != nil {
+			t.Fatalf("LoadCA: %v", err)
+		}
+
+		leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "leaf"}, PublicKey: &leafKey.PublicKey}
+		certPEM, err := IssueCertificate(context.Background(), ca, DefaultProfile, csr, time.Now(), time.Now().Add(time.Hour), nil)
+		if err != nil {
+			t.Fatalf("IssueCertificate: %v", err)
+		}
+		cert, err := ParseCertificatePEM(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cert.CheckSignatureFrom(ca.Certificate()); err != nil {
+			t.Errorf("certificate not issued by %s: %v", ca.Certificate().Subject, err)
+		}
+	}
+}
+
+// fakeLogs records the submitted precertificate and returns a fixed SCT list
+type fakeLogs struct {
+	precert *x509.Certificate
+	chain   []*x509.Certificate
+}
+
+func (f *fakeLogs) SubmitPrecertificate(_ context.Context, precert []byte, chain []*x509.Certificate) ([]byte, error) {
+	cert, err := x509.ParseCertificate(precert)
+	if err != nil {
+		return nil, err
+	}
+	f.precert, f.chain = cert, chain
+	return asn1.Marshal([]byte{0, 0})
+}
+
+func TestIssueCertificateWithSCTs(t *testing.T) {
+	ca, err := LoadCA(writeCA(t, t.TempDir(), "CA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr := &x509.CertificateRequest{DNSNames: []string{"plc1.plant.example"}, PublicKey: &leafKey.PublicKey}
+
+	logs := &fakeLogs{}
+	certPEM, err := IssueCertificate(context.Background(), ca, DefaultProfile, csr, time.Now(), time.Now().Add(time.Hour), logs)
+	if err != nil {
+		t.Fatalf("IssueCertificate: %v", err)
+	}
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logs.precert == nil || len(logs.chain) != 1 || !logs.chain[0].Equal(ca.Certificate()) {
+		t.Fatalf("precertificate %v submitted with chain %v", logs.precert, logs.chain)
+	}
+	if logs.precert.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Error("precertificate and certificate have different serial numbers")
+	}
+	hasExtension := func(c *x509.Certificate, oid asn1.ObjectIdentifier, critical bool) bool {
+		for _, ext := range c.Extensions {
+			if ext.Id.Equal(oid) && ext.Critical == critical {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasExtension(logs.precert, oidCTPoison, true) || hasExtension(cert, oidCTPoison, true) {
+		t.Error("poison extension not only in the precertificate")
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSCTList) && !bytes.Equal(ext.Value, []byte{4, 2, 0, 0}) {
+			t.Errorf("SCT list extension = %x", ext.Value)
+		}
+	}
+	if !hasExtension(cert, oidSCTList, false) {
+		t.Error("certificate has no SCT list")
+	}
+}
@@ -1,10 +1,13 @@
 package pki
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"math/big"
@@ -13,10 +16,27 @@ import (
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
 )
 
-// IssueCertificate generates a certificate based on the CSR and order details
-func IssueCertificate(csr *x509.CertificateRequest, order *types.Order) (string, error) {
-	// Load CA certificate and key
-	caCert, caKey, err := loadCA()
+var (
+	// oidCTPoison marks a precertificate (RFC 6962 Section 3.1)
+	oidCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// oidSCTList holds the SCTs embedded in a certificate (RFC 6962
+	// Section 3.3)
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// Transparency submits precertificates to Certificate Transparency logs
+type Transparency interface {
+	// SubmitPrecertificate submits a DER encoded precertificate and the
+	// chain of its issuer and returns the value of the SCT list extension
+	SubmitPrecertificate(ctx context.Context, precert []byte, chain []*x509.Certificate) ([]byte, error)
+}
+
+// IssueCertificate signs a certificate for the CSR with ca that is valid for
+// the given period. The profile selects the extended key usages. With
+// logs, a precertificate is submitted first and the SCTs are embedded in
+// the certificate.
+func IssueCertificate(ctx context.Context, ca *CA, profile *Profile, csr *x509.CertificateRequest, notBefore, notAfter time.Time, logs Transparency) (string, error) {
+	caCert, caKey, err := loadCA(ca)
 	if err != nil {
 		return "", fmt.Errorf("failed to load CA: %w", err)
 	}
@@ -25,10 +45,10 @@ func IssueCertificate(csr *x509.CertificateRequest, order *types.Order) (string,
 	template := &x509.Certificate{
 		SerialNumber:          newSerialNumber(),
 		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour), // 1 year validity
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage:           profile.ExtKeyUsage,
 		BasicConstraintsValid: true,
 	}
 
@@ -36,6 +56,14 @@ func IssueCertificate(csr *x509.CertificateRequest, order *types.Order) (string,
 	template.DNSNames = csr.DNSNames
 	template.IPAddresses = csr.IPAddresses
 
+	if logs != nil {
+		sctList, err := submitPrecertificate(ctx, logs, template, caCert, csr.PublicKey, caKey, ca)
+		if err != nil {
+			return "", err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: sctList}}
+	}
+
 	// Create certificate
 	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
 	if err != nil {
@@ -51,9 +79,60 @@ func IssueCertificate(csr *x509.CertificateRequest, order *types.Order) (string,
 	return string(certPEM), nil
 }
 
-// loadCA loads the CA certificate and private key.
-// This is a dummy implementation for testing.
-func loadCA() (*x509.Certificate, interface{}, error) {
+// submitPrecertificate signs the template with the poison extension and
+// submits the precertificate to the logs. The final certificate has to be
+// signed from the same template.
+func submitPrecertificate(ctx context.Context, logs Transparency, template, caCert *x509.Certificate, pub, caKey any, ca *CA) ([]byte, error) {
+	precert := *template
+	precert.ExtraExtensions = []pkix.Extension{{Id: oidCTPoison, Critical: true, Value: asn1.NullBytes}}
+	precertDER, err := x509.CreateCertificate(rand.Reader, &precert, caCert, pub, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create precertificate: %w", err)
+	}
+
+	chain := []*x509.Certificate{caCert}
+	if ca != nil {
+		chain = ca.Chain
+	}
+	sctList, err := logs.SubmitPrecertificate(ctx, precertDER, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log precertificate: %w", err)
+	}
+	return sctList, nil
+}
+
+// CertificateRecord parses an issued PEM certificate and returns the record
+// to store for it, including serial, issuer key identifier and validity.
+// The record gets a random ID, so replicas issuing at the same time cannot
+// pick the same one.
+func CertificateRecord(orderID string, certPEM string) (*types.Certificate, error) {
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate certificate ID: %w", err)
+	}
+
+	return &types.Certificate{
+		ID:             "cert_" + hex.EncodeToString(id),
+		OrderID:        orderID,
+		Certificate:    certPEM,
+		Serial:         SerialHex(cert.SerialNumber),
+		AuthorityKeyID: hex.EncodeToString(cert.AuthorityKeyId),
+		NotBefore:      types.Time{Time: cert.NotBefore},
+		NotAfter:       types.Time{Time: cert.NotAfter},
+	}, nil
+}
+
+// loadCA returns the certificate and private key of ca. Without a
+// configured CA it generates a dummy CA for testing.
+func loadCA(ca *CA) (*x509.Certificate, interface{}, error) {
+	if ca != nil {
+		return ca.Certificate(), ca.Key, nil
+	}
+
 	// Generate a dummy CA certificate and key for testing.
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
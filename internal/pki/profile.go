@@ -0,0 +1,44 @@
+package pki
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// Profile describes a kind of certificate a client can order, e.g. a TLS
+// server or client certificate
+type Profile struct {
+	Name        string
+	Description string
+	Validity    time.Duration
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+// DefaultProfile is offered when no profiles are configured
+var DefaultProfile = &Profile{
+	Name:        "default",
+	Description: "TLS server certificate",
+	Validity:    365 * 24 * time.Hour, // 1 year validity
+	ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+}
+
+// extKeyUsages maps configuration names to extended key usages
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// ParseExtKeyUsage returns the extended key usage with the given name, e.g.
+// "server_auth" or "client_auth"
+func ParseExtKeyUsage(name string) (x509.ExtKeyUsage, error) {
+	usage, ok := extKeyUsages[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown extended key usage %q", name)
+	}
+	return usage, nil
+}
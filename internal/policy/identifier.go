@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+const (
+	errUnsupportedIdentifier = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	errCompound              = "urn:ietf:params:acme:error:compound"
+)
+
+const (
+	// maxDomainLength is the longest domain name in text form without the
+	// trailing dot (RFC 1035 Section 2.3.4)
+	maxDomainLength = 253
+	maxLabelLength  = 63
+)
+
+// Canonicalize validates the syntax of identifiers and returns them in
+// canonical form without duplicates: DNS names as lower case A-labels
+// without a trailing dot, IP addresses in the text form of RFC 8738 Section
+// 3, i.e. dotted decimal for IPv4 and RFC 5952 for IPv6. Malformed values
+// are reported as rejectedIdentifier, types other than dns and ip as
+// unsupportedIdentifier subproblems.
+func Canonicalize(identifiers []types.Identifier) ([]types.Identifier, *types.Problem) {
+	var canonical []types.Identifier
+	var subproblems []types.Subproblem
+	seen := make(map[types.Identifier]bool)
+	for _, id := range identifiers {
+		var value string
+		var err error
+		problemType := errRejectedIdentifier
+		switch id.Type {
+		case "dns":
+			value, err = canonicalDomain(id.Value)
+		case "ip":
+			value, err = canonicalIP(id.Value)
+		default:
+			err = errors.New("is not a supported identifier type")
+			problemType = errUnsupportedIdentifier
+		}
+		if err != nil {
+			subproblems = append(subproblems, types.Subproblem{
+				Type:       problemType,
+				Detail:     fmt.Sprintf("%s %q %v", id.Type, id.Value, err),
+				Status:     http.StatusBadRequest,
+				Identifier: &types.Identifier{Type: id.Type, Value: id.Value},
+			})
+			continue
+		}
+		id.Value = value
+		if !seen[id] {
+			seen[id] = true
+			canonical = append(canonical, id)
+		}
+	}
+	if len(subproblems) > 0 {
+		return nil, newIdentifierProblem(subproblems)
+	}
+	return canonical, nil
+}
+
+// canonicalDomain checks that name is a domain name of letters, digits and
+// hyphens after IDNA conversion, optionally with a wildcard as its whole
+// leftmost label
+func canonicalDomain(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("is empty")
+	}
+	if strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+		return "", errors.New("may only contain a wildcard as its whole leftmost label")
+	}
+	ascii, err := NormalizeDomain(name)
+	if err != nil {
+		return "", fmt.Errorf("is not a valid domain name: %w", err)
+	}
+	if len(ascii) > maxDomainLength {
+		return "", fmt.Errorf("is longer than %d characters", maxDomainLength)
+	}
+
+	base, wildcard := strings.CutPrefix(ascii, "*.")
+	labels := strings.Split(base, ".")
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return "", errors.New("has an empty label")
+		case len(label) > maxLabelLength:
+			return "", fmt.Errorf("has a label longer than %d characters", maxLabelLength)
+		case strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-"):
+			return "", fmt.Errorf("has label %q starting or ending with a hyphen", label)
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", fmt.Errorf("has label %q with character %q", label, c)
+			}
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", errors.New("has a numeric top-level label, use an identifier of type ip for addresses")
+	}
+	if wildcard && len(labels) < 2 {
+		return "", errors.New("is a wildcard for a top-level domain")
+	}
+	return ascii, nil
+}
+
+// canonicalIP returns the RFC 8738 text form of an IP address. IPv4-mapped
+// IPv6 addresses are returned as IPv4; zones are not allowed.
+func canonicalIP(value string) (string, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", errors.New("is not a valid IP address")
+	}
+	if addr.Zone() != "" {
+		return "", errors.New("must not have a zone")
+	}
+	return addr.Unmap().String(), nil
+}
+
+// newIdentifierProblem reports rejected identifiers as subproblems. The
+// problem has the type of its subproblems, or compound if they differ.
+func newIdentifierProblem(subproblems []types.Subproblem) *types.Problem {
+	problem := &types.Problem{
+		Type:        subproblems[0].Type,
+		Detail:      subproblems[0].Detail,
+		Status:      http.StatusBadRequest,
+		Subproblems: subproblems,
+	}
+	if len(subproblems) > 1 {
+		problem.Detail = fmt.Sprintf("%d identifiers are not allowed", len(subproblems))
+	}
+	for _, sub := range subproblems {
+		if sub.Type != problem.Type {
+			problem.Type = errCompound
+		}
+	}
+	return problem
+}
@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+func TestCanonicalize(t *testing.T) {
+	got, problem := Canonicalize([]types.Identifier{
+		{Type: "dns", Value: "*.Bücher.Example."},
+		{Type: "dns", Value: "PLC1.line3.plant.example"},
+		{Type: "dns", Value: "plc1.line3.plant.example."},
+		{Type: "ip", Value: "::ffff:10.0.0.1"},
+		{Type: "ip", Value: "2001:DB8:0:0:0:0:0:1"},
+		{Type: "ip", Value: "2001:db8::1"},
+	})
+	if problem != nil {
+		t.Fatalf("Canonicalize: %+v", problem)
+	}
+	want := []types.Identifier{
+		{Type: "dns", Value: "*.xn--bcher-kva.example"},
+		{Type: "dns", Value: "plc1.line3.plant.example"},
+		{Type: "ip", Value: "10.0.0.1"},
+		{Type: "ip", Value: "2001:db8::1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Canonicalize = %+v, want %+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		id          types.Identifier
+		problemType string
+	}{
+		{types.Identifier{Type: "dns", Value: "foo bar"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: ""}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "plc1..plant.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "-plc1.plant.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "plc_1.plant.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "plc1.*.plant.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "*plc.plant.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "*.example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: "10.0.0.1"}, errRejectedIdentifier},
+		{types.Identifier{Type: "dns", Value: strings.Repeat("a", 64) + ".example"}, errRejectedIdentifier},
+		{types.Identifier{Type: "ip", Value: "10.0.0.999"}, errRejectedIdentifier},
+		{types.Identifier{Type: "ip", Value: "010.0.0.1"}, errRejectedIdentifier},
+		{types.Identifier{Type: "ip", Value: "fe80::1%eth0"}, errRejectedIdentifier},
+		{types.Identifier{Type: "email", Value: "plc1@plant.example"}, errUnsupportedIdentifier},
+	} {
+		_, problem := Canonicalize([]types.Identifier{tc.id})
+		if problem == nil {
+			t.Errorf("Canonicalize accepted %+v", tc.id)
+			continue
+		}
+		if problem.Type != tc.problemType || len(problem.Subproblems) != 1 || *problem.Subproblems[0].Identifier != tc.id {
+			t.Errorf("Canonicalize(%+v) = %+v", tc.id, problem)
+		}
+	}
+
+	_, problem = Canonicalize([]types.Identifier{{Type: "ip", Value: "foo"}, {Type: "email", Value: "plc1@plant.example"}})
+	if problem == nil || problem.Type != errCompound || len(problem.Subproblems) != 2 {
+		t.Errorf("Canonicalize = %+v, want a compound problem", problem)
+	}
+}
@@ -0,0 +1,178 @@
+// Package policy decides which identifiers may be ordered. A tenant policy
+// allows and denies domain suffixes and IP ranges and limits the number of
+// identifiers per order; account scopes, inherited from the EAB key the
+// account was bound with, narrow it down further for single devices.
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+const errRejectedIdentifier = "urn:ietf:params:acme:error:rejectedIdentifier"
+
+// Rules match identifiers by domain suffix or IP range
+type Rules struct {
+	// Domains match themselves and all names below them, in A-label form
+	Domains []string
+	IPs     []netip.Prefix
+}
+
+// ParseRules parses domain suffixes, e.g. "line3.plant.example", and IP
+// addresses or CIDR ranges, e.g. "10.3.0.0/16"
+func ParseRules(entries []string) (Rules, error) {
+	var rules Rules
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			rules.IPs = append(rules.IPs, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			rules.IPs = append(rules.IPs, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		domain, err := NormalizeDomain(strings.TrimPrefix(entry, "."))
+		if err != nil || domain == "" || strings.HasPrefix(domain, "*.") {
+			return Rules{}, fmt.Errorf("invalid domain or IP range %q", entry)
+		}
+		rules.Domains = append(rules.Domains, domain)
+	}
+	return rules, nil
+}
+
+// Empty reports whether there are no rules
+func (r Rules) Empty() bool {
+	return len(r.Domains) == 0 && len(r.IPs) == 0
+}
+
+// covers reports whether every name the identifier certifies lies within
+// the rules
+func (r Rules) covers(id types.Identifier) bool {
+	switch id.Type {
+	case "dns":
+		base := strings.TrimPrefix(id.Value, "*.")
+		for _, domain := range r.Domains {
+			if isWithin(base, domain) {
+				return true
+			}
+		}
+	case "ip":
+		addr, err := netip.ParseAddr(id.Value)
+		if err != nil {
+			return false
+		}
+		for _, prefix := range r.IPs {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// touches reports whether any name the identifier certifies lies within
+// the rules. A wildcard also certifies the names directly below its base
+// domain, so "*.plant.example" touches "scada.plant.example".
+func (r Rules) touches(id types.Identifier) bool {
+	if r.covers(id) {
+		return true
+	}
+	if id.Type != "dns" || !strings.HasPrefix(id.Value, "*.") {
+		return false
+	}
+	base := strings.TrimPrefix(id.Value, "*.")
+	for _, domain := range r.Domains {
+		if _, parent, ok := strings.Cut(domain, "."); ok && parent == base {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether name equals domain or lies below it
+func isWithin(name, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// Policy restricts the identifiers of orders
+type Policy struct {
+	// Allow lists everything that may be ordered; empty allows everything
+	// not denied
+	Allow Rules
+	// Deny takes precedence over Allow and account scopes
+	Deny Rules
+	// MaxIdentifiers limits the identifiers per order; 0 means no limit
+	MaxIdentifiers int
+}
+
+// NormalizeDomain converts a domain name to lower case A-labels, e.g.
+// "Bücher.Example." to "xn--bcher-kva.example". A leading wildcard label is
+// kept.
+func NormalizeDomain(name string) (string, error) {
+	wildcard := strings.HasPrefix(name, "*.")
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "*."), ".")
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", err
+	}
+	ascii = strings.ToLower(ascii)
+	if wildcard {
+		ascii = "*." + ascii
+	}
+	return ascii, nil
+}
+
+// Check evaluates identifiers returned by Canonicalize against the policy
+// and the scopes of the ordering account. Every identifier must be allowed
+// by the policy and covered by each non-empty scope. All rejected identifiers are
+// reported as subproblems of one rejectedIdentifier problem.
+func (p *Policy) Check(identifiers []types.Identifier, scopes ...Rules) *types.Problem {
+	if p != nil && p.MaxIdentifiers > 0 && len(identifiers) > p.MaxIdentifiers {
+		return &types.Problem{
+			Type:   errRejectedIdentifier,
+			Detail: fmt.Sprintf("Order has %d identifiers, at most %d are allowed", len(identifiers), p.MaxIdentifiers),
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	var subproblems []types.Subproblem
+	for _, id := range identifiers {
+		if reason := p.reject(id, scopes); reason != "" {
+			subproblems = append(subproblems, types.Subproblem{
+				Type:       errRejectedIdentifier,
+				Detail:     fmt.Sprintf("%s %s: %s", id.Type, id.Value, reason),
+				Status:     http.StatusBadRequest,
+				Identifier: &types.Identifier{Type: id.Type, Value: id.Value},
+			})
+		}
+	}
+	if len(subproblems) == 0 {
+		return nil
+	}
+	return newIdentifierProblem(subproblems)
+}
+
+// reject returns why the identifier may not be ordered, or "" if it may
+func (p *Policy) reject(id types.Identifier, scopes []Rules) string {
+	if p != nil {
+		if p.Deny.touches(id) {
+			return "denied by policy"
+		}
+		if !p.Allow.Empty() && !p.Allow.covers(id) {
+			return "not allowed by policy"
+		}
+	}
+	for _, scope := range scopes {
+		if !scope.Empty() && !scope.covers(id) {
+			return "outside the scope of the account"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+func mustRules(t *testing.T, entries ...string) Rules {
+	t.Helper()
+	rules, err := ParseRules(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{
+		Allow:          mustRules(t, "plant.example", "10.0.0.0/8"),
+		Deny:           mustRules(t, "scada.plant.example", "10.0.0.1"),
+		MaxIdentifiers: 3,
+	}
+	// A device on line 3 is bound with a scope
+	line3 := mustRules(t, "line3.plant.example", "10.3.0.0/16")
+
+	for _, tc := range []struct {
+		name        string
+		identifiers []types.Identifier
+		rejected    int // number of subproblems, -1 for a problem without
+	}{
+		{"own names", []types.Identifier{{Type: "dns", Value: "plc1.line3.plant.example"}, {Type: "ip", Value: "10.3.0.7"}}, 0},
+		{"mixed case and trailing dot", []types.Identifier{{Type: "dns", Value: "Plc1.Line3.Plant.Example."}}, 0},
+		{"SCADA master", []types.Identifier{{Type: "dns", Value: "scada.plant.example"}}, 1},
+		{"wildcard covering the SCADA master", []types.Identifier{{Type: "dns", Value: "*.plant.example"}}, 1},
+		{"other line", []types.Identifier{{Type: "dns", Value: "plc1.line4.plant.example"}, {Type: "ip", Value: "10.4.0.7"}}, 2},
+		{"denied IP", []types.Identifier{{Type: "ip", Value: "10.0.0.1"}}, 1},
+		{"outside the allowlist", []types.Identifier{{Type: "dns", Value: "line3.plant.example.com"}}, 1},
+		{"too many", []types.Identifier{{Type: "ip", Value: "10.3.0.1"}, {Type: "ip", Value: "10.3.0.2"}, {Type: "ip", Value: "10.3.0.3"}, {Type: "ip", Value: "10.3.0.4"}}, -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identifiers, problem := Canonicalize(tc.identifiers)
+			if problem != nil {
+				t.Fatalf("Canonicalize: %+v", problem)
+			}
+			problem = p.Check(identifiers, line3)
+			switch {
+			case tc.rejected == 0 && problem != nil:
+				t.Errorf("Check rejected allowed identifiers: %+v", problem)
+			case tc.rejected != 0 && problem == nil:
+				t.Error("Check allowed rejected identifiers")
+			case problem != nil && problem.Type != errRejectedIdentifier:
+				t.Errorf("problem type = %s", problem.Type)
+			case tc.rejected > 0 && len(problem.Subproblems) != tc.rejected:
+				t.Errorf("%d subproblems, want %d: %+v", len(problem.Subproblems), tc.rejected, problem.Subproblems)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules := mustRules(t, "Bücher.Example", "10.3.0.7/16", "::1")
+	if rules.Domains[0] != "xn--bcher-kva.example" || rules.IPs[0].String() != "10.3.0.0/16" || rules.IPs[1].String() != "::1/128" {
+		t.Errorf("ParseRules = %+v", rules)
+	}
+	if _, err := ParseRules([]string{"*.plant.example"}); err == nil {
+		t.Error("ParseRules accepted a wildcard")
+	}
+}
@@ -0,0 +1,295 @@
+// Package ratelimit limits how fast clients can create accounts, orders and
+// certificates. Every limit is a token bucket kept in the store, so replicas
+// sharing a PostgreSQL database share their limits as well.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+const errRateLimited = "urn:ietf:params:acme:error:rateLimited"
+
+// DefaultDocumentation is linked from rateLimited problems unless another
+// page is configured
+const DefaultDocumentation = "https://github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme#rate-limits"
+
+// Limit allows Count events per Period. A full bucket allows a burst of
+// Count events and is refilled evenly over the period.
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// enabled reports whether the limit applies; a zero Limit disables it
+func (l Limit) enabled() bool {
+	return l.Count > 0 && l.Period > 0
+}
+
+// interval is the time it takes to refill one token
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Count)
+}
+
+// Limits are the limits enforced by a Limiter
+type Limits struct {
+	NewAccountsPerIP        Limit
+	NewOrdersPerAccount     Limit
+	FinalizationsPerAccount Limit
+	// FailedValidations limits failed validations per account and
+	// identifier
+	FailedValidations Limit
+	// CertificatesPerIdentifier counts the certificates ordered for an
+	// identifier by all accounts
+	CertificatesPerIdentifier Limit
+}
+
+// DefaultLimits are used for limits that are not configured
+var DefaultLimits = Limits{
+	NewAccountsPerIP:          Limit{Count: 50, Period: 3 * time.Hour},
+	NewOrdersPerAccount:       Limit{Count: 300, Period: 3 * time.Hour},
+	FinalizationsPerAccount:   Limit{Count: 300, Period: 3 * time.Hour},
+	FailedValidations:         Limit{Count: 5, Period: time.Hour},
+	CertificatesPerIdentifier: Limit{Count: 50, Period: 7 * 24 * time.Hour},
+}
+
+// bucket is the token bucket of one limit for one client
+type bucket struct {
+	// name identifies the limit in problems and metrics
+	name  string
+	key   string
+	limit Limit
+}
+
+// Exceeded is returned when a request hits a rate limit
+type Exceeded struct {
+	// Name identifies the limit, e.g. "new_orders_per_account"
+	Name string
+	// Limit describes the limit that was hit, e.g. "300
+	// new_orders_per_account per 3h"
+	Limit string
+	// RetryAfter is how long the client has to wait for the next token
+	RetryAfter time.Duration
+	// Documentation is a page describing the limits
+	Documentation string
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded", e.Limit)
+}
+
+// RetryAfterSeconds returns RetryAfter for the Retry-After header, rounded
+// up to full seconds
+func (e *Exceeded) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Problem returns the rateLimited problem reported to the client
+func (e *Exceeded) Problem() *types.Problem {
+	return &types.Problem{
+		Type:   errRateLimited,
+		Detail: fmt.Sprintf("Rate limit of %s exceeded, retry after %ds", e.Limit, e.RetryAfterSeconds()),
+		Status: http.StatusTooManyRequests,
+	}
+}
+
+// Limiter enforces rate limits on the ACME endpoints. A nil Limiter allows
+// everything.
+type Limiter struct {
+	store         storage.RateLimitStore
+	limits        Limits
+	documentation string
+	now           func() time.Time
+}
+
+// New creates a limiter keeping its buckets in store
+func New(store storage.RateLimitStore, limits Limits, documentation string) *Limiter {
+	return &Limiter{
+		store:         store,
+		limits:        limits,
+		documentation: documentation,
+		now:           time.Now,
+	}
+}
+
+// NewAccount takes a new-account token of the client address. IPv6 clients
+// are limited per /64 network since they usually control all of it.
+func (l *Limiter) NewAccount(ctx context.Context, remoteAddr string) error {
+	if l == nil {
+		return nil
+	}
+	return l.spend(ctx, bucket{"new_accounts_per_ip", "new-account:" + clientNetwork(remoteAddr), l.limits.NewAccountsPerIP})
+}
+
+// NewOrder takes a new-order token of the account. Orders for identifiers
+// that cannot be certified or validated any more fail early without taking
+// a token.
+func (l *Limiter) NewOrder(ctx context.Context, accountID string, identifiers []types.Identifier) error {
+	if l == nil {
+		return nil
+	}
+	for _, id := range identifiers {
+		if err := l.check(ctx, l.certificates(id)); err != nil {
+			return err
+		}
+		if err := l.check(ctx, l.failedValidations(accountID, id)); err != nil {
+			return err
+		}
+	}
+	return l.spend(ctx, bucket{"new_orders_per_account", "new-order:" + accountID, l.limits.NewOrdersPerAccount})
+}
+
+// Finalize takes a finalization token of the account and a certificate
+// token of every identifier of the order. The tokens are taken together,
+// so a rejected finalization takes none of them.
+func (l *Limiter) Finalize(ctx context.Context, accountID string, identifiers []types.Identifier) error {
+	if l == nil {
+		return nil
+	}
+	buckets := []bucket{{"finalizations_per_account", "finalize:" + accountID, l.limits.FinalizationsPerAccount}}
+	for _, id := range identifiers {
+		buckets = append(buckets, l.certificates(id))
+	}
+	return l.spendAll(ctx, buckets)
+}
+
+// CheckValidation fails once the account has failed to validate the
+// identifier too often
+func (l *Limiter) CheckValidation(ctx context.Context, accountID string, id types.Identifier) error {
+	if l == nil {
+		return nil
+	}
+	return l.check(ctx, l.failedValidations(accountID, id))
+}
+
+// ValidationFailed takes a failed-validation token of the account and
+// identifier. An empty bucket is not an error here; the next validation
+// fails CheckValidation.
+func (l *Limiter) ValidationFailed(ctx context.Context, accountID string, id types.Identifier) error {
+	if l == nil {
+		return nil
+	}
+	var exceeded *Exceeded
+	if err := l.spend(ctx, l.failedValidations(accountID, id)); err != nil && !errors.As(err, &exceeded) {
+		return err
+	}
+	return nil
+}
+
+func (l *Limiter) certificates(id types.Identifier) bucket {
+	return bucket{"certificates_per_identifier", "certificates:" + id.Type + ":" + id.Value, l.limits.CertificatesPerIdentifier}
+}
+
+func (l *Limiter) failedValidations(accountID string, id types.Identifier) bucket {
+	return bucket{"failed_validations", "failed-validation:" + accountID + ":" + id.Type + ":" + id.Value, l.limits.FailedValidations}
+}
+
+// spend takes a token from the bucket
+func (l *Limiter) spend(ctx context.Context, b bucket) error {
+	if !b.limit.enabled() {
+		return nil
+	}
+	now := l.now()
+	fullAt, ok, err := l.store.SpendRateLimit(ctx, b.key, now, b.limit.interval(), b.limit.Period)
+	if err != nil {
+		return fmt.Errorf("error spending rate limit %s: %w", b.name, err)
+	}
+	if !ok {
+		return l.exceeded(b, fullAt, now)
+	}
+	return nil
+}
+
+// spendAll takes a token from every bucket, or from none if one of them is
+// empty
+func (l *Limiter) spendAll(ctx context.Context, buckets []bucket) error {
+	var enabled []bucket
+	var spends []types.RateLimitSpend
+	for _, b := range buckets {
+		if b.limit.enabled() {
+			enabled = append(enabled, b)
+			spends = append(spends, types.RateLimitSpend{Key: b.key, Interval: b.limit.interval(), Period: b.limit.Period})
+		}
+	}
+	if len(spends) == 0 {
+		return nil
+	}
+	now := l.now()
+	empty, fullAt, err := l.store.SpendRateLimits(ctx, spends, now)
+	if err != nil {
+		return fmt.Errorf("error spending rate limits: %w", err)
+	}
+	if empty >= 0 {
+		return l.exceeded(enabled[empty], fullAt, now)
+	}
+	return nil
+}
+
+// check fails if the bucket has no token left without taking one
+func (l *Limiter) check(ctx context.Context, b bucket) error {
+	if !b.limit.enabled() {
+		return nil
+	}
+	now := l.now()
+	fullAt, err := l.store.GetRateLimit(ctx, b.key)
+	if err != nil {
+		return fmt.Errorf("error checking rate limit %s: %w", b.name, err)
+	}
+	if fullAt.Add(b.limit.interval()).Sub(now) > b.limit.Period {
+		return l.exceeded(b, fullAt, now)
+	}
+	return nil
+}
+
+// exceeded reports an empty bucket. The next token is available once the
+// bucket is no more than a period minus one interval from being full.
+func (l *Limiter) exceeded(b bucket, fullAt, now time.Time) *Exceeded {
+	return &Exceeded{
+		Name:          b.name,
+		Limit:         fmt.Sprintf("%d %s per %s", b.limit.Count, b.name, formatPeriod(b.limit.Period)),
+		RetryAfter:    fullAt.Add(b.limit.interval()).Sub(now.Add(b.limit.Period)),
+		Documentation: l.documentation,
+	}
+}
+
+// formatPeriod formats a duration without trailing zero units, e.g. "3h"
+// instead of "3h0m0s"
+func formatPeriod(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// clientNetwork returns the address of a client, or its /64 network for
+// IPv6 clients
+func clientNetwork(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	l := New(memory.New(), Limits{
+		NewOrdersPerAccount:       Limit{Count: 2, Period: time.Hour},
+		CertificatesPerIdentifier: Limit{Count: 1, Period: 7 * 24 * time.Hour},
+	}, DefaultDocumentation)
+	l.now = func() time.Time { return now }
+	plc := []types.Identifier{{Type: "dns", Value: "plc1.line3.plant.example"}}
+
+	for i := 0; i < 2; i++ {
+		if err := l.NewOrder(ctx, "acct_1", plc); err != nil {
+			t.Fatalf("NewOrder #%d: %v", i+1, err)
+		}
+	}
+	var exceeded *Exceeded
+	if err := l.NewOrder(ctx, "acct_1", plc); !errors.As(err, &exceeded) {
+		t.Fatalf("third NewOrder = %v, want Exceeded", err)
+	}
+	if exceeded.RetryAfterSeconds() != 1800 || exceeded.Limit != "2 new_orders_per_account per 1h" {
+		t.Errorf("Exceeded = %+v", exceeded)
+	}
+	if problem := exceeded.Problem(); problem.Type != errRateLimited || problem.Status != 429 {
+		t.Errorf("Problem = %+v", problem)
+	}
+
+	// Other accounts have their own bucket, which refills over time
+	if err := l.NewOrder(ctx, "acct_2", plc); err != nil {
+		t.Errorf("NewOrder of another account: %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := l.NewOrder(ctx, "acct_1", plc); err != nil {
+		t.Errorf("NewOrder after a refill: %v", err)
+	}
+
+	// Certificates count per identifier for all accounts
+	if err := l.Finalize(ctx, "acct_1", plc); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	if err := l.NewOrder(ctx, "acct_2", plc); !errors.As(err, &exceeded) || exceeded.RetryAfter != 7*24*time.Hour {
+		t.Errorf("NewOrder after the certificate limit = %v", err)
+	}
+
+	var nilLimiter *Limiter
+	if err := nilLimiter.NewAccount(ctx, "192.0.2.1:443"); err != nil {
+		t.Errorf("nil Limiter: %v", err)
+	}
+}
+
+func TestFinalizeAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	l := New(memory.New(), Limits{
+		FinalizationsPerAccount:   Limit{Count: 2, Period: time.Hour},
+		CertificatesPerIdentifier: Limit{Count: 1, Period: 7 * 24 * time.Hour},
+	}, DefaultDocumentation)
+	plc1 := types.Identifier{Type: "dns", Value: "plc1.line3.plant.example"}
+	plc2 := types.Identifier{Type: "dns", Value: "plc2.line3.plant.example"}
+	plc3 := types.Identifier{Type: "dns", Value: "plc3.line3.plant.example"}
+
+	if err := l.Finalize(ctx, "acct_1", []types.Identifier{plc1}); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	// plc1 has no certificate token left, so neither the finalization nor
+	// the plc2 token is taken
+	var exceeded *Exceeded
+	if err := l.Finalize(ctx, "acct_1", []types.Identifier{plc2, plc1}); !errors.As(err, &exceeded) || exceeded.Name != "certificates_per_identifier" {
+		t.Fatalf("Finalize with an exhausted identifier = %v, want certificates_per_identifier exceeded", err)
+	}
+	if err := l.Finalize(ctx, "acct_1", []types.Identifier{plc2}); err != nil {
+		t.Fatalf("Finalize after a rejected finalization: %v", err)
+	}
+	if err := l.Finalize(ctx, "acct_1", []types.Identifier{plc3}); !errors.As(err, &exceeded) || exceeded.Name != "finalizations_per_account" {
+		t.Errorf("third Finalize = %v, want finalizations_per_account exceeded", err)
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:443":           "192.0.2.1",
+		"[2001:db8:1:2:3::4]:443": "2001:db8:1:2::/64",
+		"[::ffff:192.0.2.1]:443":  "192.0.2.1",
+		"2001:db8:1:2:ffff::1":    "2001:db8:1:2::/64",
+		"[fe80::1%eth0]:443":      "fe80::/64",
+	} {
+		if got := clientNetwork(addr); got != want {
+			t.Errorf("clientNetwork(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
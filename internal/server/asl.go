@@ -0,0 +1,142 @@
+//go:build !noasl
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	asl "github.com/Laboratory-for-Safe-and-Secure-Systems/go-asl"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/go-asl/listener"
+)
+
+// InitASL initializes the ASL library. It must be called once before the
+// first ASL listener is created.
+func InitASL(loggingEnabled bool, logLevel int) error {
+	return asl.ASLinit(&asl.ASLConfig{
+		LoggingEnabled: loggingEnabled,
+		LogLevel:       int32(logLevel),
+	})
+}
+
+// endpointConfig converts the listener configuration for ASL
+func endpointConfig(config *Config) *asl.EndpointConfig {
+	endpointConfig := &asl.EndpointConfig{
+		MutualAuthentication: config.ClientAuth == tls.RequireAndVerifyClientCert,
+		NoEncryption:         config.ASL.NoEncryption,
+		ASLKeyExchangeMethod: asl.ASLKeyExchangeMethod(config.ASL.KeyExchangeMethod),
+		PreSharedKey: asl.PreSharedKey{
+			Enable: false,
+		},
+		DeviceCertificateChain: asl.DeviceCertificateChain{Path: config.Certificates},
+		PrivateKey: asl.PrivateKey{
+			Path: config.PrivateKey,
+		},
+		KeylogFile: config.ASL.KeylogFile,
+		PKCS11: asl.PKCS11ASL{
+			Path: config.ASL.PKCS11Path,
+			Pin:  config.ASL.PKCS11Pin,
+		},
+	}
+	if len(config.RootCertificates) > 0 {
+		endpointConfig.RootCertificate = asl.RootCertificate{Path: config.RootCertificates[0]}
+	}
+	return endpointConfig
+}
+
+// newEndpoint sets up an ASL server endpoint, reading the certificate chain
+// and private key named in config
+func newEndpoint(config *Config) (*asl.ASLEndpoint, error) {
+	endpoint := asl.ASLsetupServerEndpoint(endpointConfig(config))
+	if endpoint == nil {
+		return nil, fmt.Errorf("failed to setup ASL endpoint")
+	}
+	return endpoint, nil
+}
+
+// aslConnState returns the TLS state of an ASL connection
+func aslConnState(c net.Conn) (*tls.ConnectionState, bool) {
+	if aslConn, ok := c.(*listener.ASLConn); ok {
+		return aslConn.TLSState, true
+	}
+	return nil, false
+}
+
+// aslTransport accepts connections on one TCP socket through an ASL
+// endpoint that can be replaced at runtime. Connections keep the endpoint
+// they were accepted with, so replaced endpoints are only freed on Close.
+type aslTransport struct {
+	mu      sync.Mutex
+	current *listener.ASLListener
+	retired []*asl.ASLEndpoint
+}
+
+func newASLTransport(netListener net.Listener, config *Config) (*aslTransport, error) {
+	// Setup ASL Endpoint
+	endpoint, err := newEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create ASL Listener
+	return &aslTransport{current: &listener.ASLListener{
+		Logger:   config.Logger,
+		Endpoint: endpoint,
+		Listener: netListener,
+	}}, nil
+}
+
+func (t *aslTransport) active() *listener.ASLListener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+// Accept waits on the shared TCP socket. An Accept that is already blocked
+// when the endpoint is swapped completes with the previous endpoint.
+func (t *aslTransport) Accept() (net.Conn, error) {
+	return t.active().Accept()
+}
+
+func (t *aslTransport) Close() error {
+	return t.active().Close()
+}
+
+func (t *aslTransport) Addr() net.Addr {
+	return t.active().Addr()
+}
+
+func (t *aslTransport) prepare(config *Config) (*Reload, error) {
+	endpoint, err := newEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reload{
+		apply:   func() { t.swap(endpoint) },
+		discard: func() { asl.ASLFreeEndpoint(endpoint) },
+	}, nil
+}
+
+// swap makes endpoint the one used for new connections
+func (t *aslTransport) swap(endpoint *asl.ASLEndpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retired = append(t.retired, t.current.Endpoint)
+	t.current = &listener.ASLListener{
+		Logger:   t.current.Logger,
+		Endpoint: endpoint,
+		Listener: t.current.Listener,
+	}
+}
+
+// free releases the current and all replaced endpoints
+func (t *aslTransport) free() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, endpoint := range append(t.retired, t.current.Endpoint) {
+		asl.ASLFreeEndpoint(endpoint)
+	}
+	t.retired = nil
+}
@@ -0,0 +1,24 @@
+//go:build noasl
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+var errNoASL = errors.New("ASL listener unavailable: built with the noasl tag")
+
+// InitASL reports that ASL is not available in this build
+func InitASL(loggingEnabled bool, logLevel int) error {
+	return errNoASL
+}
+
+func newASLTransport(net.Listener, *Config) (transport, error) {
+	return nil, errNoASL
+}
+
+func aslConnState(net.Conn) (*tls.ConnectionState, bool) {
+	return nil, false
+}
@@ -2,12 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/go-asl"
-	"github.com/Laboratory-for-Safe-and-Secure-Systems/go-asl/listener"
 	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
 )
 
@@ -15,20 +15,130 @@ type contextKey string
 
 const TLSStateKey contextKey = "TLSState"
 
+// ModeKey holds the Mode of the listener a request arrived on
+const ModeKey contextKey = "ListenerMode"
+
+// Mode selects how a listener secures its connections
+type Mode string
+
+const (
+	// ModeASL serves through the KRITIS3M ASL library
+	ModeASL Mode = "asl"
+	// ModeTLS serves through Go's crypto/tls
+	ModeTLS Mode = "tls"
+	// ModeHTTP serves plain HTTP, e.g. for local testing behind a TLS
+	// terminating proxy
+	ModeHTTP Mode = "http"
+)
+
+// Secure reports whether connections of this mode are encrypted
+func (m Mode) Secure() bool {
+	return m == ModeASL || m == ModeTLS
+}
+
+// Config describes a single listener
+type Config struct {
+	Logger  *logger.Logger
+	Address string
+	Mode    Mode // defaults to ModeASL
+
+	// Certificates is the PEM server certificate chain and PrivateKey its
+	// key. Both are unused in ModeHTTP.
+	Certificates string
+	PrivateKey   string
+	// RootCertificates verify client certificates
+	RootCertificates []string
+	// ClientAuth is the client certificate policy. ASL only distinguishes
+	// tls.RequireAndVerifyClientCert from no client authentication.
+	ClientAuth tls.ClientAuthType
+
+	// ASL holds the settings specific to ModeASL
+	ASL ASLOptions
+}
+
+// ASLOptions are the ASL endpoint settings beyond certificates and keys
+type ASLOptions struct {
+	NoEncryption      bool
+	KeyExchangeMethod int
+	KeylogFile        string
+	PKCS11Path        string
+	PKCS11Pin         string
+}
+
+func (c *Config) mode() Mode {
+	if c.Mode == "" {
+		return ModeASL
+	}
+	return c.Mode
+}
+
+// transport accepts the connections of one listener mode
+type transport interface {
+	net.Listener
+
+	// prepare loads new credentials from config without using them yet
+	prepare(config *Config) (*Reload, error)
+	// free releases the resources held for the credentials
+	free()
+}
+
+// Reload holds credentials loaded by PrepareReload until they are applied
+// or discarded
+type Reload struct {
+	apply   func()
+	discard func()
+}
+
+// Apply puts the prepared credentials in use for new connections
+func (r *Reload) Apply() {
+	if r.apply != nil {
+		r.apply()
+	}
+}
+
+// Discard releases the prepared credentials without using them
+func (r *Reload) Discard() {
+	if r.discard != nil {
+		r.discard()
+	}
+}
+
 type Server struct {
 	// Get Logger from context
-	logger      *logger.Logger
-	aslListener *listener.ASLListener
+	logger   *logger.Logger
+	mode     Mode
+	listener transport
 
 	*http.Server
 }
 
-func ASLServer(config *ASLServerConfig, handler http.Handler, ctx context.Context) (*Server, error) {
-	// Create ASL Listener
+// New creates a server listening on config.Address in config.Mode
+func New(config *Config, handler http.Handler, ctx context.Context) (*Server, error) {
+	if config == nil {
+		return nil, fmt.Errorf("server config is required")
+	}
 	logger := logger.GetLogger(ctx)
+	mode := config.mode()
 
-	aslListener, err := NewASLListener(config)
+	netListener, err := net.Listen("tcp", config.Address)
 	if err != nil {
+		return nil, fmt.Errorf("failed to create TCP listener: %v", err)
+	}
+
+	var t transport
+	switch mode {
+	case ModeASL:
+		t, err = newASLTransport(netListener, config)
+	case ModeTLS:
+		t, err = newTLSTransport(netListener, config)
+		handler = withTLSState(handler)
+	case ModeHTTP:
+		t = plainTransport{netListener}
+	default:
+		err = fmt.Errorf("unknown listener mode %q", mode)
+	}
+	if err != nil {
+		netListener.Close()
 		return nil, err
 	}
 
@@ -37,24 +147,40 @@ func ASLServer(config *ASLServerConfig, handler http.Handler, ctx context.Contex
 		Addr:     config.Address,
 		ErrorLog: log.New(logger, "", 0),
 		Handler:  handler,
-		// Add custom connection context to access ASL state
+		// Add custom connection context to access the listener mode and
+		// the ASL state
 		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
-			if aslConn, ok := c.(*listener.ASLConn); ok {
-				return context.WithValue(ctx, TLSStateKey, aslConn.TLSState)
+			ctx = context.WithValue(ctx, ModeKey, mode)
+			if state, ok := aslConnState(c); ok {
+				return context.WithValue(ctx, TLSStateKey, state)
 			}
 			return ctx
 		},
 	}
 
 	return &Server{
-		logger:      logger,
-		Server:      srv,
-		aslListener: aslListener,
+		logger:   logger,
+		mode:     mode,
+		Server:   srv,
+		listener: t,
 	}, nil
 }
 
-func (s *Server) ListenAndServeASL() error {
-	return s.Server.Serve(s.aslListener)
+// Mode returns the listener mode of the server
+func (s *Server) Mode() Mode {
+	return s.mode
+}
+
+// Run serves requests until the server is shut down
+func (s *Server) Run() error {
+	return s.Server.Serve(s.listener)
+}
+
+// PrepareReload loads the certificates and keys named in config, e.g. a
+// renewed server certificate, for a later Apply. Established connections
+// are not interrupted by the reload.
+func (s *Server) PrepareReload(config *Config) (*Reload, error) {
+	return s.listener.prepare(config)
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
@@ -64,8 +190,30 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.logger.Errorf("Error shutting down HTTP server: %v", err)
 	}
 
-	// Shutdown the ASL endpoint
-	asl.ASLFreeEndpoint(s.aslListener.Endpoint)
+	// Release the endpoints and keys
+	s.listener.free()
 
 	return nil
 }
+
+// withTLSState exposes the crypto/tls connection state under TLSStateKey
+// like ASL connections do
+func withTLSState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			r = r.WithContext(context.WithValue(r.Context(), TLSStateKey, r.TLS))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// plainTransport serves unencrypted HTTP
+type plainTransport struct {
+	net.Listener
+}
+
+func (plainTransport) prepare(*Config) (*Reload, error) {
+	return &Reload{}, nil
+}
+
+func (plainTransport) free() {}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+)
+
+// writeCertificate writes a self-signed server certificate for localhost
+func writeCertificate(t *testing.T, cn string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certPath, keyPath
+}
+
+func TestTLSListenerReload(t *testing.T) {
+	log := logger.New(io.Discard)
+	ctx := context.WithValue(context.Background(), types.CtxKeyLogger, log)
+	certPath, keyPath := writeCertificate(t, "first")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode, _ := r.Context().Value(ModeKey).(Mode)
+		_, hasState := r.Context().Value(TLSStateKey).(*tls.ConnectionState)
+		if mode != ModeTLS || !hasState {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	srv, err := New(&Config{Logger: log, Address: "127.0.0.1:0", Mode: ModeTLS, Certificates: certPath, PrivateKey: keyPath}, handler, ctx)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	go srv.Run()
+	t.Cleanup(func() { srv.Shutdown(ctx) })
+
+	// servedCN connects on a fresh connection and returns the subject of the
+	// server certificate
+	servedCN := func() string {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get("https://" + srv.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, want mode and TLS state in the request context", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if cn := servedCN(); cn != "first" {
+		t.Errorf("served %q, want first", cn)
+	}
+
+	if _, err := srv.PrepareReload(&Config{Mode: ModeTLS, Certificates: certPath, PrivateKey: "/nonexistent"}); err == nil {
+		t.Error("PrepareReload accepted a missing key")
+	}
+	certPath, keyPath = writeCertificate(t, "second")
+	reload, err := srv.PrepareReload(&Config{Mode: ModeTLS, Certificates: certPath, PrivateKey: keyPath})
+	if err != nil {
+		t.Fatalf("PrepareReload: %v", err)
+	}
+	if cn := servedCN(); cn != "first" {
+		t.Errorf("served %q before Apply, want first", cn)
+	}
+	reload.Apply()
+	if cn := servedCN(); cn != "second" {
+		t.Errorf("served %q after Apply, want second", cn)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// tlsTransport serves through crypto/tls. Certificates are looked up per
+// handshake, so a reload takes effect for the next connection.
+type tlsTransport struct {
+	net.Listener
+	creds atomic.Pointer[tls.Config]
+}
+
+func newTLSTransport(netListener net.Listener, config *Config) (*tlsTransport, error) {
+	t := &tlsTransport{}
+	creds, err := loadTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	t.creds.Store(creds)
+
+	t.Listener = tls.NewListener(netListener, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.creds.Load(), nil
+		},
+	})
+	return t, nil
+}
+
+func (t *tlsTransport) prepare(config *Config) (*Reload, error) {
+	creds, err := loadTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reload{apply: func() { t.creds.Store(creds) }}, nil
+}
+
+func (t *tlsTransport) free() {}
+
+// loadTLSConfig reads the server certificate, its key and the client roots
+func loadTLSConfig(config *Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.Certificates, config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   config.ClientAuth,
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		pool := x509.NewCertPool()
+		for _, path := range config.RootCertificates {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read client CA: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no PEM certificate in client CA %s", path)
+			}
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
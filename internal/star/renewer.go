@@ -0,0 +1,307 @@
+package star
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/caa"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/webhook"
+)
+
+// DefaultInterval is used when no renewal interval is configured
+const DefaultInterval = time.Minute
+
+// renewalLease is how long a renewer owns a STAR order while it signs the
+// next certificate. Renewers of other replicas skip the order until the
+// lease has run out.
+const renewalLease = 5 * time.Minute
+
+// Config holds the STAR renewer settings
+type Config struct {
+	// Interval between renewal passes; zero selects DefaultInterval
+	Interval time.Duration
+	// Audit records every renewed certificate; nil disables auditing
+	Audit *audit.Log
+	// Webhooks announces every renewed certificate; nil disables webhooks
+	Webhooks *webhook.Notifier
+	// CAA checks the CAA records of dns identifiers before every renewal
+	// for tenants with CAA identities; nil disables CAA checking
+	CAA *caa.Checker
+	// AccountURL returns the account URL CAA accounturi parameters are
+	// compared with. Without it no accounturi parameter matches.
+	AccountURL func(t *tenant.Tenant, accountID string) string
+}
+
+// Renewer periodically reissues the short-lived certificates of active STAR
+// orders from the CSR stored at finalization
+type Renewer struct {
+	store   storage.Store
+	tenants *tenant.Registry
+	config  Config
+	logger  *logger.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRenewer creates a STAR renewer that signs with the CA of the tenant
+// of each order
+func NewRenewer(store storage.Store, tenants *tenant.Registry, cfg Config, log *logger.Logger) *Renewer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+
+	return &Renewer{
+		store:   store,
+		tenants: tenants,
+		config:  cfg,
+		logger:  log,
+	}
+}
+
+// Start runs the renewer in the background until Stop is called or ctx is
+// cancelled
+func (rn *Renewer) Start(ctx context.Context) {
+	ctx, rn.cancel = context.WithCancel(ctx)
+
+	rn.wg.Add(1)
+	go func() {
+		defer rn.wg.Done()
+		rn.run(ctx)
+	}()
+
+	rn.logger.Infow("STAR renewer started", "interval", rn.config.Interval)
+}
+
+// Stop cancels the background renewer and waits for a running pass to finish
+func (rn *Renewer) Stop() {
+	if rn.cancel == nil {
+		return
+	}
+	rn.cancel()
+	rn.wg.Wait()
+	rn.logger.Info("STAR renewer stopped")
+}
+
+func (rn *Renewer) run(ctx context.Context) {
+	ticker := time.NewTicker(rn.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := rn.RenewDue(ctx); err != nil && ctx.Err() == nil {
+			rn.logger.Errorf("STAR renewal pass failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RenewDue issues the next certificate for every active STAR order whose
+// current certificate is due for renewal. It returns the number of issued
+// certificates. Failures for single orders are logged and do not stop the
+// pass.
+func (rn *Renewer) RenewDue(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	orderIDs, err := rn.store.ListActiveStarOrderIDs(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for _, orderID := range orderIDs {
+		if ctx.Err() != nil {
+			return issued, ctx.Err()
+		}
+
+		renewed, err := rn.renewOrder(ctx, orderID, now)
+		if err != nil {
+			rn.logger.Errorw("Failed to renew STAR certificate",
+				"order", orderID,
+				"error", err,
+			)
+			continue
+		}
+		if renewed {
+			issued++
+		}
+	}
+
+	if issued > 0 {
+		rn.logger.Infow("STAR renewal pass completed", "issued", issued)
+	}
+
+	return issued, nil
+}
+
+// renewOrder issues the successor of the latest certificate of an order if
+// it is due. The account, the identifier policy and the CAA records are
+// checked again before every renewal, since they may have changed since the
+// order was validated.
+func (rn *Renewer) renewOrder(ctx context.Context, orderID string, now time.Time) (bool, error) {
+	order, err := rn.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	latest, err := rn.store.GetLatestCertificateByOrder(ctx, order.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get latest certificate: %w", err)
+	}
+
+	if !RenewalDue(order.AutoRenewal, latest.NotAfter.Time, now) {
+		return false, nil
+	}
+
+	notBefore, notAfter, ok := NextValidity(order.AutoRenewal, latest.NotAfter.Time)
+	if !ok {
+		return false, nil
+	}
+
+	account, err := rn.store.GetAccount(ctx, order.AccountID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get account: %w", err)
+	}
+	if account.Status != types.AccountStatusValid {
+		return false, nil
+	}
+
+	t, ok := rn.tenants.Get(order.Tenant)
+	if !ok {
+		return false, fmt.Errorf("unknown tenant %q", order.Tenant)
+	}
+	settings := t.Settings()
+	profile, ok := settings.Profile(order.Profile)
+	if !ok {
+		return false, fmt.Errorf("unknown profile %q", order.Profile)
+	}
+
+	scope, err := policy.ParseRules(account.Scope)
+	if err != nil {
+		return false, fmt.Errorf("invalid account scope: %w", err)
+	}
+	if problem := settings.Policy.Check(order.Identifiers, scope); problem != nil {
+		return false, fmt.Errorf("identifier policy: %s", problem.Detail)
+	}
+	if err := rn.checkCAA(ctx, t, order); err != nil {
+		return false, err
+	}
+
+	csrDER, err := base64.RawURLEncoding.DecodeString(order.CSR)
+	if err != nil {
+		return false, fmt.Errorf("invalid stored CSR encoding: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return false, fmt.Errorf("invalid stored CSR: %w", err)
+	}
+
+	// Only one renewer across all replicas may sign the next certificate
+	claimed, err := rn.store.ClaimStarRenewal(ctx, order.ID, now, now.Add(renewalLease))
+	if err != nil {
+		return false, fmt.Errorf("failed to claim order: %w", err)
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	certPEM, err := pki.IssueCertificate(ctx, settings.CA, profile, csr, notBefore, notAfter, settings.CT)
+	if err != nil {
+		return false, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	cert, err := pki.CertificateRecord(order.ID, certPEM)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	// A renewal that cannot be audited is not stored, so clients never
+	// fetch it; the next pass retries
+	if err := rn.config.Audit.RecordIssuance(ctx, order, cert, true); err != nil {
+		return false, fmt.Errorf("failed to record certificate in the audit log: %w", err)
+	}
+	completed, err := rn.store.CompleteStarRenewal(ctx, cert, latest.NotAfter.Time)
+	if err != nil {
+		return false, fmt.Errorf("failed to store certificate: %w", err)
+	}
+	if !completed {
+		rn.logger.Infow("Order was renewed elsewhere, discarding certificate", "order", order.ID)
+		if err := rn.config.Audit.RecordDiscard(ctx, order, cert, "order renewed elsewhere"); err != nil {
+			rn.logger.Errorf("Failed to record discarded certificate %s in the audit log: %v", cert.ID, err)
+		}
+		return false, nil
+	}
+	metrics.CertificatesIssued.WithLabelValues("star").Inc()
+	if err := rn.config.Webhooks.NotifyIssuance(ctx, order, cert, true); err != nil {
+		rn.logger.Errorf("Failed to queue webhook for certificate %s: %v", cert.ID, err)
+	}
+
+	rn.logger.Infow("STAR certificate renewed",
+		"order", order.ID,
+		"certificate", cert.ID,
+		"serial", cert.Serial,
+		"not_before", notBefore,
+		"not_after", notAfter,
+	)
+
+	return true, nil
+}
+
+// checkCAA checks the CAA records of the dns identifiers of an order for
+// the tenant, with the challenge type each identifier was validated with
+func (rn *Renewer) checkCAA(ctx context.Context, t *tenant.Tenant, order *types.Order) error {
+	identities := t.Settings().CAAIdentities
+	if rn.config.CAA == nil || len(identities) == 0 {
+		return nil
+	}
+	var accountURI string
+	if rn.config.AccountURL != nil {
+		accountURI = rn.config.AccountURL(t, order.AccountID)
+	}
+
+	authzs, err := rn.store.GetAuthorizationsByOrder(ctx, order.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get authorizations: %w", err)
+	}
+	for _, authz := range authzs {
+		if authz.Identifier.Type != "dns" {
+			continue
+		}
+		challenges, err := rn.store.GetChallengesByAuthorization(ctx, authz.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get challenges: %w", err)
+		}
+		var method string
+		for _, c := range challenges {
+			if c.Status == types.ChallengeStatusValid {
+				method = c.Type
+				break
+			}
+		}
+		if problem := rn.config.CAA.Check(ctx, caa.Request{
+			Domain:           authz.Identifier.Value,
+			Identities:       identities,
+			AccountURI:       accountURI,
+			ValidationMethod: method,
+		}); problem != nil {
+			return fmt.Errorf("CAA: %s", problem.Detail)
+		}
+	}
+	return nil
+}
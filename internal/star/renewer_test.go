@@ -0,0 +1,178 @@
+package star
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/audit"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/tenant"
+)
+
+// newDueOrder stores a valid STAR order for plc1.plant.example whose first
+// certificate is due for renewal
+func newDueOrder(t *testing.T, store *memory.Store, id string) *types.Order {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"plc1.plant.example"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := &types.Account{ID: "acct_" + id, Key: json.RawMessage(`{}`), Status: types.AccountStatusValid}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	order := &types.Order{
+		ID:          id,
+		AccountID:   account.ID,
+		Status:      types.OrderStatusPending,
+		ExpiresAt:   types.Time{Time: now.Add(time.Hour)},
+		Identifiers: []types.Identifier{{Type: "dns", Value: "plc1.plant.example"}},
+		AutoRenewal: &types.AutoRenewal{
+			StartDate: types.Time{Time: now},
+			EndDate:   types.Time{Time: now.Add(30 * 24 * time.Hour)},
+			Lifetime:  int64((24 * time.Hour).Seconds()),
+		},
+	}
+	if err := store.CreateOrder(ctx, order, nil); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = types.OrderStatusProcessing
+	order.CSR = base64.RawURLEncoding.EncodeToString(csr)
+	order.UpdatedAt = types.Time{Time: now}
+	if err := store.UpdateOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	first := &types.Certificate{ID: "cert_" + id, OrderID: id, NotBefore: types.Time{Time: now}, NotAfter: types.Time{Time: now.Add(time.Minute)}}
+	if completed, err := store.CompleteOrderIssuance(ctx, order, first); err != nil || !completed {
+		t.Fatalf("CompleteOrderIssuance = %v, %v", completed, err)
+	}
+	return order
+}
+
+// latestCertificate returns the ID of the latest certificate of an order
+func latestCertificate(t *testing.T, store *memory.Store, orderID string) string {
+	t.Helper()
+	cert, err := store.GetLatestCertificateByOrder(context.Background(), orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.ID
+}
+
+func TestRenewDue(t *testing.T) {
+	ctx := context.Background()
+	settings := &tenant.Settings{}
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.New()
+	renewer := NewRenewer(store, tenants, Config{}, logger.New(io.Discard))
+
+	// A due order is renewed once
+	newDueOrder(t, store, "order_due")
+	if issued, err := renewer.RenewDue(ctx); err != nil || issued != 1 {
+		t.Fatalf("RenewDue = %d, %v, want 1", issued, err)
+	}
+	if latestCertificate(t, store, "order_due") == "cert_order_due" {
+		t.Error("the renewed certificate was not stored")
+	}
+	if issued, err := renewer.RenewDue(ctx); err != nil || issued != 0 {
+		t.Errorf("RenewDue of a renewed order = %d, %v, want 0", issued, err)
+	}
+
+	// Another replica holds the lease of the order
+	newDueOrder(t, store, "order_claimed")
+	if claimed, err := store.ClaimStarRenewal(ctx, "order_claimed", time.Now(), time.Now().Add(time.Minute)); !claimed || err != nil {
+		t.Fatalf("ClaimStarRenewal = %v, %v", claimed, err)
+	}
+	if issued, _ := renewer.RenewDue(ctx); issued != 0 || latestCertificate(t, store, "order_claimed") != "cert_order_claimed" {
+		t.Errorf("RenewDue of an order claimed elsewhere issued %d certificate(s)", issued)
+	}
+
+	// The account was deactivated after the order was finalized
+	newDueOrder(t, store, "order_deactivated")
+	account, _ := store.GetAccount(ctx, "acct_order_deactivated")
+	account.Status = types.AccountStatusDeactivated
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identifier was denied after the order was finalized
+	newDueOrder(t, store, "order_denied")
+	deny, err := policy.ParseRules([]string{"plant.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants.All()[0].SetSettings(&tenant.Settings{Policy: &policy.Policy{Deny: deny}})
+
+	renewer.RenewDue(ctx)
+	for _, id := range []string{"order_deactivated", "order_denied"} {
+		if latest := latestCertificate(t, store, id); latest != "cert_"+id {
+			t.Errorf("%s was renewed with %s", id, latest)
+		}
+	}
+}
+
+// renewedElsewhere loses every renewal to another replica
+type renewedElsewhere struct {
+	*memory.Store
+}
+
+func (renewedElsewhere) CompleteStarRenewal(ctx context.Context, cert *types.Certificate, previousNotAfter time.Time) (bool, error) {
+	return false, nil
+}
+
+func TestRenewDiscarded(t *testing.T) {
+	ctx := context.Background()
+	tenants, err := tenant.NewRegistry(tenant.New(tenant.DefaultName, "", &tenant.Settings{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, auditStore := memory.New(), memory.New()
+	auditLog, err := audit.Open(audit.Config{Store: auditStore})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewer := NewRenewer(renewedElsewhere{store}, tenants, Config{Audit: auditLog}, logger.New(io.Discard))
+
+	// The certificate recorded before the renewal was lost is marked as
+	// discarded
+	newDueOrder(t, store, "order_lost")
+	if issued, err := renewer.RenewDue(ctx); err != nil || issued != 0 {
+		t.Fatalf("RenewDue = %d, %v, want 0", issued, err)
+	}
+	records, err := auditStore.ListAuditRecords(ctx, 0, 10)
+	if err != nil || len(records) != 2 || records[0].Event != audit.EventCertificateIssued || records[1].Event != audit.EventCertificateDiscarded {
+		t.Fatalf("audit records = %+v, %v, want an issued and a discarded entry", records, err)
+	}
+	var issued, discarded struct{ Data struct{ Certificate string } }
+	if err := json.Unmarshal(records[0].Entry, &issued); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(records[1].Entry, &discarded); err != nil {
+		t.Fatal(err)
+	}
+	if discarded.Data.Certificate == "" || discarded.Data.Certificate != issued.Data.Certificate {
+		t.Errorf("discarded certificate %q, want the issued %q", discarded.Data.Certificate, issued.Data.Certificate)
+	}
+}
@@ -0,0 +1,110 @@
+package star
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// Limits bounds the auto-renewal parameters clients may request
+type Limits struct {
+	MinLifetime         time.Duration
+	MaxDuration         time.Duration
+	AllowCertificateGet bool
+}
+
+// DefaultLimits are the STAR limits advertised in the directory and enforced
+// at new-order
+var DefaultLimits = Limits{
+	MinLifetime:         time.Hour,
+	MaxDuration:         365 * 24 * time.Hour,
+	AllowCertificateGet: true,
+}
+
+// Metadata returns the directory representation of the limits
+func (l Limits) Metadata() *types.AutoRenewalMetadata {
+	return &types.AutoRenewalMetadata{
+		MinLifetime:         int64(l.MinLifetime.Seconds()),
+		MaxDuration:         int64(l.MaxDuration.Seconds()),
+		AllowCertificateGet: l.AllowCertificateGet,
+	}
+}
+
+// Validate checks an auto-renewal request against the limits
+func (l Limits) Validate(ar *types.AutoRenewal, now time.Time) *types.Problem {
+	lifetime := time.Duration(ar.Lifetime) * time.Second
+	adjust := time.Duration(ar.LifetimeAdjust) * time.Second
+
+	switch {
+	case ar.StartDate.IsZero() || ar.EndDate.IsZero():
+		return malformed("auto-renewal requires start-date and end-date")
+	case !ar.EndDate.After(ar.StartDate.Time):
+		return malformed("auto-renewal end-date must be after start-date")
+	case !ar.EndDate.After(now):
+		return malformed("auto-renewal end-date is in the past")
+	case ar.EndDate.Sub(ar.StartDate.Time) > l.MaxDuration:
+		return malformed(fmt.Sprintf("auto-renewal duration exceeds the maximum of %d seconds", int64(l.MaxDuration.Seconds())))
+	case lifetime < l.MinLifetime:
+		return malformed(fmt.Sprintf("auto-renewal lifetime is below the minimum of %d seconds", int64(l.MinLifetime.Seconds())))
+	case adjust < 0 || adjust >= lifetime:
+		return malformed("auto-renewal lifetime-adjust must be non-negative and shorter than lifetime")
+	case ar.AllowCertificateGet && !l.AllowCertificateGet:
+		return malformed("auto-renewal allow-certificate-get is not supported by this server")
+	}
+
+	return nil
+}
+
+// FirstValidity returns the validity period of the first certificate of a
+// STAR order finalized at the given time
+func FirstValidity(ar *types.AutoRenewal, now time.Time) (time.Time, time.Time) {
+	start := ar.StartDate.Time
+	if now.After(start) {
+		start = now
+	}
+	return validity(ar, start)
+}
+
+// NextValidity returns the validity period of the certificate following one
+// that expires at previousNotAfter. It returns false once the end-date of
+// the order has been reached.
+func NextValidity(ar *types.AutoRenewal, previousNotAfter time.Time) (time.Time, time.Time, bool) {
+	if !previousNotAfter.Before(ar.EndDate.Time) {
+		return time.Time{}, time.Time{}, false
+	}
+	notBefore, notAfter := validity(ar, previousNotAfter)
+	return notBefore, notAfter, true
+}
+
+// RenewalDue reports whether the successor of a certificate expiring at
+// notAfter should be issued now. Successors are issued once half of the
+// certificate lifetime remains.
+func RenewalDue(ar *types.AutoRenewal, notAfter time.Time, now time.Time) bool {
+	lead := time.Duration(ar.Lifetime) * time.Second / 2
+	return !now.Before(notAfter.Add(-lead))
+}
+
+// validity computes a certificate validity period starting at start. The
+// lifetime-adjust pre-dates the certificate so that consecutive certificates
+// overlap, and no certificate outlives the end-date of the order.
+func validity(ar *types.AutoRenewal, start time.Time) (time.Time, time.Time) {
+	lifetime := time.Duration(ar.Lifetime) * time.Second
+	adjust := time.Duration(ar.LifetimeAdjust) * time.Second
+
+	notBefore := start.Add(-adjust)
+	notAfter := start.Add(lifetime)
+	if notAfter.After(ar.EndDate.Time) {
+		notAfter = ar.EndDate.Time
+	}
+	return notBefore, notAfter
+}
+
+func malformed(detail string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:malformed",
+		Detail: detail,
+		Status: http.StatusBadRequest,
+	}
+}
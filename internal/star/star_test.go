@@ -0,0 +1,75 @@
+package star
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := types.AutoRenewal{
+		StartDate: types.Time{Time: now},
+		EndDate:   types.Time{Time: now.Add(30 * 24 * time.Hour)},
+		Lifetime:  int64((4 * 24 * time.Hour).Seconds()),
+	}
+
+	if problem := DefaultLimits.Validate(&valid, now); problem != nil {
+		t.Fatalf("Expected valid auto-renewal, got %s", problem.Detail)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ar *types.AutoRenewal)
+	}{
+		{"end before start", func(ar *types.AutoRenewal) { ar.EndDate = ar.StartDate }},
+		{"lifetime too short", func(ar *types.AutoRenewal) { ar.Lifetime = 60 }},
+		{"duration too long", func(ar *types.AutoRenewal) { ar.EndDate.Time = now.Add(2 * 365 * 24 * time.Hour) }},
+		{"adjust exceeds lifetime", func(ar *types.AutoRenewal) { ar.LifetimeAdjust = ar.Lifetime }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar := valid
+			tt.modify(&ar)
+			if problem := DefaultLimits.Validate(&ar, now); problem == nil {
+				t.Error("Expected validation to fail")
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ar := &types.AutoRenewal{
+		StartDate:      types.Time{Time: start},
+		EndDate:        types.Time{Time: start.Add(10 * 24 * time.Hour)},
+		Lifetime:       int64((4 * 24 * time.Hour).Seconds()),
+		LifetimeAdjust: int64(time.Hour.Seconds()),
+	}
+
+	notBefore, notAfter := FirstValidity(ar, start.Add(-time.Hour))
+	if want := start.Add(-time.Hour); !notBefore.Equal(want) {
+		t.Errorf("Want first notBefore %v, got %v", want, notBefore)
+	}
+	if want := start.Add(4 * 24 * time.Hour); !notAfter.Equal(want) {
+		t.Errorf("Want first notAfter %v, got %v", want, notAfter)
+	}
+
+	if RenewalDue(ar, notAfter, start.Add(24*time.Hour)) {
+		t.Error("Renewal should not be due after one day")
+	}
+	if !RenewalDue(ar, notAfter, start.Add(2*24*time.Hour)) {
+		t.Error("Renewal should be due at half the lifetime")
+	}
+
+	// Certificates never outlive the end-date
+	_, notAfter, ok := NextValidity(ar, start.Add(8*24*time.Hour))
+	if !ok || !notAfter.Equal(ar.EndDate.Time) {
+		t.Errorf("Want last notAfter capped at %v, got %v", ar.EndDate.Time, notAfter)
+	}
+	if _, _, ok := NextValidity(ar, ar.EndDate.Time); ok {
+		t.Error("Expected no certificate after the end-date")
+	}
+}
@@ -0,0 +1,280 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// page applies the limit and offset of filter to n records and returns the
+// bounds of the selected range
+func page(n int, filter types.ListFilter) (int, int) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = types.DefaultListLimit
+	}
+	start := min(max(filter.Offset, 0), n)
+	return start, min(start+limit, n)
+}
+
+// ListAccounts returns accounts matching filter, including deactivated ones,
+// newest first
+func (s *Store) ListAccounts(ctx context.Context, filter types.ListFilter) ([]*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var accounts []*types.Account
+	for _, account := range s.accounts {
+		if filter.AccountID != "" && account.ID != filter.AccountID ||
+			filter.Status != "" && string(account.Status) != filter.Status ||
+			filter.Query != "" && !containsFold(strings.Join(account.Contact, " "), filter.Query) {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].CreatedAt != accounts[j].CreatedAt {
+			return accounts[i].CreatedAt > accounts[j].CreatedAt
+		}
+		return accounts[i].ID < accounts[j].ID
+	})
+
+	start, end := page(len(accounts), filter)
+	result := make([]*types.Account, 0, end-start)
+	for _, account := range accounts[start:end] {
+		result = append(result, copyAccount(account))
+	}
+	return result, nil
+}
+
+// ListOrderIDs returns the IDs of orders matching filter, newest first
+func (s *Store) ListOrderIDs(ctx context.Context, filter types.ListFilter) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []*types.Order
+	for _, order := range s.orders {
+		if filter.AccountID != "" && order.AccountID != filter.AccountID ||
+			filter.Status != "" && string(order.Status) != filter.Status ||
+			filter.Query != "" && !matchesIdentifier(order.Identifiers, filter.Query) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt.Time) {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt.Time)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+
+	start, end := page(len(orders), filter)
+	ids := make([]string, 0, end-start)
+	for _, order := range orders[start:end] {
+		ids = append(ids, order.ID)
+	}
+	return ids, nil
+}
+
+// ListCertificates returns certificates matching filter, newest first. The
+// status filter accepts "valid", "expired" and "revoked".
+func (s *Store) ListCertificates(ctx context.Context, filter types.ListFilter) ([]*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var certs []*certificate
+	for _, cert := range s.certs {
+		if filter.AccountID != "" {
+			order, ok := s.orders[cert.OrderID]
+			if !ok || order.AccountID != filter.AccountID {
+				continue
+			}
+		}
+		if filter.Serial != "" && cert.Serial != filter.Serial {
+			continue
+		}
+		switch filter.Status {
+		case "":
+		case "revoked":
+			if !cert.Revoked {
+				continue
+			}
+		case "valid":
+			if cert.Revoked || !cert.NotAfter.After(now) {
+				continue
+			}
+		case "expired":
+			if cert.Revoked || cert.NotAfter.After(now) {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("unknown certificate status %q", filter.Status)
+		}
+		certs = append(certs, cert)
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		if !certs[i].CreatedAt.Equal(certs[j].CreatedAt.Time) {
+			return certs[i].CreatedAt.After(certs[j].CreatedAt.Time)
+		}
+		return certs[i].ID < certs[j].ID
+	})
+
+	start, end := page(len(certs), filter)
+	result := make([]*types.Certificate, 0, end-start)
+	for _, cert := range certs[start:end] {
+		c := cert.Certificate
+		result = append(result, &c)
+	}
+	return result, nil
+}
+
+// ListPendingChallenges returns challenges that are pending or being
+// validated, in the creation order of their authorizations
+func (s *Store) ListPendingChallenges(ctx context.Context, filter types.ListFilter) ([]types.Challenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var challenges []types.Challenge
+	for _, authzID := range s.authzOrder {
+		if filter.AccountID != "" {
+			order, ok := s.orders[s.authzs[authzID].OrderID]
+			if !ok || order.AccountID != filter.AccountID {
+				continue
+			}
+		}
+		for _, token := range s.challenges[authzID] {
+			challenge := s.challengeTokens[token]
+			if challenge.Status == types.ChallengeStatusPending || challenge.Status == types.ChallengeStatusProcessing {
+				challenges = append(challenges, *copyChallenge(challenge))
+			}
+		}
+	}
+
+	start, end := page(len(challenges), filter)
+	return challenges[start:end], nil
+}
+
+// RevokeCertificate marks a certificate as revoked. It fails with an
+// alreadyRevoked problem if the certificate has been revoked before.
+func (s *Store) RevokeCertificate(ctx context.Context, id string, reason string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cert, ok := s.certs[id]
+	if !ok {
+		return certificateNotFound(fmt.Sprintf("certificate %s does not exist", id))
+	}
+	if cert.Revoked {
+		return &types.Problem{
+			Type:   "urn:ietf:params:acme:error:alreadyRevoked",
+			Detail: fmt.Sprintf("certificate %s is already revoked", id),
+			Status: http.StatusBadRequest,
+		}
+	}
+	cert.Revoked = true
+	cert.RevocationReason = reason
+	cert.RevokedAt = types.Time{Time: revokedAt}
+	return nil
+}
+
+// CreateEABKey stores a new External Account Binding key
+func (s *Store) CreateEABKey(ctx context.Context, key *types.EABKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.eabKeys[key.ID]; exists {
+		return fmt.Errorf("error creating EAB key: key %s already exists", key.ID)
+	}
+	s.eabKeys[key.ID] = copyEABKey(key)
+	return nil
+}
+
+// GetEABKey returns an External Account Binding key by its key ID
+func (s *Store) GetEABKey(ctx context.Context, id string) (*types.EABKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.eabKeys[id]
+	if !ok {
+		return nil, eabKeyNotFound(id)
+	}
+	return copyEABKey(key), nil
+}
+
+// ListEABKeys returns all External Account Binding keys, oldest first
+func (s *Store) ListEABKeys(ctx context.Context) ([]*types.EABKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*types.EABKey, 0, len(s.eabKeys))
+	for _, key := range s.eabKeys {
+		keys = append(keys, copyEABKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// DeleteEABKey removes an External Account Binding key
+func (s *Store) DeleteEABKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.eabKeys[id]; !ok {
+		return eabKeyNotFound(id)
+	}
+	delete(s.eabKeys, id)
+	return nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func matchesIdentifier(identifiers []types.Identifier, query string) bool {
+	for _, id := range identifiers {
+		if containsFold(id.Value, query) {
+			return true
+		}
+	}
+	return false
+}
+
+func eabKeyNotFound(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:malformed",
+		Detail: fmt.Sprintf("EAB key %s does not exist", id),
+		Status: http.StatusNotFound,
+	}
+}
+
+// eabKeyUnusable reports an EAB key that is unknown or already bound to
+// another account
+func eabKeyUnusable(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:unauthorized",
+		Detail: fmt.Sprintf("EAB key %s is unknown or already bound", id),
+		Status: http.StatusUnauthorized,
+	}
+}
+
+func copyEABKey(key *types.EABKey) *types.EABKey {
+	c := *key
+	c.HMACKey = append([]byte(nil), key.HMACKey...)
+	c.Scope = append([]string(nil), key.Scope...)
+	if key.BoundAt != nil {
+		boundAt := *key.BoundAt
+		c.BoundAt = &boundAt
+	}
+	return &c
+}
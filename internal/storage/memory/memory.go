@@ -0,0 +1,1168 @@
+// Package memory implements the storage interface in process memory. It is
+// meant for tests and short-lived development servers; all data is lost when
+// the process exits.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// certificate is a stored certificate together with its renewal window
+// override and whether it was announced as expiring
+type certificate struct {
+	types.Certificate
+	override       *types.RenewalOverride
+	expiryNotified bool
+}
+
+// Store keeps all records in maps guarded by a single lock. Records are
+// copied on the way in and out so callers cannot modify stored state.
+type Store struct {
+	mu sync.RWMutex
+
+	accounts        map[string]*types.Account
+	orders          map[string]*types.Order
+	issuanceClaims  map[string]time.Time // order ID -> end of the issuance lease
+	authzs          map[string]*types.Authorization
+	authzOrder      []string            // authorization IDs in creation order
+	challenges      map[string][]string // authorization ID -> challenge tokens
+	challengeTokens map[string]*types.Challenge
+	certs           map[string]*certificate
+	issuerOverrides map[string]*types.RenewalOverride
+	nonces          map[string]time.Time
+	rateLimits      map[string]time.Time // key -> time the bucket is full again
+	auditLog        []types.AuditRecord  // in sequence order
+	webhooks        map[string]*types.WebhookDelivery
+	eabKeys         map[string]*types.EABKey
+}
+
+// New creates an empty in-memory store
+func New() *Store {
+	return &Store{
+		accounts:        make(map[string]*types.Account),
+		orders:          make(map[string]*types.Order),
+		issuanceClaims:  make(map[string]time.Time),
+		authzs:          make(map[string]*types.Authorization),
+		challenges:      make(map[string][]string),
+		challengeTokens: make(map[string]*types.Challenge),
+		certs:           make(map[string]*certificate),
+		issuerOverrides: make(map[string]*types.RenewalOverride),
+		nonces:          make(map[string]time.Time),
+		rateLimits:      make(map[string]time.Time),
+		webhooks:        make(map[string]*types.WebhookDelivery),
+		eabKeys:         make(map[string]*types.EABKey),
+	}
+}
+
+// PingContext always succeeds
+func (s *Store) PingContext(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op
+func (s *Store) Close() error {
+	return nil
+}
+
+// CreateAccount stores a new account
+func (s *Store) CreateAccount(ctx context.Context, account *types.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[account.ID]; exists {
+		return fmt.Errorf("error creating account: account %s already exists", account.ID)
+	}
+	if account.EABKeyID != "" {
+		key, ok := s.eabKeys[account.EABKeyID]
+		if !ok || key.BoundAccountID != "" {
+			return eabKeyUnusable(account.EABKeyID)
+		}
+		boundAt := time.Unix(account.CreatedAt, 0)
+		key.BoundAccountID = account.ID
+		key.BoundAt = &boundAt
+	}
+	s.accounts[account.ID] = copyAccount(account)
+	return nil
+}
+
+// GetAccount returns an account unless it has been deactivated
+func (s *Store) GetAccount(ctx context.Context, id string) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok || account.Status == types.AccountStatusDeactivated {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:accountDoesNotExist",
+			Detail: fmt.Sprintf("account %s does not exist", id),
+			Status: http.StatusNotFound,
+		}
+	}
+	return copyAccount(account), nil
+}
+
+// UpdateAccount updates the contact and status of an account
+func (s *Store) UpdateAccount(ctx context.Context, account *types.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.accounts[account.ID]
+	if !ok {
+		return fmt.Errorf("account not found: %s", account.ID)
+	}
+	stored.Contact = append([]string(nil), account.Contact...)
+	stored.Status = account.Status
+	stored.Scope = append([]string(nil), account.Scope...)
+	return nil
+}
+
+// CreateOrder stores an order together with its authorizations and their
+// challenges
+func (s *Store) CreateOrder(ctx context.Context, order *types.Order, authzs []*types.Authorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.orders[order.ID]; exists {
+		return fmt.Errorf("error creating order: order %s already exists", order.ID)
+	}
+	for _, authz := range authzs {
+		if _, exists := s.authzs[authz.ID]; exists {
+			return fmt.Errorf("error creating authorization: authorization %s already exists", authz.ID)
+		}
+	}
+
+	now := time.Now()
+	stored := copyOrder(order)
+	stored.Authorizations = nil
+	stored.CreatedAt = types.Time{Time: now}
+	stored.UpdatedAt = types.Time{Time: now}
+
+	for _, authz := range authzs {
+		authz.OrderID = order.ID
+		s.insertAuthorization(authz, now)
+		stored.Authorizations = append(stored.Authorizations, authz.ID)
+	}
+
+	s.orders[order.ID] = stored
+	return nil
+}
+
+// GetOrder returns an order with the IDs of its authorizations
+func (s *Store) GetOrder(ctx context.Context, id string) (*types.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, orderNotFound(id)
+	}
+	return copyOrder(order), nil
+}
+
+// UpdateOrder updates the status, certificate, CSR and error of an order
+func (s *Store) UpdateOrder(ctx context.Context, order *types.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[order.ID]
+	if !ok {
+		return orderNotFound(order.ID)
+	}
+	stored.Status = order.Status
+	stored.CertificateID = order.CertificateID
+	stored.UpdatedAt = order.UpdatedAt
+	stored.CSR = order.CSR
+	stored.Error = copyProblem(order.Error)
+	return nil
+}
+
+// ClaimOrderIssuance leases a processing order to the caller until the
+// given time. It returns false if the order is no longer processing or
+// another worker holds an unexpired lease.
+func (s *Store) ClaimOrderIssuance(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[id]
+	if !ok || stored.Status != types.OrderStatusProcessing {
+		return false, nil
+	}
+	if claimed, ok := s.issuanceClaims[id]; ok && claimed.After(now) {
+		return false, nil
+	}
+	s.issuanceClaims[id] = until
+	return true, nil
+}
+
+// FailOrderIssuance moves a processing order to invalid with the error of
+// the order. It returns false if the order is no longer processing.
+func (s *Store) FailOrderIssuance(ctx context.Context, order *types.Order) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[order.ID]
+	if !ok || stored.Status != types.OrderStatusProcessing {
+		return false, nil
+	}
+	stored.Status = types.OrderStatusInvalid
+	stored.UpdatedAt = order.UpdatedAt
+	stored.Error = copyProblem(order.Error)
+	delete(s.issuanceClaims, order.ID)
+	return true, nil
+}
+
+// CompleteOrderIssuance stores the certificate of a processing order and
+// moves the order to valid. It returns false if the order is no longer
+// processing.
+func (s *Store) CompleteOrderIssuance(ctx context.Context, order *types.Order, cert *types.Certificate) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[order.ID]
+	if !ok {
+		return false, nil
+	}
+	if stored.Status != types.OrderStatusProcessing {
+		return false, nil
+	}
+	if _, exists := s.certs[cert.ID]; exists {
+		return false, fmt.Errorf("error creating certificate: certificate %s already exists", cert.ID)
+	}
+
+	stored.Status = types.OrderStatusValid
+	stored.CertificateID = cert.ID
+	stored.UpdatedAt = types.Time{Time: time.Now()}
+	stored.Error = nil
+	delete(s.issuanceClaims, order.ID)
+
+	s.insertCertificate(cert)
+	return true, nil
+}
+
+// ListProcessingOrderIDs returns the IDs of orders waiting for issuance,
+// least recently updated first
+func (s *Store) ListProcessingOrderIDs(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []*types.Order
+	for _, order := range s.orders {
+		if order.Status == types.OrderStatusProcessing {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].UpdatedAt.Before(orders[j].UpdatedAt.Time)
+	})
+
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+	return ids, nil
+}
+
+// ListActiveStarOrderIDs returns the IDs of finalized STAR orders of valid
+// accounts whose end-date has not passed
+func (s *Store) ListActiveStarOrderIDs(ctx context.Context, now time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	for _, order := range s.orders {
+		account, ok := s.accounts[order.AccountID]
+		if order.Status == types.OrderStatusValid &&
+			order.AutoRenewal != nil &&
+			order.CSR != "" &&
+			order.AutoRenewal.EndDate.After(now) &&
+			ok && account.Status == types.AccountStatusValid {
+			ids = append(ids, order.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ClaimStarRenewal leases a valid STAR order to the caller until the given
+// time. It returns false if the order is not valid or another renewer
+// holds an unexpired lease.
+func (s *Store) ClaimStarRenewal(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[id]
+	if !ok || stored.Status != types.OrderStatusValid || stored.AutoRenewal == nil {
+		return false, nil
+	}
+	if claimed, ok := s.issuanceClaims[id]; ok && claimed.After(now) {
+		return false, nil
+	}
+	s.issuanceClaims[id] = until
+	return true, nil
+}
+
+// CompleteStarRenewal stores the renewed certificate of a valid STAR order
+// and releases the lease. It returns false without storing anything if the
+// order is no longer valid or its latest certificate no longer ends at
+// previousNotAfter, i.e. another renewer got there first.
+func (s *Store) CompleteStarRenewal(ctx context.Context, cert *types.Certificate, previousNotAfter time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[cert.OrderID]
+	if !ok || stored.Status != types.OrderStatusValid {
+		return false, nil
+	}
+	for _, c := range s.certs {
+		if c.OrderID == cert.OrderID && c.NotAfter.After(previousNotAfter) {
+			return false, nil
+		}
+	}
+	if _, exists := s.certs[cert.ID]; exists {
+		return false, fmt.Errorf("error creating certificate: certificate %s already exists", cert.ID)
+	}
+
+	delete(s.issuanceClaims, cert.OrderID)
+	s.insertCertificate(cert)
+	return true, nil
+}
+
+// ExpireOrders moves pending and ready orders past their expiry to invalid
+func (s *Store) ExpireOrders(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired int64
+	for _, order := range s.orders {
+		if (order.Status == types.OrderStatusPending || order.Status == types.OrderStatusReady) &&
+			order.ExpiresAt.Before(now) {
+			order.Status = types.OrderStatusInvalid
+			order.UpdatedAt = types.Time{Time: now}
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// PurgeOrders deletes invalid and canceled orders, and valid orders whose
+// certificates have all been purged, that have not been updated since
+// before, together with their authorizations and challenges
+func (s *Store) PurgeOrders(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	withCertificate := make(map[string]bool)
+	for _, cert := range s.certs {
+		withCertificate[cert.OrderID] = true
+	}
+
+	purge := make(map[string]bool)
+	for id, order := range s.orders {
+		switch order.Status {
+		case types.OrderStatusInvalid, types.OrderStatusCanceled, types.OrderStatusValid:
+		default:
+			continue
+		}
+		if order.UpdatedAt.Before(before) && !withCertificate[id] {
+			purge[id] = true
+		}
+	}
+	if len(purge) == 0 {
+		return 0, nil
+	}
+
+	s.deleteAuthorizations(func(authz *types.Authorization) bool {
+		return purge[authz.OrderID]
+	})
+	for id := range purge {
+		delete(s.orders, id)
+		delete(s.issuanceClaims, id)
+	}
+	return int64(len(purge)), nil
+}
+
+// PurgeAuthorizations deletes deactivated, expired, invalid and revoked
+// authorizations that have not been updated since before, together with
+// their challenges
+func (s *Store) PurgeAuthorizations(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteAuthorizations(func(authz *types.Authorization) bool {
+		switch authz.Status {
+		case types.AuthzStatusDeactivated, types.AuthzStatusExpired, types.AuthzStatusInvalid, types.AuthzStatusRevoked:
+			return authz.UpdatedAt.Before(before)
+		}
+		return false
+	}), nil
+}
+
+// deleteAuthorizations deletes the authorizations matching purge and their
+// challenges. The caller must hold the write lock.
+func (s *Store) deleteAuthorizations(purge func(*types.Authorization) bool) int64 {
+	var deleted int64
+	remaining := s.authzOrder[:0]
+	for _, authzID := range s.authzOrder {
+		if !purge(s.authzs[authzID]) {
+			remaining = append(remaining, authzID)
+			continue
+		}
+		for _, token := range s.challenges[authzID] {
+			delete(s.challengeTokens, token)
+		}
+		delete(s.challenges, authzID)
+		delete(s.authzs, authzID)
+		deleted++
+	}
+	s.authzOrder = remaining
+	return deleted
+}
+
+// CreateAuthorization stores a single authorization and its challenges
+func (s *Store) CreateAuthorization(ctx context.Context, authz *types.Authorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.authzs[authz.ID]; exists {
+		return fmt.Errorf("error creating authorization: authorization %s already exists", authz.ID)
+	}
+	s.insertAuthorization(authz, time.Now())
+	return nil
+}
+
+// GetAuthorization returns an authorization with its challenges
+func (s *Store) GetAuthorization(ctx context.Context, id string) (*types.Authorization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	authz, ok := s.authzs[id]
+	if !ok {
+		return nil, fmt.Errorf("authorization not found")
+	}
+
+	result := copyAuthorization(authz)
+	for _, token := range s.challenges[id] {
+		challenge := s.challengeTokens[token]
+		result.Challenges = append(result.Challenges, types.Challenge{
+			Type:   challenge.Type,
+			Status: challenge.Status,
+			Token:  challenge.Token,
+		})
+	}
+	return result, nil
+}
+
+// GetAuthorizationsByOrder returns the authorizations of an order without
+// their challenges
+func (s *Store) GetAuthorizationsByOrder(ctx context.Context, orderID string) ([]*types.Authorization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var authzs []*types.Authorization
+	for _, id := range s.authzOrder {
+		if authz := s.authzs[id]; authz.OrderID == orderID {
+			authzs = append(authzs, copyAuthorization(authz))
+		}
+	}
+	return authzs, nil
+}
+
+// UpdateAuthorization updates the status of an authorization
+func (s *Store) UpdateAuthorization(ctx context.Context, authz *types.Authorization) error {
+	return s.UpdateAuthorizationStatus(ctx, authz.ID, string(authz.Status))
+}
+
+// UpdateAuthorizationStatus sets the status of an authorization
+func (s *Store) UpdateAuthorizationStatus(ctx context.Context, authzID string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authz, ok := s.authzs[authzID]
+	if !ok {
+		return fmt.Errorf("error updating authorization status: authorization %s not found", authzID)
+	}
+	authz.Status = types.AuthorizationStatus(status)
+	authz.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeactivateAuthorization deactivates a pending or valid authorization and
+// stops the order depending on it: a pending or ready order becomes
+// invalid, a valid STAR order is canceled. It returns the number of stopped
+// orders.
+func (s *Store) DeactivateAuthorization(ctx context.Context, authzID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authz, ok := s.authzs[authzID]
+	if !ok || (authz.Status != types.AuthzStatusPending && authz.Status != types.AuthzStatusValid) {
+		return 0, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: fmt.Sprintf("authorization %s cannot be deactivated in its current state", authzID),
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	now := time.Now()
+	authz.Status = types.AuthzStatusDeactivated
+	authz.UpdatedAt = now
+
+	order, ok := s.orders[authz.OrderID]
+	if !ok {
+		return 0, nil
+	}
+	switch {
+	case order.Status == types.OrderStatusPending || order.Status == types.OrderStatusReady:
+		order.Status = types.OrderStatusInvalid
+	case order.Status == types.OrderStatusValid && order.AutoRenewal != nil:
+		order.Status = types.OrderStatusCanceled
+	default:
+		return 0, nil
+	}
+	order.UpdatedAt = types.Time{Time: now}
+	return 1, nil
+}
+
+// ExpireAuthorizations moves pending and valid authorizations past their
+// expiry to expired
+func (s *Store) ExpireAuthorizations(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired int64
+	for _, authz := range s.authzs {
+		if (authz.Status == types.AuthzStatusPending || authz.Status == types.AuthzStatusValid) &&
+			authz.Expires != nil && authz.Expires.Before(now) {
+			authz.Status = types.AuthzStatusExpired
+			authz.UpdatedAt = now
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// GetChallenge returns the challenge with the given token
+func (s *Store) GetChallenge(ctx context.Context, token string) (*types.Challenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	challenge, ok := s.challengeTokens[token]
+	if !ok {
+		return nil, fmt.Errorf("error getting challenge: challenge %s not found", token)
+	}
+	return copyChallenge(challenge), nil
+}
+
+// GetChallengesByAuthorization returns the challenges of an authorization
+func (s *Store) GetChallengesByAuthorization(ctx context.Context, authzID string) ([]types.Challenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var challenges []types.Challenge
+	for _, token := range s.challenges[authzID] {
+		challenges = append(challenges, *copyChallenge(s.challengeTokens[token]))
+	}
+	return challenges, nil
+}
+
+// UpdateChallengeStatus sets the status of the challenge with the given token
+// and records the time of validation when it becomes valid
+func (s *Store) UpdateChallengeStatus(ctx context.Context, token string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challengeTokens[token]
+	if !ok {
+		return fmt.Errorf("challenge not found")
+	}
+	challenge.Status = types.ChallengeStatus(status)
+	if challenge.Status == types.ChallengeStatusValid {
+		challenge.Validated = &types.Time{Time: time.Now()}
+	}
+	return nil
+}
+
+// ExpireChallenges invalidates pending and processing challenges of
+// authorizations in a terminal state
+func (s *Store) ExpireChallenges(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired int64
+	for authzID, tokens := range s.challenges {
+		switch s.authzs[authzID].Status {
+		case types.AuthzStatusExpired, types.AuthzStatusInvalid, types.AuthzStatusDeactivated, types.AuthzStatusRevoked:
+		default:
+			continue
+		}
+		for _, token := range tokens {
+			challenge := s.challengeTokens[token]
+			if challenge.Status == types.ChallengeStatusPending || challenge.Status == types.ChallengeStatusProcessing {
+				challenge.Status = types.ChallengeStatusInvalid
+				expired++
+			}
+		}
+	}
+	return expired, nil
+}
+
+// CreateCertificate stores an issued certificate
+func (s *Store) CreateCertificate(ctx context.Context, cert *types.Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.certs[cert.ID]; exists {
+		return fmt.Errorf("error creating certificate: certificate %s already exists", cert.ID)
+	}
+	s.insertCertificate(cert)
+	return nil
+}
+
+// GetCertificate returns a certificate by ID
+func (s *Store) GetCertificate(ctx context.Context, id string) (*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cert, ok := s.certs[id]
+	if !ok {
+		return nil, certificateNotFound(fmt.Sprintf("certificate %s does not exist", id))
+	}
+	c := cert.Certificate
+	return &c, nil
+}
+
+// GetCertificateBySerial returns a certificate by the hex encoded authority
+// key identifier of its issuer and its hex encoded serial number
+func (s *Store) GetCertificateBySerial(ctx context.Context, aki string, serial string) (*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, cert := range s.certs {
+		if cert.Serial == serial && cert.AuthorityKeyID == aki {
+			c := cert.Certificate
+			return &c, nil
+		}
+	}
+	return nil, certificateNotFound(fmt.Sprintf("certificate with serial %s does not exist", serial))
+}
+
+// GetLatestCertificateByOrder returns the certificate of an order that
+// expires last
+func (s *Store) GetLatestCertificateByOrder(ctx context.Context, orderID string) (*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *certificate
+	for _, cert := range s.certs {
+		if cert.OrderID == orderID && (latest == nil || cert.NotAfter.After(latest.NotAfter.Time)) {
+			latest = cert
+		}
+	}
+	if latest == nil {
+		return nil, certificateNotFound(fmt.Sprintf("order %s has no certificate", orderID))
+	}
+	c := latest.Certificate
+	return &c, nil
+}
+
+// GetCurrentCertificateByOrder returns the certificate of an order that is
+// valid at the given time and expires last, or the earliest one if none is
+// valid yet
+func (s *Store) GetCurrentCertificateByOrder(ctx context.Context, orderID string, now time.Time) (*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var current, earliest *certificate
+	for _, cert := range s.certs {
+		if cert.OrderID != orderID {
+			continue
+		}
+		if !cert.NotBefore.After(now) {
+			if current == nil || cert.NotAfter.After(current.NotAfter.Time) {
+				current = cert
+			}
+		} else if earliest == nil || cert.NotBefore.Before(earliest.NotBefore.Time) {
+			earliest = cert
+		}
+	}
+	if current == nil {
+		current = earliest
+	}
+	if current == nil {
+		return nil, certificateNotFound(fmt.Sprintf("order %s has no certificate", orderID))
+	}
+	c := current.Certificate
+	return &c, nil
+}
+
+// MarkCertificateReplaced links a certificate to its successor
+func (s *Store) MarkCertificateReplaced(ctx context.Context, id string, replacedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cert, ok := s.certs[id]
+	if !ok {
+		return fmt.Errorf("certificate not found: %s", id)
+	}
+	cert.ReplacedBy = replacedBy
+	return nil
+}
+
+// GetRenewalOverride returns the renewal window set on a certificate or,
+// failing that, on its issuer. It returns nil if neither exists.
+func (s *Store) GetRenewalOverride(ctx context.Context, cert *types.Certificate) (*types.RenewalOverride, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored, ok := s.certs[cert.ID]
+	if !ok {
+		return nil, fmt.Errorf("error querying certificate renewal window: certificate %s not found", cert.ID)
+	}
+	if stored.override != nil {
+		override := *stored.override
+		return &override, nil
+	}
+	if issuer, ok := s.issuerOverrides[cert.AuthorityKeyID]; ok {
+		override := *issuer
+		return &override, nil
+	}
+	return nil, nil
+}
+
+// SetCertificateRenewalWindow overrides the renewal window of a single
+// certificate. A nil override removes it.
+func (s *Store) SetCertificateRenewalWindow(ctx context.Context, id string, override *types.RenewalOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cert, ok := s.certs[id]
+	if !ok {
+		return fmt.Errorf("certificate not found: %s", id)
+	}
+	cert.override = copyOverride(override)
+	return nil
+}
+
+// SetIssuerRenewalWindow overrides the renewal window of every certificate
+// of an issuer. A nil override removes it.
+func (s *Store) SetIssuerRenewalWindow(ctx context.Context, aki string, override *types.RenewalOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if override == nil {
+		delete(s.issuerOverrides, aki)
+		return nil
+	}
+	s.issuerOverrides[aki] = copyOverride(override)
+	return nil
+}
+
+// PurgeExpiredCertificates deletes certificates that expired before before
+func (s *Store) PurgeExpiredCertificates(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, cert := range s.certs {
+		if !cert.NotAfter.IsZero() && cert.NotAfter.Before(before) {
+			delete(s.certs, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// CreateNonce stores a newly issued replay nonce
+func (s *Store) CreateNonce(ctx context.Context, nonce string, createdAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.nonces[nonce]; exists {
+		return fmt.Errorf("error creating nonce: nonce already exists")
+	}
+	s.nonces[nonce] = createdAt
+	return nil
+}
+
+// ConsumeNonce removes a nonce and reports whether it existed and was issued
+// after notBefore
+func (s *Store) ConsumeNonce(ctx context.Context, nonce string, notBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt, ok := s.nonces[nonce]
+	if !ok {
+		return false, nil
+	}
+	delete(s.nonces, nonce)
+	return !createdAt.Before(notBefore), nil
+}
+
+// DeleteExpiredNonces removes nonces issued before the given time
+func (s *Store) DeleteExpiredNonces(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for nonce, createdAt := range s.nonces {
+		if createdAt.Before(before) {
+			delete(s.nonces, nonce)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CountNonces returns the number of stored nonces
+func (s *Store) CountNonces(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.nonces)), nil
+}
+
+// SpendRateLimit takes a token from the bucket of key
+func (s *Store) SpendRateLimit(ctx context.Context, key string, now time.Time, interval, period time.Duration) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fullAt := s.rateLimits[key]
+	next := fullAt
+	if next.Before(now) {
+		next = now
+	}
+	next = next.Add(interval)
+	if next.Sub(now) > period {
+		return fullAt, false, nil
+	}
+	s.rateLimits[key] = next
+	return next, true, nil
+}
+
+// SpendRateLimits takes a token from every bucket, or from none if one of
+// them is empty
+func (s *Store) SpendRateLimits(ctx context.Context, spends []types.RateLimitSpend, now time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := make(map[string]time.Time, len(spends))
+	for i, spend := range spends {
+		fullAt, ok := taken[spend.Key]
+		if !ok {
+			fullAt = s.rateLimits[spend.Key]
+		}
+		next := fullAt
+		if next.Before(now) {
+			next = now
+		}
+		next = next.Add(spend.Interval)
+		if next.Sub(now) > spend.Period {
+			return i, fullAt, nil
+		}
+		taken[spend.Key] = next
+	}
+	for key, fullAt := range taken {
+		s.rateLimits[key] = fullAt
+	}
+	return -1, time.Time{}, nil
+}
+
+// GetRateLimit returns the time the bucket of key is full again
+func (s *Store) GetRateLimit(ctx context.Context, key string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rateLimits[key], nil
+}
+
+// DeleteFullRateLimits removes the buckets that are full again at now
+func (s *Store) DeleteFullRateLimits(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, fullAt := range s.rateLimits {
+		if !fullAt.After(now) {
+			delete(s.rateLimits, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// AppendAuditRecord adds an entry to the audit log. It returns false if an
+// entry with the same sequence number exists.
+func (s *Store) AppendAuditRecord(ctx context.Context, record *types.AuditRecord) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := len(s.auditLog); n > 0 && s.auditLog[n-1].Seq >= record.Seq {
+		return false, nil
+	}
+	stored := *record
+	stored.Entry = append([]byte(nil), record.Entry...)
+	s.auditLog = append(s.auditLog, stored)
+	return true, nil
+}
+
+// GetLastAuditRecord returns the latest audit log entry, or nil if the log
+// is empty
+func (s *Store) GetLastAuditRecord(ctx context.Context) (*types.AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.auditLog) == 0 {
+		return nil, nil
+	}
+	record := s.auditLog[len(s.auditLog)-1]
+	return &record, nil
+}
+
+// ListAuditRecords returns up to limit audit log entries following afterSeq
+// in order
+func (s *Store) ListAuditRecords(ctx context.Context, afterSeq int64, limit int) ([]*types.AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.Search(len(s.auditLog), func(i int) bool { return s.auditLog[i].Seq > afterSeq })
+	var records []*types.AuditRecord
+	for ; i < len(s.auditLog) && len(records) < limit; i++ {
+		record := s.auditLog[i]
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// CreateWebhookDeliveries queues deliveries of an event
+func (s *Store) CreateWebhookDeliveries(ctx context.Context, deliveries []*types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deliveries {
+		if _, exists := s.webhooks[d.ID]; exists {
+			return fmt.Errorf("error queueing webhook delivery: delivery %s already exists", d.ID)
+		}
+	}
+	for _, d := range deliveries {
+		s.webhooks[d.ID] = copyWebhookDelivery(d)
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries that are due at now
+// and postpones them to until
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]*types.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*types.WebhookDelivery
+	for _, d := range s.webhooks {
+		if !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*types.WebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = until
+		claimed = append(claimed, copyWebhookDelivery(d))
+	}
+	return claimed, nil
+}
+
+// UpdateWebhookDelivery stores the attempts, next attempt and last error of
+// a delivery
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d *types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.webhooks[d.ID]
+	if !ok {
+		return fmt.Errorf("webhook delivery not found: %s", d.ID)
+	}
+	stored.Attempts = d.Attempts
+	stored.NextAttemptAt = d.NextAttemptAt
+	stored.LastError = d.LastError
+	return nil
+}
+
+// DeleteWebhookDelivery removes a delivery from the queue
+func (s *Store) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.webhooks, id)
+	return nil
+}
+
+// PurgeWebhookDeliveries deletes deliveries queued before before
+func (s *Store) PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, d := range s.webhooks {
+		if d.CreatedAt.Before(before) {
+			delete(s.webhooks, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ListExpiringCertificates returns up to limit certificates that expire
+// between now and before and have not been announced as expiring. Revoked
+// and replaced certificates and those of STAR orders are left out.
+func (s *Store) ListExpiringCertificates(ctx context.Context, now, before time.Time, limit int) ([]*types.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var certs []*types.Certificate
+	for _, stored := range s.certs {
+		cert := stored.Certificate
+		if stored.expiryNotified || cert.Revoked || cert.ReplacedBy != "" ||
+			!cert.NotAfter.After(now) || cert.NotAfter.After(before) {
+			continue
+		}
+		if order, ok := s.orders[cert.OrderID]; !ok || order.AutoRenewal != nil {
+			continue
+		}
+		certs = append(certs, &cert)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter.Time) })
+	if len(certs) > limit {
+		certs = certs[:limit]
+	}
+	return certs, nil
+}
+
+// MarkCertificateExpiryNotified records that a certificate was announced as
+// expiring. It returns false if it already was.
+func (s *Store) MarkCertificateExpiryNotified(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.certs[id]
+	if !ok {
+		return false, certificateNotFound(fmt.Sprintf("certificate %s does not exist", id))
+	}
+	if stored.expiryNotified {
+		return false, nil
+	}
+	stored.expiryNotified = true
+	return true, nil
+}
+
+// insertAuthorization stores an authorization and its challenges. The
+// caller must hold the write lock.
+func (s *Store) insertAuthorization(authz *types.Authorization, now time.Time) {
+	stored := copyAuthorization(authz)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.authzs[authz.ID] = stored
+	s.authzOrder = append(s.authzOrder, authz.ID)
+
+	for i := range authz.Challenges {
+		challenge := &authz.Challenges[i]
+		challenge.AuthorizationID = authz.ID
+		s.challengeTokens[challenge.Token] = copyChallenge(challenge)
+		s.challenges[authz.ID] = append(s.challenges[authz.ID], challenge.Token)
+	}
+}
+
+// insertCertificate stores a certificate. The caller must hold the write
+// lock.
+func (s *Store) insertCertificate(cert *types.Certificate) {
+	stored := &certificate{Certificate: *cert}
+	stored.CreatedAt = types.Time{Time: time.Now()}
+	s.certs[cert.ID] = stored
+}
+
+func orderNotFound(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:orderDoesNotExist",
+		Detail: fmt.Sprintf("order %s does not exist", id),
+		Status: http.StatusNotFound,
+	}
+}
+
+func certificateNotFound(detail string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:malformed",
+		Detail: detail,
+		Status: http.StatusNotFound,
+	}
+}
+
+func copyAccount(account *types.Account) *types.Account {
+	c := *account
+	c.Key = append([]byte(nil), account.Key...)
+	c.Contact = append([]string(nil), account.Contact...)
+	c.Scope = append([]string(nil), account.Scope...)
+	return &c
+}
+
+func copyOrder(order *types.Order) *types.Order {
+	c := *order
+	c.Identifiers = append([]types.Identifier(nil), order.Identifiers...)
+	c.Authorizations = append([]string(nil), order.Authorizations...)
+	c.Error = copyProblem(order.Error)
+	if order.AutoRenewal != nil {
+		autoRenewal := *order.AutoRenewal
+		c.AutoRenewal = &autoRenewal
+	}
+	return &c
+}
+
+func copyAuthorization(authz *types.Authorization) *types.Authorization {
+	c := *authz
+	c.Challenges = nil
+	if authz.Expires != nil {
+		expires := *authz.Expires
+		c.Expires = &expires
+	}
+	return &c
+}
+
+func copyChallenge(challenge *types.Challenge) *types.Challenge {
+	c := *challenge
+	if challenge.Validated != nil {
+		validated := *challenge.Validated
+		c.Validated = &validated
+	}
+	return &c
+}
+
+func copyProblem(problem *types.Problem) *types.Problem {
+	if problem == nil {
+		return nil
+	}
+	c := *problem
+	c.Subproblems = append([]types.Subproblem(nil), problem.Subproblems...)
+	return &c
+}
+
+func copyOverride(override *types.RenewalOverride) *types.RenewalOverride {
+	if override == nil {
+		return nil
+	}
+	c := *override
+	return &c
+}
+
+func copyWebhookDelivery(d *types.WebhookDelivery) *types.WebhookDelivery {
+	c := *d
+	c.Payload = append([]byte(nil), d.Payload...)
+	return &c
+}
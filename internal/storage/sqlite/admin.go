@@ -0,0 +1,328 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// whereClause collects the conditions of an admin list query. Conditions
+// reference their argument as $%d.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+func (w *whereClause) add(cond string, arg any) {
+	w.args = append(w.args, arg)
+	w.conds = append(w.conds, fmt.Sprintf(cond, len(w.args)))
+}
+
+// build returns the WHERE clause followed by the pagination of filter
+func (w *whereClause) build(orderBy string, filter types.ListFilter) string {
+	var b strings.Builder
+	if len(w.conds) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(w.conds, " AND "))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = types.DefaultListLimit
+	}
+	w.args = append(w.args, limit, filter.Offset)
+	fmt.Fprintf(&b, " ORDER BY %s LIMIT $%d OFFSET $%d", orderBy, len(w.args)-1, len(w.args))
+	return b.String()
+}
+
+// ListAccounts returns accounts matching filter, including deactivated ones,
+// newest first
+func (db *DB) ListAccounts(ctx context.Context, filter types.ListFilter) ([]*types.Account, error) {
+	var where whereClause
+	if filter.AccountID != "" {
+		where.add("id = $%d", filter.AccountID)
+	}
+	if filter.Status != "" {
+		where.add("status = $%d", filter.Status)
+	}
+	if filter.Query != "" {
+		where.add("contact LIKE '%%' || $%d || '%%'", filter.Query)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, key, contact, status, terms_agreed, created_at, initial_ip, tenant, scope
+		FROM accounts`+where.build("created_at DESC, id", filter), where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []*types.Account
+	for rows.Next() {
+		var account types.Account
+		var keyJSON, contactJSON, scopeJSON []byte
+		if err := rows.Scan(
+			&account.ID,
+			&keyJSON,
+			&contactJSON,
+			&account.Status,
+			&account.TermsOfServiceAgreed,
+			&account.CreatedAt,
+			&account.InitialIP,
+			&account.Tenant,
+			&scopeJSON,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning account: %w", err)
+		}
+		account.Key = keyJSON
+		if contactJSON != nil {
+			if err := json.Unmarshal(contactJSON, &account.Contact); err != nil {
+				return nil, fmt.Errorf("error unmarshaling contact info: %w", err)
+			}
+		}
+		if account.Scope, err = unmarshalScope(scopeJSON); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &account)
+	}
+	return accounts, rows.Err()
+}
+
+// ListOrderIDs returns the IDs of orders matching filter, newest first
+func (db *DB) ListOrderIDs(ctx context.Context, filter types.ListFilter) ([]string, error) {
+	var where whereClause
+	if filter.AccountID != "" {
+		where.add("account_id = $%d", filter.AccountID)
+	}
+	if filter.Status != "" {
+		where.add("status = $%d", filter.Status)
+	}
+	if filter.Query != "" {
+		where.add("identifiers LIKE '%%' || $%d || '%%'", filter.Query)
+	}
+
+	ids, err := db.queryIDs(ctx, `SELECT id FROM orders`+where.build("created_at DESC, id", filter), where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying orders: %w", err)
+	}
+	return ids, nil
+}
+
+// ListCertificates returns certificates matching filter, newest first. The
+// status filter accepts "valid", "expired" and "revoked".
+func (db *DB) ListCertificates(ctx context.Context, filter types.ListFilter) ([]*types.Certificate, error) {
+	var where whereClause
+	if filter.AccountID != "" {
+		where.add("order_id IN (SELECT id FROM orders WHERE account_id = $%d)", filter.AccountID)
+	}
+	if filter.Serial != "" {
+		where.add("serial = $%d", filter.Serial)
+	}
+	switch filter.Status {
+	case "":
+	case "revoked":
+		where.conds = append(where.conds, "revoked = 1")
+	case "valid":
+		where.add("revoked = 0 AND not_after > $%d", timestamp{time.Now()})
+	case "expired":
+		where.add("revoked = 0 AND not_after <= $%d", timestamp{time.Now()})
+	default:
+		return nil, fmt.Errorf("unknown certificate status %q", filter.Status)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT`+certificateColumns+`
+		FROM certificates`+where.build("created_at DESC, id", filter), where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certs []*types.Certificate
+	for rows.Next() {
+		cert, err := scanCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, rows.Err()
+}
+
+// ListPendingChallenges returns challenges that are pending or being
+// validated, oldest first
+func (db *DB) ListPendingChallenges(ctx context.Context, filter types.ListFilter) ([]types.Challenge, error) {
+	var where whereClause
+	where.conds = append(where.conds, "status IN ('pending', 'processing')")
+	if filter.AccountID != "" {
+		where.add(`authorization_id IN (
+			SELECT a.id FROM authorizations a
+			JOIN orders o ON o.id = a.order_id
+			WHERE o.account_id = $%d)`, filter.AccountID)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT`+challengeColumns+`
+		FROM challenges`+where.build("created_at, id", filter), where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying challenges: %w", err)
+	}
+	defer rows.Close()
+
+	var challenges []types.Challenge
+	for rows.Next() {
+		c, err := scanChallenge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning challenge: %w", err)
+		}
+		challenges = append(challenges, *c)
+	}
+	return challenges, rows.Err()
+}
+
+// RevokeCertificate marks a certificate as revoked. It fails with an
+// alreadyRevoked problem if the certificate has been revoked before.
+func (db *DB) RevokeCertificate(ctx context.Context, id string, reason string, revokedAt time.Time) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE certificates
+		SET revoked = 1, revocation_reason = $2, revoked_at = $3
+		WHERE id = $1
+		AND revoked = 0`,
+		id, reason, timestamp{revokedAt},
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking certificate: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	if affected == 0 {
+		if _, err := db.GetCertificate(ctx, id); err != nil {
+			return err
+		}
+		return &types.Problem{
+			Type:   "urn:ietf:params:acme:error:alreadyRevoked",
+			Detail: fmt.Sprintf("certificate %s is already revoked", id),
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// CreateEABKey stores a new External Account Binding key
+func (db *DB) CreateEABKey(ctx context.Context, key *types.EABKey) error {
+	scopeJSON, err := marshalScope(key.Scope)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO eab_keys (id, hmac_key, label, created_at, tenant, scope)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, key.HMACKey, nullString(key.Label), timestamp{key.CreatedAt}, key.Tenant, nullJSON(scopeJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating EAB key: %w", err)
+	}
+	return nil
+}
+
+const eabKeyColumns = `
+		id, hmac_key, label, created_at, bound_account_id, bound_at, tenant, scope`
+
+// scanEABKey scans a row selected with eabKeyColumns
+func scanEABKey(row interface{ Scan(...any) error }) (*types.EABKey, error) {
+	var key types.EABKey
+	var label, boundAccountID sql.NullString
+	var createdAt, boundAt timestamp
+	var scopeJSON []byte
+
+	err := row.Scan(&key.ID, &key.HMACKey, &label, &createdAt, &boundAccountID, &boundAt, &key.Tenant, &scopeJSON)
+	if err != nil {
+		return nil, err
+	}
+	if key.Scope, err = unmarshalScope(scopeJSON); err != nil {
+		return nil, err
+	}
+	key.Label = label.String
+	key.CreatedAt = createdAt.Time
+	key.BoundAccountID = boundAccountID.String
+	if !boundAt.IsZero() {
+		key.BoundAt = &boundAt.Time
+	}
+	return &key, nil
+}
+
+// GetEABKey retrieves an External Account Binding key by its key ID
+func (db *DB) GetEABKey(ctx context.Context, id string) (*types.EABKey, error) {
+	key, err := scanEABKey(db.QueryRowContext(ctx, `SELECT`+eabKeyColumns+`
+		FROM eab_keys
+		WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, eabKeyNotFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting EAB key: %w", err)
+	}
+	return key, nil
+}
+
+// ListEABKeys returns all External Account Binding keys, oldest first
+func (db *DB) ListEABKeys(ctx context.Context) ([]*types.EABKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT`+eabKeyColumns+`
+		FROM eab_keys
+		ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying EAB keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*types.EABKey
+	for rows.Next() {
+		key, err := scanEABKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning EAB key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteEABKey removes an External Account Binding key. Accounts already
+// bound to it are not affected.
+func (db *DB) DeleteEABKey(ctx context.Context, id string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM eab_keys WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting EAB key: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	if affected == 0 {
+		return eabKeyNotFound(id)
+	}
+	return nil
+}
+
+func eabKeyNotFound(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:malformed",
+		Detail: fmt.Sprintf("EAB key %s does not exist", id),
+		Status: http.StatusNotFound,
+	}
+}
+
+// eabKeyUnusable reports an EAB key that is unknown or already bound to
+// another account
+func eabKeyUnusable(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:unauthorized",
+		Detail: fmt.Sprintf("EAB key %s is unknown or already bound", id),
+		Status: http.StatusUnauthorized,
+	}
+}
@@ -0,0 +1,180 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const createMigrationsTableQuery = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`
+
+// Migration is one versioned schema change. SQLite databases are only
+// migrated forward, when they are opened.
+type Migration struct {
+	Version int
+	Name    string
+	Script  string
+}
+
+// Migrations returns the embedded migrations ordered by version. Files are
+// named NNN_description.sql.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, description, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNN_description.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, prefix)
+		}
+
+		script, err := fs.ReadFile(migrationFiles, path.Join("migrations", name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: description, Script: string(script)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migration version %d is used twice", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// migrateUp applies every pending migration in order. Each migration and
+// its entry in schema_migrations are committed together, so a failed
+// migration leaves neither schema nor version behind.
+func migrateUp(ctx context.Context, db *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	var versioned bool
+	err = db.QueryRowContext(ctx,
+		`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+	).Scan(&versioned)
+	if err != nil {
+		return fmt.Errorf("error inspecting schema: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	if !versioned {
+		if err := adopt(ctx, db, migrations); err != nil {
+			return err
+		}
+	}
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, m.Script); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %03d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, timestamp{time.Now()},
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %03d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// adoptionProbes name a table and column that each migration creates.
+// Databases created before the schema was versioned had their tables created
+// and columns added on open, so the migrations whose probe exists are
+// recorded as applied instead of being run.
+var adoptionProbes = map[int]struct{ table, column string }{
+	1: {"accounts", "id"},
+	2: {"accounts", "tenant"},
+	3: {"accounts", "scope"},
+	4: {"rate_limits", "key"},
+	5: {"audit_log", "seq"},
+	6: {"webhook_deliveries", "id"},
+	7: {"orders", "issuance_claimed_until"},
+}
+
+// adopt records the migrations that an unversioned database already has
+func adopt(ctx context.Context, db *sql.DB, migrations []Migration) error {
+	for _, m := range migrations {
+		probe, ok := adoptionProbes[m.Version]
+		if !ok {
+			return nil
+		}
+		var exists bool
+		err := db.QueryRowContext(ctx,
+			`SELECT COUNT(*) > 0 FROM pragma_table_info($1) WHERE name = $2`,
+			probe.table, probe.column,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error inspecting table %s: %w", probe.table, err)
+		}
+		if !exists {
+			continue
+		}
+		if _, err := db.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, timestamp{time.Now()},
+		); err != nil {
+			return fmt.Errorf("error recording migration %03d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// appliedVersions returns the versions recorded in schema_migrations
+func appliedVersions(t *testing.T, db *DB) []int {
+	t.Helper()
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []int
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d has version %d, want consecutive versions", i, m.Version)
+		}
+		all = append(all, m.Version)
+	}
+
+	// A new database is migrated to the latest version, reopening it applies
+	// nothing again
+	path := filepath.Join(t.TempDir(), "acme.db")
+	for i := 0; i < 2; i++ {
+		db, err := New(path)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if got := appliedVersions(t, db); !reflect.DeepEqual(got, all) {
+			t.Errorf("open %d: applied versions = %v, want %v", i+1, got, all)
+		}
+		db.Close()
+	}
+}
+
+func TestMigrationsAdoptUnversioned(t *testing.T) {
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Databases created before the schema was versioned, with the tables and
+	// columns of the first n migrations
+	for _, n := range []int{1, 3, len(migrations)} {
+		path := filepath.Join(t.TempDir(), "acme.db")
+		raw, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range migrations[:n] {
+			if _, err := raw.ExecContext(ctx, m.Script); err != nil {
+				t.Fatalf("%03d_%s: %v", m.Version, m.Name, err)
+			}
+		}
+		raw.Close()
+
+		db, err := New(path)
+		if err != nil {
+			t.Fatalf("adopting a database with %d migration(s): %v", n, err)
+		}
+		if got := appliedVersions(t, db); len(got) != len(migrations) {
+			t.Errorf("adopting a database with %d migration(s): applied versions = %v", n, got)
+		}
+		var claims int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('orders') WHERE name = 'issuance_claimed_until'`).Scan(&claims); err != nil || claims != 1 {
+			t.Errorf("adopting a database with %d migration(s): orders.issuance_claimed_until missing", n)
+		}
+		db.Close()
+	}
+}
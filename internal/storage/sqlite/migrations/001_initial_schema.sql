@@ -0,0 +1,102 @@
+-- Initial SQLite schema of the ACME server. Timestamps are stored as
+-- fixed-width UTC text so that comparisons follow chronological order; JSON
+-- documents are stored as text.
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id TEXT PRIMARY KEY,
+    key TEXT NOT NULL,
+    contact TEXT,
+    status TEXT NOT NULL,
+    terms_agreed INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    initial_ip TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL REFERENCES accounts(id),
+    status TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    not_before TEXT,
+    not_after TEXT,
+    identifiers TEXT NOT NULL,
+    finalize TEXT NOT NULL,
+    error TEXT,
+    certificate_id TEXT,
+    replaces TEXT,
+    auto_renewal TEXT,
+    auto_renewal_end TEXT,
+    csr TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS authorizations (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL REFERENCES orders(id),
+    status TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    identifier TEXT NOT NULL,
+    wildcard INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS challenges (
+    id TEXT PRIMARY KEY,
+    authorization_id TEXT NOT NULL REFERENCES authorizations(id),
+    type TEXT NOT NULL,
+    url TEXT NOT NULL,
+    status TEXT NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    validated TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS certificates (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL REFERENCES orders(id),
+    certificate TEXT NOT NULL,
+    serial TEXT,
+    authority_key_id TEXT,
+    not_before TEXT,
+    not_after TEXT,
+    replaced_by TEXT,
+    renewal_window_start TEXT,
+    renewal_window_end TEXT,
+    renewal_explanation_url TEXT,
+    revoked INTEGER NOT NULL DEFAULT 0,
+    revocation_reason TEXT,
+    revoked_at TEXT,
+    created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS issuer_renewal_overrides (
+    authority_key_id TEXT PRIMARY KEY,
+    window_start TEXT NOT NULL,
+    window_end TEXT NOT NULL,
+    explanation_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS nonces (
+    nonce TEXT PRIMARY KEY,
+    created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS eab_keys (
+    id TEXT PRIMARY KEY,
+    hmac_key BLOB NOT NULL,
+    label TEXT,
+    created_at TEXT NOT NULL,
+    bound_account_id TEXT REFERENCES accounts(id),
+    bound_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_account_id ON orders(account_id);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status);
+CREATE INDEX IF NOT EXISTS idx_authorizations_order_id ON authorizations(order_id);
+CREATE INDEX IF NOT EXISTS idx_challenges_authorization_id ON challenges(authorization_id);
+CREATE INDEX IF NOT EXISTS idx_certificates_order_id ON certificates(order_id);
+CREATE INDEX IF NOT EXISTS idx_certificates_serial ON certificates(serial);
+CREATE INDEX IF NOT EXISTS idx_nonces_created_at ON nonces(created_at);
@@ -0,0 +1,5 @@
+-- Accounts, orders and EAB keys belong to a tenant; '' is the default tenant
+ALTER TABLE accounts ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN profile TEXT;
+ALTER TABLE eab_keys ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
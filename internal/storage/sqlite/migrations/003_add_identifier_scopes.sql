@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN scope TEXT;
+ALTER TABLE eab_keys ADD COLUMN scope TEXT;
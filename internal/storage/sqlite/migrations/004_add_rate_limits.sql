@@ -0,0 +1,7 @@
+-- full_at is the time a rate limit bucket is full again, in Unix nanoseconds
+CREATE TABLE rate_limits (
+    key TEXT PRIMARY KEY,
+    full_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_full_at ON rate_limits(full_at);
@@ -0,0 +1,7 @@
+-- entry is the JSON audit log entry exactly as it was hashed
+CREATE TABLE audit_log (
+    seq INTEGER PRIMARY KEY,
+    event TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    entry TEXT NOT NULL
+);
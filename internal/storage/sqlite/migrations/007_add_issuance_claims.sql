@@ -0,0 +1,3 @@
+-- issuance_claimed_until is the end of the lease of the worker signing the
+-- order
+ALTER TABLE orders ADD COLUMN issuance_claimed_until TEXT;
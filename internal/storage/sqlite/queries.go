@@ -0,0 +1,1575 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// CreateAccount creates a new account in the database
+func (db *DB) CreateAccount(ctx context.Context, account *types.Account) error {
+	keyJSON, err := json.Marshal(account.Key)
+	if err != nil {
+		return fmt.Errorf("error marshaling account key: %w", err)
+	}
+
+	contactJSON, err := json.Marshal(account.Contact)
+	if err != nil {
+		return fmt.Errorf("error marshaling contact info: %w", err)
+	}
+	scopeJSON, err := marshalScope(account.Scope)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO accounts (id, key, contact, status, terms_agreed, created_at, initial_ip, tenant, scope)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			account.ID,
+			string(keyJSON),
+			string(contactJSON),
+			account.Status,
+			account.TermsOfServiceAgreed,
+			account.CreatedAt,
+			account.InitialIP,
+			account.Tenant,
+			nullJSON(scopeJSON),
+		)
+		if err != nil {
+			return fmt.Errorf("error creating account: %w", err)
+		}
+
+		// Bind the EAB key in the same transaction so that it is used by
+		// one account only
+		if account.EABKeyID != "" {
+			res, err := tx.ExecContext(ctx, `
+				UPDATE eab_keys
+				SET bound_account_id = $2, bound_at = $3
+				WHERE id = $1
+				AND bound_account_id IS NULL`,
+				account.EABKeyID, account.ID, timestamp{time.Unix(account.CreatedAt, 0)},
+			)
+			if err != nil {
+				return fmt.Errorf("error binding EAB key: %w", err)
+			}
+			if affected, err := res.RowsAffected(); err != nil {
+				return fmt.Errorf("error fetching rows affected: %w", err)
+			} else if affected == 0 {
+				return eabKeyUnusable(account.EABKeyID)
+			}
+		}
+		return nil
+	})
+}
+
+// GetAccount retrieves an account from the database
+func (db *DB) GetAccount(ctx context.Context, id string) (*types.Account, error) {
+	var account types.Account
+	var keyJSON, contactJSON, scopeJSON []byte
+
+	err := db.QueryRowContext(ctx, `
+		SELECT id, key, contact, status, terms_agreed, created_at, initial_ip, tenant, scope
+		FROM accounts
+		WHERE id = $1
+		AND status != 'deactivated'`,
+		id,
+	).Scan(
+		&account.ID,
+		&keyJSON,
+		&contactJSON,
+		&account.Status,
+		&account.TermsOfServiceAgreed,
+		&account.CreatedAt,
+		&account.InitialIP,
+		&account.Tenant,
+		&scopeJSON,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:accountDoesNotExist",
+			Detail: fmt.Sprintf("account %s does not exist", id),
+			Status: http.StatusNotFound,
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying account: %w", err)
+	}
+
+	if err := json.Unmarshal(keyJSON, &account.Key); err != nil {
+		return nil, fmt.Errorf("error unmarshaling account key: %w", err)
+	}
+	if contactJSON != nil {
+		if err := json.Unmarshal(contactJSON, &account.Contact); err != nil {
+			return nil, fmt.Errorf("error unmarshaling contact info: %w", err)
+		}
+	}
+	if account.Scope, err = unmarshalScope(scopeJSON); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// UpdateAccount updates an existing account in the database
+func (db *DB) UpdateAccount(ctx context.Context, account *types.Account) error {
+	contactJSON, err := json.Marshal(account.Contact)
+	if err != nil {
+		return fmt.Errorf("error marshaling contact info: %w", err)
+	}
+	scopeJSON, err := marshalScope(account.Scope)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE accounts
+		SET contact = $2, status = $3, scope = $4
+		WHERE id = $1`,
+		account.ID, string(contactJSON), account.Status, nullJSON(scopeJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating account: %w", err)
+	}
+	return requireAffected(res, fmt.Sprintf("account not found: %s", account.ID))
+}
+
+// CreateOrder creates a new order with its authorizations and challenges
+func (db *DB) CreateOrder(ctx context.Context, order *types.Order, authzs []*types.Authorization) error {
+	identifiersJSON, err := json.Marshal(order.Identifiers)
+	if err != nil {
+		return fmt.Errorf("error marshaling identifiers: %w", err)
+	}
+
+	var autoRenewalJSON []byte
+	var autoRenewalEnd time.Time
+	if order.AutoRenewal != nil {
+		if autoRenewalJSON, err = json.Marshal(order.AutoRenewal); err != nil {
+			return fmt.Errorf("error marshaling auto-renewal: %w", err)
+		}
+		autoRenewalEnd = order.AutoRenewal.EndDate.Time
+	}
+
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO orders (
+				id, account_id, status, expires_at, not_before, not_after,
+				identifiers, finalize, created_at, updated_at, replaces,
+				auto_renewal, auto_renewal_end, tenant, profile
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9, $10, $11, $12, $13, $14)`,
+			order.ID,
+			order.AccountID,
+			order.Status,
+			timestamp{order.ExpiresAt.Time},
+			timestamp{order.NotBefore.Time},
+			timestamp{order.NotAfter.Time},
+			string(identifiersJSON),
+			order.Finalize,
+			timestamp{now},
+			nullString(order.Replaces),
+			nullJSON(autoRenewalJSON),
+			timestamp{autoRenewalEnd},
+			order.Tenant,
+			nullString(order.Profile),
+		)
+		if err != nil {
+			return fmt.Errorf("error creating order: %w", err)
+		}
+
+		for _, authz := range authzs {
+			authz.OrderID = order.ID
+			if err := createAuthorizationTx(ctx, tx, authz, now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetOrder retrieves an order with the IDs of its authorizations
+func (db *DB) GetOrder(ctx context.Context, id string) (*types.Order, error) {
+	var order types.Order
+	var identifiersJSON, autoRenewalJSON, errorJSON []byte
+	var certificateID, replaces, csr, profile sql.NullString
+	var expiresAt, notBefore, notAfter, createdAt, updatedAt timestamp
+
+	err := db.QueryRowContext(ctx, `
+		SELECT id, account_id, status, expires_at, not_before, not_after,
+			   identifiers, finalize, certificate_id, replaces, auto_renewal, csr,
+			   error, created_at, updated_at, tenant, profile
+		FROM orders
+		WHERE id = $1`,
+		id,
+	).Scan(
+		&order.ID,
+		&order.AccountID,
+		&order.Status,
+		&expiresAt,
+		&notBefore,
+		&notAfter,
+		&identifiersJSON,
+		&order.Finalize,
+		&certificateID,
+		&replaces,
+		&autoRenewalJSON,
+		&csr,
+		&errorJSON,
+		&createdAt,
+		&updatedAt,
+		&order.Tenant,
+		&profile,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, orderNotFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying order: %w", err)
+	}
+
+	order.ExpiresAt = types.Time{Time: expiresAt.Time}
+	order.NotBefore = types.Time{Time: notBefore.Time}
+	order.NotAfter = types.Time{Time: notAfter.Time}
+	order.CreatedAt = types.Time{Time: createdAt.Time}
+	order.UpdatedAt = types.Time{Time: updatedAt.Time}
+	order.CertificateID = certificateID.String
+	order.Replaces = replaces.String
+	order.CSR = csr.String
+	order.Profile = profile.String
+
+	if err := json.Unmarshal(identifiersJSON, &order.Identifiers); err != nil {
+		return nil, fmt.Errorf("error unmarshaling identifiers: %w", err)
+	}
+	if errorJSON != nil {
+		order.Error = &types.Problem{}
+		if err := json.Unmarshal(errorJSON, order.Error); err != nil {
+			return nil, fmt.Errorf("error unmarshaling order error: %w", err)
+		}
+	}
+	if autoRenewalJSON != nil {
+		order.AutoRenewal = &types.AutoRenewal{}
+		if err := json.Unmarshal(autoRenewalJSON, order.AutoRenewal); err != nil {
+			return nil, fmt.Errorf("error unmarshaling auto-renewal: %w", err)
+		}
+	}
+
+	order.Authorizations, err = db.queryIDs(ctx, `
+		SELECT id FROM authorizations
+		WHERE order_id = $1
+		ORDER BY rowid`,
+		order.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying authorizations: %w", err)
+	}
+	if order.Authorizations == nil {
+		order.Authorizations = make([]string, 0)
+	}
+
+	return &order, nil
+}
+
+// UpdateOrder updates the status, certificate, CSR and error of an order
+func (db *DB) UpdateOrder(ctx context.Context, order *types.Order) error {
+	var errorJSON []byte
+	if order.Error != nil {
+		var err error
+		if errorJSON, err = json.Marshal(order.Error); err != nil {
+			return fmt.Errorf("error marshaling order error: %w", err)
+		}
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $2, certificate_id = $3, updated_at = $4, csr = $5, error = $6
+		WHERE id = $1`,
+		order.ID,
+		order.Status,
+		nullString(order.CertificateID),
+		timestamp{order.UpdatedAt.Time},
+		nullString(order.CSR),
+		nullJSON(errorJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating order: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	if affected == 0 {
+		return orderNotFound(order.ID)
+	}
+	return nil
+}
+
+// ClaimOrderIssuance leases a processing order to the caller until the
+// given time. It returns false if the order is no longer processing or
+// another worker holds an unexpired lease.
+func (db *DB) ClaimOrderIssuance(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE orders
+		SET issuance_claimed_until = $3
+		WHERE id = $1
+		AND status = $4
+		AND (issuance_claimed_until IS NULL OR issuance_claimed_until <= $2)`,
+		id, timestamp{now}, timestamp{until}, types.OrderStatusProcessing,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error claiming order: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// FailOrderIssuance moves a processing order to invalid with the error of
+// the order. It returns false if the order is no longer processing, e.g.
+// because another worker completed it.
+func (db *DB) FailOrderIssuance(ctx context.Context, order *types.Order) (bool, error) {
+	errorJSON, err := json.Marshal(order.Error)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling order error: %w", err)
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $2, updated_at = $3, error = $4
+		WHERE id = $1
+		AND status = $5`,
+		order.ID,
+		types.OrderStatusInvalid,
+		timestamp{order.UpdatedAt.Time},
+		nullJSON(errorJSON),
+		types.OrderStatusProcessing,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error failing order: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// CompleteOrderIssuance stores the certificate issued for a processing order
+// and moves the order to valid in a single transaction. It returns false
+// without storing anything if the order is no longer processing.
+func (db *DB) CompleteOrderIssuance(ctx context.Context, order *types.Order, cert *types.Certificate) (bool, error) {
+	completed := false
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE orders
+			SET status = $2, certificate_id = $3, updated_at = $4, error = NULL,
+				issuance_claimed_until = NULL
+			WHERE id = $1
+			AND status = $5`,
+			order.ID,
+			types.OrderStatusValid,
+			cert.ID,
+			timestamp{time.Now()},
+			types.OrderStatusProcessing,
+		)
+		if err != nil {
+			return fmt.Errorf("error completing order: %w", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error fetching rows affected: %w", err)
+		}
+		if affected == 0 {
+			return nil
+		}
+
+		if err := insertCertificate(ctx, tx, cert); err != nil {
+			return err
+		}
+
+		completed = true
+		return nil
+	})
+
+	return completed, err
+}
+
+// ListProcessingOrderIDs returns the IDs of orders waiting for certificate
+// issuance
+func (db *DB) ListProcessingOrderIDs(ctx context.Context) ([]string, error) {
+	ids, err := db.queryIDs(ctx, `
+		SELECT id FROM orders
+		WHERE status = $1
+		ORDER BY updated_at`,
+		types.OrderStatusProcessing,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying processing orders: %w", err)
+	}
+	return ids, nil
+}
+
+// ListActiveStarOrderIDs returns the IDs of finalized STAR orders of valid
+// accounts that have not been canceled and whose end-date has not passed
+func (db *DB) ListActiveStarOrderIDs(ctx context.Context, now time.Time) ([]string, error) {
+	ids, err := db.queryIDs(ctx, `
+		SELECT o.id FROM orders o
+		JOIN accounts a ON a.id = o.account_id
+		WHERE o.status = $1
+		AND o.auto_renewal IS NOT NULL
+		AND o.csr IS NOT NULL
+		AND o.auto_renewal_end > $2
+		AND a.status = $3`,
+		types.OrderStatusValid, timestamp{now}, types.AccountStatusValid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying STAR orders: %w", err)
+	}
+	return ids, nil
+}
+
+// ClaimStarRenewal leases a valid STAR order to the caller until the given
+// time. It returns false if the order is not valid or another renewer
+// holds an unexpired lease.
+func (db *DB) ClaimStarRenewal(ctx context.Context, id string, now, until time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE orders
+		SET issuance_claimed_until = $3
+		WHERE id = $1
+		AND status = $4
+		AND auto_renewal IS NOT NULL
+		AND (issuance_claimed_until IS NULL OR issuance_claimed_until <= $2)`,
+		id, timestamp{now}, timestamp{until}, types.OrderStatusValid,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error claiming order: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// CompleteStarRenewal stores the renewed certificate of a valid STAR order
+// and releases the lease in a single transaction. It returns false without
+// storing anything if the order is no longer valid or its latest
+// certificate no longer ends at previousNotAfter, i.e. another renewer got
+// there first.
+func (db *DB) CompleteStarRenewal(ctx context.Context, cert *types.Certificate, previousNotAfter time.Time) (bool, error) {
+	completed := false
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE orders
+			SET issuance_claimed_until = NULL
+			WHERE id = $1
+			AND status = $2
+			AND NOT EXISTS (
+				SELECT 1 FROM certificates
+				WHERE order_id = $1
+				AND not_after > $3
+			)`,
+			cert.OrderID, types.OrderStatusValid, timestamp{previousNotAfter},
+		)
+		if err != nil {
+			return fmt.Errorf("error completing renewal: %w", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error fetching rows affected: %w", err)
+		}
+		if affected == 0 {
+			return nil
+		}
+
+		if err := insertCertificate(ctx, tx, cert); err != nil {
+			return err
+		}
+
+		completed = true
+		return nil
+	})
+
+	return completed, err
+}
+
+// ExpireOrders transitions pending and ready orders whose expiry has passed
+// to invalid. It returns the number of orders that were updated.
+func (db *DB) ExpireOrders(ctx context.Context, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $1, updated_at = $2
+		WHERE status IN ('pending', 'ready')
+		AND expires_at < $2`,
+		types.OrderStatusInvalid, timestamp{now},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error expiring orders: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// PurgeOrders deletes finished orders that have not been updated since the
+// cutoff, together with their authorizations and challenges: invalid and
+// canceled orders, and valid orders whose certificates have all been
+// purged. Orders that still reference a certificate are kept. It returns
+// the number of orders that were deleted.
+func (db *DB) PurgeOrders(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		const purgeable = `
+			SELECT id FROM orders
+			WHERE status IN ('invalid', 'canceled', 'valid')
+			AND updated_at < $1
+			AND id NOT IN (SELECT order_id FROM certificates)`
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM challenges
+			WHERE authorization_id IN (
+				SELECT id FROM authorizations
+				WHERE order_id IN (`+purgeable+`)
+			)`, timestamp{before}); err != nil {
+			return fmt.Errorf("error purging challenges: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM authorizations
+			WHERE order_id IN (`+purgeable+`)`, timestamp{before}); err != nil {
+			return fmt.Errorf("error purging authorizations: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM orders
+			WHERE id IN (`+purgeable+`)`, timestamp{before})
+		if err != nil {
+			return fmt.Errorf("error purging orders: %w", err)
+		}
+
+		purged, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error fetching rows affected: %w", err)
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// PurgeAuthorizations deletes deactivated, expired, invalid and revoked
+// authorizations that have not been updated since the cutoff, together with
+// their challenges. It returns the number of authorizations that were
+// deleted.
+func (db *DB) PurgeAuthorizations(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		const purgeable = `
+			SELECT id FROM authorizations
+			WHERE status IN ('deactivated', 'expired', 'invalid', 'revoked')
+			AND updated_at < $1`
+
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM challenges
+			WHERE authorization_id IN (`+purgeable+`)`, timestamp{before}); err != nil {
+			return fmt.Errorf("error purging challenges: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM authorizations
+			WHERE id IN (`+purgeable+`)`, timestamp{before})
+		if err != nil {
+			return fmt.Errorf("error purging authorizations: %w", err)
+		}
+
+		purged, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error fetching rows affected: %w", err)
+		}
+		return nil
+	})
+
+	return purged, err
+}
+
+// PurgeExpiredCertificates deletes certificates that expired before the
+// cutoff. Their orders are purged by PurgeOrders once no certificate is
+// left. It returns the number of certificates that were deleted.
+func (db *DB) PurgeExpiredCertificates(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM certificates
+		WHERE not_after < $1`, timestamp{before})
+	if err != nil {
+		return 0, fmt.Errorf("error purging certificates: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// CreateAuthorization stores an authorization and its challenges
+func (db *DB) CreateAuthorization(ctx context.Context, authz *types.Authorization) error {
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		return createAuthorizationTx(ctx, tx, authz, time.Now())
+	})
+}
+
+// createAuthorizationTx inserts an authorization and its challenges using
+// the provided transaction
+func createAuthorizationTx(ctx context.Context, tx *sql.Tx, authz *types.Authorization, now time.Time) error {
+	identifierJSON, err := json.Marshal(authz.Identifier)
+	if err != nil {
+		return fmt.Errorf("error marshaling identifier: %w", err)
+	}
+
+	var expires time.Time
+	if authz.Expires != nil {
+		expires = authz.Expires.Time
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO authorizations (id, order_id, status, expires_at, identifier, wildcard, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+		authz.ID,
+		authz.OrderID,
+		authz.Status,
+		timestamp{expires},
+		string(identifierJSON),
+		authz.Wildcard,
+		timestamp{now},
+	); err != nil {
+		return fmt.Errorf("error creating authorization: %w", err)
+	}
+
+	for i := range authz.Challenges {
+		challenge := &authz.Challenges[i]
+		challenge.AuthorizationID = authz.ID
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO challenges (id, authorization_id, type, url, status, token, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+			challenge.ID,
+			challenge.AuthorizationID,
+			challenge.Type,
+			challenge.URL,
+			challenge.Status,
+			challenge.Token,
+			timestamp{now},
+		); err != nil {
+			return fmt.Errorf("failed to create %s challenge: %w", challenge.Type, err)
+		}
+	}
+
+	return nil
+}
+
+const authorizationColumns = `
+		id, order_id, status, expires_at, identifier, wildcard, created_at, updated_at`
+
+// scanAuthorization scans a row selected with authorizationColumns
+func scanAuthorization(row interface{ Scan(...any) error }) (*types.Authorization, error) {
+	var authz types.Authorization
+	var identifierJSON []byte
+	var expiresAt, createdAt, updatedAt timestamp
+
+	if err := row.Scan(
+		&authz.ID,
+		&authz.OrderID,
+		&authz.Status,
+		&expiresAt,
+		&identifierJSON,
+		&authz.Wildcard,
+		&createdAt,
+		&updatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	authz.Expires = &types.Time{Time: expiresAt.Time}
+	authz.CreatedAt = createdAt.Time
+	authz.UpdatedAt = updatedAt.Time
+	if err := json.Unmarshal(identifierJSON, &authz.Identifier); err != nil {
+		return nil, fmt.Errorf("error parsing identifier: %w", err)
+	}
+
+	return &authz, nil
+}
+
+// GetAuthorization retrieves an authorization and its challenges
+func (db *DB) GetAuthorization(ctx context.Context, id string) (*types.Authorization, error) {
+	authz, err := scanAuthorization(db.QueryRowContext(ctx, `SELECT`+authorizationColumns+`
+		FROM authorizations
+		WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("authorization not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying authorization: %w", err)
+	}
+
+	challenges, err := db.GetChallengesByAuthorization(ctx, authz.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, challenge := range challenges {
+		// The handler sets the URL based on the request
+		authz.Challenges = append(authz.Challenges, types.Challenge{
+			Type:   challenge.Type,
+			Status: challenge.Status,
+			Token:  challenge.Token,
+		})
+	}
+
+	return authz, nil
+}
+
+// GetAuthorizationsByOrder retrieves all authorizations of an order
+func (db *DB) GetAuthorizationsByOrder(ctx context.Context, orderID string) ([]*types.Authorization, error) {
+	rows, err := db.QueryContext(ctx, `SELECT`+authorizationColumns+`
+		FROM authorizations
+		WHERE order_id = $1
+		ORDER BY rowid`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	var authzs []*types.Authorization
+	for rows.Next() {
+		authz, err := scanAuthorization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning authorization: %w", err)
+		}
+		authzs = append(authzs, authz)
+	}
+	return authzs, rows.Err()
+}
+
+// UpdateAuthorization updates the status of an authorization
+func (db *DB) UpdateAuthorization(ctx context.Context, authz *types.Authorization) error {
+	if err := db.UpdateAuthorizationStatus(ctx, authz.ID, string(authz.Status)); err != nil {
+		return fmt.Errorf("error updating authorization: %w", err)
+	}
+	return nil
+}
+
+// UpdateAuthorizationStatus sets the status of an authorization
+func (db *DB) UpdateAuthorizationStatus(ctx context.Context, authzID string, status string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE authorizations
+		SET status = $2, updated_at = $3
+		WHERE id = $1`,
+		authzID, status, timestamp{time.Now()},
+	)
+	if err != nil {
+		return fmt.Errorf("error updating authorization status: %w", err)
+	}
+	return requireAffected(res, fmt.Sprintf("authorization not found: %s", authzID))
+}
+
+// DeactivateAuthorization marks an authorization as deactivated and stops
+// the order that depends on it: a pending or ready order becomes invalid, a
+// valid STAR order is canceled so that it is no longer renewed. It returns
+// the number of orders that were stopped.
+func (db *DB) DeactivateAuthorization(ctx context.Context, authzID string) (int64, error) {
+	var invalidated int64
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		now := timestamp{time.Now()}
+
+		var orderID string
+		err := tx.QueryRowContext(ctx, `
+			UPDATE authorizations
+			SET status = $2, updated_at = $3
+			WHERE id = $1
+			AND status IN ('pending', 'valid')
+			RETURNING order_id`,
+			authzID, types.AuthzStatusDeactivated, now,
+		).Scan(&orderID)
+
+		if err == sql.ErrNoRows {
+			return &types.Problem{
+				Type:   "urn:ietf:params:acme:error:malformed",
+				Detail: fmt.Sprintf("authorization %s cannot be deactivated in its current state", authzID),
+				Status: http.StatusBadRequest,
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error deactivating authorization: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE orders
+			SET status = CASE WHEN status IN ('pending', 'ready') THEN $2 ELSE $4 END,
+				updated_at = $3
+			WHERE id = $1
+			AND (status IN ('pending', 'ready')
+				OR (status = 'valid' AND auto_renewal IS NOT NULL))`,
+			orderID, types.OrderStatusInvalid, now, types.OrderStatusCanceled,
+		)
+		if err != nil {
+			return fmt.Errorf("error stopping order: %w", err)
+		}
+
+		invalidated, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error fetching rows affected: %w", err)
+		}
+		return nil
+	})
+
+	return invalidated, err
+}
+
+// ExpireAuthorizations transitions pending and valid authorizations whose
+// expiry has passed to expired. It returns the number of authorizations that
+// were updated.
+func (db *DB) ExpireAuthorizations(ctx context.Context, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE authorizations
+		SET status = $1, updated_at = $2
+		WHERE status IN ('pending', 'valid')
+		AND expires_at < $2`,
+		types.AuthzStatusExpired, timestamp{now},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error expiring authorizations: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+const challengeColumns = `
+		id, authorization_id, type, url, status, token, validated`
+
+// scanChallenge scans a row selected with challengeColumns
+func scanChallenge(row interface{ Scan(...any) error }) (*types.Challenge, error) {
+	var c types.Challenge
+	var validated timestamp
+
+	if err := row.Scan(
+		&c.ID,
+		&c.AuthorizationID,
+		&c.Type,
+		&c.URL,
+		&c.Status,
+		&c.Token,
+		&validated,
+	); err != nil {
+		return nil, err
+	}
+
+	if !validated.IsZero() {
+		c.Validated = &types.Time{Time: validated.Time}
+	}
+	return &c, nil
+}
+
+// GetChallenge retrieves the challenge with the given token
+func (db *DB) GetChallenge(ctx context.Context, token string) (*types.Challenge, error) {
+	c, err := scanChallenge(db.QueryRowContext(ctx, `SELECT`+challengeColumns+`
+		FROM challenges
+		WHERE token = $1`, token))
+	if err != nil {
+		return nil, fmt.Errorf("error getting challenge: %w", err)
+	}
+	return c, nil
+}
+
+// GetChallengesByAuthorization retrieves the challenges of an authorization
+func (db *DB) GetChallengesByAuthorization(ctx context.Context, authzID string) ([]types.Challenge, error) {
+	rows, err := db.QueryContext(ctx, `SELECT`+challengeColumns+`
+		FROM challenges
+		WHERE authorization_id = $1
+		ORDER BY rowid`, authzID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying challenges: %w", err)
+	}
+	defer rows.Close()
+
+	var challenges []types.Challenge
+	for rows.Next() {
+		c, err := scanChallenge(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning challenge: %w", err)
+		}
+		challenges = append(challenges, *c)
+	}
+	return challenges, rows.Err()
+}
+
+// UpdateChallengeStatus sets the status of the challenge with the given token
+// and records the time of validation when it becomes valid
+func (db *DB) UpdateChallengeStatus(ctx context.Context, token string, status string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE challenges
+		SET status = $1, updated_at = $2,
+			validated = CASE WHEN $1 = 'valid' THEN $2 ELSE validated END
+		WHERE token = $3`,
+		status, timestamp{time.Now()}, token,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating challenge status: %w", err)
+	}
+	return requireAffected(res, "challenge not found")
+}
+
+// ExpireChallenges transitions pending and processing challenges that belong
+// to an authorization in a terminal state to invalid. It returns the number
+// of challenges that were updated.
+func (db *DB) ExpireChallenges(ctx context.Context, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE challenges
+		SET status = $1, updated_at = $2
+		WHERE status IN ('pending', 'processing')
+		AND authorization_id IN (
+			SELECT id FROM authorizations
+			WHERE status IN ('expired', 'invalid', 'deactivated', 'revoked')
+		)`,
+		types.ChallengeStatusInvalid, timestamp{now},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error expiring challenges: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+const certificateColumns = `
+		id, order_id, certificate, serial, authority_key_id, not_before, not_after,
+		replaced_by, revoked, revocation_reason, revoked_at, created_at`
+
+// scanCertificate scans a row selected with certificateColumns
+func scanCertificate(row interface{ Scan(...any) error }) (*types.Certificate, error) {
+	var cert types.Certificate
+	var serial, aki, replacedBy, reason sql.NullString
+	var notBefore, notAfter, revokedAt, createdAt timestamp
+
+	if err := row.Scan(
+		&cert.ID,
+		&cert.OrderID,
+		&cert.Certificate,
+		&serial,
+		&aki,
+		&notBefore,
+		&notAfter,
+		&replacedBy,
+		&cert.Revoked,
+		&reason,
+		&revokedAt,
+		&createdAt,
+	); err != nil {
+		return nil, err
+	}
+
+	cert.Serial = serial.String
+	cert.AuthorityKeyID = aki.String
+	cert.NotBefore = types.Time{Time: notBefore.Time}
+	cert.NotAfter = types.Time{Time: notAfter.Time}
+	cert.ReplacedBy = replacedBy.String
+	cert.RevocationReason = reason.String
+	cert.RevokedAt = types.Time{Time: revokedAt.Time}
+	cert.CreatedAt = types.Time{Time: createdAt.Time}
+
+	return &cert, nil
+}
+
+// queryCertificate runs a query selecting certificateColumns and returns
+// the first certificate, or a not-found problem with the given detail
+func (db *DB) queryCertificate(ctx context.Context, notFound string, query string, args ...any) (*types.Certificate, error) {
+	cert, err := scanCertificate(db.QueryRowContext(ctx, `SELECT`+certificateColumns+query, args...))
+	if err == sql.ErrNoRows {
+		return nil, &types.Problem{
+			Type:   "urn:ietf:params:acme:error:malformed",
+			Detail: notFound,
+			Status: http.StatusNotFound,
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// insertCertificate stores a certificate using the provided transaction
+func insertCertificate(ctx context.Context, tx *sql.Tx, cert *types.Certificate) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO certificates (id, order_id, certificate, serial, authority_key_id,
+			not_before, not_after, revoked, revocation_reason, revoked_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		cert.ID,
+		cert.OrderID,
+		cert.Certificate,
+		nullString(cert.Serial),
+		nullString(cert.AuthorityKeyID),
+		timestamp{cert.NotBefore.Time},
+		timestamp{cert.NotAfter.Time},
+		cert.Revoked,
+		nullString(cert.RevocationReason),
+		timestamp{cert.RevokedAt.Time},
+		timestamp{time.Now()},
+	); err != nil {
+		return fmt.Errorf("error creating certificate: %w", err)
+	}
+	return nil
+}
+
+// CreateCertificate stores an issued certificate
+func (db *DB) CreateCertificate(ctx context.Context, cert *types.Certificate) error {
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		return insertCertificate(ctx, tx, cert)
+	})
+}
+
+// GetCertificate retrieves a certificate by ID
+func (db *DB) GetCertificate(ctx context.Context, id string) (*types.Certificate, error) {
+	return db.queryCertificate(ctx, fmt.Sprintf("certificate %s does not exist", id), `
+		FROM certificates
+		WHERE id = $1`, id)
+}
+
+// GetCertificateBySerial retrieves a certificate by its issuer's authority
+// key identifier and its hex encoded serial number.
+func (db *DB) GetCertificateBySerial(ctx context.Context, aki string, serial string) (*types.Certificate, error) {
+	return db.queryCertificate(ctx, fmt.Sprintf("certificate with serial %s does not exist", serial), `
+		FROM certificates
+		WHERE serial = $1
+		AND authority_key_id = $2`, serial, aki)
+}
+
+// GetLatestCertificateByOrder returns the certificate of an order that
+// expires last. For STAR orders this is the most recently issued one.
+func (db *DB) GetLatestCertificateByOrder(ctx context.Context, orderID string) (*types.Certificate, error) {
+	return db.queryCertificate(ctx, fmt.Sprintf("order %s has no certificate", orderID), `
+		FROM certificates
+		WHERE order_id = $1
+		ORDER BY not_after DESC
+		LIMIT 1`, orderID)
+}
+
+// GetCurrentCertificateByOrder returns the certificate of an order that is
+// valid at the given time and expires last. If no certificate is valid yet,
+// the earliest one is returned.
+func (db *DB) GetCurrentCertificateByOrder(ctx context.Context, orderID string, now time.Time) (*types.Certificate, error) {
+	return db.queryCertificate(ctx, fmt.Sprintf("order %s has no certificate", orderID), `
+		FROM certificates
+		WHERE order_id = $1
+		ORDER BY (not_before <= $2) DESC,
+			CASE WHEN not_before <= $2 THEN not_after END DESC,
+			not_before ASC
+		LIMIT 1`, orderID, timestamp{now})
+}
+
+// MarkCertificateReplaced links a certificate to the certificate that
+// replaced it (RFC 9773 Section 5)
+func (db *DB) MarkCertificateReplaced(ctx context.Context, id string, replacedBy string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE certificates
+		SET replaced_by = $2
+		WHERE id = $1`,
+		id, replacedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("error marking certificate as replaced: %w", err)
+	}
+	return requireAffected(res, fmt.Sprintf("certificate not found: %s", id))
+}
+
+// GetRenewalOverride returns the operator-defined renewal window for a
+// certificate. A window set on the certificate itself takes precedence over
+// one set for its issuer. It returns nil if no override exists.
+func (db *DB) GetRenewalOverride(ctx context.Context, cert *types.Certificate) (*types.RenewalOverride, error) {
+	var start, end timestamp
+	var explanationURL sql.NullString
+
+	err := db.QueryRowContext(ctx, `
+		SELECT renewal_window_start, renewal_window_end, renewal_explanation_url
+		FROM certificates
+		WHERE id = $1`,
+		cert.ID,
+	).Scan(&start, &end, &explanationURL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying certificate renewal window: %w", err)
+	}
+
+	if start.IsZero() || end.IsZero() {
+		err = db.QueryRowContext(ctx, `
+			SELECT window_start, window_end, explanation_url
+			FROM issuer_renewal_overrides
+			WHERE authority_key_id = $1`,
+			cert.AuthorityKeyID,
+		).Scan(&start, &end, &explanationURL)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error querying issuer renewal window: %w", err)
+		}
+	}
+
+	return &types.RenewalOverride{
+		Window: types.RenewalWindow{
+			Start: types.Time{Time: start.Time},
+			End:   types.Time{Time: end.Time},
+		},
+		ExplanationURL: explanationURL.String,
+	}, nil
+}
+
+// SetCertificateRenewalWindow overrides the suggested renewal window of a
+// single certificate. A nil override removes it.
+func (db *DB) SetCertificateRenewalWindow(ctx context.Context, id string, override *types.RenewalOverride) error {
+	var start, end timestamp
+	var explanationURL sql.NullString
+	if override != nil {
+		start = timestamp{override.Window.Start.Time}
+		end = timestamp{override.Window.End.Time}
+		explanationURL = nullString(override.ExplanationURL)
+	}
+
+	res, err := db.ExecContext(ctx, `
+		UPDATE certificates
+		SET renewal_window_start = $2, renewal_window_end = $3, renewal_explanation_url = $4
+		WHERE id = $1`,
+		id, start, end, explanationURL,
+	)
+	if err != nil {
+		return fmt.Errorf("error setting certificate renewal window: %w", err)
+	}
+	return requireAffected(res, fmt.Sprintf("certificate not found: %s", id))
+}
+
+// SetIssuerRenewalWindow overrides the suggested renewal window of every
+// certificate issued under the given authority key identifier. A nil
+// override removes it.
+func (db *DB) SetIssuerRenewalWindow(ctx context.Context, aki string, override *types.RenewalOverride) error {
+	if override == nil {
+		if _, err := db.ExecContext(ctx, `
+			DELETE FROM issuer_renewal_overrides
+			WHERE authority_key_id = $1`, aki); err != nil {
+			return fmt.Errorf("error removing issuer renewal window: %w", err)
+		}
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO issuer_renewal_overrides (authority_key_id, window_start, window_end, explanation_url)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (authority_key_id) DO UPDATE
+		SET window_start = excluded.window_start,
+			window_end = excluded.window_end,
+			explanation_url = excluded.explanation_url`,
+		aki,
+		timestamp{override.Window.Start.Time},
+		timestamp{override.Window.End.Time},
+		nullString(override.ExplanationURL),
+	)
+	if err != nil {
+		return fmt.Errorf("error setting issuer renewal window: %w", err)
+	}
+	return nil
+}
+
+// CreateNonce stores a newly issued replay nonce
+func (db *DB) CreateNonce(ctx context.Context, nonce string, createdAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO nonces (nonce, created_at)
+		VALUES ($1, $2)`,
+		nonce, timestamp{createdAt},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating nonce: %w", err)
+	}
+	return nil
+}
+
+// ConsumeNonce deletes a nonce and reports whether it existed and was issued
+// after notBefore
+func (db *DB) ConsumeNonce(ctx context.Context, nonce string, notBefore time.Time) (bool, error) {
+	var createdAt timestamp
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM nonces
+		WHERE nonce = $1
+		RETURNING created_at`,
+		nonce,
+	).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error consuming nonce: %w", err)
+	}
+	return !createdAt.Before(notBefore), nil
+}
+
+// nonceCleanupBatchSize bounds the rows removed by one DELETE so that the
+// cleanup of a large backlog does not hold locks for long
+const nonceCleanupBatchSize = 1000
+
+// DeleteExpiredNonces removes nonces issued before the given time in batches.
+// It returns the number of nonces that were deleted.
+func (db *DB) DeleteExpiredNonces(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		res, err := db.ExecContext(ctx, `
+			DELETE FROM nonces
+			WHERE nonce IN (
+				SELECT nonce FROM nonces
+				WHERE created_at < $1
+				LIMIT $2
+			)`,
+			timestamp{before}, nonceCleanupBatchSize,
+		)
+		if err != nil {
+			return total, fmt.Errorf("error deleting expired nonces: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < nonceCleanupBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// CountNonces returns the number of stored nonces
+func (db *DB) CountNonces(ctx context.Context) (int64, error) {
+	var count int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM nonces`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting nonces: %w", err)
+	}
+	return count, nil
+}
+
+// spendRateLimitQuery takes a token from a bucket unless it is empty. It
+// returns no row for an empty bucket.
+const spendRateLimitQuery = `
+		INSERT INTO rate_limits (key, full_at)
+		VALUES ($1, $2 + $3)
+		ON CONFLICT (key) DO UPDATE
+		SET full_at = MAX(rate_limits.full_at, $2) + $3
+		WHERE MAX(rate_limits.full_at, $2) + $3 <= $2 + $4
+		RETURNING full_at`
+
+// SpendRateLimit takes a token from the bucket of key in a single upsert
+func (db *DB) SpendRateLimit(ctx context.Context, key string, now time.Time, interval, period time.Duration) (time.Time, bool, error) {
+	var fullAt int64
+	err := db.QueryRowContext(ctx, spendRateLimitQuery,
+		key, now.UnixNano(), int64(interval), int64(period),
+	).Scan(&fullAt)
+	if err == sql.ErrNoRows {
+		current, err := db.GetRateLimit(ctx, key)
+		return current, false, err
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error spending rate limit: %w", err)
+	}
+	return time.Unix(0, fullAt), true, nil
+}
+
+// errRateLimitEmpty rolls back the tokens taken by SpendRateLimits
+var errRateLimitEmpty = errors.New("rate limit bucket is empty")
+
+// SpendRateLimits takes a token from every bucket in one transaction. The
+// upserts lock the buckets, so concurrent spends cannot overdraw them; if
+// one of them is empty, the tokens taken before are rolled back.
+func (db *DB) SpendRateLimits(ctx context.Context, spends []types.RateLimitSpend, now time.Time) (int, time.Time, error) {
+	empty, emptyFullAt := -1, time.Time{}
+
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		for i, spend := range spends {
+			var fullAt int64
+			err := tx.QueryRowContext(ctx, spendRateLimitQuery,
+				spend.Key, now.UnixNano(), int64(spend.Interval), int64(spend.Period),
+			).Scan(&fullAt)
+			if err == sql.ErrNoRows {
+				if err := tx.QueryRowContext(ctx, `SELECT full_at FROM rate_limits WHERE key = $1`, spend.Key).Scan(&fullAt); err != nil {
+					return fmt.Errorf("error getting rate limit: %w", err)
+				}
+				empty, emptyFullAt = i, time.Unix(0, fullAt)
+				return errRateLimitEmpty
+			}
+			if err != nil {
+				return fmt.Errorf("error spending rate limit: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRateLimitEmpty) {
+		return -1, time.Time{}, err
+	}
+	return empty, emptyFullAt, nil
+}
+
+// GetRateLimit returns the time the bucket of key is full again
+func (db *DB) GetRateLimit(ctx context.Context, key string) (time.Time, error) {
+	var fullAt int64
+	err := db.QueryRowContext(ctx, `SELECT full_at FROM rate_limits WHERE key = $1`, key).Scan(&fullAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting rate limit: %w", err)
+	}
+	return time.Unix(0, fullAt), nil
+}
+
+// DeleteFullRateLimits removes the buckets that are full again at now
+func (db *DB) DeleteFullRateLimits(ctx context.Context, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at <= $1`, now.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("error deleting full rate limits: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// AppendAuditRecord adds an entry to the audit log. It returns false if an
+// entry with the same sequence number exists.
+func (db *DB) AppendAuditRecord(ctx context.Context, record *types.AuditRecord) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO audit_log (seq, event, created_at, entry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (seq) DO NOTHING`,
+		record.Seq, record.Event, timestamp{record.CreatedAt}, string(record.Entry),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error appending audit record: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// GetLastAuditRecord returns the latest audit log entry, or nil if the log
+// is empty
+func (db *DB) GetLastAuditRecord(ctx context.Context) (*types.AuditRecord, error) {
+	records, err := db.queryAuditRecords(ctx, `
+		SELECT seq, event, created_at, entry
+		FROM audit_log
+		ORDER BY seq DESC
+		LIMIT 1`)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// ListAuditRecords returns up to limit audit log entries following afterSeq
+// in order
+func (db *DB) ListAuditRecords(ctx context.Context, afterSeq int64, limit int) ([]*types.AuditRecord, error) {
+	return db.queryAuditRecords(ctx, `
+		SELECT seq, event, created_at, entry
+		FROM audit_log
+		WHERE seq > $1
+		ORDER BY seq
+		LIMIT $2`, afterSeq, limit)
+}
+
+func (db *DB) queryAuditRecords(ctx context.Context, query string, args ...any) ([]*types.AuditRecord, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*types.AuditRecord
+	for rows.Next() {
+		var record types.AuditRecord
+		var createdAt timestamp
+		var entry string
+		if err := rows.Scan(&record.Seq, &record.Event, &createdAt, &entry); err != nil {
+			return nil, fmt.Errorf("error scanning audit record: %w", err)
+		}
+		record.CreatedAt = createdAt.Time
+		record.Entry = []byte(entry)
+		records = append(records, &record)
+	}
+	return records, rows.Err()
+}
+
+// CreateWebhookDeliveries queues deliveries of an event
+func (db *DB) CreateWebhookDeliveries(ctx context.Context, deliveries []*types.WebhookDelivery) error {
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, d := range deliveries {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO webhook_deliveries (id, endpoint, event, payload, attempts, next_attempt_at, last_error, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				d.ID, d.Endpoint, d.Event, string(d.Payload), d.Attempts,
+				timestamp{d.NextAttemptAt}, nullString(d.LastError), timestamp{d.CreatedAt},
+			)
+			if err != nil {
+				return fmt.Errorf("error queueing webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries that are due at now
+// and postpones them to until
+func (db *DB) ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]*types.WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+		)
+		RETURNING id, endpoint, event, payload, attempts, next_attempt_at, last_error, created_at`,
+		timestamp{now}, timestamp{until}, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*types.WebhookDelivery
+	for rows.Next() {
+		var d types.WebhookDelivery
+		var payload string
+		var lastError sql.NullString
+		var nextAttemptAt, createdAt timestamp
+		if err := rows.Scan(&d.ID, &d.Endpoint, &d.Event, &payload, &d.Attempts, &nextAttemptAt, &lastError, &createdAt); err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		d.Payload = []byte(payload)
+		d.NextAttemptAt = nextAttemptAt.Time
+		d.LastError = lastError.String
+		d.CreatedAt = createdAt.Time
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
+
+// UpdateWebhookDelivery stores the attempts, next attempt and last error of
+// a delivery
+func (db *DB) UpdateWebhookDelivery(ctx context.Context, d *types.WebhookDelivery) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET attempts = $2, next_attempt_at = $3, last_error = $4
+		WHERE id = $1`,
+		d.ID, d.Attempts, timestamp{d.NextAttemptAt}, nullString(d.LastError),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating webhook delivery: %w", err)
+	}
+	return requireAffected(res, fmt.Sprintf("webhook delivery %s not found", d.ID))
+}
+
+// DeleteWebhookDelivery removes a delivery from the queue
+func (db *DB) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error deleting webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// PurgeWebhookDeliveries deletes deliveries queued before the cutoff.
+// Finished deliveries are removed right away, so a delivery this old has
+// finished but could not be removed, or targets an endpoint that is gone.
+// It returns the number of deliveries that were deleted.
+func (db *DB) PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1`, timestamp{before})
+	if err != nil {
+		return 0, fmt.Errorf("error purging webhook deliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ListExpiringCertificates returns up to limit certificates that expire
+// between now and before and have not been announced as expiring. Revoked
+// and replaced certificates and those of STAR orders, which are renewed by
+// the server, are left out.
+func (db *DB) ListExpiringCertificates(ctx context.Context, now, before time.Time, limit int) ([]*types.Certificate, error) {
+	rows, err := db.QueryContext(ctx, `SELECT`+certificateColumns+`
+		FROM certificates
+		WHERE expiry_notified = 0
+		AND revoked = 0
+		AND replaced_by IS NULL
+		AND not_after > $1
+		AND not_after <= $2
+		AND order_id IN (SELECT id FROM orders WHERE auto_renewal IS NULL)
+		ORDER BY not_after
+		LIMIT $3`,
+		timestamp{now}, timestamp{before}, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing expiring certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certs []*types.Certificate
+	for rows.Next() {
+		cert, err := scanCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, rows.Err()
+}
+
+// MarkCertificateExpiryNotified records that a certificate was announced as
+// expiring. It returns false if it already was.
+func (db *DB) MarkCertificateExpiryNotified(ctx context.Context, id string) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE certificates SET expiry_notified = 1
+		WHERE id = $1 AND expiry_notified = 0`, id)
+	if err != nil {
+		return false, fmt.Errorf("error marking certificate expiry notified: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// queryIDs runs a query selecting a single ID column
+func (db *DB) queryIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// requireAffected returns an error with the given message if a statement did
+// not change any row
+func requireAffected(res sql.Result, notFound string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error fetching rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errors.New(notFound)
+	}
+	return nil
+}
+
+func orderNotFound(id string) *types.Problem {
+	return &types.Problem{
+		Type:   "urn:ietf:params:acme:error:orderDoesNotExist",
+		Detail: fmt.Sprintf("order %s does not exist", id),
+		Status: http.StatusNotFound,
+	}
+}
@@ -0,0 +1,157 @@
+// Package sqlite implements the storage interface on an embedded SQLite
+// database for single-box edge deployments that cannot run PostgreSQL.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// DB represents a SQLite database
+type DB struct {
+	*sql.DB
+}
+
+// New opens the SQLite database at path, creating it if necessary, and
+// applies pending schema migrations. The special path ":memory:" opens a
+// private in-memory database.
+func New(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+
+	// SQLite serialises writers anyway; a single connection avoids
+	// SQLITE_BUSY errors and keeps ":memory:" databases on one connection
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA busy_timeout = 5000",
+	} {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error configuring database: %w", err)
+		}
+	}
+
+	if err := migrateUp(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating schema: %w", err)
+	}
+
+	return &DB{db}, nil
+}
+
+// Transaction executes a function within a database transaction
+func (db *DB) Transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p) // re-throw panic after rollback
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back transaction: %v (original error: %w)", rbErr, err)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// timeLayout is fixed-width so that the text representation of UTC times
+// sorts chronologically
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// timestamp maps time.Time to SQLite text. The zero time is stored as NULL
+// and NULL is scanned as the zero time.
+type timestamp struct {
+	time.Time
+}
+
+// Value implements driver.Valuer
+func (t timestamp) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.UTC().Format(timeLayout), nil
+}
+
+// Scan implements sql.Scanner
+func (t *timestamp) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into timestamp", src)
+	}
+
+	parsed, err := time.Parse(timeLayout, text)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", text, err)
+	}
+	t.Time = parsed
+	return nil
+}
+
+// nullString maps the empty string to SQL NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// marshalScope encodes an identifier scope; an empty scope is stored as NULL
+func marshalScope(scope []string) ([]byte, error) {
+	if len(scope) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(scope)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling scope: %w", err)
+	}
+	return b, nil
+}
+
+// unmarshalScope decodes a scope stored by marshalScope
+func unmarshalScope(b []byte) ([]string, error) {
+	if b == nil {
+		return nil, nil
+	}
+	var scope []string
+	if err := json.Unmarshal(b, &scope); err != nil {
+		return nil, fmt.Errorf("error unmarshaling scope: %w", err)
+	}
+	return scope, nil
+}
+
+// nullJSON maps a nil JSON document to SQL NULL and stores others as text
+func nullJSON(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
@@ -0,0 +1,203 @@
+// Package storage defines the persistence interface of the ACME server and
+// selects one of its backends: PostgreSQL for clustered deployments, SQLite
+// for single-box edge deployments and an in-memory store for tests.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/database"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/sqlite"
+)
+
+// Supported storage backends
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// AccountStore persists ACME accounts
+type AccountStore interface {
+	CreateAccount(ctx context.Context, account *types.Account) error
+	GetAccount(ctx context.Context, id string) (*types.Account, error)
+	UpdateAccount(ctx context.Context, account *types.Account) error
+}
+
+// OrderStore persists orders. Orders returned by the store list the IDs of
+// their authorizations; handlers turn them into URLs.
+type OrderStore interface {
+	CreateOrder(ctx context.Context, order *types.Order, authzs []*types.Authorization) error
+	GetOrder(ctx context.Context, id string) (*types.Order, error)
+	UpdateOrder(ctx context.Context, order *types.Order) error
+	ClaimOrderIssuance(ctx context.Context, id string, now, until time.Time) (bool, error)
+	CompleteOrderIssuance(ctx context.Context, order *types.Order, cert *types.Certificate) (bool, error)
+	FailOrderIssuance(ctx context.Context, order *types.Order) (bool, error)
+	ListProcessingOrderIDs(ctx context.Context) ([]string, error)
+	ListActiveStarOrderIDs(ctx context.Context, now time.Time) ([]string, error)
+	ClaimStarRenewal(ctx context.Context, id string, now, until time.Time) (bool, error)
+	CompleteStarRenewal(ctx context.Context, cert *types.Certificate, previousNotAfter time.Time) (bool, error)
+	ExpireOrders(ctx context.Context, now time.Time) (int64, error)
+	PurgeOrders(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuthorizationStore persists authorizations
+type AuthorizationStore interface {
+	CreateAuthorization(ctx context.Context, authz *types.Authorization) error
+	GetAuthorization(ctx context.Context, id string) (*types.Authorization, error)
+	GetAuthorizationsByOrder(ctx context.Context, orderID string) ([]*types.Authorization, error)
+	UpdateAuthorization(ctx context.Context, authz *types.Authorization) error
+	UpdateAuthorizationStatus(ctx context.Context, authzID string, status string) error
+	DeactivateAuthorization(ctx context.Context, authzID string) (int64, error)
+	ExpireAuthorizations(ctx context.Context, now time.Time) (int64, error)
+	PurgeAuthorizations(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ChallengeStore persists challenges. Challenges are looked up by token.
+type ChallengeStore interface {
+	GetChallenge(ctx context.Context, token string) (*types.Challenge, error)
+	GetChallengesByAuthorization(ctx context.Context, authzID string) ([]types.Challenge, error)
+	UpdateChallengeStatus(ctx context.Context, token string, status string) error
+	ExpireChallenges(ctx context.Context, now time.Time) (int64, error)
+}
+
+// CertificateStore persists issued certificates and their renewal windows
+type CertificateStore interface {
+	CreateCertificate(ctx context.Context, cert *types.Certificate) error
+	GetCertificate(ctx context.Context, id string) (*types.Certificate, error)
+	GetCertificateBySerial(ctx context.Context, aki string, serial string) (*types.Certificate, error)
+	GetLatestCertificateByOrder(ctx context.Context, orderID string) (*types.Certificate, error)
+	GetCurrentCertificateByOrder(ctx context.Context, orderID string, now time.Time) (*types.Certificate, error)
+	MarkCertificateReplaced(ctx context.Context, id string, replacedBy string) error
+	GetRenewalOverride(ctx context.Context, cert *types.Certificate) (*types.RenewalOverride, error)
+	SetCertificateRenewalWindow(ctx context.Context, id string, override *types.RenewalOverride) error
+	SetIssuerRenewalWindow(ctx context.Context, aki string, override *types.RenewalOverride) error
+	PurgeExpiredCertificates(ctx context.Context, before time.Time) (int64, error)
+}
+
+// NonceStore persists replay nonces. ConsumeNonce removes a nonce and
+// reports whether it existed and was issued after notBefore.
+type NonceStore interface {
+	CreateNonce(ctx context.Context, nonce string, createdAt time.Time) error
+	ConsumeNonce(ctx context.Context, nonce string, notBefore time.Time) (bool, error)
+	DeleteExpiredNonces(ctx context.Context, before time.Time) (int64, error)
+	CountNonces(ctx context.Context) (int64, error)
+}
+
+// RateLimitStore persists the token buckets of the rate limiter. A bucket
+// is stored as the time at which it is full again and holds period/interval
+// tokens. SpendRateLimit takes a token by moving that time interval further,
+// unless it would end up more than period after now. It returns the new time
+// and true, or the unchanged time and false if the bucket is empty.
+// GetRateLimit returns the zero time for a full bucket. SpendRateLimits takes
+// a token from every bucket in one transaction; if one of them is empty, it
+// takes none and returns the index of that bucket and the time it is full
+// again, otherwise -1.
+type RateLimitStore interface {
+	SpendRateLimit(ctx context.Context, key string, now time.Time, interval, period time.Duration) (time.Time, bool, error)
+	SpendRateLimits(ctx context.Context, spends []types.RateLimitSpend, now time.Time) (int, time.Time, error)
+	GetRateLimit(ctx context.Context, key string) (time.Time, error)
+	DeleteFullRateLimits(ctx context.Context, now time.Time) (int64, error)
+}
+
+// AuditStore persists the hash-chained audit log. AppendAuditRecord returns
+// false if an entry with the same sequence number exists, so that appends
+// of several replicas cannot fork the chain.
+type AuditStore interface {
+	AppendAuditRecord(ctx context.Context, record *types.AuditRecord) (bool, error)
+	GetLastAuditRecord(ctx context.Context) (*types.AuditRecord, error)
+	ListAuditRecords(ctx context.Context, afterSeq int64, limit int) ([]*types.AuditRecord, error)
+}
+
+// WebhookStore persists the webhook delivery queue and which certificates
+// were announced as expiring. ClaimWebhookDeliveries postpones the due
+// deliveries it returns, so that replicas do not send them at the same time
+// and deliveries interrupted by a crash are retried once the claim lapses.
+type WebhookStore interface {
+	CreateWebhookDeliveries(ctx context.Context, deliveries []*types.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]*types.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *types.WebhookDelivery) error
+	DeleteWebhookDelivery(ctx context.Context, id string) error
+	PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+	ListExpiringCertificates(ctx context.Context, now, before time.Time, limit int) ([]*types.Certificate, error)
+	MarkCertificateExpiryNotified(ctx context.Context, id string) (bool, error)
+}
+
+// AdminStore backs the operator admin API. Unlike the ACME lookups it also
+// returns deactivated accounts.
+type AdminStore interface {
+	ListAccounts(ctx context.Context, filter types.ListFilter) ([]*types.Account, error)
+	ListOrderIDs(ctx context.Context, filter types.ListFilter) ([]string, error)
+	ListCertificates(ctx context.Context, filter types.ListFilter) ([]*types.Certificate, error)
+	ListPendingChallenges(ctx context.Context, filter types.ListFilter) ([]types.Challenge, error)
+	RevokeCertificate(ctx context.Context, id string, reason string, revokedAt time.Time) error
+}
+
+// EABStore persists External Account Binding keys
+type EABStore interface {
+	CreateEABKey(ctx context.Context, key *types.EABKey) error
+	GetEABKey(ctx context.Context, id string) (*types.EABKey, error)
+	ListEABKeys(ctx context.Context) ([]*types.EABKey, error)
+	DeleteEABKey(ctx context.Context, id string) error
+}
+
+// Store is the complete persistence interface used by the handlers and the
+// background workers
+type Store interface {
+	AccountStore
+	OrderStore
+	AuthorizationStore
+	ChallengeStore
+	CertificateStore
+	NonceStore
+	RateLimitStore
+	AuditStore
+	WebhookStore
+	AdminStore
+	EABStore
+
+	PingContext(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Store = (*database.DB)(nil)
+	_ Store = (*sqlite.DB)(nil)
+	_ Store = (*memory.Store)(nil)
+)
+
+// Config selects and configures a storage backend
+type Config struct {
+	Backend    string
+	Postgres   database.Config
+	SQLitePath string
+}
+
+// Open creates the configured storage backend
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case BackendPostgres:
+		db, err := database.New(&cfg.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case BackendSQLite:
+		if cfg.SQLitePath == "" {
+			return nil, fmt.Errorf("sqlite backend requires a database path")
+		}
+		db, err := sqlite.New(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case BackendMemory:
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
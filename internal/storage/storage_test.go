@@ -0,0 +1,758 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+)
+
+// TestBackends runs the same scenario against every backend that does not
+// need an external server
+func TestBackends(t *testing.T) {
+	backends := map[string]Config{
+		BackendMemory: {Backend: BackendMemory},
+		BackendSQLite: {Backend: BackendSQLite, SQLitePath: ":memory:"},
+	}
+
+	for name, cfg := range backends {
+		t.Run(name, func(t *testing.T) {
+			store, err := Open(cfg)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer store.Close()
+
+			testAccounts(t, store)
+			testOrderLifecycle(t, store)
+			testStarRenewal(t, store)
+			testAdmin(t, store)
+			testExpiry(t, store)
+			testDeactivation(t, store)
+			testNonces(t, store)
+			testRateLimits(t, store)
+			testAuditLog(t, store)
+			testWebhooks(t, store)
+
+			empty, err := Open(cfg)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer empty.Close()
+			testPurge(t, empty)
+		})
+	}
+}
+
+func testAccounts(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	account := &types.Account{
+		ID:                   "acct_1",
+		Key:                  json.RawMessage(`{"kty":"EC"}`),
+		Contact:              []string{"mailto:ops@example.com"},
+		Status:               types.AccountStatusValid,
+		TermsOfServiceAgreed: true,
+		CreatedAt:            time.Now().Unix(),
+		InitialIP:            "192.0.2.1",
+	}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	got, err := store.GetAccount(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	if string(got.Key) != string(account.Key) || len(got.Contact) != 1 {
+		t.Errorf("GetAccount = %+v, want %+v", got, account)
+	}
+
+	account.Status = types.AccountStatusDeactivated
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	_, err = store.GetAccount(ctx, account.ID)
+	if _, ok := err.(*types.Problem); !ok {
+		t.Errorf("GetAccount error = %T, want *types.Problem", err)
+	}
+}
+
+func newTestOrder(t *testing.T, store Store, id string, expires time.Time) (*types.Order, *types.Authorization) {
+	t.Helper()
+
+	account := &types.Account{
+		ID:        "acct_" + id,
+		Key:       json.RawMessage(`{}`),
+		Status:    types.AccountStatusValid,
+		InitialIP: "192.0.2.1",
+	}
+	if err := store.CreateAccount(context.Background(), account); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+
+	order := &types.Order{
+		ID:          id,
+		AccountID:   account.ID,
+		Status:      types.OrderStatusPending,
+		ExpiresAt:   types.Time{Time: expires},
+		Identifiers: []types.Identifier{{Type: "dns", Value: "example.com"}},
+		Finalize:    "https://acme.example/order/" + id + "/finalize",
+		Tenant:      "ot",
+		Profile:     "tls-server",
+	}
+	authz := &types.Authorization{
+		ID:         "authz_" + id,
+		Status:     types.AuthzStatusPending,
+		Identifier: order.Identifiers[0],
+		Expires:    &types.Time{Time: expires},
+		Challenges: []types.Challenge{
+			{ID: "chall_" + id, Type: "http-01", Status: types.ChallengeStatusPending, Token: "token_" + id},
+		},
+	}
+	if err := store.CreateOrder(context.Background(), order, []*types.Authorization{authz}); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return order, authz
+}
+
+func testOrderLifecycle(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+
+	order, authz := newTestOrder(t, store, "order_1", now.Add(time.Hour))
+
+	got, err := store.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if len(got.Authorizations) != 1 || got.Authorizations[0] != authz.ID {
+		t.Errorf("GetOrder authorizations = %v, want [%s]", got.Authorizations, authz.ID)
+	}
+	if got.Tenant != "ot" || got.Profile != "tls-server" {
+		t.Errorf("GetOrder tenant, profile = %q, %q, want ot, tls-server", got.Tenant, got.Profile)
+	}
+
+	gotAuthz, err := store.GetAuthorization(ctx, authz.ID)
+	if err != nil {
+		t.Fatalf("GetAuthorization: %v", err)
+	}
+	if len(gotAuthz.Challenges) != 1 || gotAuthz.Challenges[0].Token != "token_order_1" {
+		t.Errorf("GetAuthorization challenges = %+v", gotAuthz.Challenges)
+	}
+
+	if err := store.UpdateChallengeStatus(ctx, "token_order_1", string(types.ChallengeStatusValid)); err != nil {
+		t.Fatalf("UpdateChallengeStatus: %v", err)
+	}
+	challenge, err := store.GetChallenge(ctx, "token_order_1")
+	if err != nil {
+		t.Fatalf("GetChallenge: %v", err)
+	}
+	if challenge.Status != types.ChallengeStatusValid || challenge.AuthorizationID != authz.ID || challenge.Validated == nil {
+		t.Errorf("GetChallenge = %+v", challenge)
+	}
+
+	got.Status = types.OrderStatusProcessing
+	got.CSR = "csr"
+	got.UpdatedAt = types.Time{Time: now}
+	if err := store.UpdateOrder(ctx, got); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	ids, err := store.ListProcessingOrderIDs(ctx)
+	if err != nil {
+		t.Fatalf("ListProcessingOrderIDs: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != order.ID {
+		t.Errorf("ListProcessingOrderIDs = %v, want [%s]", ids, order.ID)
+	}
+
+	if claimed, err := store.ClaimOrderIssuance(ctx, order.ID, now, now.Add(time.Minute)); err != nil || !claimed {
+		t.Fatalf("ClaimOrderIssuance = %v, %v", claimed, err)
+	}
+	if claimed, err := store.ClaimOrderIssuance(ctx, order.ID, now.Add(time.Second), now.Add(time.Minute)); err != nil || claimed {
+		t.Errorf("ClaimOrderIssuance of a leased order = %v, %v, want false", claimed, err)
+	}
+	if claimed, err := store.ClaimOrderIssuance(ctx, order.ID, now.Add(2*time.Minute), now.Add(3*time.Minute)); err != nil || !claimed {
+		t.Errorf("ClaimOrderIssuance after the lease ran out = %v, %v, want true", claimed, err)
+	}
+
+	cert := &types.Certificate{
+		ID:             "cert_1",
+		OrderID:        order.ID,
+		Certificate:    "PEM",
+		Serial:         "01",
+		AuthorityKeyID: "aa",
+		NotBefore:      types.Time{Time: now.Add(-time.Minute)},
+		NotAfter:       types.Time{Time: now.Add(24 * time.Hour)},
+	}
+	completed, err := store.CompleteOrderIssuance(ctx, got, cert)
+	if err != nil || !completed {
+		t.Fatalf("CompleteOrderIssuance = %v, %v", completed, err)
+	}
+	completed, err = store.CompleteOrderIssuance(ctx, got, &types.Certificate{ID: "cert_2", OrderID: order.ID})
+	if err != nil || completed {
+		t.Errorf("second CompleteOrderIssuance = %v, %v, want false", completed, err)
+	}
+	failed := *got
+	failed.Error = &types.Problem{Type: "urn:ietf:params:acme:error:serverInternal", Detail: "late failure"}
+	if ok, err := store.FailOrderIssuance(ctx, &failed); err != nil || ok {
+		t.Errorf("FailOrderIssuance of a valid order = %v, %v, want false", ok, err)
+	}
+
+	got, err = store.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if got.Status != types.OrderStatusValid || got.CertificateID != cert.ID || got.CSR != "csr" {
+		t.Errorf("completed order = %+v", got)
+	}
+
+	bySerial, err := store.GetCertificateBySerial(ctx, "aa", "01")
+	if err != nil || bySerial.ID != cert.ID {
+		t.Errorf("GetCertificateBySerial = %+v, %v", bySerial, err)
+	}
+	current, err := store.GetCurrentCertificateByOrder(ctx, order.ID, now)
+	if err != nil || current.ID != cert.ID || !current.NotAfter.Equal(cert.NotAfter.Time) {
+		t.Errorf("GetCurrentCertificateByOrder = %+v, %v", current, err)
+	}
+
+	override, err := store.GetRenewalOverride(ctx, cert)
+	if err != nil || override != nil {
+		t.Errorf("GetRenewalOverride = %+v, %v, want nil", override, err)
+	}
+	issuerWindow := &types.RenewalOverride{Window: types.RenewalWindow{
+		Start: types.Time{Time: now},
+		End:   types.Time{Time: now.Add(time.Hour)},
+	}}
+	if err := store.SetIssuerRenewalWindow(ctx, "aa", issuerWindow); err != nil {
+		t.Fatalf("SetIssuerRenewalWindow: %v", err)
+	}
+	override, err = store.GetRenewalOverride(ctx, cert)
+	if err != nil || override == nil || !override.Window.End.Equal(issuerWindow.Window.End.Time) {
+		t.Errorf("GetRenewalOverride = %+v, %v, want issuer window", override, err)
+	}
+}
+
+// newValidStarOrder stores a valid STAR order of a new account with its
+// first certificate, which ends at notAfter
+func newValidStarOrder(t *testing.T, store Store, id string, notAfter time.Time) (*types.Order, *types.Account) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	account := &types.Account{ID: "acct_" + id, Key: json.RawMessage(`{}`), Status: types.AccountStatusValid, InitialIP: "192.0.2.1"}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatalf("CreateAccount: %v", err)
+	}
+	order := &types.Order{
+		ID:          id,
+		AccountID:   account.ID,
+		Status:      types.OrderStatusPending,
+		ExpiresAt:   types.Time{Time: now.Add(time.Hour)},
+		Identifiers: []types.Identifier{{Type: "dns", Value: "plc1.plant.example"}},
+		Finalize:    "https://acme.example/order/" + id + "/finalize",
+		AutoRenewal: &types.AutoRenewal{
+			StartDate: types.Time{Time: now},
+			EndDate:   types.Time{Time: now.Add(30 * 24 * time.Hour)},
+			Lifetime:  int64((24 * time.Hour).Seconds()),
+		},
+	}
+	authz := &types.Authorization{
+		ID:         "authz_" + id,
+		Status:     types.AuthzStatusValid,
+		Identifier: order.Identifiers[0],
+		Expires:    &types.Time{Time: now.Add(time.Hour)},
+		Challenges: []types.Challenge{
+			{ID: "chall_" + id, Type: "http-01", Status: types.ChallengeStatusValid, Token: "token_" + id},
+		},
+	}
+	if err := store.CreateOrder(ctx, order, []*types.Authorization{authz}); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	order.Status = types.OrderStatusProcessing
+	order.CSR = "csr"
+	order.UpdatedAt = types.Time{Time: now}
+	if err := store.UpdateOrder(ctx, order); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+	if claimed, err := store.ClaimOrderIssuance(ctx, order.ID, now, now.Add(time.Minute)); err != nil || !claimed {
+		t.Fatalf("ClaimOrderIssuance = %v, %v", claimed, err)
+	}
+	cert := &types.Certificate{
+		ID:        "cert_" + id,
+		OrderID:   order.ID,
+		NotBefore: types.Time{Time: now},
+		NotAfter:  types.Time{Time: notAfter},
+	}
+	if completed, err := store.CompleteOrderIssuance(ctx, order, cert); err != nil || !completed {
+		t.Fatalf("CompleteOrderIssuance = %v, %v", completed, err)
+	}
+	return order, account
+}
+
+func testStarRenewal(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+	first := now.Add(24 * time.Hour).Truncate(time.Second)
+	order, account := newValidStarOrder(t, store, "order_star", first)
+
+	ids, err := store.ListActiveStarOrderIDs(ctx, now)
+	if err != nil || len(ids) != 1 || ids[0] != order.ID {
+		t.Fatalf("ListActiveStarOrderIDs = %v, %v, want [%s]", ids, err, order.ID)
+	}
+
+	// Completing the issuance released its lease, so a renewer can claim
+	// the order; a second renewer cannot until the lease runs out
+	if claimed, err := store.ClaimStarRenewal(ctx, order.ID, now, now.Add(time.Minute)); err != nil || !claimed {
+		t.Fatalf("ClaimStarRenewal = %v, %v", claimed, err)
+	}
+	if claimed, err := store.ClaimStarRenewal(ctx, order.ID, now.Add(time.Second), now.Add(time.Minute)); err != nil || claimed {
+		t.Errorf("ClaimStarRenewal of a leased order = %v, %v, want false", claimed, err)
+	}
+	if claimed, err := store.ClaimStarRenewal(ctx, order.ID, now.Add(2*time.Minute), now.Add(3*time.Minute)); err != nil || !claimed {
+		t.Errorf("ClaimStarRenewal after the lease ran out = %v, %v, want true", claimed, err)
+	}
+
+	// Only the first of two renewals of the same certificate is stored
+	next := &types.Certificate{ID: "cert_star_2", OrderID: order.ID, NotBefore: types.Time{Time: first.Add(-time.Hour)}, NotAfter: types.Time{Time: first.Add(24 * time.Hour)}}
+	if completed, err := store.CompleteStarRenewal(ctx, next, first); err != nil || !completed {
+		t.Fatalf("CompleteStarRenewal = %v, %v", completed, err)
+	}
+	late := &types.Certificate{ID: "cert_star_3", OrderID: order.ID, NotBefore: types.Time{Time: first.Add(-time.Hour)}, NotAfter: types.Time{Time: first.Add(24 * time.Hour)}}
+	if completed, err := store.CompleteStarRenewal(ctx, late, first); err != nil || completed {
+		t.Errorf("CompleteStarRenewal of a renewed certificate = %v, %v, want false", completed, err)
+	}
+	latest, err := store.GetLatestCertificateByOrder(ctx, order.ID)
+	if err != nil || latest.ID != next.ID {
+		t.Errorf("GetLatestCertificateByOrder = %+v, %v, want %s", latest, err, next.ID)
+	}
+	if _, err := store.GetCertificate(ctx, late.ID); err == nil {
+		t.Error("the discarded renewal was stored")
+	}
+
+	// Orders of deactivated accounts are not renewed
+	account.Status = types.AccountStatusDeactivated
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	if ids, err := store.ListActiveStarOrderIDs(ctx, now); err != nil || len(ids) != 0 {
+		t.Errorf("ListActiveStarOrderIDs with a deactivated account = %v, %v, want none", ids, err)
+	}
+}
+
+func testAdmin(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	accounts, err := store.ListAccounts(ctx, types.ListFilter{Status: string(types.AccountStatusDeactivated), Query: "OPS@example"})
+	if err != nil || len(accounts) != 1 || accounts[0].ID != "acct_1" {
+		t.Errorf("ListAccounts = %+v, %v, want deactivated acct_1", accounts, err)
+	}
+	ids, err := store.ListOrderIDs(ctx, types.ListFilter{AccountID: "acct_order_1", Query: "example.com"})
+	if err != nil || len(ids) != 1 || ids[0] != "order_1" {
+		t.Errorf("ListOrderIDs = %v, %v, want [order_1]", ids, err)
+	}
+	challenges, err := store.ListPendingChallenges(ctx, types.ListFilter{AccountID: "acct_order_1"})
+	if err != nil || len(challenges) != 0 {
+		t.Errorf("ListPendingChallenges = %+v, %v, want none", challenges, err)
+	}
+
+	certs, err := store.ListCertificates(ctx, types.ListFilter{AccountID: "acct_order_1", Status: "valid", Serial: "01"})
+	if err != nil || len(certs) != 1 || certs[0].ID != "cert_1" {
+		t.Fatalf("ListCertificates = %+v, %v, want [cert_1]", certs, err)
+	}
+	if err := store.RevokeCertificate(ctx, "cert_1", "keyCompromise", time.Now()); err != nil {
+		t.Fatalf("RevokeCertificate: %v", err)
+	}
+	if _, ok := store.RevokeCertificate(ctx, "cert_1", "keyCompromise", time.Now()).(*types.Problem); !ok {
+		t.Error("second RevokeCertificate did not return a problem")
+	}
+	certs, err = store.ListCertificates(ctx, types.ListFilter{Status: "revoked"})
+	if err != nil || len(certs) != 1 || certs[0].RevocationReason != "keyCompromise" || certs[0].RevokedAt.IsZero() {
+		t.Errorf("ListCertificates(revoked) = %+v, %v", certs, err)
+	}
+
+	key := &types.EABKey{ID: "kid_1", HMACKey: []byte("secret"), Label: "plant-a", CreatedAt: time.Now().UTC()}
+	if err := store.CreateEABKey(ctx, key); err != nil {
+		t.Fatalf("CreateEABKey: %v", err)
+	}
+	got, err := store.GetEABKey(ctx, key.ID)
+	if err != nil || string(got.HMACKey) != "secret" || got.Label != "plant-a" || got.BoundAt != nil {
+		t.Errorf("GetEABKey = %+v, %v", got, err)
+	}
+	if keys, err := store.ListEABKeys(ctx); err != nil || len(keys) != 1 {
+		t.Errorf("ListEABKeys = %+v, %v, want 1 key", keys, err)
+	}
+
+	// The key binds the first account created with it only
+	tenantKey := &types.EABKey{ID: "kid_ot", HMACKey: []byte("secret"), Tenant: "ot", Scope: []string{"line3.plant.example"}, CreatedAt: time.Now().UTC()}
+	if err := store.CreateEABKey(ctx, tenantKey); err != nil {
+		t.Fatalf("CreateEABKey: %v", err)
+	}
+	for i, id := range []string{"acct_eab_1", "acct_eab_2"} {
+		err := store.CreateAccount(ctx, &types.Account{
+			ID:        id,
+			Key:       json.RawMessage(`{"kty":"EC"}`),
+			Status:    types.AccountStatusValid,
+			CreatedAt: time.Now().Unix(),
+			Tenant:    "ot",
+			Scope:     tenantKey.Scope,
+			EABKeyID:  tenantKey.ID,
+		})
+		if i == 0 && err != nil {
+			t.Fatalf("CreateAccount with EAB key: %v", err)
+		}
+		if _, ok := err.(*types.Problem); i == 1 && !ok {
+			t.Errorf("CreateAccount with a bound EAB key = %v, want a problem", err)
+		}
+	}
+	got, err = store.GetEABKey(ctx, tenantKey.ID)
+	if err != nil || got.Tenant != "ot" || got.BoundAccountID != "acct_eab_1" || got.BoundAt == nil || !reflect.DeepEqual(got.Scope, tenantKey.Scope) {
+		t.Errorf("GetEABKey = %+v, %v, want bound to acct_eab_1", got, err)
+	}
+	account, err := store.GetAccount(ctx, "acct_eab_1")
+	if err != nil || account.Tenant != "ot" || !reflect.DeepEqual(account.Scope, tenantKey.Scope) {
+		t.Fatalf("GetAccount = %+v, %v, want tenant ot and the scope of the key", account, err)
+	}
+	account.Scope = []string{"10.3.0.0/16"}
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	if account, err := store.GetAccount(ctx, "acct_eab_1"); err != nil || !reflect.DeepEqual(account.Scope, []string{"10.3.0.0/16"}) {
+		t.Errorf("GetAccount after scope update = %+v, %v", account, err)
+	}
+	if _, err := store.GetAccount(ctx, "acct_eab_2"); err == nil {
+		t.Error("account with a bound EAB key was created")
+	}
+	if err := store.DeleteEABKey(ctx, key.ID); err != nil {
+		t.Fatalf("DeleteEABKey: %v", err)
+	}
+	if _, ok := store.DeleteEABKey(ctx, key.ID).(*types.Problem); !ok {
+		t.Error("DeleteEABKey of a missing key did not return a problem")
+	}
+}
+
+func testExpiry(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+
+	order, authz := newTestOrder(t, store, "order_expired", now.Add(-time.Hour))
+
+	if n, err := store.ExpireOrders(ctx, now); err != nil || n != 1 {
+		t.Errorf("ExpireOrders = %d, %v, want 1", n, err)
+	}
+	if n, err := store.ExpireAuthorizations(ctx, now); err != nil || n != 1 {
+		t.Errorf("ExpireAuthorizations = %d, %v, want 1", n, err)
+	}
+	if n, err := store.ExpireChallenges(ctx, now); err != nil || n != 1 {
+		t.Errorf("ExpireChallenges = %d, %v, want 1", n, err)
+	}
+	if _, err := store.DeactivateAuthorization(ctx, authz.ID); err == nil {
+		t.Error("DeactivateAuthorization succeeded on an expired authorization")
+	}
+	if got, err := store.GetOrder(ctx, order.ID); err != nil || got.Status != types.OrderStatusInvalid {
+		t.Errorf("GetOrder = %+v, %v, want invalid", got, err)
+	}
+}
+
+// testPurge runs on an empty store, so that the purge counts only cover the
+// records created here
+func testPurge(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+
+	// An expired order, an order whose authorization was deactivated, a
+	// pending order, a STAR order whose certificate expired two hours ago
+	// and one with a current certificate
+	expired, _ := newTestOrder(t, store, "order_purge_expired", now.Add(-time.Hour))
+	for _, expire := range []func(context.Context, time.Time) (int64, error){store.ExpireOrders, store.ExpireAuthorizations, store.ExpireChallenges} {
+		if _, err := expire(ctx, now); err != nil {
+			t.Fatalf("expire: %v", err)
+		}
+	}
+	deactivated, deactivatedAuthz := newTestOrder(t, store, "order_purge_deactivated", now.Add(time.Hour))
+	if _, err := store.DeactivateAuthorization(ctx, deactivatedAuthz.ID); err != nil {
+		t.Fatalf("DeactivateAuthorization: %v", err)
+	}
+	pending, pendingAuthz := newTestOrder(t, store, "order_purge_pending", now.Add(time.Hour))
+	outdated, _ := newValidStarOrder(t, store, "order_purge_outdated", now.Add(-2*time.Hour))
+	current, _ := newValidStarOrder(t, store, "order_purge_current", now.Add(time.Hour))
+
+	deliveries := []*types.WebhookDelivery{
+		{ID: "whd_purge_old", Endpoint: "inventory", Event: "order.invalid", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "whd_purge_recent", Endpoint: "inventory", Event: "order.invalid", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now},
+	}
+	if err := store.CreateWebhookDeliveries(ctx, deliveries); err != nil {
+		t.Fatalf("CreateWebhookDeliveries: %v", err)
+	}
+
+	// Everything was updated just now, so a retention window of an hour only
+	// covers the certificate and the delivery
+	before := now.Add(-time.Hour)
+	if n, err := store.PurgeExpiredCertificates(ctx, before); err != nil || n != 1 {
+		t.Errorf("PurgeExpiredCertificates = %d, %v, want 1", n, err)
+	}
+	if _, err := store.GetCertificate(ctx, "cert_"+outdated.ID); err == nil {
+		t.Error("GetCertificate returned a purged certificate")
+	}
+	if _, err := store.GetCertificate(ctx, "cert_"+current.ID); err != nil {
+		t.Errorf("current certificate was purged: %v", err)
+	}
+	if n, err := store.PurgeAuthorizations(ctx, before); err != nil || n != 0 {
+		t.Errorf("PurgeAuthorizations = %d, %v, want 0 within the retention window", n, err)
+	}
+	if n, err := store.PurgeOrders(ctx, before); err != nil || n != 0 {
+		t.Errorf("PurgeOrders = %d, %v, want 0 within the retention window", n, err)
+	}
+	if n, err := store.PurgeWebhookDeliveries(ctx, before); err != nil || n != 1 {
+		t.Errorf("PurgeWebhookDeliveries = %d, %v, want 1", n, err)
+	}
+	claimed, err := store.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != "whd_purge_recent" {
+		t.Errorf("ClaimWebhookDeliveries after purging = %+v, %v, want the recent delivery", claimed, err)
+	}
+
+	// Once the retention window has passed, the expired and deactivated
+	// authorizations go, then the invalid orders and the STAR order left
+	// without a certificate
+	before = now.Add(time.Minute)
+	if n, err := store.PurgeAuthorizations(ctx, before); err != nil || n != 2 {
+		t.Errorf("PurgeAuthorizations = %d, %v, want 2", n, err)
+	}
+	for _, order := range []*types.Order{expired, deactivated} {
+		if _, err := store.GetAuthorization(ctx, "authz_"+order.ID); err == nil {
+			t.Errorf("GetAuthorization returned the purged authorization of %s", order.ID)
+		}
+		if _, err := store.GetChallenge(ctx, "token_"+order.ID); err == nil {
+			t.Errorf("GetChallenge returned a challenge of the purged authorization of %s", order.ID)
+		}
+	}
+	if _, err := store.GetChallenge(ctx, "token_"+pending.ID); err != nil {
+		t.Errorf("challenge of the pending authorization was purged: %v", err)
+	}
+	if _, err := store.GetAuthorization(ctx, pendingAuthz.ID); err != nil {
+		t.Errorf("pending authorization was purged: %v", err)
+	}
+
+	if n, err := store.PurgeOrders(ctx, before); err != nil || n != 3 {
+		t.Errorf("PurgeOrders = %d, %v, want 3", n, err)
+	}
+	for _, order := range []*types.Order{expired, deactivated, outdated} {
+		if _, err := store.GetOrder(ctx, order.ID); err == nil {
+			t.Errorf("GetOrder(%s) returned a purged order", order.ID)
+		}
+	}
+	if _, err := store.GetAuthorization(ctx, "authz_"+outdated.ID); err == nil {
+		t.Error("GetAuthorization returned the authorization of a purged order")
+	}
+	for _, order := range []*types.Order{pending, current} {
+		if _, err := store.GetOrder(ctx, order.ID); err != nil {
+			t.Errorf("order %s was purged: %v", order.ID, err)
+		}
+	}
+}
+
+func testDeactivation(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	order, authz := newTestOrder(t, store, "order_deactivated", time.Now().Add(time.Hour))
+
+	if n, err := store.DeactivateAuthorization(ctx, authz.ID); err != nil || n != 1 {
+		t.Fatalf("DeactivateAuthorization = %d, %v, want 1 stopped order", n, err)
+	}
+	if got, err := store.GetAuthorization(ctx, authz.ID); err != nil || got.Status != types.AuthzStatusDeactivated {
+		t.Errorf("GetAuthorization = %+v, %v, want deactivated", got, err)
+	}
+	if got, err := store.GetOrder(ctx, order.ID); err != nil || got.Status != types.OrderStatusInvalid {
+		t.Errorf("GetOrder = %+v, %v, want invalid", got, err)
+	}
+	if _, err := store.DeactivateAuthorization(ctx, authz.ID); err == nil {
+		t.Error("DeactivateAuthorization succeeded twice")
+	}
+
+	// A STAR order stops renewing once an authorization it depends on is
+	// deactivated
+	star, _ := newValidStarOrder(t, store, "order_star_deactivated", time.Now().Add(time.Hour))
+	if n, err := store.DeactivateAuthorization(ctx, "authz_"+star.ID); err != nil || n != 1 {
+		t.Fatalf("DeactivateAuthorization(STAR) = %d, %v, want 1 stopped order", n, err)
+	}
+	if got, err := store.GetOrder(ctx, star.ID); err != nil || got.Status != types.OrderStatusCanceled {
+		t.Errorf("GetOrder(STAR) = %+v, %v, want canceled", got, err)
+	}
+	ids, err := store.ListActiveStarOrderIDs(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("ListActiveStarOrderIDs: %v", err)
+	}
+	for _, id := range ids {
+		if id == star.ID {
+			t.Errorf("ListActiveStarOrderIDs = %v, still lists the canceled order", ids)
+		}
+	}
+}
+
+func testNonces(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := store.CreateNonce(ctx, "fresh", now); err != nil {
+		t.Fatalf("CreateNonce: %v", err)
+	}
+	if err := store.CreateNonce(ctx, "stale", now.Add(-time.Hour)); err != nil {
+		t.Fatalf("CreateNonce: %v", err)
+	}
+
+	if ok, err := store.ConsumeNonce(ctx, "fresh", now.Add(-time.Minute)); err != nil || !ok {
+		t.Errorf("ConsumeNonce(fresh) = %v, %v, want true", ok, err)
+	}
+	if ok, err := store.ConsumeNonce(ctx, "fresh", now.Add(-time.Minute)); err != nil || ok {
+		t.Errorf("second ConsumeNonce(fresh) = %v, %v, want false", ok, err)
+	}
+	if ok, err := store.ConsumeNonce(ctx, "stale", now.Add(-time.Minute)); err != nil || ok {
+		t.Errorf("ConsumeNonce(stale) = %v, %v, want false", ok, err)
+	}
+
+	if err := store.CreateNonce(ctx, "old", now.Add(-time.Hour)); err != nil {
+		t.Fatalf("CreateNonce: %v", err)
+	}
+	if n, err := store.DeleteExpiredNonces(ctx, now.Add(-time.Minute)); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredNonces = %d, %v, want 1", n, err)
+	}
+}
+
+func testRateLimits(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	// Two tokens per hour
+	for i := 1; i <= 2; i++ {
+		fullAt, ok, err := store.SpendRateLimit(ctx, "orders:acct_1", now, 30*time.Minute, time.Hour)
+		if err != nil || !ok || !fullAt.Equal(now.Add(time.Duration(i)*30*time.Minute)) {
+			t.Fatalf("SpendRateLimit #%d = %v, %v, %v", i, fullAt, ok, err)
+		}
+	}
+	if fullAt, ok, err := store.SpendRateLimit(ctx, "orders:acct_1", now, 30*time.Minute, time.Hour); err != nil || ok || !fullAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("SpendRateLimit on an empty bucket = %v, %v, %v", fullAt, ok, err)
+	}
+	if _, ok, err := store.SpendRateLimit(ctx, "orders:acct_1", now.Add(30*time.Minute), 30*time.Minute, time.Hour); err != nil || !ok {
+		t.Errorf("SpendRateLimit after a refill = %v, %v", ok, err)
+	}
+
+	if fullAt, err := store.GetRateLimit(ctx, "orders:acct_2"); err != nil || !fullAt.IsZero() {
+		t.Errorf("GetRateLimit of an unused bucket = %v, %v", fullAt, err)
+	}
+	if n, err := store.DeleteFullRateLimits(ctx, now.Add(2*time.Hour)); err != nil || n != 1 {
+		t.Errorf("DeleteFullRateLimits = %d, %v, want 1", n, err)
+	}
+
+	// Tokens of several buckets are taken together or not at all
+	spends := []types.RateLimitSpend{
+		{Key: "finalize:acct_1", Interval: 30 * time.Minute, Period: time.Hour},
+		{Key: "certificates:dns:plc1.plant.example", Interval: time.Hour, Period: time.Hour},
+	}
+	if empty, _, err := store.SpendRateLimits(ctx, spends, now); err != nil || empty != -1 {
+		t.Fatalf("SpendRateLimits = %d, %v, want -1", empty, err)
+	}
+	if empty, fullAt, err := store.SpendRateLimits(ctx, spends, now); err != nil || empty != 1 || !fullAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("SpendRateLimits with an empty bucket = %d, %v, %v, want 1", empty, fullAt, err)
+	}
+	if fullAt, err := store.GetRateLimit(ctx, "finalize:acct_1"); err != nil || !fullAt.Equal(now.Add(30*time.Minute)) {
+		t.Errorf("GetRateLimit after a rejected spend = %v, %v, want the token returned", fullAt, err)
+	}
+}
+
+func testAuditLog(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if last, err := store.GetLastAuditRecord(ctx); err != nil || last != nil {
+		t.Fatalf("GetLastAuditRecord of an empty log = %v, %v", last, err)
+	}
+	for seq := int64(1); seq <= 3; seq++ {
+		record := &types.AuditRecord{Seq: seq, Event: "certificate.issued", CreatedAt: time.Unix(1700000000+seq, 0), Entry: []byte(`{"seq":` + strconv.FormatInt(seq, 10) + `}`)}
+		if ok, err := store.AppendAuditRecord(ctx, record); err != nil || !ok {
+			t.Fatalf("AppendAuditRecord %d = %v, %v", seq, ok, err)
+		}
+	}
+	// Another replica appended entry 3 first
+	if ok, err := store.AppendAuditRecord(ctx, &types.AuditRecord{Seq: 3, Event: "x", CreatedAt: time.Now(), Entry: []byte("{}")}); err != nil || ok {
+		t.Errorf("AppendAuditRecord with a taken sequence number = %v, %v", ok, err)
+	}
+
+	last, err := store.GetLastAuditRecord(ctx)
+	if err != nil || last.Seq != 3 || string(last.Entry) != `{"seq":3}` || !last.CreatedAt.Equal(time.Unix(1700000003, 0)) {
+		t.Errorf("GetLastAuditRecord = %+v, %v", last, err)
+	}
+	records, err := store.ListAuditRecords(ctx, 1, 10)
+	if err != nil || len(records) != 2 || records[0].Seq != 2 || records[1].Event != "certificate.issued" {
+		t.Errorf("ListAuditRecords = %v, %v", records, err)
+	}
+}
+
+func testWebhooks(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	deliveries := []*types.WebhookDelivery{
+		{ID: "whd_1", Endpoint: "inventory", Event: "certificate.issued", Payload: []byte(`{"id":"1"}`), NextAttemptAt: now, CreatedAt: now},
+		{ID: "whd_2", Endpoint: "inventory", Event: "certificate.issued", Payload: []byte(`{"id":"2"}`), NextAttemptAt: now.Add(time.Hour), CreatedAt: now},
+	}
+	if err := store.CreateWebhookDeliveries(ctx, deliveries); err != nil {
+		t.Fatalf("CreateWebhookDeliveries: %v", err)
+	}
+
+	claimed, err := store.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != "whd_1" || string(claimed[0].Payload) != `{"id":"1"}` {
+		t.Fatalf("ClaimWebhookDeliveries = %+v, %v", claimed, err)
+	}
+	// Claimed deliveries are skipped until the claim lapses
+	if again, err := store.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10); err != nil || len(again) != 0 {
+		t.Errorf("ClaimWebhookDeliveries of a claimed delivery = %+v, %v", again, err)
+	}
+
+	retry := claimed[0]
+	retry.Attempts, retry.NextAttemptAt, retry.LastError = 1, now.Add(30*time.Minute), "503 Service Unavailable"
+	if err := store.UpdateWebhookDelivery(ctx, retry); err != nil {
+		t.Fatalf("UpdateWebhookDelivery: %v", err)
+	}
+	claimed, err = store.ClaimWebhookDeliveries(ctx, now.Add(2*time.Hour), now.Add(3*time.Hour), 1)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != "whd_1" || claimed[0].Attempts != 1 || claimed[0].LastError != retry.LastError {
+		t.Fatalf("ClaimWebhookDeliveries after a failed attempt = %+v, %v", claimed, err)
+	}
+	for _, d := range deliveries {
+		if err := store.DeleteWebhookDelivery(ctx, d.ID); err != nil {
+			t.Fatalf("DeleteWebhookDelivery: %v", err)
+		}
+	}
+	if left, err := store.ClaimWebhookDeliveries(ctx, now.Add(24*time.Hour), now.Add(25*time.Hour), 10); err != nil || len(left) != 0 {
+		t.Errorf("ClaimWebhookDeliveries after delete = %+v, %v", left, err)
+	}
+
+	order, _ := newTestOrder(t, store, "order_expiring", now.Add(time.Hour))
+	for i, notAfter := range []time.Time{now.Add(2 * time.Hour), now.Add(48 * time.Hour)} {
+		cert := &types.Certificate{
+			ID:        "cert_expiring_" + strconv.Itoa(i),
+			OrderID:   order.ID,
+			NotBefore: types.Time{Time: now.Add(-time.Hour)},
+			NotAfter:  types.Time{Time: notAfter},
+		}
+		if err := store.CreateCertificate(ctx, cert); err != nil {
+			t.Fatalf("CreateCertificate: %v", err)
+		}
+	}
+	expiring, err := store.ListExpiringCertificates(ctx, now, now.Add(24*time.Hour), 10)
+	if err != nil || len(expiring) != 1 || expiring[0].ID != "cert_expiring_0" {
+		t.Fatalf("ListExpiringCertificates = %+v, %v", expiring, err)
+	}
+	if ok, err := store.MarkCertificateExpiryNotified(ctx, "cert_expiring_0"); err != nil || !ok {
+		t.Fatalf("MarkCertificateExpiryNotified = %v, %v", ok, err)
+	}
+	if ok, err := store.MarkCertificateExpiryNotified(ctx, "cert_expiring_0"); err != nil || ok {
+		t.Errorf("second MarkCertificateExpiryNotified = %v, %v, want false", ok, err)
+	}
+	if expiring, err := store.ListExpiringCertificates(ctx, now, now.Add(24*time.Hour), 10); err != nil || len(expiring) != 0 {
+		t.Errorf("ListExpiringCertificates after notification = %+v, %v", expiring, err)
+	}
+}
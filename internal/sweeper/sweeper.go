@@ -0,0 +1,193 @@
+package sweeper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage"
+)
+
+const (
+	// DefaultInterval is used when no sweep interval is configured
+	DefaultInterval = 5 * time.Minute
+
+	// DefaultRetention is used when no retention window is configured
+	DefaultRetention = 30 * 24 * time.Hour
+)
+
+// Config holds the sweeper settings
+type Config struct {
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// Result describes the work done by a single sweep
+type Result struct {
+	ExpiredOrders         int64
+	ExpiredAuthorizations int64
+	ExpiredChallenges     int64
+	PurgedOrders          int64
+	PurgedAuthorizations  int64
+	PurgedCertificates    int64
+	PurgedWebhooks        int64
+	PurgedRateLimits      int64
+	Duration              time.Duration
+}
+
+// Sweeper periodically moves expired orders, authorizations and challenges
+// to their terminal states and purges finished records older than the
+// retention window as well as rate limit buckets that have filled up again.
+type Sweeper struct {
+	store  storage.Store
+	config Config
+	logger *logger.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a sweeper. Zero values in cfg are replaced by the defaults.
+func New(store storage.Store, cfg Config, log *logger.Logger) *Sweeper {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+
+	return &Sweeper{
+		store:  store,
+		config: cfg,
+		logger: log,
+	}
+}
+
+// Start runs the sweeper in the background until Stop is called or ctx is
+// cancelled. A first sweep is performed immediately.
+func (s *Sweeper) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx)
+	}()
+
+	s.logger.Infow("Expiry sweeper started",
+		"interval", s.config.Interval,
+		"retention", s.config.Retention,
+	)
+}
+
+// Stop cancels the background sweeper and waits for a running sweep to finish
+func (s *Sweeper) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	s.logger.Info("Expiry sweeper stopped")
+}
+
+func (s *Sweeper) run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Errorf("Expiry sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep performs a single pass: it expires orders, authorizations and
+// pending challenges, then purges what has been finished for longer than
+// the retention window: certificates past their expiry, deactivated,
+// expired, invalid and revoked authorizations with their challenges,
+// invalid, canceled and valid orders without certificates, and webhook
+// deliveries. Full rate limit buckets are purged as well.
+func (s *Sweeper) Sweep(ctx context.Context) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+
+	var err error
+	defer func() {
+		if err != nil {
+			metrics.SweeperRuns.WithLabelValues("failure").Inc()
+		} else {
+			metrics.SweeperRuns.WithLabelValues("success").Inc()
+		}
+	}()
+
+	if result.ExpiredOrders, err = s.store.ExpireOrders(ctx, start); err != nil {
+		return nil, fmt.Errorf("failed to expire orders: %w", err)
+	}
+	if result.ExpiredAuthorizations, err = s.store.ExpireAuthorizations(ctx, start); err != nil {
+		return nil, fmt.Errorf("failed to expire authorizations: %w", err)
+	}
+	if result.ExpiredChallenges, err = s.store.ExpireChallenges(ctx, start); err != nil {
+		return nil, fmt.Errorf("failed to expire challenges: %w", err)
+	}
+
+	// Certificates go first, so that the orders left without one are purged
+	// in the same pass
+	before := start.Add(-s.config.Retention)
+	if result.PurgedCertificates, err = s.store.PurgeExpiredCertificates(ctx, before); err != nil {
+		return nil, fmt.Errorf("failed to purge certificates: %w", err)
+	}
+	if result.PurgedAuthorizations, err = s.store.PurgeAuthorizations(ctx, before); err != nil {
+		return nil, fmt.Errorf("failed to purge authorizations: %w", err)
+	}
+	if result.PurgedOrders, err = s.store.PurgeOrders(ctx, before); err != nil {
+		return nil, fmt.Errorf("failed to purge orders: %w", err)
+	}
+	if result.PurgedWebhooks, err = s.store.PurgeWebhookDeliveries(ctx, before); err != nil {
+		return nil, fmt.Errorf("failed to purge webhook deliveries: %w", err)
+	}
+	if result.PurgedRateLimits, err = s.store.DeleteFullRateLimits(ctx, start); err != nil {
+		return nil, fmt.Errorf("failed to purge rate limits: %w", err)
+	}
+
+	result.Duration = time.Since(start)
+
+	metrics.OrderTransitions.WithLabelValues("expired", "invalid").Add(float64(result.ExpiredOrders))
+	metrics.SweeperRecords.WithLabelValues("order", "expired").Add(float64(result.ExpiredOrders))
+	metrics.SweeperRecords.WithLabelValues("authorization", "expired").Add(float64(result.ExpiredAuthorizations))
+	metrics.SweeperRecords.WithLabelValues("challenge", "expired").Add(float64(result.ExpiredChallenges))
+	metrics.SweeperRecords.WithLabelValues("order", "purged").Add(float64(result.PurgedOrders))
+	metrics.SweeperRecords.WithLabelValues("authorization", "purged").Add(float64(result.PurgedAuthorizations))
+	metrics.SweeperRecords.WithLabelValues("certificate", "purged").Add(float64(result.PurgedCertificates))
+	metrics.SweeperRecords.WithLabelValues("webhook_delivery", "purged").Add(float64(result.PurgedWebhooks))
+	metrics.SweeperRecords.WithLabelValues("rate_limit", "purged").Add(float64(result.PurgedRateLimits))
+
+	purged := result.PurgedOrders + result.PurgedAuthorizations + result.PurgedCertificates + result.PurgedWebhooks + result.PurgedRateLimits
+	if result.ExpiredOrders+result.ExpiredAuthorizations+result.ExpiredChallenges+purged > 0 {
+		s.logger.Infow("Expiry sweep completed",
+			"expired_orders", result.ExpiredOrders,
+			"expired_authorizations", result.ExpiredAuthorizations,
+			"expired_challenges", result.ExpiredChallenges,
+			"purged_orders", result.PurgedOrders,
+			"purged_authorizations", result.PurgedAuthorizations,
+			"purged_certificates", result.PurgedCertificates,
+			"purged_webhook_deliveries", result.PurgedWebhooks,
+			"purged_rate_limits", result.PurgedRateLimits,
+			"duration", result.Duration,
+		)
+	} else {
+		s.logger.Debugw("Expiry sweep completed, nothing to do",
+			"duration", result.Duration,
+		)
+	}
+
+	return result, nil
+}
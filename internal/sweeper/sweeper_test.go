@@ -0,0 +1,164 @@
+package sweeper
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	now := time.Now()
+
+	// A pending order past its expiry and two invalid orders, one of them
+	// older than the retention window
+	expired := &types.Order{ID: "order_expired", AccountID: "acct_1", Status: types.OrderStatusPending, ExpiresAt: types.Time{Time: now.Add(-time.Minute)}}
+	authz := &types.Authorization{
+		ID:         "authz_expired",
+		Status:     types.AuthzStatusPending,
+		Identifier: types.Identifier{Type: "dns", Value: "plc1.plant.example"},
+		Expires:    &types.Time{Time: now.Add(-time.Minute)},
+		Challenges: []types.Challenge{{ID: "chall_expired", Type: "http-01", Status: types.ChallengeStatusPending, Token: "token_expired"}},
+	}
+	if err := store.CreateOrder(ctx, expired, []*types.Authorization{authz}); err != nil {
+		t.Fatal(err)
+	}
+	for id, updated := range map[string]time.Time{"order_old": now.Add(-2 * time.Hour), "order_recent": now.Add(-10 * time.Minute)} {
+		order := &types.Order{ID: id, AccountID: "acct_1", Status: types.OrderStatusInvalid, ExpiresAt: types.Time{Time: updated}, UpdatedAt: types.Time{Time: updated}}
+		if err := store.CreateOrder(ctx, order, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateOrder(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New(store, Config{Retention: time.Hour}, logger.New(io.Discard))
+	result, err := s.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if result.ExpiredOrders != 1 || result.ExpiredAuthorizations != 1 || result.ExpiredChallenges != 1 || result.PurgedOrders != 1 {
+		t.Errorf("Sweep = %+v, want one expired order, authorization and challenge and one purged order", result)
+	}
+
+	if o, err := store.GetOrder(ctx, expired.ID); err != nil || o.Status != types.OrderStatusInvalid {
+		t.Errorf("expired order = %+v, %v, want invalid", o, err)
+	}
+	if a, err := store.GetAuthorization(ctx, authz.ID); err != nil || a.Status != types.AuthzStatusExpired {
+		t.Errorf("expired authorization = %+v, %v, want expired", a, err)
+	}
+	if _, err := store.GetOrder(ctx, "order_old"); err == nil {
+		t.Error("order older than the retention window was not purged")
+	}
+	if _, err := store.GetOrder(ctx, "order_recent"); err != nil {
+		t.Errorf("order within the retention window was purged: %v", err)
+	}
+
+	// A second sweep has nothing left to do
+	if result, err := s.Sweep(ctx); err != nil || result.ExpiredOrders+result.ExpiredAuthorizations+result.PurgedOrders != 0 {
+		t.Errorf("second Sweep = %+v, %v, want no work", result, err)
+	}
+
+	if got := testutil.ToFloat64(metrics.SweeperRecords.WithLabelValues("challenge", "expired")); got != 1 {
+		t.Errorf("expired challenges metric = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.SweeperRuns.WithLabelValues("success")); got != 2 {
+		t.Errorf("successful sweeps metric = %v, want 2", got)
+	}
+}
+
+func TestSweepPurge(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	now := time.Now()
+
+	// newOrder stores an order with a single authorization and challenge
+	newOrder := func(id string, status types.OrderStatus, authzStatus types.AuthorizationStatus) {
+		t.Helper()
+		order := &types.Order{ID: id, AccountID: "acct_1", Status: status, ExpiresAt: types.Time{Time: now.Add(time.Hour)}}
+		authz := &types.Authorization{
+			ID:         "authz_" + id,
+			Status:     authzStatus,
+			Identifier: types.Identifier{Type: "dns", Value: "plc1.plant.example"},
+			Expires:    &types.Time{Time: now.Add(time.Hour)},
+			Challenges: []types.Challenge{{ID: "chall_" + id, Type: "http-01", Status: types.ChallengeStatusValid, Token: "token_" + id}},
+		}
+		if err := store.CreateOrder(ctx, order, []*types.Authorization{authz}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newOrder("order_pending", types.OrderStatusPending, types.AuthzStatusPending)
+	newOrder("order_canceled", types.OrderStatusCanceled, types.AuthzStatusValid)
+	newOrder("order_deactivated", types.OrderStatusValid, types.AuthzStatusDeactivated)
+	newOrder("order_outdated", types.OrderStatusValid, types.AuthzStatusValid)
+	newOrder("order_current", types.OrderStatusValid, types.AuthzStatusValid)
+	for id, notAfter := range map[string]time.Time{"order_deactivated": now.Add(time.Hour), "order_outdated": now.Add(-time.Minute), "order_current": now.Add(time.Hour)} {
+		cert := &types.Certificate{ID: "cert_" + id, OrderID: id, NotBefore: types.Time{Time: now.Add(-time.Hour)}, NotAfter: types.Time{Time: notAfter}}
+		if err := store.CreateCertificate(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	delivery := &types.WebhookDelivery{ID: "whd_1", Endpoint: "inventory", Event: "order.invalid", Payload: []byte(`{}`), NextAttemptAt: now, CreatedAt: now.Add(-time.Minute)}
+	if err := store.CreateWebhookDeliveries(ctx, []*types.WebhookDelivery{delivery}); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a retention window of a nanosecond, every finished record is
+	// past it
+	s := New(store, Config{Retention: time.Nanosecond}, logger.New(io.Discard))
+	result, err := s.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if result.PurgedCertificates != 1 || result.PurgedAuthorizations != 1 || result.PurgedOrders != 2 || result.PurgedWebhooks != 1 {
+		t.Errorf("Sweep = %+v, want one purged certificate, authorization and webhook delivery and two purged orders", result)
+	}
+
+	for _, id := range []string{"order_canceled", "order_outdated"} {
+		if _, err := store.GetOrder(ctx, id); err == nil {
+			t.Errorf("order %s was not purged", id)
+		}
+		if _, err := store.GetAuthorization(ctx, "authz_"+id); err == nil {
+			t.Errorf("authorization of purged order %s was not purged", id)
+		}
+	}
+	if _, err := store.GetCertificate(ctx, "cert_order_outdated"); err == nil {
+		t.Error("expired certificate was not purged")
+	}
+	if _, err := store.GetAuthorization(ctx, "authz_order_deactivated"); err == nil {
+		t.Error("deactivated authorization was not purged")
+	}
+	if _, err := store.GetChallenge(ctx, "token_order_deactivated"); err == nil {
+		t.Error("challenge of the deactivated authorization was not purged")
+	}
+	if claimed, err := store.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10); err != nil || len(claimed) != 0 {
+		t.Errorf("ClaimWebhookDeliveries = %+v, %v, want the delivery purged", claimed, err)
+	}
+
+	// Pending records and valid orders with a current certificate are kept
+	for _, id := range []string{"order_pending", "order_deactivated", "order_current"} {
+		if _, err := store.GetOrder(ctx, id); err != nil {
+			t.Errorf("order %s was purged: %v", id, err)
+		}
+	}
+	if _, err := store.GetAuthorization(ctx, "authz_order_pending"); err != nil {
+		t.Errorf("pending authorization was purged: %v", err)
+	}
+	if _, err := store.GetCertificate(ctx, "cert_order_current"); err != nil {
+		t.Errorf("current certificate was purged: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.SweeperRecords.WithLabelValues("certificate", "purged")); got != 1 {
+		t.Errorf("purged certificates metric = %v, want 1", got)
+	}
+}
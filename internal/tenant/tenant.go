@@ -0,0 +1,161 @@
+// Package tenant separates the ACME CAs served by one process. Every tenant
+// has its own directory, issuing CA, certificate profiles and account
+// requirements. Tenants share the store; accounts, orders and EAB keys
+// record the tenant they belong to.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/policy"
+)
+
+// DefaultName is the name of the tenant served directly below the ACME path
+// prefix
+const DefaultName = ""
+
+// Settings are the parts of a tenant that can be replaced at runtime
+type Settings struct {
+	// CA issues the certificates of the tenant. Without a CA a throwaway
+	// test CA is generated for every certificate.
+	CA *pki.CA
+	// Profiles are offered to clients, the first one being the default
+	Profiles []*pki.Profile
+	// ExternalAccountRequired rejects new accounts that do not bind an EAB
+	// key of the tenant
+	ExternalAccountRequired bool
+	// Policy restricts the identifiers of orders; nil allows all
+	Policy *policy.Policy
+	// CAAIdentities are the issuer domain names announced in the directory
+	// and looked for in CAA records. Without identities CAA records are
+	// not checked.
+	CAAIdentities []string
+	// CT submits precertificates to Certificate Transparency logs before
+	// issuance; nil issues without SCTs
+	CT pki.Transparency
+}
+
+// Profile returns the profile with the given name, or the default profile
+// for an empty name
+func (s *Settings) Profile(name string) (*pki.Profile, bool) {
+	if len(s.Profiles) == 0 {
+		if name == "" || name == pki.DefaultProfile.Name {
+			return pki.DefaultProfile, true
+		}
+		return nil, false
+	}
+	if name == "" {
+		return s.Profiles[0], true
+	}
+	for _, profile := range s.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return nil, false
+}
+
+// ProfileList returns the offered profiles
+func (s *Settings) ProfileList() []*pki.Profile {
+	if len(s.Profiles) == 0 {
+		return []*pki.Profile{pki.DefaultProfile}
+	}
+	return s.Profiles
+}
+
+// Tenant is one ACME CA served by the process
+type Tenant struct {
+	Name string
+	// PathPrefix is where the ACME routes of the tenant are mounted below
+	// the ACME path prefix, e.g. "/ot"; empty for the default tenant
+	PathPrefix string
+
+	settings atomic.Pointer[Settings]
+}
+
+// New creates a tenant
+func New(name, pathPrefix string, settings *Settings) *Tenant {
+	t := &Tenant{Name: name, PathPrefix: pathPrefix}
+	t.settings.Store(settings)
+	return t
+}
+
+// Settings returns the current settings of the tenant
+func (t *Tenant) Settings() *Settings {
+	return t.settings.Load()
+}
+
+// SetSettings replaces the settings for all following requests, e.g. on a
+// configuration reload
+func (t *Tenant) SetSettings(settings *Settings) {
+	t.settings.Store(settings)
+}
+
+// String returns the tenant name for logs
+func (t *Tenant) String() string {
+	if t.Name == DefaultName {
+		return "default"
+	}
+	return t.Name
+}
+
+// Registry holds the tenants of the process
+type Registry struct {
+	tenants []*Tenant
+	byName  map[string]*Tenant
+}
+
+// NewRegistry creates a registry of tenants with distinct names and paths
+func NewRegistry(tenants ...*Tenant) (*Registry, error) {
+	r := &Registry{byName: make(map[string]*Tenant)}
+	paths := make(map[string]bool)
+	for _, t := range tenants {
+		if _, ok := r.byName[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate tenant %q", t.Name)
+		}
+		if paths[t.PathPrefix] {
+			return nil, fmt.Errorf("tenant %s: path %q is already in use", t, t.PathPrefix)
+		}
+		r.byName[t.Name] = t
+		paths[t.PathPrefix] = true
+		r.tenants = append(r.tenants, t)
+	}
+	return r, nil
+}
+
+// Get returns the tenant with the given name
+func (r *Registry) Get(name string) (*Tenant, bool) {
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// All returns the tenants in the order they were registered
+func (r *Registry) All() []*Tenant {
+	return r.tenants
+}
+
+// Settings returns the current settings of the named tenant. Objects of a
+// tenant that has been removed from the configuration cannot be served.
+func (r *Registry) Settings(name string) (*Settings, error) {
+	t, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", name)
+	}
+	return t.Settings(), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tenant a request is served for
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant a request is served for
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Tenant)
+	return t, ok && t != nil
+}
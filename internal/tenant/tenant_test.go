@@ -0,0 +1,46 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+func TestSettingsProfile(t *testing.T) {
+	var empty Settings
+	if p, ok := empty.Profile(""); !ok || p != pki.DefaultProfile {
+		t.Errorf("Profile(\"\") without profiles = %v, want the default profile", p)
+	}
+
+	client := &pki.Profile{Name: "tls-client"}
+	server := &pki.Profile{Name: "tls-server"}
+	s := &Settings{Profiles: []*pki.Profile{client, server}}
+	if p, _ := s.Profile(""); p != client {
+		t.Errorf("Profile(\"\") = %v, want the first profile", p)
+	}
+	if p, _ := s.Profile("tls-server"); p != server {
+		t.Errorf("Profile(tls-server) = %v", p)
+	}
+	if _, ok := s.Profile("default"); ok {
+		t.Error("Profile(default) found a profile that is not offered")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ot := New("ot", "/ot", &Settings{})
+	if _, err := NewRegistry(New(DefaultName, "", &Settings{}), ot, New("it", "/ot", &Settings{})); err == nil {
+		t.Error("NewRegistry accepted two tenants on the same path")
+	}
+
+	r, err := NewRegistry(New(DefaultName, "", &Settings{}), ot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Settings("pqc"); err == nil {
+		t.Error("Settings returned settings of an unknown tenant")
+	}
+	if got, ok := FromContext(NewContext(context.Background(), ot)); !ok || got != ot {
+		t.Errorf("FromContext = %v, want the tenant of the context", got)
+	}
+}
@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/pki"
+)
+
+// Event types
+const (
+	EventAccountCreated      = "account.created"
+	EventOrderCreated        = "order.created"
+	EventChallengeFailed     = "challenge.failed"
+	EventCertificateIssued   = "certificate.issued"
+	EventCertificateRevoked  = "certificate.revoked"
+	EventCertificateExpiring = "certificate.expiring"
+)
+
+// expiryBatch is how many expiring certificates are read at once
+const expiryBatch = 100
+
+// Account is the data of an account.created event
+type Account struct {
+	Status   string   `json:"status"`
+	Contact  []string `json:"contact,omitempty"`
+	EABKeyID string   `json:"eabKeyId,omitempty"`
+}
+
+// Order is the data of an order.created event
+type Order struct {
+	Order       string             `json:"order"`
+	Status      string             `json:"status"`
+	Identifiers []types.Identifier `json:"identifiers"`
+	Profile     string             `json:"profile,omitempty"`
+	Expires     time.Time          `json:"expires"`
+	// Replaces is the ID of the certificate the order renews (RFC 9773)
+	Replaces string `json:"replaces,omitempty"`
+	// STAR marks orders of automatically renewed certificates (RFC 8739)
+	STAR bool `json:"star,omitempty"`
+}
+
+// ChallengeFailed is the data of a challenge.failed event
+type ChallengeFailed struct {
+	Order         string           `json:"order"`
+	Authorization string           `json:"authorization"`
+	Identifier    types.Identifier `json:"identifier"`
+	Challenge     string           `json:"challenge"` // challenge type
+	Error         *types.Problem   `json:"error,omitempty"`
+}
+
+// Certificate is the data of certificate.issued and certificate.expiring
+// events
+type Certificate struct {
+	Order          string    `json:"order"`
+	Certificate    string    `json:"certificate"`
+	Serial         string    `json:"serial"`
+	AuthorityKeyID string    `json:"authorityKeyId"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	// Renewal marks certificates reissued for a STAR order
+	Renewal bool `json:"renewal,omitempty"`
+	// PEM is the issued certificate; it is only sent with
+	// certificate.issued
+	PEM string `json:"pem,omitempty"`
+}
+
+// newCertificate describes a stored certificate
+func newCertificate(cert *types.Certificate) (*Certificate, error) {
+	parsed, err := pki.ParseCertificatePEM(cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	c := &Certificate{
+		Order:          cert.OrderID,
+		Certificate:    cert.ID,
+		Serial:         cert.Serial,
+		AuthorityKeyID: cert.AuthorityKeyID,
+		DNSNames:       parsed.DNSNames,
+		NotBefore:      parsed.NotBefore,
+		NotAfter:       parsed.NotAfter,
+	}
+	for _, ip := range parsed.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	return c, nil
+}
+
+// CertificateRevoked is the data of a certificate.revoked event
+type CertificateRevoked struct {
+	Order          string `json:"order"`
+	Certificate    string `json:"certificate"`
+	Serial         string `json:"serial"`
+	AuthorityKeyID string `json:"authorityKeyId"`
+	Reason         string `json:"reason"`
+}
+
+// NotifyIssuance sends a certificate.issued event with the certificate
+// issued for an order. renewal marks the reissued certificates of STAR
+// orders.
+func (n *Notifier) NotifyIssuance(ctx context.Context, order *types.Order, cert *types.Certificate, renewal bool) error {
+	if n == nil {
+		return nil
+	}
+	issued, err := newCertificate(cert)
+	if err != nil {
+		return err
+	}
+	issued.Renewal = renewal
+	issued.PEM = cert.Certificate
+	return n.Notify(ctx, EventCertificateIssued, order.Tenant, order.AccountID, issued)
+}
+
+// NotifyExpiring sends a certificate.expiring event for every certificate
+// that expires within the expiry warning and has not been announced yet. It
+// returns the number of announced certificates. Certificates are only marked
+// as announced while an endpoint subscribes to the event.
+func (n *Notifier) NotifyExpiring(ctx context.Context) (int, error) {
+	if n == nil || !n.subscribed(EventCertificateExpiring) {
+		return 0, nil
+	}
+
+	announced := 0
+	for {
+		now := time.Now()
+		certs, err := n.store.ListExpiringCertificates(ctx, now, now.Add(n.config.ExpiryWarning), expiryBatch)
+		if err != nil {
+			return announced, err
+		}
+		for _, cert := range certs {
+			// Another replica may have announced it in the meantime
+			marked, err := n.store.MarkCertificateExpiryNotified(ctx, cert.ID)
+			if err != nil {
+				return announced, err
+			}
+			if !marked {
+				continue
+			}
+			if err := n.notifyExpiring(ctx, cert); err != nil {
+				return announced, fmt.Errorf("certificate %s: %w", cert.ID, err)
+			}
+			announced++
+		}
+		if len(certs) < expiryBatch {
+			return announced, nil
+		}
+	}
+}
+
+func (n *Notifier) notifyExpiring(ctx context.Context, cert *types.Certificate) error {
+	order, err := n.store.GetOrder(ctx, cert.OrderID)
+	if err != nil {
+		return err
+	}
+	expiring, err := newCertificate(cert)
+	if err != nil {
+		return err
+	}
+	return n.Notify(ctx, EventCertificateExpiring, order.Tenant, order.AccountID, expiring)
+}
+
+// subscribed reports whether any endpoint receives events of a type
+func (n *Notifier) subscribed(event string) bool {
+	for _, ep := range n.endpoints {
+		if ep.subscribed(event) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,447 @@
+// Package webhook notifies operator-defined endpoints of lifecycle events:
+// new accounts, orders and certificates, failed challenges, revocations and
+// certificates about to expire. Events are queued in the store and POSTed
+// as JSON by a background worker that signs them with HMAC-SHA256 and
+// retries failed deliveries with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/metrics"
+)
+
+const (
+	// DefaultInterval is how often the delivery queue is polled if not
+	// configured otherwise. New events are sent right away.
+	DefaultInterval = 10 * time.Second
+
+	// DefaultTimeout bounds a single delivery if not configured otherwise
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxAttempts is how often a delivery is attempted before it is
+	// dropped. With the backoff below this covers almost two days.
+	DefaultMaxAttempts = 50
+
+	// DefaultExpiryWarning is how long before its expiry a certificate is
+	// announced as expiring
+	DefaultExpiryWarning = 30 * 24 * time.Hour
+
+	// minBackoff and maxBackoff bound the delay between attempts, which
+	// doubles with every failure
+	minBackoff = 10 * time.Second
+	maxBackoff = time.Hour
+
+	// claimBatch is how many due deliveries are sent at once
+	claimBatch = 50
+
+	// expiryScanInterval is how often certificates are checked for
+	// upcoming expiry
+	expiryScanInterval = 15 * time.Minute
+)
+
+// Events are the types of events endpoints can subscribe to
+var Events = []string{
+	EventAccountCreated,
+	EventOrderCreated,
+	EventChallengeFailed,
+	EventCertificateIssued,
+	EventCertificateRevoked,
+	EventCertificateExpiring,
+}
+
+// Event is the JSON body POSTed to the endpoints
+type Event struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	Tenant  string          `json:"tenant,omitempty"`
+	Account string          `json:"account,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Endpoint is an operator-defined URL that receives events
+type Endpoint struct {
+	Name string
+	URL  string
+	// Secret is the HMAC-SHA256 key the requests are signed with
+	Secret []byte
+	// Events lists the event types sent to the endpoint; empty subscribes
+	// to all events
+	Events  []string
+	Timeout time.Duration
+}
+
+// subscribed reports whether the endpoint receives events of a type
+func (e *Endpoint) subscribed(event string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, event)
+}
+
+// Store keeps the delivery queue in the storage backend; storage.Store
+// implements it
+type Store interface {
+	CreateWebhookDeliveries(ctx context.Context, deliveries []*types.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]*types.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *types.WebhookDelivery) error
+	DeleteWebhookDelivery(ctx context.Context, id string) error
+	ListExpiringCertificates(ctx context.Context, now, before time.Time, limit int) ([]*types.Certificate, error)
+	MarkCertificateExpiryNotified(ctx context.Context, id string) (bool, error)
+	GetOrder(ctx context.Context, id string) (*types.Order, error)
+}
+
+// Config holds the notifier settings. Zero values select the defaults.
+type Config struct {
+	Endpoints     []Endpoint
+	Interval      time.Duration
+	MaxAttempts   int
+	ExpiryWarning time.Duration
+	Client        *http.Client
+}
+
+// Notifier queues events for the subscribed endpoints and delivers them
+type Notifier struct {
+	store     Store
+	config    Config
+	endpoints map[string]*Endpoint
+	// lease is how long claimed deliveries are hidden from other replicas;
+	// it outlasts the longest endpoint timeout
+	lease  time.Duration
+	logger *logger.Logger
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a notifier for the configured endpoints. Events of types no
+// endpoint subscribes to are not queued.
+func New(store Store, cfg Config, log *logger.Logger) *Notifier {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.ExpiryWarning <= 0 {
+		cfg.ExpiryWarning = DefaultExpiryWarning
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+
+	n := &Notifier{
+		store:     store,
+		config:    cfg,
+		endpoints: make(map[string]*Endpoint),
+		lease:     time.Minute,
+		logger:    log,
+		wake:      make(chan struct{}, 1),
+	}
+	for i := range cfg.Endpoints {
+		ep := &cfg.Endpoints[i]
+		if ep.Timeout <= 0 {
+			ep.Timeout = DefaultTimeout
+		}
+		n.endpoints[ep.Name] = ep
+		n.lease = max(n.lease, ep.Timeout+time.Minute)
+	}
+	return n
+}
+
+// Notify queues an event for every endpoint subscribed to its type. data
+// is sent as JSON. A nil Notifier queues nothing, so callers need not check
+// whether webhooks are enabled.
+func (n *Notifier) Notify(ctx context.Context, event, tenant, account string, data any) error {
+	if n == nil {
+		return nil
+	}
+
+	var subscribed []*Endpoint
+	for _, ep := range n.config.Endpoints {
+		if ep.subscribed(event) {
+			subscribed = append(subscribed, n.endpoints[ep.Name])
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook data: %w", err)
+	}
+	now := time.Now().UTC()
+	payload, err := json.Marshal(&Event{
+		ID:      newID("evt"),
+		Type:    event,
+		Time:    now,
+		Tenant:  tenant,
+		Account: account,
+		Data:    raw,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook event: %w", err)
+	}
+
+	deliveries := make([]*types.WebhookDelivery, 0, len(subscribed))
+	for _, ep := range subscribed {
+		deliveries = append(deliveries, &types.WebhookDelivery{
+			ID:            newID("whd"),
+			Endpoint:      ep.Name,
+			Event:         event,
+			Payload:       payload,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if err := n.store.CreateWebhookDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+
+	// Deliver right away instead of at the next poll
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the delivery worker in the background until Stop is called or
+// ctx is cancelled
+func (n *Notifier) Start(ctx context.Context) {
+	ctx, n.cancel = context.WithCancel(ctx)
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.run(ctx)
+	}()
+
+	n.logger.Infow("Webhook notifier started",
+		"endpoints", len(n.endpoints),
+		"interval", n.config.Interval,
+	)
+}
+
+// Stop cancels the delivery worker and waits for running deliveries to
+// finish
+func (n *Notifier) Stop() {
+	if n.cancel == nil {
+		return
+	}
+	n.cancel()
+	n.wg.Wait()
+	n.logger.Info("Webhook notifier stopped")
+}
+
+func (n *Notifier) run(ctx context.Context) {
+	ticker := time.NewTicker(n.config.Interval)
+	defer ticker.Stop()
+
+	var lastScan time.Time
+	for {
+		if time.Since(lastScan) >= expiryScanInterval {
+			lastScan = time.Now()
+			if _, err := n.NotifyExpiring(ctx); err != nil && ctx.Err() == nil {
+				n.logger.Errorf("Expiring certificate scan failed: %v", err)
+			}
+		}
+		if _, err := n.Deliver(ctx); err != nil && ctx.Err() == nil {
+			n.logger.Errorf("Webhook delivery pass failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// Deliver sends all due deliveries and returns how many were accepted by
+// their endpoints. Failed deliveries are rescheduled with exponential
+// backoff and dropped after the maximum number of attempts.
+func (n *Notifier) Deliver(ctx context.Context) (int, error) {
+	delivered := 0
+	for {
+		now := time.Now()
+		claimed, err := n.store.ClaimWebhookDeliveries(ctx, now, now.Add(n.lease), claimBatch)
+		if err != nil {
+			return delivered, err
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, d := range claimed {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if n.attempt(ctx, d) {
+					mu.Lock()
+					delivered++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(claimed) < claimBatch || ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+	}
+}
+
+// attempt sends a claimed delivery once and updates the queue with the
+// outcome. It reports whether the endpoint accepted the delivery.
+func (n *Notifier) attempt(ctx context.Context, d *types.WebhookDelivery) bool {
+	ep, ok := n.endpoints[d.Endpoint]
+	if !ok {
+		n.logger.Errorw("Dropping webhook delivery to an endpoint that is no longer configured",
+			"delivery", d.ID,
+			"endpoint", d.Endpoint,
+			"event", d.Event,
+		)
+		n.finish(ctx, d, "dropped")
+		return false
+	}
+
+	err := n.send(ctx, ep, d)
+	if err == nil {
+		n.finish(ctx, d, "delivered")
+		return true
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the delivery is retried once the claim lapses
+		return false
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= n.config.MaxAttempts {
+		n.logger.Errorw("Dropping webhook delivery after the maximum number of attempts",
+			"delivery", d.ID,
+			"endpoint", d.Endpoint,
+			"event", d.Event,
+			"attempts", d.Attempts,
+			"error", d.LastError,
+		)
+		n.finish(ctx, d, "dropped")
+		return false
+	}
+
+	d.NextAttemptAt = time.Now().Add(backoff(d.Attempts))
+	metrics.WebhookDeliveries.WithLabelValues(d.Endpoint, "retry").Inc()
+	n.logger.Infow("Webhook delivery failed, retrying",
+		"delivery", d.ID,
+		"endpoint", d.Endpoint,
+		"event", d.Event,
+		"attempts", d.Attempts,
+		"next_attempt", d.NextAttemptAt,
+		"error", d.LastError,
+	)
+	if err := n.store.UpdateWebhookDelivery(ctx, d); err != nil {
+		n.logger.Errorf("Failed to reschedule webhook delivery %s: %v", d.ID, err)
+	}
+	return false
+}
+
+// finish removes a delivery from the queue
+func (n *Notifier) finish(ctx context.Context, d *types.WebhookDelivery, result string) {
+	metrics.WebhookDeliveries.WithLabelValues(d.Endpoint, result).Inc()
+	if err := n.store.DeleteWebhookDelivery(ctx, d.ID); err != nil {
+		n.logger.Errorf("Failed to remove webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+// send POSTs a delivery to its endpoint. Any 2xx response accepts it.
+func (n *Notifier) send(ctx context.Context, ep *Endpoint, d *types.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, ep.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", d.ID)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("Webhook-Signature", Sign(ep.Secret, d.ID, now, d.Payload))
+
+	resp, err := n.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the Webhook-Signature header of a request: "v1," followed by
+// the base64 HMAC-SHA256 of the Webhook-Id, the Webhook-Timestamp and the
+// body, joined by dots. This follows the Standard Webhooks specification,
+// so receivers can verify requests with its libraries.
+func Sign(secret []byte, id string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// minSecretSize is the minimum size of endpoint secrets in bytes, as
+// required by the Standard Webhooks specification
+const minSecretSize = 24
+
+// ParseSecret decodes a base64 endpoint secret. The "whsec_" prefix used by
+// Standard Webhooks libraries is accepted.
+func ParseSecret(s string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "whsec_"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret encoding: %w", err)
+	}
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("secret has %d bytes, at least %d are required", len(secret), minSecretSize)
+	}
+	return secret, nil
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of failed attempts
+func backoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return maxBackoff
+	}
+	return min(minBackoff<<(attempts-1), maxBackoff)
+}
+
+// newID returns a random ID with a prefix. Deliveries are queued by several
+// replicas, so IDs must not depend on the clock alone.
+func newID(prefix string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("error generating webhook ID: %w", err))
+	}
+	return prefix + "_" + hex.EncodeToString(b)
+}
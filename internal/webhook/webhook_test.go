@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/api/types"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/logger"
+	"github.com/Laboratory-for-Safe-and-Secure-Systems/kritis3m_acme/internal/storage/memory"
+)
+
+// receiver records the events of valid requests and answers with status
+type receiver struct {
+	secret []byte
+	status int
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	unix, _ := strconv.ParseInt(r.Header.Get("Webhook-Timestamp"), 10, 64)
+	if r.Header.Get("Webhook-Signature") != Sign(rc.secret, r.Header.Get("Webhook-Id"), time.Unix(unix, 0), body) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	rc.events = append(rc.events, e)
+	rc.mu.Unlock()
+	w.WriteHeader(rc.status)
+}
+
+// newCertificatePEM returns a certificate for plc1.plant.example
+func newCertificatePEM(t *testing.T, notAfter time.Time) string {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"plc1.plant.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNotifier(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+
+	inventory := &receiver{secret: []byte("inventory-secret"), status: http.StatusNoContent}
+	siem := &receiver{secret: []byte("siem-secret"), status: http.StatusServiceUnavailable}
+	inventorySrv := httptest.NewServer(inventory)
+	defer inventorySrv.Close()
+	siemSrv := httptest.NewServer(siem)
+	defer siemSrv.Close()
+
+	n := New(store, Config{Endpoints: []Endpoint{
+		{Name: "inventory", URL: inventorySrv.URL, Secret: inventory.secret, Events: []string{EventCertificateIssued, EventCertificateExpiring}},
+		{Name: "siem", URL: siemSrv.URL, Secret: siem.secret},
+	}}, logger.New(io.Discard))
+
+	account := &types.Account{ID: "acct_1", Key: json.RawMessage(`{}`), Status: types.AccountStatusValid, Tenant: "plant"}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	order := &types.Order{ID: "order_1", AccountID: account.ID, Status: types.OrderStatusValid, Tenant: "plant"}
+	if err := store.CreateOrder(ctx, order, nil); err != nil {
+		t.Fatal(err)
+	}
+	cert := &types.Certificate{
+		ID:          "cert_1",
+		OrderID:     order.ID,
+		Certificate: newCertificatePEM(t, time.Now().Add(24*time.Hour)),
+		Serial:      "01",
+		NotAfter:    types.Time{Time: time.Now().Add(24 * time.Hour)},
+	}
+	if err := store.CreateCertificate(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Notify(ctx, EventAccountCreated, "plant", account.ID, Account{Status: "valid"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.NotifyIssuance(ctx, order, cert, false); err != nil {
+		t.Fatal(err)
+	}
+	if delivered, err := n.Deliver(ctx); err != nil || delivered != 1 {
+		t.Fatalf("Deliver = %d, %v, want 1 delivery accepted", delivered, err)
+	}
+	if len(inventory.events) != 1 || inventory.events[0].Type != EventCertificateIssued || inventory.events[0].Tenant != "plant" {
+		t.Fatalf("inventory received %+v", inventory.events)
+	}
+	var issued Certificate
+	if err := json.Unmarshal(inventory.events[0].Data, &issued); err != nil || issued.PEM != cert.Certificate || issued.DNSNames[0] != "plc1.plant.example" {
+		t.Errorf("certificate.issued data = %+v, %v", issued, err)
+	}
+
+	// The failed deliveries to the SIEM are retried after a backoff
+	if len(siem.events) != 2 {
+		t.Fatalf("siem received %d events, want 2", len(siem.events))
+	}
+	if due, _ := store.ClaimWebhookDeliveries(ctx, time.Now(), time.Now(), 10); len(due) != 0 {
+		t.Errorf("failed deliveries are due again right away: %+v", due)
+	}
+	retries, err := store.ClaimWebhookDeliveries(ctx, time.Now().Add(minBackoff), time.Now(), 10)
+	if err != nil || len(retries) != 2 || retries[0].Attempts != 1 || !strings.Contains(retries[0].LastError, "503") {
+		t.Errorf("queued retries = %+v, %v", retries, err)
+	}
+	if backoff(1) != minBackoff || backoff(3) != 4*minBackoff || backoff(50) != maxBackoff {
+		t.Errorf("backoff = %v, %v, %v", backoff(1), backoff(3), backoff(50))
+	}
+
+	// Certificates are announced as expiring once
+	for range 2 {
+		if announced, err := n.NotifyExpiring(ctx); err != nil || announced > 1 {
+			t.Fatalf("NotifyExpiring = %d, %v", announced, err)
+		}
+		if _, err := n.Deliver(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(inventory.events) != 2 || inventory.events[1].Type != EventCertificateExpiring || inventory.events[1].Account != account.ID {
+		t.Errorf("inventory received %+v", inventory.events)
+	}
+}
@@ -24,7 +24,7 @@ if docker ps -a | grep -q "$CONTAINER_NAME"; then
     docker volume rm acme_data
 fi
 
-# Start PostgreSQL container with mounted schema and migrations
+# Start PostgreSQL container
 echo "Starting PostgreSQL container..."
 docker volume create acme_data
 docker run --name "$CONTAINER_NAME" \
@@ -32,8 +32,6 @@ docker run --name "$CONTAINER_NAME" \
     -e POSTGRES_USER="$DB_USER" \
     -e POSTGRES_PASSWORD="$DB_PASSWORD" \
     -v acme_data:/var/lib/postgresql/data \
-    -v "$SCRIPT_DIR/../internal/database/migrations":/migrations \
-    -v "$SCRIPT_DIR/../internal/database/schema.sql":/schema.sql \
     -p "$DB_PORT":5432 \
     -d postgres:15
 
@@ -41,19 +39,6 @@ docker run --name "$CONTAINER_NAME" \
 echo "Waiting for PostgreSQL to start..."
 sleep 5
 
-# Initialize the database schema from the schema file
-echo "Applying initial schema..."
-docker exec -i "$CONTAINER_NAME" psql -U "$DB_USER" -d "$DB_NAME" -f /schema.sql
-
-# Apply migrations in sorted order
-echo "Applying migration files..."
-MIGRATIONS_DIR="$SCRIPT_DIR/../internal/database/migrations"
-for sql_file in $(ls "$MIGRATIONS_DIR"/*.sql | sort); do
-    filename=$(basename "$sql_file")
-    echo "Applying migration: $filename"
-    docker exec -i "$CONTAINER_NAME" psql -U "$DB_USER" -d "$DB_NAME" -f /migrations/"$filename"
-done
-
 echo "Database initialized successfully!"
 
 echo "
@@ -86,4 +71,8 @@ cat > "$SCRIPT_DIR/../config/database.json" << EOF
 }
 EOF
 
-echo "Database configuration file created at $SCRIPT_DIR/../config/database.json"
\ No newline at end of file
+echo "Database configuration file created at $SCRIPT_DIR/../config/database.json"
+
+# Apply the schema migrations embedded in the server
+echo "Applying schema migrations..."
+(cd "$SCRIPT_DIR/.." && go run ./cmd/acme-server -db-config config/database.json migrate up)
\ No newline at end of file